@@ -0,0 +1,101 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"encoding/xml"
+	"errors"
+
+	"github.com/katydid/katydid/parser"
+	"github.com/katydid/katydid/relapse/ast"
+	"github.com/katydid/katydid/relapse/interp"
+)
+
+//NewTokenParser reads all the tokens, from a xml.Decoder or another xml.TokenReader,
+//and returns a parser with the labels of the xml parser of the options.
+//Only the local names of elements are used, like NewXMLParser does.
+func NewTokenParser(r xml.TokenReader, opts TranslateOptions) (parser.Interface, error) {
+	p := newTreeParser(opts.withDefaults())
+	root, err := p.buildXML(r.Token)
+	if err != nil {
+		return nil, err
+	}
+	p.init(root)
+	return &p, nil
+}
+
+//ValidateTokens validates the xml tokens against a Katydid Relapse Grammar, that was translated with the options.
+func ValidateTokens(katydid *ast.Grammar, r xml.TokenReader, opts TranslateOptions) error {
+	p, err := NewTokenParser(r, opts)
+	if err != nil {
+		return err
+	}
+	return validateParser(katydid, p)
+}
+
+//Node is an element or text in a tree, like the DOM of an xml library, that can be validated without serializing it.
+type Node interface {
+	//Name returns the name of an element, where only the local name is used, or an empty name for text.
+	Name() xml.Name
+	//Attrs returns the attributes of an element.
+	Attrs() []xml.Attr
+	//Children returns the elements and text inside an element.
+	Children() []Node
+	//Text returns the text of a text node.
+	Text() string
+}
+
+//NewNodeParser returns a parser for the tree of the root element, with the labels of the xml parser of the options.
+func NewNodeParser(root Node, opts TranslateOptions) (parser.Interface, error) {
+	p := newTreeParser(opts.withDefaults())
+	if len(root.Name().Local) == 0 {
+		return nil, errors.New("the root node is not an element")
+	}
+	p.init(&labelNode{children: []*labelNode{p.node(root)}})
+	return &p, nil
+}
+
+//node returns the element with its attributes and content, where adjacent text nodes are one text leaf.
+func (this *treeParser) node(n Node) *labelNode {
+	elem := this.newElement(n.Name().Local, n.Attrs())
+	for _, c := range n.Children() {
+		if len(c.Name().Local) == 0 {
+			elem.children = this.addText(elem.children, []byte(c.Text()))
+		} else {
+			elem.children = append(elem.children, this.node(c))
+		}
+	}
+	return elem
+}
+
+//ValidateNode validates the tree of the root element against a Katydid Relapse Grammar, that was translated with the options.
+func ValidateNode(katydid *ast.Grammar, root Node, opts TranslateOptions) error {
+	p, err := NewNodeParser(root, opts)
+	if err != nil {
+		return err
+	}
+	return validateParser(katydid, p)
+}
+
+func validateParser(katydid *ast.Grammar, p parser.Interface) error {
+	valid, err := interp.Interpret(katydid, p)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("not valid")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+func TestTokenSuite(t *testing.T) {
+	for _, spec := range scanFiles() {
+		num := testNumber(spec.Filename)
+		if len(spec.SimpleFilename) == 0 || namespaces[num] || datatypeLibrary[num] {
+			continue
+		}
+		g, err := ParseGrammar(spec.SimpleContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		katydid, err := Translate(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, x := range spec.Xmls {
+			want := Validate(katydid, x.Content) == nil
+			if got := ValidateTokens(katydid, xml.NewDecoder(bytes.NewReader(x.Content)), TranslateOptions{}) == nil; got != want {
+				t.Fatalf("%s: %s: expected valid %v for the tokens of\n%s", num, x.Filename, want, x.Content)
+			}
+		}
+	}
+}
+
+type testNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []Node
+	text     string
+}
+
+func (this *testNode) Name() xml.Name    { return xml.Name{Local: this.name} }
+func (this *testNode) Attrs() []xml.Attr { return this.attrs }
+func (this *testNode) Children() []Node  { return this.children }
+func (this *testNode) Text() string      { return this.text }
+
+func textNode(text string) Node {
+	return &testNode{text: text}
+}
+
+func TestNode(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="p" xmlns="http://relaxng.org/ns/structure/1.0">
+	<attribute name="lang"><value>en</value></attribute>
+	<value type="token">a b</value>
+</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		node  Node
+		valid bool
+	}{
+		{&testNode{name: "p", attrs: []xml.Attr{{Name: xml.Name{Local: "lang"}, Value: "en"}}, children: []Node{textNode(" a "), textNode(""), textNode(" b")}}, true},
+		{&testNode{name: "p", attrs: []xml.Attr{{Name: xml.Name{Local: "lang"}, Value: "fr"}}, children: []Node{textNode("a b")}}, false},
+		{&testNode{name: "p", attrs: []xml.Attr{{Name: xml.Name{Local: "lang"}, Value: "en"}}, children: []Node{textNode("a"), &testNode{name: "b"}}}, false},
+		{&testNode{name: "q", attrs: []xml.Attr{{Name: xml.Name{Local: "lang"}, Value: "en"}}, children: []Node{textNode("a b")}}, false},
+	}
+	for _, opts := range []TranslateOptions{{}, {TypedText: true}} {
+		katydid, err := TranslateWithOptions(g, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, test := range tests {
+			if got := ValidateNode(katydid, test.node, opts) == nil; got != test.valid {
+				t.Fatalf("%d: expected valid %v", i, test.valid)
+			}
+		}
+		if err := ValidateTokens(katydid, xml.NewDecoder(bytes.NewReader([]byte(`<p lang="en"> a <![CDATA[b]]></p>`))), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewNodeParser(textNode("a"), TranslateOptions{}); err == nil {
+		t.Fatal("expected an error for a root that is text")
+	}
+	if _, err := NewTokenParser(xml.NewDecoder(bytes.NewReader([]byte(`<p>`))), TranslateOptions{}); err == nil {
+		t.Fatal("expected an error for an unclosed element")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+//Annotation is an element outside of the RelaxNG namespace, such as a:documentation, that annotates a pattern.
+//Character data inside an annotation is represented as a child, that only has Text and no Name.
+type Annotation struct {
+	Name     xml.Name
+	Attrs    []xml.Attr
+	Children []Annotation
+	Text     string
+}
+
+//IsText returns whether the annotation is character data, instead of an element.
+func (this *Annotation) IsText() bool {
+	return len(this.Name.Local) == 0
+}
+
+//TextContent returns the character data of the annotation and all its descendants.
+func (this *Annotation) TextContent() string {
+	if this.IsText() {
+		return this.Text
+	}
+	var ss []string
+	for i := range this.Children {
+		ss = append(ss, this.Children[i].TextContent())
+	}
+	return strings.Join(ss, "")
+}
+
+//decodeAnnotation decodes the foreign element, that starts with the start token, and all its content.
+func decodeAnnotation(d *xml.Decoder, start xml.StartElement) (Annotation, error) {
+	a := Annotation{Name: start.Name}
+	for _, attr := range start.Attr {
+		//Names are already resolved, so namespace declarations are not kept.
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		a.Attrs = append(a.Attrs, attr)
+	}
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return a, err
+		}
+		switch tok := t.(type) {
+		case xml.StartElement:
+			a.trimIndent()
+			child, err := decodeAnnotation(d, tok)
+			if err != nil {
+				return a, err
+			}
+			a.Children = append(a.Children, child)
+		case xml.CharData:
+			a.Children = append(a.Children, Annotation{Text: string(tok)})
+		case xml.EndElement:
+			a.trimIndent()
+			return a, nil
+		}
+	}
+}
+
+//trimIndent removes the indentation, a new line followed by tabs, from the end of the last child, if it is text.
+//This is the indentation that Grammar.String writes before tags, so that writing and reading an annotation keeps it the same.
+func (this *Annotation) trimIndent() {
+	last := len(this.Children) - 1
+	if last < 0 || !this.Children[last].IsText() {
+		return
+	}
+	text := this.Children[last].Text
+	i := strings.LastIndex(text, "\n")
+	if i < 0 || len(strings.Trim(text[i+1:], "\t")) > 0 {
+		return
+	}
+	if i == 0 {
+		this.Children = this.Children[:last]
+		return
+	}
+	this.Children[last].Text = text[:i]
+}
+
+//Documentation returns the text of the a:documentation annotations, with each paragraph on its own.
+//Paragraphs are separated by empty lines and the white space inside a paragraph is collapsed.
+func Documentation(annotations []Annotation) []string {
+	var paragraphs []string
+	for i := range annotations {
+		a := &annotations[i]
+		if a.Name.Space != compatibilityAnnotations || a.Name.Local != "documentation" {
+			continue
+		}
+		var lines []string
+		flush := func() {
+			if len(lines) > 0 {
+				paragraphs = append(paragraphs, strings.Join(strings.Fields(strings.Join(lines, " ")), " "))
+				lines = nil
+			}
+		}
+		for _, line := range strings.Split(a.TextContent(), "\n") {
+			if len(strings.TrimSpace(line)) == 0 {
+				flush()
+			} else {
+				lines = append(lines, line)
+			}
+		}
+		flush()
+	}
+	return paragraphs
+}
+
+//annotationPrefixes are the prefixes that are declared for the namespaces of annotations, when they are written.
+//Other namespaces get a numbered prefix.
+var annotationPrefixes = map[string]string{
+	compatibilityAnnotations: "a",
+	schematronNs:             "sch",
+}
+
+//annotationWriter writes RelaxNG elements, together with their foreign attributes and annotations.
+//Namespaces of foreign names are declared, with a prefix, on the element where they are used.
+type annotationWriter struct {
+	e *xml.Encoder
+	//defaultNs is the default namespace of the RelaxNG elements.
+	defaultNs string
+}
+
+//start writes the start tag of a RelaxNG element, followed by its annotations.
+func (this *annotationWriter) start(name xml.Name, attrs []xml.Attr, foreignAttrs []xml.Attr, annotations []Annotation) error {
+	scope := make(map[string]string)
+	var decls []xml.Attr
+	for _, a := range foreignAttrs {
+		attrs = append(attrs, xml.Attr{Name: prefixName(a.Name, scope, &decls), Value: a.Value})
+	}
+	if err := this.e.EncodeToken(xml.StartElement{Name: name, Attr: append(attrs, decls...)}); err != nil {
+		return err
+	}
+	for i := range annotations {
+		if err := this.annotation(&annotations[i], scope, this.defaultNs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *annotationWriter) end(name string) error {
+	return this.e.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}})
+}
+
+//except writes the except element of a data, anyName or nsName element.
+func (this *annotationWriter) except(p *NameOrPattern) error {
+	if err := this.start(xml.Name{Local: "except"}, nil, nil, nil); err != nil {
+		return err
+	}
+	if err := p.marshal(this); err != nil {
+		return err
+	}
+	return this.end("except")
+}
+
+//annotation writes the annotation, where the prefixes in scope are already declared
+//and defaultNs is the default namespace of the parent element.
+func (this *annotationWriter) annotation(a *Annotation, parentScope map[string]string, defaultNs string) error {
+	if a.IsText() {
+		return this.e.EncodeToken(xml.CharData(a.Text))
+	}
+	scope := make(map[string]string, len(parentScope))
+	for ns, prefix := range parentScope {
+		scope[ns] = prefix
+	}
+	var decls []xml.Attr
+	name := prefixName(a.Name, scope, &decls)
+	if len(a.Name.Space) == 0 && len(defaultNs) > 0 {
+		decls = append(decls, xml.Attr{Name: xml.Name{Local: "xmlns"}})
+		defaultNs = ""
+	}
+	attrs := make([]xml.Attr, 0, len(a.Attrs)+len(decls))
+	for _, attr := range a.Attrs {
+		attrs = append(attrs, xml.Attr{Name: prefixName(attr.Name, scope, &decls), Value: attr.Value})
+	}
+	if err := this.e.EncodeToken(xml.StartElement{Name: name, Attr: append(attrs, decls...)}); err != nil {
+		return err
+	}
+	for i := range a.Children {
+		if err := this.annotation(&a.Children[i], scope, defaultNs); err != nil {
+			return err
+		}
+	}
+	return this.e.EncodeToken(xml.EndElement{Name: name})
+}
+
+//prefixName returns the name with the prefix of its namespace.
+//If the prefix is not in scope yet, it is added to the scope and its declaration is added to decls.
+func prefixName(name xml.Name, scope map[string]string, decls *[]xml.Attr) xml.Name {
+	switch name.Space {
+	case "":
+		return name
+	case xmlNs:
+		return xml.Name{Local: "xml:" + name.Local}
+	}
+	prefix, ok := scope[name.Space]
+	if !ok {
+		prefix, ok = annotationPrefixes[name.Space]
+		if !ok {
+			prefix = "ns" + strconv.Itoa(len(scope)+1)
+		}
+		scope[name.Space] = prefix
+		*decls = append(*decls, xml.Attr{Name: xml.Name{Local: "xmlns:" + prefix}, Value: name.Space})
+	}
+	return xml.Name{Local: prefix + ":" + name.Local}
+}
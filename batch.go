@@ -0,0 +1,138 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sync"
+
+	"github.com/katydid/katydid/relapse/ast"
+)
+
+//Result is the result of validating a single file.
+type Result struct {
+	Filename string
+	//Err is nil if the file is valid.
+	//Otherwise it is the validation error, the error from reading the file
+	//or the context's error if the file was not validated before the context was done.
+	Err error
+}
+
+//ValidateAll validates the files against a Katydid Relapse Grammar,
+//which is shared by a pool of at most workers goroutines.
+//If workers is zero or less, runtime.NumCPU() workers are used.
+//The results are returned in the same order as the filenames.
+//If the context is done before all files are validated, the remaining files are skipped
+//and the context's error is returned together with all the results.
+func ValidateAll(ctx context.Context, katydid *ast.Grammar, filenames []string, workers int) ([]*Result, error) {
+	results := make([]*Result, len(filenames))
+	err := parallel(ctx, len(filenames), workers, func(i int) {
+		results[i] = &Result{Filename: filenames[i], Err: validateFile(katydid, filenames[i])}
+	})
+	for i := range results {
+		if results[i] == nil {
+			results[i] = &Result{Filename: filenames[i], Err: err}
+		}
+	}
+	return results, err
+}
+
+func validateFile(katydid *ast.Grammar, filename string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	xmlContent, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return Validate(katydid, xmlContent)
+}
+
+//ReportAll is the same as ValidateAll, except that it returns a Report for each file.
+//The RelaxNG Grammar, from which the Katydid Relapse Grammar was translated, is used to find where validation failed.
+//The reports of files that could not be read or were skipped contain a single finding with the error.
+func ReportAll(ctx context.Context, g *Grammar, katydid *ast.Grammar, filenames []string, workers int) ([]*Report, error) {
+	reports := make([]*Report, len(filenames))
+	r := newReporter(g, katydid)
+	err := parallel(ctx, len(filenames), workers, func(i int) {
+		reports[i] = reportFile(r, filenames[i])
+	})
+	for i := range reports {
+		if reports[i] == nil {
+			reports[i] = errorReport(filenames[i], err)
+		}
+	}
+	return reports, err
+}
+
+func reportFile(reporter *reporter, filename string) (r *Report) {
+	defer func() {
+		if e := recover(); e != nil {
+			r = errorReport(filename, fmt.Errorf("%v", e))
+		}
+	}()
+	xmlContent, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errorReport(filename, err)
+	}
+	return reporter.report(filename, xmlContent)
+}
+
+func errorReport(filename string, err error) *Report {
+	return &Report{File: filename, Findings: []*Finding{
+		{Line: 1, Column: 1, Path: "/", Rule: "start", Message: err.Error()},
+	}}
+}
+
+//parallel calls f for 0 up to n on a pool of workers,
+//until all calls are done or the context is done.
+func parallel(ctx context.Context, n int, workers int, f func(i int)) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				f(i)
+			}
+		}()
+	}
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return err
+}
@@ -0,0 +1,133 @@
+package relaxng
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testFiles(t *testing.T, n int) (*Grammar, string, []string) {
+	g, err := Simplify([]byte(`<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><empty/></element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "relaxng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filenames := make([]string, n)
+	for i := range filenames {
+		filenames[i] = filepath.Join(dir, fmt.Sprintf("%d.xml", i))
+		content := "<a/>"
+		if i%3 == 0 {
+			content = "<b/>"
+		}
+		if err := ioutil.WriteFile(filenames[i], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g, dir, filenames
+}
+
+func TestValidateAll(t *testing.T) {
+	g, dir, filenames := testFiles(t, 50)
+	defer os.RemoveAll(dir)
+	filenames = append(filenames, filepath.Join(dir, "missing.xml"))
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ValidateAll(context.Background(), katydid, filenames, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r.Filename != filenames[i] {
+			t.Fatalf("expected %s at %d, got %s", filenames[i], i, r.Filename)
+		}
+		invalid := i%3 == 0 || i == len(filenames)-1
+		if invalid != (r.Err != nil) {
+			t.Fatalf("%s: unexpected error %v", r.Filename, r.Err)
+		}
+	}
+}
+
+func TestValidateAllCancel(t *testing.T) {
+	g, dir, filenames := testFiles(t, 10)
+	defer os.RemoveAll(dir)
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := ValidateAll(ctx, katydid, filenames, 0)
+	if err != context.Canceled {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+	if len(results) != len(filenames) {
+		t.Fatalf("expected a result per file, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Err != context.Canceled {
+			t.Fatalf("%s: expected canceled, got %v", r.Filename, r.Err)
+		}
+	}
+}
+
+func TestReportAll(t *testing.T) {
+	g, dir, filenames := testFiles(t, 7)
+	defer os.RemoveAll(dir)
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := ReportAll(context.Background(), g, katydid, filenames, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range reports {
+		if r.File != filenames[i] || r.Valid != (i%3 != 0) {
+			t.Fatalf("unexpected report %#v at %d", r, i)
+		}
+	}
+}
+
+func TestReportAllSchematron(t *testing.T) {
+	g, err := Simplify([]byte(schematronGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "relaxng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filenames := make([]string, 8)
+	for i := range filenames {
+		filenames[i] = filepath.Join(dir, fmt.Sprintf("%d.xml", i))
+		content := `<order><item sku="a" quantity="1"/></order>`
+		if i%2 == 0 {
+			content = `<order><item sku="a" quantity="11"/></order>`
+		}
+		if err := ioutil.WriteFile(filenames[i], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reports, err := ReportAll(context.Background(), g, katydid, filenames, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range reports {
+		if r.Valid != (i%2 != 0) || (!r.Valid && (len(r.Findings) != 1 || r.Findings[0].Rule != schematronRule)) {
+			t.Fatalf("unexpected report %#v at %d", r, i)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/katydid/relaxng"
+)
+
+//The kind of location the cursor is at.
+type contextKind int
+
+const (
+	inContent contextKind = iota
+	inElementName
+	inClosingTag
+	inAttributeName
+	inAttributeValue
+	inOther
+)
+
+//The location of the cursor in a document.
+type cursorContext struct {
+	kind contextKind
+	//The names of the elements that are open at the cursor.
+	parents []string
+	//The element whose start tag the cursor is in.
+	element string
+	//The attribute whose value the cursor is in.
+	attribute string
+	//The attributes that are already present in the start tag.
+	present []string
+}
+
+func (this cursorContext) parent() string {
+	if len(this.parents) == 0 {
+		return ""
+	}
+	return this.parents[len(this.parents)-1]
+}
+
+//openElements returns the names of the elements that are still open at the end of text.
+func openElements(text string) []string {
+	d := xml.NewDecoder(strings.NewReader(text))
+	d.Strict = false
+	stack := []string{}
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			return stack
+		}
+		switch tok := t.(type) {
+		case xml.StartElement:
+			stack = append(stack, tok.Name.Local)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+}
+
+var (
+	attrNameBeforeValue = regexp.MustCompile(`([\w:.-]+)\s*=\s*$`)
+	attrNames           = regexp.MustCompile(`([\w:.-]+)\s*=`)
+)
+
+//completionContext finds out where in the document the offset is.
+func completionContext(text string, offset int) cursorContext {
+	prefix := text[:offset]
+	lt := strings.LastIndex(prefix, "<")
+	gt := strings.LastIndex(prefix, ">")
+	if lt <= gt {
+		return cursorContext{kind: inContent, parents: openElements(prefix)}
+	}
+	c := cursorContext{parents: openElements(prefix[:lt])}
+	tag := prefix[lt+1:]
+	if strings.HasPrefix(tag, "/") {
+		c.kind = inClosingTag
+		return c
+	}
+	if strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") {
+		c.kind = inOther
+		return c
+	}
+	space := strings.IndexAny(tag, " \t\r\n")
+	if space < 0 {
+		c.kind = inElementName
+		return c
+	}
+	c.element = localName(tag[:space])
+	rest := tag[space:]
+	var quote rune
+	quoteStart := 0
+	for i, r := range rest {
+		if quote == 0 && (r == '"' || r == '\'') {
+			quote = r
+			quoteStart = i
+		} else if r == quote {
+			quote = 0
+		}
+	}
+	if quote != 0 {
+		c.kind = inAttributeValue
+		if m := attrNameBeforeValue.FindStringSubmatch(rest[:quoteStart]); m != nil {
+			c.attribute = localName(m[1])
+		}
+		return c
+	}
+	c.kind = inAttributeName
+	for _, m := range attrNames.FindAllStringSubmatch(rest, -1) {
+		c.present = append(c.present, localName(m[1]))
+	}
+	return c
+}
+
+func localName(name string) string {
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+//matchesName returns whether the name class accepts the local name.
+func matchesName(n *relaxng.NameOrPattern, name string) bool {
+	switch {
+	case n.Name != nil:
+		return n.Name.Text == name
+	case n.AnyName != nil:
+		return n.AnyName.Except == nil || !matchesName(n.AnyName.Except, name)
+	case n.NsName != nil:
+		return n.NsName.Except == nil || !matchesName(n.NsName.Except, name)
+	case n.Choice != nil:
+		return matchesName(n.Choice.Left, name) || matchesName(n.Choice.Right, name)
+	}
+	return false
+}
+
+//nameClassNames returns the names listed in a name class.
+func nameClassNames(n *relaxng.NameOrPattern) []string {
+	switch {
+	case n.Name != nil:
+		return []string{n.Name.Text}
+	case n.Choice != nil:
+		return append(nameClassNames(n.Choice.Left), nameClassNames(n.Choice.Right)...)
+	}
+	return nil
+}
+
+//definesFor returns the defines of the elements with the given name.
+func definesFor(g *relaxng.Grammar, name string) []*relaxng.Define {
+	var ds []*relaxng.Define
+	for i := range g.Define {
+		if matchesName(g.Define[i].Element.Left, name) {
+			ds = append(ds, &g.Define[i])
+		}
+	}
+	return ds
+}
+
+//walkContent calls the visit function for every pattern that is not inside an attribute.
+//Attributes, with the patterns inside them, are only passed to the attr function.
+func walkContent(p *relaxng.NameOrPattern, visit func(*relaxng.NameOrPattern), attr func(*relaxng.NameOrPattern)) {
+	if p == nil {
+		return
+	}
+	if p.Attribute != nil {
+		attr(p)
+		return
+	}
+	visit(p)
+	switch {
+	case p.List != nil:
+		walkContent(p.List.NameOrPattern, visit, attr)
+	case p.OneOrMore != nil:
+		walkContent(p.OneOrMore.NameOrPattern, visit, attr)
+	case p.Choice != nil:
+		walkContent(p.Choice.Left, visit, attr)
+		walkContent(p.Choice.Right, visit, attr)
+	case p.Group != nil:
+		walkContent(p.Group.Left, visit, attr)
+		walkContent(p.Group.Right, visit, attr)
+	case p.Interleave != nil:
+		walkContent(p.Interleave.Left, visit, attr)
+		walkContent(p.Interleave.Right, visit, attr)
+	}
+}
+
+//values returns the values of all the value patterns inside p.
+func values(p *relaxng.NameOrPattern) []string {
+	var vs []string
+	walkContent(p, func(p *relaxng.NameOrPattern) {
+		if p.Value != nil {
+			vs = append(vs, p.Value.Text)
+		}
+	}, func(*relaxng.NameOrPattern) {})
+	return vs
+}
+
+//childElements returns the names of the elements that may appear in the content of p.
+func childElements(g *relaxng.Grammar, p *relaxng.NameOrPattern) []string {
+	var names []string
+	walkContent(p, func(p *relaxng.NameOrPattern) {
+		if p.Ref == nil {
+			return
+		}
+		for _, d := range g.Define {
+			if d.Name == p.Ref.Name {
+				names = append(names, nameClassNames(d.Element.Left)...)
+			}
+		}
+	}, func(*relaxng.NameOrPattern) {})
+	return names
+}
+
+//attributes returns the attributes that may appear on the element described by p.
+func attributes(p *relaxng.NameOrPattern) []*relaxng.NameOrPattern {
+	var attrs []*relaxng.NameOrPattern
+	walkContent(p, func(*relaxng.NameOrPattern) {}, func(a *relaxng.NameOrPattern) {
+		attrs = append(attrs, a)
+	})
+	return attrs
+}
+
+type completions map[string]completionItem
+
+func (this completions) add(label string, kind int, detail string) {
+	if len(label) == 0 {
+		return
+	}
+	if _, ok := this[label]; ok {
+		return
+	}
+	this[label] = completionItem{Label: label, Kind: kind, Detail: detail}
+}
+
+func (this completions) list() []completionItem {
+	labels := make([]string, 0, len(this))
+	for label := range this {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	items := make([]completionItem, len(labels))
+	for i, label := range labels {
+		items[i] = this[label]
+	}
+	return items
+}
+
+//complete returns the completion items for the cursor context.
+func complete(g *relaxng.Grammar, c cursorContext) []completionItem {
+	items := make(completions)
+	switch c.kind {
+	case inElementName:
+		if len(c.parents) == 0 {
+			for _, name := range childElements(g, g.Start) {
+				items.add(name, kindClass, "root element")
+			}
+			break
+		}
+		for _, d := range definesFor(g, c.parent()) {
+			for _, name := range childElements(g, d.Element.Right) {
+				items.add(name, kindClass, "element")
+			}
+		}
+	case inClosingTag:
+		items.add(c.parent(), kindClass, "closing tag")
+	case inAttributeName:
+		present := make(map[string]bool)
+		for _, name := range c.present {
+			present[name] = true
+		}
+		for _, d := range definesFor(g, c.element) {
+			for _, a := range attributes(d.Element.Right) {
+				for _, name := range nameClassNames(a.Attribute.Left) {
+					if !present[name] {
+						items.add(name, kindProperty, "attribute")
+					}
+				}
+			}
+		}
+	case inAttributeValue:
+		for _, d := range definesFor(g, c.element) {
+			for _, a := range attributes(d.Element.Right) {
+				if !matchesName(a.Attribute.Left, c.attribute) {
+					continue
+				}
+				for _, v := range values(a.Attribute.Right) {
+					items.add(v, kindValue, "value")
+				}
+			}
+		}
+	case inContent:
+		for _, d := range definesFor(g, c.parent()) {
+			for _, v := range values(d.Element.Right) {
+				items.add(v, kindValue, "value")
+			}
+		}
+	}
+	return items.list()
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c == ':' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c >= 0x80
+}
+
+//wordAt returns the start and end offsets of the name at the offset.
+func wordAt(text string, offset int) (int, int) {
+	start := offset
+	for start > 0 && isNameChar(text[start-1]) {
+		start--
+	}
+	end := offset
+	for end < len(text) && isNameChar(text[end]) {
+		end++
+	}
+	return start, end
+}
+
+//hoverText describes the element or attribute name at the offset,
+//starting with the text of its a:documentation annotations.
+func hoverText(g *relaxng.Grammar, text string, offset int) (string, int, int) {
+	start, end := wordAt(text, offset)
+	if start == end {
+		return "", 0, 0
+	}
+	name := localName(text[start:end])
+	c := completionContext(text, start)
+	buf := []string{}
+	switch c.kind {
+	case inElementName, inClosingTag:
+		for _, d := range definesFor(g, name) {
+			buf = append(buf, hoverDocumentation(d.Annotations)+"element `"+name+"` (define `"+d.Name+"`)\n\n```xml\n"+d.Element.Right.String()+"\n```")
+		}
+	case inAttributeName:
+		for _, d := range definesFor(g, c.element) {
+			for _, a := range attributes(d.Element.Right) {
+				if matchesName(a.Attribute.Left, name) {
+					buf = append(buf, hoverDocumentation(a.Annotations)+"attribute `"+name+"` of element `"+c.element+"`\n\n```xml\n"+a.Attribute.Right.String()+"\n```")
+				}
+			}
+		}
+	}
+	return strings.Join(buf, "\n\n---\n\n"), start, end
+}
+
+//hoverDocumentation returns the paragraphs of the a:documentation annotations, followed by an empty line.
+func hoverDocumentation(annotations []relaxng.Annotation) string {
+	docs := relaxng.Documentation(annotations)
+	if len(docs) == 0 {
+		return ""
+	}
+	return strings.Join(docs, "\n\n") + "\n\n"
+}
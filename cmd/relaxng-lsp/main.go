@@ -0,0 +1,59 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//Command relaxng-lsp is a Language Server Protocol server, speaking over stdio,
+//for editing XML documents that are described by RelaxNG grammars, which are simplified when they are loaded.
+//
+//A document is associated with a schema using an xml-model processing instruction
+//	<?xml-model href="schema.rng" schematypens="http://relaxng.org/ns/structure/1.0"?>
+//or using the configuration, which can be passed as initializationOptions,
+//workspace settings or a json file given with the -config flag:
+//	{"schemas": [{"pattern": "*.xml", "schema": "/path/to/schema.rng"}]}
+//
+//The server publishes a diagnostic for every violation, at the element where it was found,
+//completes element names, attribute names and enumerated values
+//and shows the documentation, from the a:documentation annotations, and the content model of elements and attributes on hover.
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func main() {
+	configFile := flag.String("config", "", "json file that associates documents with schemas")
+	flag.Parse()
+	log.SetOutput(os.Stderr)
+	c := &config{}
+	if len(*configFile) > 0 {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c, err = parseConfig(data)
+		if err != nil {
+			log.Fatalf("config %s: %v", *configFile, err)
+		}
+	}
+	s := newServer(os.Stdout, c)
+	if err := s.serve(os.Stdin); err != nil && err != io.EOF {
+		log.Fatal(err)
+	}
+	if !s.shutdown {
+		os.Exit(1)
+	}
+}
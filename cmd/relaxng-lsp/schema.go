@@ -0,0 +1,184 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/katydid/katydid/relapse/ast"
+	"github.com/katydid/relaxng"
+)
+
+const relaxngNs = "http://relaxng.org/ns/structure/1.0"
+
+//An association maps documents, matched by a filepath.Match pattern
+//against either the full path or the base name, to a RelaxNG schema.
+type association struct {
+	Pattern string `json:"pattern"`
+	Schema  string `json:"schema"`
+}
+
+//The configuration accepted as initializationOptions,
+//through workspace/didChangeConfiguration (optionally nested under a "relaxng" key)
+//or from the file passed with the -config flag.
+type config struct {
+	Schemas []association `json:"schemas"`
+}
+
+//A loaded schema.
+type schema struct {
+	path    string
+	modTime time.Time
+	grammar *relaxng.Grammar
+	relapse *ast.Grammar
+	err     error
+}
+
+type schemaCache map[string]*schema
+
+//load returns the simplified and translated schema at path,
+//reloading it when the file has been modified.
+func (this schemaCache) load(path string) *schema {
+	info, err := os.Stat(path)
+	if err != nil {
+		return &schema{path: path, err: err}
+	}
+	if s, ok := this[path]; ok && s.modTime.Equal(info.ModTime()) {
+		return s
+	}
+	s := &schema{path: path, modTime: info.ModTime()}
+	s.grammar, s.relapse, s.err = parseSchema(path)
+	this[path] = s
+	return s
+}
+
+func parseSchema(path string) (g *relaxng.Grammar, relapse *ast.Grammar, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	g, err = relaxng.SimplifyFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("relaxng parse error: %v", err)
+	}
+	relaxng.RemoveTODOs(g)
+	relapse, err = relaxng.Translate(g)
+	if err != nil {
+		return nil, nil, fmt.Errorf("translate error: %v", err)
+	}
+	return g, relapse, nil
+}
+
+var pseudoAttr = regexp.MustCompile(`([\w:.-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+//xmlModel returns the href of the first xml-model processing instruction
+//that refers to a RelaxNG schema, together with the offset of the instruction.
+//Only the prolog, up to the root element, is searched.
+func xmlModel(text string) (string, int, bool) {
+	d := xml.NewDecoder(strings.NewReader(text))
+	for {
+		offset := int(d.InputOffset())
+		t, err := d.RawToken()
+		if err != nil {
+			return "", 0, false
+		}
+		switch tok := t.(type) {
+		case xml.StartElement:
+			return "", 0, false
+		case xml.ProcInst:
+			if tok.Target != "xml-model" {
+				continue
+			}
+			attrs := make(map[string]string)
+			for _, m := range pseudoAttr.FindAllStringSubmatch(string(tok.Inst), -1) {
+				attrs[m[1]] = m[2] + m[3]
+			}
+			if ns, ok := attrs["schematypens"]; ok && ns != relaxngNs {
+				continue
+			}
+			if typ, ok := attrs["type"]; ok && !strings.Contains(typ, "xml") {
+				continue
+			}
+			if href, ok := attrs["href"]; ok {
+				return href, offset, true
+			}
+		}
+	}
+}
+
+//uriToPath converts a file URI into a local path.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+//resolve returns the schema path for a document,
+//preferring an xml-model processing instruction over the configured associations.
+func (this *config) resolve(docPath string, text string) (string, int) {
+	if href, offset, ok := xmlModel(text); ok {
+		if p := uriToPath(href); len(p) > 0 {
+			return p, offset
+		}
+		href = filepath.FromSlash(href)
+		if !filepath.IsAbs(href) && len(docPath) > 0 {
+			href = filepath.Join(filepath.Dir(docPath), href)
+		}
+		return href, offset
+	}
+	for _, a := range this.Schemas {
+		if matched, _ := filepath.Match(a.Pattern, docPath); matched {
+			return a.Schema, 0
+		}
+		if matched, _ := filepath.Match(a.Pattern, filepath.Base(docPath)); matched {
+			return a.Schema, 0
+		}
+	}
+	return "", 0
+}
+
+//parseConfig accepts either a bare configuration or one nested under a "relaxng" key.
+func parseConfig(data []byte) (*config, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return &config{}, nil
+	}
+	nested := &struct {
+		Relaxng *config `json:"relaxng"`
+	}{}
+	if err := json.Unmarshal(data, nested); err != nil {
+		return nil, err
+	}
+	if nested.Relaxng != nil {
+		return nested.Relaxng, nil
+	}
+	c := &config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
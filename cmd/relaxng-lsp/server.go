@@ -0,0 +1,339 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/katydid/relaxng"
+)
+
+type document struct {
+	uri  string
+	text string
+}
+
+type server struct {
+	out      io.Writer
+	config   *config
+	schemas  schemaCache
+	docs     map[string]*document
+	shutdown bool
+	exit     bool
+}
+
+func newServer(out io.Writer, c *config) *server {
+	if c == nil {
+		c = &config{}
+	}
+	return &server{
+		out:     out,
+		config:  c,
+		schemas: make(schemaCache),
+		docs:    make(map[string]*document),
+	}
+}
+
+//serve reads and handles messages until the exit notification is received.
+func (this *server) serve(in io.Reader) error {
+	r := bufio.NewReader(in)
+	for !this.exit {
+		data, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+		if err := this.handleMessage(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *server) handleMessage(data []byte) error {
+	req := &request{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return writeMessage(this.out, &errorResponse{
+			JSONRPC: "2.0",
+			Error:   &responseError{Code: codeParseError, Message: err.Error()},
+		})
+	}
+	result, err := this.handle(req)
+	if req.ID == nil {
+		return err
+	}
+	if err != nil {
+		rerr, ok := err.(*responseError)
+		if !ok {
+			rerr = &responseError{Code: codeInternalError, Message: err.Error()}
+		}
+		return writeMessage(this.out, &errorResponse{JSONRPC: "2.0", ID: req.ID, Error: rerr})
+	}
+	return writeMessage(this.out, &response{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+func (this *server) handle(req *request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		params := &initializeParams{}
+		if err := unmarshalParams(req.Params, params); err != nil {
+			return nil, err
+		}
+		if len(params.InitializationOptions) > 0 {
+			c, err := parseConfig(params.InitializationOptions)
+			if err != nil {
+				return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+			}
+			this.config.Schemas = append(this.config.Schemas, c.Schemas...)
+		}
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": 1,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"<", "/", " ", "\"", "'"},
+				},
+				"hoverProvider": true,
+			},
+			"serverInfo": map[string]interface{}{
+				"name": "relaxng-lsp",
+			},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		this.shutdown = true
+		return nil, nil
+	case "exit":
+		this.exit = true
+		return nil, nil
+	case "workspace/didChangeConfiguration":
+		params := &didChangeConfigurationParams{}
+		if err := unmarshalParams(req.Params, params); err != nil {
+			return nil, err
+		}
+		c, err := parseConfig(params.Settings)
+		if err != nil {
+			return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		this.config = c
+		for _, doc := range this.docs {
+			if err := this.publish(doc); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case "textDocument/didOpen":
+		params := &didOpenParams{}
+		if err := unmarshalParams(req.Params, params); err != nil {
+			return nil, err
+		}
+		doc := &document{uri: params.TextDocument.URI, text: params.TextDocument.Text}
+		this.docs[doc.uri] = doc
+		return nil, this.publish(doc)
+	case "textDocument/didChange":
+		params := &didChangeParams{}
+		if err := unmarshalParams(req.Params, params); err != nil {
+			return nil, err
+		}
+		doc, ok := this.docs[params.TextDocument.URI]
+		if !ok || len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		doc.text = params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, this.publish(doc)
+	case "textDocument/didSave":
+		params := &didCloseParams{}
+		if err := unmarshalParams(req.Params, params); err != nil {
+			return nil, err
+		}
+		if doc, ok := this.docs[params.TextDocument.URI]; ok {
+			return nil, this.publish(doc)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		params := &didCloseParams{}
+		if err := unmarshalParams(req.Params, params); err != nil {
+			return nil, err
+		}
+		delete(this.docs, params.TextDocument.URI)
+		return nil, this.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		})
+	case "textDocument/completion":
+		params := &textDocumentPositionParams{}
+		if err := unmarshalParams(req.Params, params); err != nil {
+			return nil, err
+		}
+		doc, s := this.lookup(params.TextDocument.URI)
+		if s == nil || s.grammar == nil {
+			return []completionItem{}, nil
+		}
+		offset := offsetAt(doc.text, params.Position)
+		return complete(s.grammar, completionContext(doc.text, offset)), nil
+	case "textDocument/hover":
+		params := &textDocumentPositionParams{}
+		if err := unmarshalParams(req.Params, params); err != nil {
+			return nil, err
+		}
+		doc, s := this.lookup(params.TextDocument.URI)
+		if s == nil || s.grammar == nil {
+			return nil, nil
+		}
+		offset := offsetAt(doc.text, params.Position)
+		text, start, end := hoverText(s.grammar, doc.text, offset)
+		if len(text) == 0 {
+			return nil, nil
+		}
+		return &hover{
+			Contents: markupContent{Kind: "markdown", Value: text},
+			Range:    &textRange{Start: positionAt(doc.text, start), End: positionAt(doc.text, end)},
+		}, nil
+	}
+	if strings.HasPrefix(req.Method, "$/") || req.ID == nil {
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+func unmarshalParams(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (this *server) notify(method string, params interface{}) error {
+	return writeMessage(this.out, &notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+//lookup returns an open document and the schema associated with it.
+func (this *server) lookup(uri string) (*document, *schema) {
+	doc, ok := this.docs[uri]
+	if !ok {
+		return nil, nil
+	}
+	path, _ := this.config.resolve(uriToPath(uri), doc.text)
+	if len(path) == 0 {
+		return doc, nil
+	}
+	return doc, this.schemas.load(path)
+}
+
+func (this *server) publish(doc *document) error {
+	return this.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{
+		URI:         doc.uri,
+		Diagnostics: this.diagnose(doc),
+	})
+}
+
+//diagnose checks that the document is well formed and valid according to its schema.
+func (this *server) diagnose(doc *document) []diagnostic {
+	diags := []diagnostic{}
+	if err := wellFormed(doc.text); err != nil {
+		line := 0
+		if serr, ok := err.(*xml.SyntaxError); ok && serr.Line > 0 {
+			line = serr.Line - 1
+		}
+		return append(diags, newDiagnostic(lineRange(doc.text, line), severityError, err.Error()))
+	}
+	path, offset := this.config.resolve(uriToPath(doc.uri), doc.text)
+	if len(path) == 0 {
+		return diags
+	}
+	s := this.schemas.load(path)
+	if s.err != nil {
+		r := textRange{Start: positionAt(doc.text, offset), End: positionAt(doc.text, offset)}
+		return append(diags, newDiagnostic(r, severityWarning, fmt.Sprintf("schema %s: %v", path, s.err)))
+	}
+	for _, f := range validate(s, doc.text) {
+		start := findingOffset(doc.text, f)
+		r := textRange{Start: positionAt(doc.text, start), End: positionAt(doc.text, start)}
+		diags = append(diags, newDiagnostic(r, severityError, f.Path+": "+f.Message))
+	}
+	return diags
+}
+
+//validate returns the findings of the report of the document,
+//which are positioned at the element where validation failed.
+func validate(s *schema, text string) (findings []*relaxng.Finding) {
+	defer func() {
+		if r := recover(); r != nil {
+			findings = []*relaxng.Finding{{Line: 1, Column: 1, Path: "/", Message: fmt.Sprintf("%v", r)}}
+		}
+	}()
+	return relaxng.ValidateReport(s.grammar, s.relapse, "", []byte(text)).Findings
+}
+
+//findingOffset returns the byte offset of the 1-based line and column, in code points, of the finding.
+//A finding without a position, at line 1 and column 1, is moved to the root element's start tag.
+func findingOffset(text string, f *relaxng.Finding) int {
+	if f.Line <= 1 && f.Column <= 1 {
+		return rootOffset(text)
+	}
+	offset := offsetAt(text, position{Line: f.Line - 1})
+	for i := 1; i < f.Column && offset < len(text) && text[offset] != '\n'; i++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+func newDiagnostic(r textRange, severity int, message string) diagnostic {
+	return diagnostic{Range: r, Severity: severity, Source: "relaxng", Message: message}
+}
+
+//lineRange returns the range covering the whole line.
+func lineRange(text string, line int) textRange {
+	start := offsetAt(text, position{Line: line})
+	end := start + strings.IndexByte(text[start:]+"\n", '\n')
+	return textRange{Start: positionAt(text, start), End: positionAt(text, end)}
+}
+
+func wellFormed(text string) error {
+	d := xml.NewDecoder(strings.NewReader(text))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//rootOffset returns the offset of the root element's start tag.
+func rootOffset(text string) int {
+	d := xml.NewDecoder(strings.NewReader(text))
+	for {
+		offset := int(d.InputOffset())
+		t, err := d.RawToken()
+		if err != nil {
+			return 0
+		}
+		if _, ok := t.(xml.StartElement); ok {
+			return offset
+		}
+	}
+}
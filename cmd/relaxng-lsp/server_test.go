@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testSchema = `<grammar>
+	<start>
+		<ref name="element1"/>
+	</start>
+	<define name="element1">
+		<element>
+			<name>order</name>
+			<group>
+				<attribute>
+					<name>status</name>
+					<choice>
+						<value>open</value>
+						<value>closed</value>
+					</choice>
+				</attribute>
+				<oneOrMore>
+					<ref name="element2"/>
+				</oneOrMore>
+			</group>
+		</element>
+	</define>
+	<define name="element2">
+		<element>
+			<name>item</name>
+			<text/>
+		</element>
+	</define>
+</grammar>`
+
+type testClient struct {
+	t   *testing.T
+	s   *server
+	out *bytes.Buffer
+	id  int
+}
+
+var documentedSchema = `<grammar xmlns="http://relaxng.org/ns/structure/1.0"
+	xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0">
+	<start>
+		<ref name="element1"/>
+	</start>
+	<define name="element1">
+		<a:documentation>An order of items.
+
+		Orders are never deleted.</a:documentation>
+		<element>
+			<name>order</name>
+			<attribute>
+				<a:documentation>The state of the order.</a:documentation>
+				<name>status</name>
+				<text/>
+			</attribute>
+		</element>
+	</define>
+</grammar>`
+
+func newTestClient(t *testing.T) (*testClient, string) {
+	return newTestClientWithSchema(t, testSchema)
+}
+
+func newTestClientWithSchema(t *testing.T, schema string) (*testClient, string) {
+	dir, err := ioutil.TempDir("", "relaxng-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaPath := filepath.Join(dir, "order.rng")
+	if err := ioutil.WriteFile(schemaPath, []byte(schema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.NewBuffer(nil)
+	c := &testClient{t: t, s: newServer(out, nil), out: out}
+	c.call("initialize", map[string]interface{}{
+		"initializationOptions": map[string]interface{}{
+			"schemas": []association{{Pattern: "*.xml", Schema: schemaPath}},
+		},
+	})
+	return c, dir
+}
+
+func (this *testClient) send(method string, params interface{}, id bool) []map[string]interface{} {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id {
+		this.id++
+		msg["id"] = this.id
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		this.t.Fatal(err)
+	}
+	if err := this.s.handleMessage(data); err != nil {
+		this.t.Fatal(err)
+	}
+	var msgs []map[string]interface{}
+	r := bufio.NewReader(this.out)
+	for this.out.Len() > 0 || r.Buffered() > 0 {
+		data, err := readMessage(r)
+		if err != nil {
+			this.t.Fatal(err)
+		}
+		m := make(map[string]interface{})
+		if err := json.Unmarshal(data, &m); err != nil {
+			this.t.Fatal(err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func (this *testClient) call(method string, params interface{}) interface{} {
+	msgs := this.send(method, params, true)
+	if len(msgs) != 1 {
+		this.t.Fatalf("expected one response, got %v", msgs)
+	}
+	if e, ok := msgs[0]["error"]; ok {
+		this.t.Fatalf("unexpected error %v", e)
+	}
+	return msgs[0]["result"]
+}
+
+func (this *testClient) open(uri, text string) []interface{} {
+	msgs := this.send("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "version": 1, "text": text},
+	}, false)
+	if len(msgs) != 1 {
+		this.t.Fatalf("expected diagnostics, got %v", msgs)
+	}
+	return msgs[0]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+}
+
+func labels(result interface{}) []string {
+	var ls []string
+	for _, item := range result.([]interface{}) {
+		ls = append(ls, item.(map[string]interface{})["label"].(string))
+	}
+	return ls
+}
+
+func TestDiagnostics(t *testing.T) {
+	c, dir := newTestClient(t)
+	defer os.RemoveAll(dir)
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "order.xml"))
+	if diags := c.open(uri, `<order status="open"><item>a</item></order>`); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+	if diags := c.open(uri, `<order status="pending"><item>a</item></order>`); len(diags) != 1 {
+		t.Fatalf("expected a validation error, got %v", diags)
+	}
+	diags := c.open(uri, "<order status=\"open\">\n<item>a</order>")
+	if len(diags) != 1 {
+		t.Fatalf("expected a syntax error, got %v", diags)
+	}
+	start := diags[0].(map[string]interface{})["range"].(map[string]interface{})["start"].(map[string]interface{})
+	if start["line"].(float64) != 1 {
+		t.Fatalf("expected syntax error on the second line, got %v", start)
+	}
+}
+
+func TestDiagnosticsPosition(t *testing.T) {
+	c, dir := newTestClient(t)
+	defer os.RemoveAll(dir)
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "order.xml"))
+	diags := c.open(uri, "<order status=\"open\">\n\t<item>a</item>\n\t<item>b<item/></item>\n</order>")
+	if len(diags) != 1 {
+		t.Fatalf("expected a validation error, got %v", diags)
+	}
+	diag := diags[0].(map[string]interface{})
+	start := diag["range"].(map[string]interface{})["start"].(map[string]interface{})
+	if start["line"].(float64) != 2 {
+		t.Fatalf("expected the validation error on the third line, got %v", start)
+	}
+	if msg := diag["message"].(string); !strings.HasPrefix(msg, "/order[1]/item[2]: ") {
+		t.Fatalf("expected the path of the invalid element, got %s", msg)
+	}
+}
+
+func TestFullGrammar(t *testing.T) {
+	c, dir := newTestClientWithSchema(t, `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+		<attribute name="status"><choice><value>open</value><value>closed</value></choice></attribute>
+		<oneOrMore><element name="item"><text/></element></oneOrMore>
+	</element>`)
+	defer os.RemoveAll(dir)
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "order.xml"))
+	if diags := c.open(uri, `<order status="open"><item>a</item></order>`); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+	if diags := c.open(uri, `<order status="pending"><item>a</item></order>`); len(diags) != 1 {
+		t.Fatalf("expected a validation error, got %v", diags)
+	}
+}
+
+func TestXMLModel(t *testing.T) {
+	c, dir := newTestClient(t)
+	defer os.RemoveAll(dir)
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "other.txt"))
+	text := `<?xml-model href="order.rng" schematypens="http://relaxng.org/ns/structure/1.0"?><order/>`
+	if diags := c.open(uri, text); len(diags) != 2 {
+		t.Fatalf("expected the missing attribute and item, got %v", diags)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c, dir := newTestClient(t)
+	defer os.RemoveAll(dir)
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "order.xml"))
+	text := `<order status="o"><`
+	c.open(uri, text)
+	complete := func(offset int) []string {
+		return labels(c.call("textDocument/completion", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     positionAt(text, offset),
+		}))
+	}
+	if ls := complete(1); strings.Join(ls, ",") != "order" {
+		t.Fatalf("expected root element completion, got %v", ls)
+	}
+	if ls := complete(len(`<order `)); strings.Join(ls, ",") != "status" {
+		t.Fatalf("expected attribute completion, got %v", ls)
+	}
+	if ls := complete(len(`<order status="`)); strings.Join(ls, ",") != "closed,open" {
+		t.Fatalf("expected value completion, got %v", ls)
+	}
+	if ls := complete(len(text)); strings.Join(ls, ",") != "item" {
+		t.Fatalf("expected child element completion, got %v", ls)
+	}
+}
+
+func TestHover(t *testing.T) {
+	c, dir := newTestClient(t)
+	defer os.RemoveAll(dir)
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "order.xml"))
+	text := `<order status="open"><item>a</item></order>`
+	c.open(uri, text)
+	result := c.call("textDocument/hover", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     positionAt(text, len(`<order sta`)),
+	})
+	value := result.(map[string]interface{})["contents"].(map[string]interface{})["value"].(string)
+	if !strings.Contains(value, "attribute `status`") || !strings.Contains(value, "closed") {
+		t.Fatalf("unexpected hover %s", value)
+	}
+}
+
+func TestHoverDocumentation(t *testing.T) {
+	c, dir := newTestClientWithSchema(t, documentedSchema)
+	defer os.RemoveAll(dir)
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "order.xml"))
+	text := `<order status="open"></order>`
+	c.open(uri, text)
+	hover := func(offset int) string {
+		result := c.call("textDocument/hover", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     positionAt(text, offset),
+		})
+		return result.(map[string]interface{})["contents"].(map[string]interface{})["value"].(string)
+	}
+	if value := hover(len(`<ord`)); !strings.HasPrefix(value, "An order of items.\n\nOrders are never deleted.\n\nelement `order`") {
+		t.Fatalf("expected the documentation of the element, got %s", value)
+	}
+	if value := hover(len(`<order sta`)); !strings.HasPrefix(value, "The state of the order.\n\nattribute `status`") {
+		t.Fatalf("expected the documentation of the attribute, got %s", value)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	text := "a\né\U0001F600b"
+	pos := positionAt(text, len(text))
+	if pos.Line != 1 || pos.Character != 4 {
+		t.Fatalf("unexpected position %v", pos)
+	}
+	if offset := offsetAt(text, pos); offset != len(text) {
+		t.Fatalf("unexpected offset %d", offset)
+	}
+}
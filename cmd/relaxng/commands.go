@@ -0,0 +1,730 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/katydid/katydid/relapse/ast"
+	"github.com/katydid/relaxng"
+)
+
+//result is the outcome of validating a document or checking a grammar.
+type result struct {
+	File  string `json:"file"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+func readFile(filename string, stdin io.Reader) ([]byte, error) {
+	if filename == "-" {
+		return ioutil.ReadAll(stdin)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+//checkStdin returns an error if more than one of the arguments is -, since stdin can only be read once.
+func checkStdin(args []string) error {
+	n := 0
+	for _, arg := range args {
+		if arg == "-" {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("- can only be given once, since stdin can only be read once")
+	}
+	return nil
+}
+
+//load reads, simplifies and translates a RelaxNG grammar.
+//If define is not empty, the start pattern is replaced with a reference to the define.
+//The returned error is a *schemaError if the grammar was read, but is not valid.
+func load(filename string, define string, stdin io.Reader) (g *relaxng.Grammar, relapse *ast.Grammar, err error) {
+	g, err = simplify(filename, define, stdin)
+	if err != nil {
+		return nil, nil, err
+	}
+	relapse, err = translate(g)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, relapse, nil
+}
+
+//translate translates a simplified grammar, returning a *schemaError if it cannot be translated.
+func translate(g *relaxng.Grammar) (relapse *ast.Grammar, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &schemaError{fmt.Errorf("%v", r)}
+		}
+	}()
+	relapse, err = relaxng.Translate(g)
+	if err != nil {
+		return nil, &schemaError{err}
+	}
+	return relapse, nil
+}
+
+//simplify loads a grammar without translating it, so that grammars with datatypes that cannot be translated are also accepted.
+func simplify(filename string, define string, stdin io.Reader) (g *relaxng.Grammar, err error) {
+	buf, err := readFile(filename, stdin)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = &schemaError{fmt.Errorf("%v", r)}
+		}
+	}()
+	switch {
+	case filename == "-" && len(define) > 0:
+		g, err = relaxng.SimplifyFragment(buf, define)
+	case filename == "-":
+		g, err = relaxng.Simplify(buf)
+	case len(define) > 0:
+		g, err = relaxng.SimplifyFragmentFile(filename, define)
+	default:
+		g, err = relaxng.SimplifyFile(filename)
+	}
+	if err != nil {
+		return nil, &schemaError{err}
+	}
+	relaxng.RemoveTODOs(g)
+	return g, nil
+}
+
+type schemaError struct {
+	err error
+}
+
+func (this *schemaError) Error() string {
+	return this.err.Error()
+}
+
+//exitCode returns exitInvalid for schema errors and exitError for any other error.
+func exitCode(err error) int {
+	if _, ok := err.(*schemaError); ok {
+		return exitInvalid
+	}
+	return exitError
+}
+
+func validate(g *relaxng.Grammar, relapse *ast.Grammar, filename string, buf []byte) (r *relaxng.Report) {
+	defer func() {
+		if e := recover(); e != nil {
+			r = &relaxng.Report{File: filename, Findings: []*relaxng.Finding{
+				{Line: 1, Column: 1, Path: "/", Rule: "start", Message: fmt.Sprintf("%v", e)},
+			}}
+		}
+	}()
+	return relaxng.ValidateReport(g, relapse, filename, buf)
+}
+
+func writeResults(w io.Writer, format string, results []result) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		return enc.Encode(results)
+	}
+	for _, r := range results {
+		if r.Valid {
+			fmt.Fprintf(w, "%s: valid\n", r.File)
+		} else {
+			fmt.Fprintf(w, "%s: invalid: %s\n", r.File, r.Error)
+		}
+	}
+	return nil
+}
+
+var reportFormats = []string{"text", "json", "jsonl", "junit", "sarif"}
+
+func writeReports(w io.Writer, format string, schema string, reports []*relaxng.Report) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		return enc.Encode(reports)
+	case "jsonl":
+		return relaxng.WriteJSONLines(w, reports)
+	case "junit":
+		return relaxng.WriteJUnit(w, schema, reports)
+	case "sarif":
+		return relaxng.WriteSARIF(w, schema, reports)
+	}
+	for _, r := range reports {
+		if r.Valid {
+			fmt.Fprintf(w, "%s: valid\n", r.File)
+		}
+		for _, f := range r.Findings {
+			fmt.Fprintf(w, "%s:%d:%d: %s: %s (%s)\n", r.File, f.Line, f.Column, f.Path, f.Message, f.Rule)
+		}
+	}
+	return nil
+}
+
+func checkFormat(format string, formats []string, stderr io.Writer) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	fmt.Fprintf(stderr, "unknown format %q, expected one of %s\n", format, strings.Join(formats, ", "))
+	return false
+}
+
+func validateCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("validate", stderr)
+	format := fs.String("format", "text", "output format: "+strings.Join(reportFormats, ", "))
+	workers := fs.Int("j", runtime.NumCPU(), "number of documents to validate in parallel")
+	define := fs.String("define", "", "validate fragments against the named define, instead of the start pattern")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() < 2 || !checkFormat(*format, reportFormats, stderr) {
+		usage(stderr)
+		return exitError
+	}
+	if err := checkStdin(fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	schema := fs.Arg(0)
+	g, relapse, err := load(schema, *define, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", schema, err)
+		return exitError
+	}
+	code := exitValid
+	filenames, err := expand(fs.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		code = exitError
+	}
+	var files []string
+	for _, filename := range filenames {
+		if filename != "-" {
+			files = append(files, filename)
+		}
+	}
+	fileReports, err := relaxng.ReportAll(ctx, g, relapse, files, *workers)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		code = exitError
+	}
+	reports := make([]*relaxng.Report, 0, len(filenames))
+	for _, filename := range filenames {
+		if filename != "-" {
+			reports = append(reports, fileReports[0])
+			fileReports = fileReports[1:]
+			continue
+		}
+		buf, err := readFile(filename, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			code = exitError
+			continue
+		}
+		reports = append(reports, validate(g, relapse, filename, buf))
+	}
+	for _, r := range reports {
+		if !r.Valid && code == exitValid {
+			code = exitInvalid
+		}
+	}
+	if err := writeReports(stdout, *format, schema, reports); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	return code
+}
+
+//expand replaces directories with the xml files that they contain
+//and returns an error for files that do not exist.
+func expand(args []string) ([]string, error) {
+	var filenames []string
+	var errs []string
+	for _, arg := range args {
+		if arg == "-" {
+			filenames = append(filenames, arg)
+			continue
+		}
+		info, err := os.Stat(arg)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !info.IsDir() {
+			filenames = append(filenames, arg)
+			continue
+		}
+		if err := filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(path) == ".xml" {
+				filenames = append(filenames, path)
+			}
+			return nil
+		}); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return filenames, errors.New(strings.Join(errs, "\n"))
+	}
+	return filenames, nil
+}
+
+func checkCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("check", stderr)
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() < 1 || !checkFormat(*format, []string{"text", "json"}, stderr) {
+		usage(stderr)
+		return exitError
+	}
+	if err := checkStdin(fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	code := exitValid
+	results := make([]result, 0, fs.NArg())
+	for _, schema := range fs.Args() {
+		r := result{File: schema, Valid: true}
+		if _, _, err := load(schema, "", stdin); err != nil {
+			if exitCode(err) == exitError {
+				fmt.Fprintf(stderr, "%v\n", err)
+				code = exitError
+				continue
+			}
+			r.Valid = false
+			r.Error = err.Error()
+			if code == exitValid {
+				code = exitInvalid
+			}
+		}
+		results = append(results, r)
+	}
+	if err := writeResults(stdout, *format, results); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	return code
+}
+
+func translateCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("translate", stderr)
+	return printCmd(fs, args, stdin, stdout, stderr, func(g *relaxng.Grammar) (string, error) {
+		relapse, err := translate(g)
+		if err != nil {
+			return "", err
+		}
+		return relapse.String(), nil
+	})
+}
+
+func simplifyCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("simplify", stderr)
+	compact := fs.Bool("compact", false, "print the grammar in the compact syntax")
+	return printCmd(fs, args, stdin, stdout, stderr, func(g *relaxng.Grammar) (string, error) {
+		if *compact {
+			return g.Compact(), nil
+		}
+		return g.String(), nil
+	})
+}
+
+//printCmd simplifies a single grammar and prints it in the form returned by str.
+func printCmd(fs *flag.FlagSet, args []string, stdin io.Reader, stdout, stderr io.Writer, str func(*relaxng.Grammar) (string, error)) int {
+	define := fs.String("define", "", "replace the start pattern with a reference to the named define")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		usage(stderr)
+		return exitError
+	}
+	g, err := simplify(fs.Arg(0), *define, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitCode(err)
+	}
+	out, err := str(g)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitCode(err)
+	}
+	fmt.Fprintln(stdout, out)
+	return exitValid
+}
+
+func generateCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("generate", stderr)
+	define := fs.String("define", "", "generate a fragment for the named define, instead of the start pattern")
+	minimal := fs.Bool("minimal", false, "generate a document with as few elements as possible")
+	opts := relaxng.GenerateOptions{}
+	fs.Int64Var(&opts.Seed, "seed", 0, "seed of the random document")
+	fs.IntVar(&opts.MaxDepth, "depth", 8, "depth of elements after which the smallest content is generated")
+	fs.IntVar(&opts.MaxRepeat, "repeat", 3, "maximum number of repetitions of oneOrMore")
+	fs.IntVar(&opts.MaxElements, "elements", 100, "number of elements after which the smallest content is generated")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		usage(stderr)
+		return exitError
+	}
+	g, err := simplify(fs.Arg(0), *define, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitCode(err)
+	}
+	var doc []byte
+	if *minimal {
+		doc, err = relaxng.GenerateMinimal(g)
+	} else {
+		doc, err = relaxng.GenerateRandom(g, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitInvalid
+	}
+	fmt.Fprintf(stdout, "%s\n", doc)
+	return exitValid
+}
+
+func genGoCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("gen-go", stderr)
+	pkg := fs.String("package", "schema", "name of the generated package")
+	define := fs.String("define", "", "generate types for the named define, instead of the start pattern")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		usage(stderr)
+		return exitError
+	}
+	g, err := simplify(fs.Arg(0), *define, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitCode(err)
+	}
+	src, err := relaxng.GenerateGo(g, *pkg)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitError
+	}
+	stdout.Write(src)
+	return exitValid
+}
+
+func inferCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("infer", stderr)
+	opts := relaxng.InferOptions{}
+	fs.BoolVar(&opts.Datatypes, "datatypes", false, "infer xsd datatypes for text and attribute values")
+	fs.IntVar(&opts.MaxEnumeration, "enum", 8, "maximum number of distinct values that are inferred as an enumeration, where -1 disables enumerations")
+	compact := fs.Bool("compact", false, "print the grammar in the compact syntax")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() < 1 {
+		usage(stderr)
+		return exitError
+	}
+	if err := checkStdin(fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	filenames, err := expand(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	docs := make([][]byte, len(filenames))
+	for i, filename := range filenames {
+		docs[i], err = readFile(filename, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return exitError
+		}
+	}
+	g, err := relaxng.Infer(docs, opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitInvalid
+	}
+	if *compact {
+		fmt.Fprint(stdout, g.Compact())
+	} else {
+		fmt.Fprintln(stdout, g.String())
+	}
+	return exitValid
+}
+
+func fromXSDCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("from-xsd", stderr)
+	compact := fs.Bool("compact", false, "print the grammar in the compact syntax")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		usage(stderr)
+		return exitError
+	}
+	var g *relaxng.Grammar
+	var err error
+	if filename := fs.Arg(0); filename == "-" {
+		buf, rerr := readFile(filename, stdin)
+		if rerr != nil {
+			fmt.Fprintf(stderr, "%v\n", rerr)
+			return exitError
+		}
+		g, err = relaxng.FromXSD(buf)
+	} else {
+		g, err = relaxng.FromXSDFile(filename)
+	}
+	unsupported, ok := err.(*relaxng.UnsupportedError)
+	if err != nil && !ok {
+		fmt.Fprintf(stderr, "%v\n", err)
+		//A schema that was read, but could not be imported, is an invalid grammar, like in from-dtd.
+		if _, unreadable := err.(*os.PathError); unreadable {
+			return exitError
+		}
+		return exitInvalid
+	}
+	if *compact {
+		fmt.Fprint(stdout, g.Compact())
+	} else {
+		fmt.Fprintln(stdout, g.String())
+	}
+	if ok {
+		for _, feature := range unsupported.Features {
+			fmt.Fprintf(stderr, "unsupported: %s\n", feature)
+		}
+		return exitInvalid
+	}
+	return exitValid
+}
+
+func toXSDCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("to-xsd", stderr)
+	define := fs.String("define", "", "export the named define, instead of the start pattern")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		usage(stderr)
+		return exitError
+	}
+	g, err := simplify(fs.Arg(0), *define, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitCode(err)
+	}
+	xsd, approximations, err := relaxng.ToXSD(g)
+	for _, a := range approximations {
+		fmt.Fprintf(stderr, "approximated: %s\n", a)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitInvalid
+	}
+	stdout.Write(xsd)
+	if len(approximations) > 0 {
+		return exitInvalid
+	}
+	return exitValid
+}
+
+func compatibleCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("compatible", stderr)
+	define := fs.String("define", "", "compare the named define, instead of the start pattern")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 2 {
+		usage(stderr)
+		return exitError
+	}
+	if err := checkStdin(fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	var gs [2]*relaxng.Grammar
+	for i := range gs {
+		g, err := simplify(fs.Arg(i), *define, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(i), err)
+			return exitCode(err)
+		}
+		gs[i] = g
+	}
+	c, err := relaxng.Compatible(gs[0], gs[1])
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	if c == nil {
+		fmt.Fprintf(stdout, "%s is compatible with %s\n", fs.Arg(1), fs.Arg(0))
+		return exitValid
+	}
+	fmt.Fprintf(stdout, "%s\n", c.Document)
+	for _, f := range c.Findings {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(1), f)
+	}
+	return exitInvalid
+}
+
+func diffCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("diff", stderr)
+	format := fs.String("format", "text", "output format: text or json")
+	define := fs.String("define", "", "compare the named define, instead of the start pattern")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 2 || !checkFormat(*format, []string{"text", "json"}, stderr) {
+		usage(stderr)
+		return exitError
+	}
+	if err := checkStdin(fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	var gs [2]*relaxng.Grammar
+	for i := range gs {
+		g, err := simplify(fs.Arg(i), *define, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(i), err)
+			return exitCode(err)
+		}
+		gs[i] = g
+	}
+	changes, err := relaxng.Diff(gs[0], gs[1])
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	if *format == "json" {
+		if changes == nil {
+			changes = []*relaxng.Change{}
+		}
+		if err := json.NewEncoder(stdout).Encode(changes); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return exitError
+		}
+	} else {
+		for _, c := range changes {
+			fmt.Fprintln(stdout, c)
+		}
+	}
+	if len(changes) > 0 {
+		return exitInvalid
+	}
+	return exitValid
+}
+
+func docCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("doc", stderr)
+	opts := relaxng.DocOptions{}
+	fs.StringVar(&opts.Format, "format", "html", "output format: html or markdown")
+	fs.StringVar(&opts.Title, "title", "", "title of the documentation, instead of the name of the schema")
+	define := fs.String("define", "", "document the named define, instead of the start pattern")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 || !checkFormat(opts.Format, []string{"html", "markdown"}, stderr) {
+		usage(stderr)
+		return exitError
+	}
+	g, err := simplify(fs.Arg(0), *define, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitCode(err)
+	}
+	if len(opts.Title) == 0 && fs.Arg(0) != "-" {
+		opts.Title = filepath.Base(fs.Arg(0))
+	}
+	doc, err := relaxng.GenerateDoc(g, opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitError
+	}
+	stdout.Write(doc)
+	return exitValid
+}
+
+func fromDTDCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("from-dtd", stderr)
+	opts := relaxng.DTDOptions{}
+	fs.StringVar(&opts.Root, "root", "", "the name of the document element, instead of any declared element")
+	compact := fs.Bool("compact", false, "print the simplified grammar in the compact syntax")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		usage(stderr)
+		return exitError
+	}
+	buf, err := readFile(fs.Arg(0), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	if fs.Arg(0) != "-" {
+		opts.Filename = fs.Arg(0)
+	}
+	var out string
+	if *compact {
+		var g *relaxng.Grammar
+		g, err = relaxng.FromDTD(buf, opts)
+		if g != nil {
+			out = g.Compact()
+		}
+	} else {
+		var rng []byte
+		rng, err = relaxng.DTDToRelaxNG(buf, opts)
+		out = string(rng)
+	}
+	unsupported, ok := err.(*relaxng.UnsupportedError)
+	if err != nil && !ok {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitInvalid
+	}
+	fmt.Fprint(stdout, out)
+	if ok {
+		for _, feature := range unsupported.Features {
+			fmt.Fprintf(stderr, "unsupported: %s\n", feature)
+		}
+		return exitInvalid
+	}
+	return exitValid
+}
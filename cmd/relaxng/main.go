@@ -0,0 +1,142 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//Command relaxng validates XML documents against RelaxNG grammars
+//and translates, simplifies and checks the grammars themselves.
+//
+//	relaxng validate [-format text|json|jsonl|junit|sarif] [-j n] [-define name] schema.rng file.xml|dir...
+//	relaxng translate [-define name] schema.rng
+//	relaxng simplify [-compact] [-define name] schema.rng
+//	relaxng check [-format text|json] schema.rng...
+//	relaxng generate [-minimal] [-seed n] [-depth n] [-repeat n] [-elements n] [-define name] schema.rng
+//	relaxng gen-go [-package name] [-define name] schema.rng
+//	relaxng infer [-datatypes] [-enum n] [-compact] file.xml|dir...
+//	relaxng from-xsd [-compact] schema.xsd
+//	relaxng from-dtd [-root name] [-compact] schema.dtd
+//	relaxng to-xsd [-define name] schema.rng
+//	relaxng compatible [-define name] old.rng new.rng
+//	relaxng diff [-format text|json] [-define name] old.rng new.rng
+//	relaxng doc [-format html|markdown] [-title title] [-define name] schema.rng
+//
+//Validation reports the line, column and element path of every violation,
+//together with the define and pattern that failed.
+//The jsonl format writes a json object per document,
+//junit writes a test case per document
+//and sarif writes a result per violation.
+//
+//With -define, documents are validated against the named define instead of the start pattern,
+//so that fragments, like a single record from a large document, can be validated.
+//
+//Directories are searched for files with the xml extension,
+//which are validated in parallel by -j workers.
+//An interrupt stops validation and the remaining documents are reported as not validated.
+//
+//Generate writes a valid sample document, which is random for the seed, unless -minimal is given.
+//Gen-go writes Go types, with encoding/xml struct tags, for the elements in the grammar.
+//Infer writes a grammar that all the documents are valid against.
+//From-xsd writes the grammar for a W3C XML Schema and lists the features that could not be imported exactly.
+//From-dtd writes the grammar for a DTD, annotated with the attribute defaults, unless -compact is given,
+//and lists the attribute types that were approximated.
+//To-xsd writes a W3C XML Schema for the grammar and lists the constructs that were approximated.
+//Compatible checks whether every document that is valid against the old grammar is valid against the new grammar
+//and otherwise writes a counterexample.
+//Diff writes the elements and attributes that were added or removed
+//and the cardinalities, datatypes and enumerations that changed between two grammars.
+//Doc writes documentation of every element in the grammar, including the text of its a:documentation annotations.
+//
+//Grammars do not need to be simplified beforehand.
+//A file named - is read from stdin.
+//
+//The exit code is 0 when everything is valid,
+//1 when a document or grammar is invalid
+//and 2 for usage errors, files that could not be read
+//and grammars that are invalid when validating documents.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+const (
+	exitValid   = 0
+	exitInvalid = 1
+	exitError   = 2
+)
+
+var commands = map[string]func(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int{
+	"validate":   validateCmd,
+	"translate":  translateCmd,
+	"simplify":   simplifyCmd,
+	"check":      checkCmd,
+	"generate":   generateCmd,
+	"gen-go":     genGoCmd,
+	"infer":      inferCmd,
+	"from-xsd":   fromXSDCmd,
+	"from-dtd":   fromDTDCmd,
+	"to-xsd":     toXSDCmd,
+	"compatible": compatibleCmd,
+	"diff":       diffCmd,
+	"doc":        docCmd,
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, `usage:
+	relaxng validate [-format text|json|jsonl|junit|sarif] [-j n] [-define name] schema.rng file.xml|dir...
+	relaxng translate [-define name] schema.rng
+	relaxng simplify [-compact] [-define name] schema.rng
+	relaxng check [-format text|json] schema.rng...
+	relaxng generate [-minimal] [-seed n] [-depth n] [-repeat n] [-elements n] [-define name] schema.rng
+	relaxng gen-go [-package name] [-define name] schema.rng
+	relaxng infer [-datatypes] [-enum n] [-compact] file.xml|dir...
+	relaxng from-xsd [-compact] schema.xsd
+	relaxng from-dtd [-root name] [-compact] schema.dtd
+	relaxng to-xsd [-define name] schema.rng
+	relaxng compatible [-define name] old.rng new.rng
+	relaxng diff [-format text|json] [-define name] old.rng new.rng
+	relaxng doc [-format html|markdown] [-title title] [-define name] schema.rng
+`)
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitError
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+			usage(stdout)
+			return exitValid
+		}
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		usage(stderr)
+		return exitError
+	}
+	return cmd(ctx, args[1:], stdin, stdout, stderr)
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
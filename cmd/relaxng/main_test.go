@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/katydid/relaxng"
+)
+
+//testDatatypesSchema uses a datatype library that cannot be translated to relapse.
+var testDatatypesSchema = `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0" datatypeLibrary="http://example.com/datatypes">
+	<element name="item"><data type="sku"/></element>
+</element>`
+
+var testSchema = `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+	<attribute name="status">
+		<choice>
+			<value>open</value>
+			<value>closed</value>
+		</choice>
+	</attribute>
+	<oneOrMore>
+		<element name="item"><text/></element>
+	</oneOrMore>
+</element>`
+
+func setup(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "relaxng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runCmd(dir string, stdin string, args ...string) (int, string, string) {
+	for i, arg := range args {
+		if strings.HasSuffix(arg, ".rng") || strings.HasSuffix(arg, ".xml") || strings.HasSuffix(arg, ".xsd") || strings.HasSuffix(arg, ".dtd") {
+			args[i] = filepath.Join(dir, arg)
+		}
+	}
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	code := run(context.Background(), args, strings.NewReader(stdin), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestValidate(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.rng":   testSchema,
+		"valid.xml":   `<order status="open"><item>a</item></order>`,
+		"invalid.xml": `<order status="pending"><item>a</item></order>`,
+	})
+	defer os.RemoveAll(dir)
+	if code, stdout, stderr := runCmd(dir, "", "validate", "order.rng", "valid.xml"); code != exitValid {
+		t.Fatalf("expected valid, got %d: %s%s", code, stdout, stderr)
+	}
+	code, stdout, _ := runCmd(dir, "", "validate", "order.rng", "valid.xml", "invalid.xml")
+	if code != exitInvalid {
+		t.Fatalf("expected invalid, got %d", code)
+	}
+	if !strings.Contains(stdout, "valid.xml: valid") || !strings.Contains(stdout, "invalid.xml:1:1: /order[1]: attribute status") {
+		t.Fatalf("unexpected output %s", stdout)
+	}
+	if code, _, _ := runCmd(dir, "", "validate", "order.rng", "missing.xml"); code != exitError {
+		t.Fatalf("expected an error for a missing file, got %d", code)
+	}
+	if code, _, _ := runCmd(dir, `<order status="closed"><item/></order>`, "validate", "order.rng", "-"); code != exitValid {
+		t.Fatalf("expected stdin to be valid, got %d", code)
+	}
+	if code, _, stderr := runCmd(dir, `<order status="closed"><item/></order>`, "validate", "order.rng", "-", "-"); code != exitError || !strings.Contains(stderr, "stdin") {
+		t.Fatalf("expected stdin to be rejected twice %d: %s", code, stderr)
+	}
+}
+
+func TestValidateDir(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.rng": testSchema,
+		"a.xml":     `<order status="open"><item>a</item></order>`,
+		"b.xml":     `<order status="closed"><item>b</item></order>`,
+		"c.txt":     `not xml`,
+	})
+	defer os.RemoveAll(dir)
+	code, stdout, stderr := runCmd(dir, "", "validate", "-j", "2", "order.rng", dir)
+	if code != exitValid {
+		t.Fatalf("expected valid, got %d: %s%s", code, stdout, stderr)
+	}
+	if strings.Count(stdout, ": valid") != 2 || strings.Index(stdout, "a.xml") > strings.Index(stdout, "b.xml") {
+		t.Fatalf("expected both xml files in order, got %s", stdout)
+	}
+}
+
+func TestValidateDefine(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"feed.rng": `<grammar xmlns="http://relaxng.org/ns/structure/1.0">
+			<start><element name="feed"><zeroOrMore><ref name="item"/></zeroOrMore></element></start>
+			<define name="item"><element name="item"><text/></element></define>
+		</grammar>`,
+		"item.xml": `<item>a</item>`,
+	})
+	defer os.RemoveAll(dir)
+	if code, _, _ := runCmd(dir, "", "validate", "feed.rng", "item.xml"); code != exitInvalid {
+		t.Fatalf("expected an item to be an invalid feed, got %d", code)
+	}
+	if code, stdout, stderr := runCmd(dir, "", "validate", "-define", "item", "feed.rng", "item.xml"); code != exitValid {
+		t.Fatalf("expected a valid item, got %d: %s%s", code, stdout, stderr)
+	}
+	if code, _, _ := runCmd(dir, "", "validate", "-define", "missing", "feed.rng", "item.xml"); code != exitError {
+		t.Fatalf("expected an error for a missing define, got %d", code)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.rng":   testSchema,
+		"invalid.xml": `<order status="open"></order>`,
+	})
+	defer os.RemoveAll(dir)
+	code, stdout, _ := runCmd(dir, "", "validate", "-format", "json", "order.rng", "invalid.xml")
+	if code != exitInvalid {
+		t.Fatalf("expected invalid, got %d", code)
+	}
+	var reports []*relaxng.Report
+	if err := json.Unmarshal([]byte(stdout), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Valid || len(reports[0].Findings) != 1 {
+		t.Fatalf("unexpected reports %#v", reports)
+	}
+	if f := reports[0].Findings[0]; f.Rule != "order" || len(f.Pattern) == 0 {
+		t.Fatalf("unexpected finding %#v", f)
+	}
+}
+
+func TestValidateFormats(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.rng":   testSchema,
+		"valid.xml":   `<order status="open"><item>a</item></order>`,
+		"invalid.xml": "<order status=\"open\">\n<note/><item>a</item></order>",
+	})
+	defer os.RemoveAll(dir)
+	expected := map[string][]string{
+		"jsonl": {`"valid":true`, `"line":2`},
+		"junit": {`<testsuite name=`, `failures="1"`, `<failure message="element note is not allowed here`},
+		"sarif": {`"version":"2.1.0"`, `"ruleId":"order"`, `"startLine":2`},
+	}
+	for format, contains := range expected {
+		code, stdout, stderr := runCmd(dir, "", "validate", "-format", format, "order.rng", "valid.xml", "invalid.xml")
+		if code != exitInvalid {
+			t.Fatalf("%s: expected invalid, got %d: %s", format, code, stderr)
+		}
+		for _, c := range contains {
+			if !strings.Contains(stdout, c) {
+				t.Fatalf("%s: expected %s in %s", format, c, stdout)
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.rng": testSchema,
+		"bad.rng":   `<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><ref name="b"/></element>`,
+	})
+	defer os.RemoveAll(dir)
+	if code, stdout, _ := runCmd(dir, "", "check", "order.rng"); code != exitValid {
+		t.Fatalf("expected a valid grammar, got %d: %s", code, stdout)
+	}
+	if code, _, _ := runCmd(dir, "", "check", "order.rng", "bad.rng"); code != exitInvalid {
+		t.Fatalf("expected an invalid grammar, got %d", code)
+	}
+	if code, _, _ := runCmd(dir, "", "validate", "bad.rng", "order.rng"); code != exitError {
+		t.Fatalf("expected an invalid grammar to be an error when validating, got %d", code)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	dir := setup(t, map[string]string{"order.rng": testSchema})
+	defer os.RemoveAll(dir)
+	code, stdout, _ := runCmd(dir, "", "simplify", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, "<define") {
+		t.Fatalf("unexpected simplified grammar %d: %s", code, stdout)
+	}
+	code, stdout, _ = runCmd(dir, "", "simplify", "-compact", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, "order = element order {") {
+		t.Fatalf("unexpected compact grammar %d: %s", code, stdout)
+	}
+	code, stdout, _ = runCmd(dir, "", "translate", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, "elem_order") {
+		t.Fatalf("unexpected relapse grammar %d: %s", code, stdout)
+	}
+}
+
+func TestPrintUntranslatable(t *testing.T) {
+	dir := setup(t, map[string]string{"order.rng": testDatatypesSchema})
+	defer os.RemoveAll(dir)
+	code, stdout, stderr := runCmd(dir, "", "simplify", "-compact", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, "order = element order {") {
+		t.Fatalf("unexpected compact grammar %d: %s%s", code, stdout, stderr)
+	}
+	if code, _, stderr := runCmd(dir, "", "translate", "order.rng"); code != exitInvalid || !strings.Contains(stderr, "datatypeLibrary") {
+		t.Fatalf("expected an untranslatable grammar %d: %s", code, stderr)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir := setup(t, map[string]string{"order.rng": testSchema})
+	defer os.RemoveAll(dir)
+	code, stdout, _ := runCmd(dir, "", "generate", "-minimal", "order.rng")
+	if code != exitValid || strings.TrimSpace(stdout) != `<order status="open"><item/></order>` {
+		t.Fatalf("unexpected minimal document %d: %s", code, stdout)
+	}
+	code, stdout, _ = runCmd(dir, "", "generate", "-seed", "3", "order.rng")
+	if code != exitValid {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	if code, _, _ := runCmd(dir, stdout, "validate", "order.rng", "-"); code != exitValid {
+		t.Fatalf("expected the generated document %s to be valid", stdout)
+	}
+}
+
+func TestGenerateUntranslatable(t *testing.T) {
+	dir := setup(t, map[string]string{"order.rng": testDatatypesSchema})
+	defer os.RemoveAll(dir)
+	code, stdout, stderr := runCmd(dir, "", "generate", "-minimal", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, "<item>") {
+		t.Fatalf("unexpected minimal document %d: %s%s", code, stdout, stderr)
+	}
+}
+
+func TestGenGo(t *testing.T) {
+	dir := setup(t, map[string]string{"order.rng": testSchema})
+	defer os.RemoveAll(dir)
+	code, stdout, _ := runCmd(dir, "", "gen-go", "-package", "orders", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, "package orders") || !strings.Contains(stdout, "type Order struct") {
+		t.Fatalf("unexpected go source %d: %s", code, stdout)
+	}
+}
+
+func TestGenGoUntranslatable(t *testing.T) {
+	dir := setup(t, map[string]string{"order.rng": testDatatypesSchema})
+	defer os.RemoveAll(dir)
+	code, stdout, stderr := runCmd(dir, "", "gen-go", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, "type Order struct") {
+		t.Fatalf("unexpected go source %d: %s%s", code, stdout, stderr)
+	}
+}
+
+func TestInfer(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"a.xml": `<order status="open"><item/></order>`,
+		"b.xml": `<order status="closed"><item/><item/></order>`,
+	})
+	defer os.RemoveAll(dir)
+	code, stdout, _ := runCmd(dir, "", "infer", "-compact", dir)
+	if code != exitValid || !strings.Contains(stdout, "order = element order { attribute status { text }, item+ }") {
+		t.Fatalf("unexpected inferred grammar %d: %s", code, stdout)
+	}
+}
+
+func TestFromXSD(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+			<xs:element name="order">
+				<xs:complexType>
+					<xs:sequence><xs:element name="item" type="xs:string" maxOccurs="unbounded"/></xs:sequence>
+				</xs:complexType>
+			</xs:element>
+		</xs:schema>`,
+		"nil.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:element name="a" nillable="true"/></xs:schema>`,
+		"bad.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:element name="a" type="missing"/></xs:schema>`,
+	})
+	defer os.RemoveAll(dir)
+	code, stdout, _ := runCmd(dir, "", "from-xsd", "-compact", "order.xsd")
+	if code != exitValid || !strings.Contains(stdout, "order = element order { item+ }") {
+		t.Fatalf("unexpected imported grammar %d: %s", code, stdout)
+	}
+	code, stdout, stderr := runCmd(dir, "", "from-xsd", "nil.xsd")
+	if code != exitInvalid || !strings.Contains(stdout, "<grammar") || !strings.Contains(stderr, "unsupported: element a: nillable") {
+		t.Fatalf("expected unsupported features %d: %s%s", code, stdout, stderr)
+	}
+	if code, _, _ := runCmd(dir, "", "from-xsd", "bad.xsd"); code != exitInvalid {
+		t.Fatalf("expected an invalid xsd, got %d", code)
+	}
+	if code, _, _ := runCmd(dir, "", "from-xsd", "missing.xsd"); code != exitError {
+		t.Fatalf("expected an unreadable xsd, got %d", code)
+	}
+}
+
+func TestFromDTD(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.dtd": `<!ELEMENT order (item+)>
+			<!ATTLIST order status (open|closed) "open">
+			<!ELEMENT item (#PCDATA)>`,
+		"bad.dtd": `<!ELEMENT order (item+)>`,
+		"tags.dtd": `<!ELEMENT order EMPTY>
+			<!ATTLIST order tag NMTOKEN #REQUIRED>`,
+	})
+	defer os.RemoveAll(dir)
+	code, stdout, _ := runCmd(dir, "", "from-dtd", "-root", "order", "-compact", "order.dtd")
+	if code != exitValid || !strings.Contains(stdout, `order = element order { attribute status { "open" | "closed" }?, item+ }`) {
+		t.Fatalf("unexpected imported grammar %d: %s", code, stdout)
+	}
+	code, stdout, _ = runCmd(dir, "", "from-dtd", "order.dtd")
+	if code != exitValid || !strings.Contains(stdout, `a:defaultValue="open"`) {
+		t.Fatalf("expected an annotated grammar %d: %s", code, stdout)
+	}
+	if code, _, _ := runCmd(dir, "", "from-dtd", "bad.dtd"); code != exitInvalid {
+		t.Fatalf("expected an invalid dtd, got %d", code)
+	}
+	code, stdout, stderr := runCmd(dir, "", "from-dtd", "-compact", "tags.dtd")
+	if code != exitInvalid || !strings.Contains(stdout, "attribute tag { token }") || !strings.Contains(stderr, "unsupported: NMTOKEN attributes") {
+		t.Fatalf("expected the NMTOKEN approximation %d: %s%s", code, stdout, stderr)
+	}
+}
+
+func TestToXSD(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.rng": `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0" datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+			<oneOrMore><element name="item"><data type="int"/></element></oneOrMore>
+		</element>`,
+		"interleave.rng": `<element name="a" xmlns="http://relaxng.org/ns/structure/1.0">
+			<interleave>
+				<oneOrMore><element name="b"><empty/></element></oneOrMore>
+				<element name="c"><empty/></element>
+			</interleave>
+		</element>`,
+	})
+	defer os.RemoveAll(dir)
+	code, stdout, _ := runCmd(dir, "", "to-xsd", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, `<xs:element maxOccurs="unbounded" name="item" type="item"/>`) {
+		t.Fatalf("unexpected exported schema %d: %s", code, stdout)
+	}
+	code, stdout, stderr := runCmd(dir, "", "to-xsd", "interleave.rng")
+	if code != exitInvalid || !strings.Contains(stdout, "<xs:schema") || !strings.Contains(stderr, "approximated: ") {
+		t.Fatalf("expected approximations %d: %s%s", code, stdout, stderr)
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"v1.rng": `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+			<optional><attribute name="id"/></optional>
+			<zeroOrMore><element name="item"><text/></element></zeroOrMore>
+		</element>`,
+		"v2.rng": `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+			<optional><attribute name="id"/></optional>
+			<zeroOrMore><element name="item"><text/></element></zeroOrMore>
+			<optional><element name="note"><text/></element></optional>
+		</element>`,
+		"v3.rng": `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+			<attribute name="id"/>
+			<zeroOrMore><element name="item"><text/></element></zeroOrMore>
+		</element>`,
+	})
+	defer os.RemoveAll(dir)
+	if code, stdout, stderr := runCmd(dir, "", "compatible", "v1.rng", "v2.rng"); code != exitValid {
+		t.Fatalf("expected compatible grammars %d: %s%s", code, stdout, stderr)
+	}
+	code, stdout, stderr := runCmd(dir, "", "compatible", "v1.rng", "v3.rng")
+	if code != exitInvalid || stdout != "<order/>\n" || !strings.Contains(stderr, "missing attribute id") {
+		t.Fatalf("expected a counterexample %d: %s%s", code, stdout, stderr)
+	}
+	code, _, stderr = runCmd(dir, "<element name=\"a\" xmlns=\"http://relaxng.org/ns/structure/1.0\"><empty/></element>", "compatible", "-", "-")
+	if code != exitError || !strings.Contains(stderr, "stdin") {
+		t.Fatalf("expected stdin to be rejected twice %d: %s", code, stderr)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"v1.rng": `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+			<optional><attribute name="id"/></optional>
+			<zeroOrMore><element name="item"><text/></element></zeroOrMore>
+		</element>`,
+		"v2.rng": `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+			<attribute name="id"/>
+			<oneOrMore><element name="item"><text/></element></oneOrMore>
+		</element>`,
+	})
+	defer os.RemoveAll(dir)
+	if code, stdout, stderr := runCmd(dir, "", "diff", "v1.rng", "v1.rng"); code != exitValid || len(stdout) > 0 {
+		t.Fatalf("expected no changes %d: %s%s", code, stdout, stderr)
+	}
+	code, stdout, _ := runCmd(dir, "", "diff", "v1.rng", "v2.rng")
+	want := "/order/@id: attribute id changed from optional to required\n/order/item: cardinality of element item changed from * to +\n"
+	if code != exitInvalid || stdout != want {
+		t.Fatalf("unexpected changes %d: %s", code, stdout)
+	}
+	code, stdout, _ = runCmd(dir, "", "diff", "-format", "json", "v1.rng", "v2.rng")
+	if code != exitInvalid || !strings.HasPrefix(stdout, `[{"kind":"cardinality-changed","path":"/order/@id","old":"?","new":"1"`) {
+		t.Fatalf("unexpected json %d: %s", code, stdout)
+	}
+	if code, _, stderr := runCmd(dir, "", "diff", "-", "-"); code != exitError || !strings.Contains(stderr, "stdin") {
+		t.Fatalf("expected stdin to be rejected twice %d: %s", code, stderr)
+	}
+}
+
+func TestDoc(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"order.rng": `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0"
+			xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0">
+			<a:documentation>An order of items.</a:documentation>
+			<zeroOrMore><element name="item"><text/></element></zeroOrMore>
+		</element>`,
+	})
+	defer os.RemoveAll(dir)
+	code, stdout, stderr := runCmd(dir, "", "doc", "order.rng")
+	if code != exitValid || !strings.Contains(stdout, "<title>order.rng</title>") || !strings.Contains(stdout, "<p>An order of items.</p>") {
+		t.Fatalf("unexpected html %d: %s%s", code, stdout, stderr)
+	}
+	code, stdout, stderr = runCmd(dir, "", "doc", "-format", "markdown", "-title", "Orders", "order.rng")
+	if code != exitValid || !strings.HasPrefix(stdout, "# Orders\n") || !strings.Contains(stdout, "Used in: [order](#order)\n") {
+		t.Fatalf("unexpected markdown %d: %s%s", code, stdout, stderr)
+	}
+	if code, _, _ := runCmd(dir, "", "doc", "-format", "pdf", "order.rng"); code != exitError {
+		t.Fatalf("expected a usage error, got %d", code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	if code, _, _ := runCmd("", ""); code != exitError {
+		t.Fatalf("expected a usage error, got %d", code)
+	}
+	if code, _, _ := runCmd("", "", "unknown"); code != exitError {
+		t.Fatalf("expected a usage error, got %d", code)
+	}
+	if code, _, _ := runCmd("", "", "validate", "-format", "xml", "a.rng", "b.xml"); code != exitError {
+		t.Fatalf("expected a usage error, got %d", code)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+//xsdDatatypes is the datatype library of the W3C XML Schema datatypes.
+const xsdDatatypes = "http://www.w3.org/2001/XMLSchema-datatypes"
+
+//compactKeywords are the keywords of the compact syntax, which have to be escaped when used as identifiers.
+var compactKeywords = map[string]bool{
+	"attribute": true, "default": true, "datatypes": true, "div": true, "element": true,
+	"empty": true, "external": true, "grammar": true, "include": true, "inherit": true,
+	"list": true, "mixed": true, "namespace": true, "notAllowed": true, "parent": true,
+	"start": true, "string": true, "text": true, "token": true,
+}
+
+//Compact returns the simplified Grammar in the RelaxNG compact syntax.
+func (this *Grammar) Compact() string {
+	w := &compactWriter{
+		namespaces: map[string]string{"http://www.w3.org/XML/1998/namespace": "xml"},
+		libraries:  map[string]string{xsdDatatypes: "xsd"},
+	}
+	body := bytes.NewBuffer(nil)
+	fmt.Fprintf(body, "start = %s\n", w.pattern(this.Start))
+	for _, d := range this.Define {
+		fmt.Fprintf(body, "%s = element %s { %s }\n", compactIdentifier(d.Name), w.nameClass(d.Element.Left), w.pattern(d.Element.Right))
+	}
+	buf := bytes.NewBuffer(nil)
+	for _, ns := range w.nsOrder {
+		fmt.Fprintf(buf, "namespace %s = %s\n", w.namespaces[ns], compactLiteral(ns))
+	}
+	for _, lib := range w.libOrder {
+		fmt.Fprintf(buf, "datatypes %s = %s\n", w.libraries[lib], compactLiteral(lib))
+	}
+	if buf.Len() > 0 {
+		buf.WriteString("\n")
+	}
+	buf.Write(body.Bytes())
+	return buf.String()
+}
+
+type compactWriter struct {
+	namespaces map[string]string
+	nsOrder    []string
+	libraries  map[string]string
+	libOrder   []string
+}
+
+func compactIdentifier(name string) string {
+	if compactKeywords[name] {
+		return `\` + name
+	}
+	return name
+}
+
+//compactLiteral quotes the string, escaping the characters that cannot appear inside a literal.
+func compactLiteral(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\x{5C}`, `"`, `\x{22}`, "\n", `\x{A}`, "\r", `\x{D}`).Replace(s) + `"`
+}
+
+func (this *compactWriter) prefix(ns string) string {
+	if p, ok := this.namespaces[ns]; ok {
+		return p
+	}
+	p := fmt.Sprintf("ns%d", len(this.nsOrder)+1)
+	this.namespaces[ns] = p
+	this.nsOrder = append(this.nsOrder, ns)
+	return p
+}
+
+func (this *compactWriter) datatype(library, typ string) string {
+	if len(library) == 0 {
+		if len(typ) == 0 {
+			return "token"
+		}
+		return typ
+	}
+	p, ok := this.libraries[library]
+	if !ok {
+		p = fmt.Sprintf("dt%d", len(this.libOrder)+1)
+		this.libraries[library] = p
+		this.libOrder = append(this.libOrder, library)
+	}
+	return p + ":" + typ
+}
+
+func (this *compactWriter) nameClass(n *NameOrPattern) string {
+	switch {
+	case n.Choice != nil:
+		return this.nameClass(n.Choice.Left) + " | " + this.nameClass(n.Choice.Right)
+	case n.AnyName != nil:
+		if n.AnyName.Except != nil {
+			return "* - (" + this.nameClass(n.AnyName.Except) + ")"
+		}
+		return "*"
+	case n.NsName != nil:
+		s := this.prefix(n.NsName.Ns) + ":*"
+		if n.NsName.Except != nil {
+			s += " - (" + this.nameClass(n.NsName.Except) + ")"
+		}
+		return s
+	case n.Name != nil:
+		if len(n.Name.Ns) > 0 {
+			return this.prefix(n.Name.Ns) + ":" + n.Name.Text
+		}
+		return compactIdentifier(n.Name.Text)
+	}
+	return ""
+}
+
+//optionalPattern returns the pattern that is in a choice with empty.
+func optionalPattern(p *NameOrPattern) (*NameOrPattern, bool) {
+	if p.Choice == nil {
+		return nil, false
+	}
+	if p.Choice.Left.Empty != nil {
+		return p.Choice.Right, true
+	}
+	if p.Choice.Right.Empty != nil {
+		return p.Choice.Left, true
+	}
+	return nil, false
+}
+
+func (this *compactWriter) pattern(p *NameOrPattern) string {
+	switch {
+	case p.NotAllowed != nil:
+		return "notAllowed"
+	case p.Empty != nil:
+		return "empty"
+	case p.Text != nil:
+		return "text"
+	case p.Data != nil:
+		s := this.datatype(p.Data.DatatypeLibrary, p.Data.Type)
+		if len(p.Data.Param) > 0 {
+			params := make([]string, len(p.Data.Param))
+			for i, param := range p.Data.Param {
+				params[i] = compactIdentifier(param.Name) + " = " + compactLiteral(param.Text)
+			}
+			s += " { " + strings.Join(params, " ") + " }"
+		}
+		if p.Data.Except != nil {
+			s += " - (" + this.pattern(p.Data.Except) + ")"
+		}
+		return s
+	case p.Value != nil:
+		lit := compactLiteral(p.Value.Text)
+		if len(p.Value.DatatypeLibrary) == 0 && (len(p.Value.Type) == 0 || p.Value.Type == "token") {
+			return lit
+		}
+		return this.datatype(p.Value.DatatypeLibrary, p.Value.Type) + " " + lit
+	case p.List != nil:
+		return "list { " + this.pattern(p.List.NameOrPattern) + " }"
+	case p.Attribute != nil:
+		return "attribute " + this.nameClass(p.Attribute.Left) + " { " + this.pattern(p.Attribute.Right) + " }"
+	case p.Ref != nil:
+		return compactIdentifier(p.Ref.Name)
+	case p.OneOrMore != nil:
+		return this.operand(p.OneOrMore.NameOrPattern) + "+"
+	case p.Choice != nil:
+		if o, ok := optionalPattern(p); ok {
+			if o.OneOrMore != nil {
+				return this.operand(o.OneOrMore.NameOrPattern) + "*"
+			}
+			return this.operand(o) + "?"
+		}
+		return this.child(p.Choice.Left, p) + " | " + this.child(p.Choice.Right, p)
+	case p.Group != nil:
+		return this.child(p.Group.Left, p) + ", " + this.child(p.Group.Right, p)
+	case p.Interleave != nil:
+		return this.child(p.Interleave.Left, p) + " & " + this.child(p.Interleave.Right, p)
+	}
+	return ""
+}
+
+//binaryOperator returns the operator of a choice, group or interleave that is not printed with a postfix operator.
+func binaryOperator(p *NameOrPattern) string {
+	switch {
+	case p.Choice != nil:
+		if _, ok := optionalPattern(p); ok {
+			return ""
+		}
+		return "|"
+	case p.Group != nil:
+		return ","
+	case p.Interleave != nil:
+		return "&"
+	}
+	return ""
+}
+
+//child returns the pattern, which is an operand of the binary parent, in parentheses if required.
+func (this *compactWriter) child(p *NameOrPattern, parent *NameOrPattern) string {
+	op := binaryOperator(p)
+	if len(op) == 0 && (p.Data == nil || p.Data.Except == nil) || op == binaryOperator(parent) {
+		return this.pattern(p)
+	}
+	return "(" + this.pattern(p) + ")"
+}
+
+//operand returns the pattern, which is an operand of a postfix operator, in parentheses if required.
+func (this *compactWriter) operand(p *NameOrPattern) string {
+	if p.OneOrMore != nil || p.Choice != nil || p.Group != nil || p.Interleave != nil || p.Data != nil && p.Data.Except != nil {
+		return "(" + this.pattern(p) + ")"
+	}
+	return this.pattern(p)
+}
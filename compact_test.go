@@ -0,0 +1,43 @@
+package relaxng
+
+import (
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	g, err := Simplify([]byte(`<grammar xmlns="http://relaxng.org/ns/structure/1.0"
+		datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+		<start>
+			<element name="text">
+				<attribute name="lang" ns="http://www.w3.org/XML/1998/namespace"><value type="token" datatypeLibrary="">en</value></attribute>
+				<zeroOrMore>
+					<choice>
+						<element name="a"><data type="string"><param name="maxLength">3</param><except><value>bad</value></except></data></element>
+						<group>
+							<element name="b"><value type="string" datatypeLibrary="">say "hi"</value></element>
+							<element name="c"><empty/></element>
+						</group>
+					</choice>
+				</zeroOrMore>
+				<oneOrMore>
+					<element><anyName><except><nsName ns="http://example.com"/></except></anyName><text/></element>
+				</oneOrMore>
+			</element>
+		</start>
+	</grammar>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `namespace ns1 = "http://example.com"
+
+start = \text
+a = element a { xsd:string { maxLength = "3" } - ("bad") }
+b = element b { string "say \x{22}hi\x{22}" }
+c = element c { empty }
+\element = element * - (ns1:*) { text }
+\text = element \text { attribute xml:lang { "en" }, (a | (b, c))*, \element+ }
+`
+	if got := g.Compact(); got != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, got)
+	}
+}
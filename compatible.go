@@ -0,0 +1,756 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Counterexample is a document that is valid against the old grammar, but not against the new grammar.
+type Counterexample struct {
+	Document []byte
+	//Findings are the violations of the new grammar.
+	Findings []*Finding
+}
+
+//maxInclusionStates is the number of states after which Compatible gives up.
+const maxInclusionStates = 200000
+
+//Compatible checks whether every document that is valid against the old grammar is also valid against the new grammar,
+//by checking whether the language of the old grammar is included in the language of the new grammar.
+//If it is not, a counterexample is returned, which is one of the smallest documents that show the difference.
+//A nil counterexample means the new grammar is backward compatible.
+//
+//Names are represented by the names in either grammar, together with a name in every namespace that is in neither,
+//and values by the values in either grammar, together with a value that is in neither.
+//Like Diagnose, only the lexical spaces of the xsd datatypes are checked and not their parameters.
+//Each xsd datatype is represented by one of its values,
+//so a change of datatype is only found to be incompatible, if that value, or a value in the grammars, shows it.
+//An error is returned if a document, that was built to be valid against the old grammar, is not,
+//since then a difference between the grammars could be missed.
+func Compatible(oldGrammar, newGrammar *Grammar) (c *Counterexample, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	this, err := newInclusion(oldGrammar, newGrammar)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var found []*elementType
+		for _, name := range this.names {
+			types, err := this.explore(name)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, types...)
+		}
+		if len(found) == 0 {
+			return nil, nil
+		}
+		this.types = append(this.types, found...)
+		for _, t := range found {
+			if !this.accepts(0, t) || this.accepts(1, t) {
+				continue
+			}
+			if c, err := counterexample(oldGrammar, newGrammar, t); c != nil || err != nil {
+				return c, err
+			}
+		}
+	}
+}
+
+//counterexample returns the witness of the type of root element, if it is not valid against the new grammar.
+//The witness is checked against the old grammar, since names in a repeated name class are not distinguished.
+//If it is not valid, an error is returned, instead of skipping it,
+//since the type is seen and no other witness for it, that could show the difference, is built.
+func counterexample(oldGrammar, newGrammar *Grammar, t *elementType) (*Counterexample, error) {
+	buf := bytes.NewBuffer(nil)
+	writeElement(buf, t.witness, "")
+	doc := buf.Bytes()
+	findings, err := Diagnose(oldGrammar, doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(findings) > 0 {
+		return nil, fmt.Errorf("the grammars cannot be compared, the document %s, that was built for the old grammar, is not valid against it: %v", doc, findings[0])
+	}
+	findings, err = Diagnose(newGrammar, doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(findings) > 0 {
+		return &Counterexample{Document: doc, Findings: findings}, nil
+	}
+	return nil, nil
+}
+
+//inclusion builds elements bottom up, by the defines of the old and new grammar that they are valid against,
+//until the root of a counterexample is found or no more kinds of elements can be built.
+type inclusion struct {
+	grammars [2]*deriver
+	//defines are the names of the defines of each grammar.
+	defines [2][]string
+	//names and values represent all the names and values.
+	names      []xml.Name
+	values     []string
+	attrValues []string
+	//types are the kinds of elements that were built so far.
+	types  []*elementType
+	seen   map[string]bool
+	keys   map[*pattern]string
+	intern map[string]*pattern
+	states int
+}
+
+//elementType is a kind of element, by the defines it is valid against, with the smallest element of that kind.
+type elementType struct {
+	defines [2]map[string]bool
+	witness *genElement
+}
+
+func newInclusion(oldGrammar, newGrammar *Grammar) (*inclusion, error) {
+	this := &inclusion{
+		seen:   make(map[string]bool),
+		keys:   make(map[*pattern]string),
+		intern: make(map[string]*pattern),
+	}
+	c := &collector{
+		names:     make(map[xml.Name]bool),
+		spaces:    map[string]bool{"": true},
+		spaceList: []string{""},
+		locals:    make(map[string]bool),
+		values:    make(map[string]bool),
+	}
+	for i, g := range []*Grammar{oldGrammar, newGrammar} {
+		this.grammars[i] = newDeriver(g)
+		c.pattern(g.Start)
+		for _, d := range g.Define {
+			this.defines[i] = append(this.defines[i], d.Name)
+			c.nameClass(d.Element.Left)
+			c.pattern(d.Element.Right)
+		}
+		sort.Strings(this.defines[i])
+	}
+	c.addExamples()
+	this.names = c.representativeNames()
+	this.values, this.attrValues = c.representativeValues()
+	return this, this.addListValues(c)
+}
+
+//addListValues adds a value for every combination of the lists, in both grammars, that accept it,
+//where the values are sequences of the tokens in the grammars.
+func (this *inclusion) addListValues(c *collector) error {
+	if len(c.lists) == 0 {
+		return nil
+	}
+	lists := make([]*pattern, len(c.lists))
+	for i, l := range c.lists {
+		lists[i] = this.normalize(compilePattern(l))
+	}
+	type listState struct {
+		lists []*pattern
+		value string
+	}
+	//Sequences of tokens are equivalent if they derive the lists to the same patterns
+	//and are equal to the same values in the grammars.
+	queue := []listState{{lists, ""}}
+	seen := map[string]bool{this.listKey(lists) + equalValues(c.valueList, ""): true}
+	accepted := make(map[string]bool)
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		key := equalValues(c.valueList, s.value)
+		for _, l := range s.lists {
+			key += fmt.Sprint(nullable(l))
+		}
+		if !accepted[key] {
+			accepted[key] = true
+			this.addValue(s.value)
+		}
+		for _, token := range c.tokens() {
+			next := listState{make([]*pattern, len(s.lists)), strings.TrimPrefix(s.value+" "+token, " ")}
+			for i, l := range s.lists {
+				next.lists[i] = this.normalize(this.grammars[0].textDeriv(l, token))
+			}
+			if key := this.listKey(next.lists) + equalValues(c.valueList, next.value); !seen[key] {
+				seen[key] = true
+				queue = append(queue, next)
+			}
+		}
+		if err := this.count(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//equalValues returns which of the values are equal to s, as a string or as a token.
+func equalValues(values []string, s string) string {
+	var equal []bool
+	for _, v := range values {
+		equal = append(equal, valueMatches("token", v, s), valueMatches("string", v, s))
+	}
+	return fmt.Sprint(equal)
+}
+
+func (this *inclusion) listKey(lists []*pattern) string {
+	keys := make([]string, len(lists))
+	for i, l := range lists {
+		keys[i] = this.key(l)
+	}
+	return strings.Join(keys, " ")
+}
+
+func (this *inclusion) addValue(v string) {
+	for _, w := range this.attrValues {
+		if v == w {
+			return
+		}
+	}
+	this.attrValues = append(this.attrValues, v)
+	if len(v) > 0 {
+		this.values = append(this.values, v)
+	}
+}
+
+//accepts returns whether a document with an element of the type as its root is valid against the grammar.
+func (this *inclusion) accepts(grammar int, t *elementType) bool {
+	return nullable(elementDeriv(this.grammars[grammar].start, t.defines[grammar]))
+}
+
+//elementDeriv derives an element that is valid against the defines.
+func elementDeriv(p *pattern, defines map[string]bool) *pattern {
+	switch p.kind {
+	case refKind:
+		if defines[p.ref] {
+			return emptyPattern
+		}
+	case choiceKind:
+		return newChoice(elementDeriv(p.left, defines), elementDeriv(p.right, defines))
+	case interleaveKind:
+		return newChoice(
+			newInterleave(elementDeriv(p.left, defines), p.right),
+			newInterleave(p.left, elementDeriv(p.right, defines)),
+		)
+	case groupKind:
+		g := newGroup(elementDeriv(p.left, defines), p.right)
+		if nullable(p.left) {
+			return newChoice(g, elementDeriv(p.right, defines))
+		}
+		return g
+	case oneOrMoreKind:
+		return newGroup(elementDeriv(p.left, defines), newChoice(p, emptyPattern))
+	}
+	return notAllowedPattern
+}
+
+//inclusionState is the content of an element with a name, derived by its attributes and children,
+//for every define, of both grammars, that has the name.
+type inclusionState struct {
+	patterns [2][]*pattern
+	attrs    []xml.Attr
+	//empty is true if the element has no children and text is true if the last child was text.
+	empty, text bool
+	prev        *inclusionState
+	child       interface{}
+}
+
+//explore returns the types of elements with the name that were not seen before.
+func (this *inclusion) explore(name xml.Name) ([]*elementType, error) {
+	var defines [2][]string
+	start := &inclusionState{empty: true}
+	for i, d := range this.grammars {
+		for _, ref := range this.defines[i] {
+			if nameMatches(d.elementName(ref), name) {
+				defines[i] = append(defines[i], ref)
+				start.patterns[i] = append(start.patterns[i], d.elementContent(ref))
+			}
+		}
+	}
+	if len(defines[0]) == 0 {
+		return nil, nil
+	}
+	var tags []*inclusionState
+	queue := []*inclusionState{start}
+	seen := map[string]bool{this.stateKey(start): true}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		tags = append(tags, s)
+		for _, attrName := range this.names {
+			if !this.allowsAttribute(s, attrName) {
+				continue
+			}
+			for _, v := range this.attrValues {
+				attr := xml.Attr{Name: attrName, Value: v}
+				next := this.derive(s, func(i int, p *pattern) *pattern {
+					return this.grammars[i].attDeriv(p, attr, false)
+				})
+				if next == nil {
+					continue
+				}
+				next.attrs = append(append([]xml.Attr(nil), s.attrs...), attr)
+				if key := this.stateKey(next); !seen[key] {
+					seen[key] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+		if err := this.count(); err != nil {
+			return nil, err
+		}
+	}
+	contentSeen := make(map[string]bool)
+	for _, s := range tags {
+		next := this.derive(s, func(i int, p *pattern) *pattern {
+			return startTagCloseDeriv(p, false)
+		})
+		if next == nil {
+			continue
+		}
+		next.attrs, next.empty = s.attrs, true
+		if key := this.stateKey(next); !contentSeen[key] {
+			contentSeen[key] = true
+			queue = append(queue, next)
+		}
+	}
+	var found []*elementType
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if t := this.end(name, defines, s); t != nil {
+			found = append(found, t)
+		}
+		var children []interface{}
+		if !s.text {
+			for _, v := range this.values {
+				children = append(children, v)
+			}
+		}
+		for _, t := range this.types {
+			children = append(children, t)
+		}
+		for _, child := range children {
+			next := this.derive(s, func(i int, p *pattern) *pattern {
+				if t, ok := child.(*elementType); ok {
+					return elementDeriv(p, t.defines[i])
+				}
+				return this.grammars[i].childText(p, child.(string))
+			})
+			if next == nil {
+				continue
+			}
+			_, next.text = child.(string)
+			next.attrs, next.prev, next.child = s.attrs, s, child
+			if key := this.stateKey(next); !contentSeen[key] {
+				contentSeen[key] = true
+				queue = append(queue, next)
+			}
+		}
+		if err := this.count(); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func (this *inclusion) count() error {
+	this.states++
+	if this.states > maxInclusionStates {
+		return fmt.Errorf("the grammars are too large to compare, more than %d states were explored", maxInclusionStates)
+	}
+	return nil
+}
+
+//allowsAttribute returns whether the attribute is expected by the old grammar and was not given yet.
+func (this *inclusion) allowsAttribute(s *inclusionState, name xml.Name) bool {
+	for _, a := range s.attrs {
+		if a.Name == name {
+			return false
+		}
+	}
+	for _, p := range s.patterns[0] {
+		if hasAttribute(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+//derive applies f to all the patterns of the state
+//and returns nil if the element is no longer valid against any define of the old grammar.
+func (this *inclusion) derive(s *inclusionState, f func(int, *pattern) *pattern) *inclusionState {
+	next := &inclusionState{}
+	valid := false
+	for i := range this.grammars {
+		next.patterns[i] = make([]*pattern, len(s.patterns[i]))
+		for j, p := range s.patterns[i] {
+			next.patterns[i][j] = this.normalize(f(i, p))
+			if i == 0 && next.patterns[i][j].kind != notAllowedKind {
+				valid = true
+			}
+		}
+	}
+	if !valid {
+		return nil
+	}
+	return next
+}
+
+//end closes the element and returns its type, if it was not seen before.
+func (this *inclusion) end(name xml.Name, defines [2][]string, s *inclusionState) *elementType {
+	t := &elementType{}
+	var key []string
+	for i, d := range this.grammars {
+		t.defines[i] = make(map[string]bool)
+		for j, p := range s.patterns[i] {
+			if s.empty {
+				p = newChoice(p, d.textDeriv(p, ""))
+			}
+			if nullable(p) {
+				t.defines[i][defines[i][j]] = true
+				key = append(key, strconv.Itoa(i)+defines[i][j])
+			}
+		}
+	}
+	if len(t.defines[0]) == 0 {
+		return nil
+	}
+	k := strings.Join(key, " ")
+	if this.seen[k] {
+		return nil
+	}
+	this.seen[k] = true
+	t.witness = &genElement{name: name, attrs: s.attrs}
+	for c := s; c.prev != nil; c = c.prev {
+		var child interface{}
+		if e, ok := c.child.(*elementType); ok {
+			child = e.witness
+		} else {
+			child = c.child
+		}
+		t.witness.children = append([]interface{}{child}, t.witness.children...)
+	}
+	return t
+}
+
+func (this *inclusion) stateKey(s *inclusionState) string {
+	var keys []string
+	for _, ps := range s.patterns {
+		for _, p := range ps {
+			keys = append(keys, this.key(p))
+		}
+		keys = append(keys, "|")
+	}
+	return fmt.Sprintf("%s %v %v", strings.Join(keys, " "), s.empty, s.text)
+}
+
+//normalize flattens, sorts and removes duplicate choices, so that equivalent derivatives are recognized
+//and the number of derivatives stays finite.
+func (this *inclusion) normalize(p *pattern) *pattern {
+	switch p.kind {
+	case choiceKind:
+		var alts []*pattern
+		this.alternatives(p, &alts)
+		if len(alts) == 0 {
+			return notAllowedPattern
+		}
+		sort.Sort(patternsByKey{alts, this})
+		c := alts[len(alts)-1]
+		for i := len(alts) - 2; i >= 0; i-- {
+			if this.key(alts[i]) != this.key(alts[i+1]) {
+				c = &pattern{kind: choiceKind, left: alts[i], right: c}
+			}
+		}
+		p = c
+	case groupKind:
+		p = newGroup(this.normalize(p.left), this.normalize(p.right))
+	case interleaveKind:
+		p = newInterleave(this.normalize(p.left), this.normalize(p.right))
+	case oneOrMoreKind:
+		p = newOneOrMore(this.normalize(p.left))
+	}
+	k := this.key(p)
+	if q, ok := this.intern[k]; ok {
+		return q
+	}
+	this.intern[k] = p
+	return p
+}
+
+func (this *inclusion) alternatives(p *pattern, alts *[]*pattern) {
+	if p.kind == choiceKind {
+		this.alternatives(p.left, alts)
+		this.alternatives(p.right, alts)
+		return
+	}
+	p = this.normalize(p)
+	switch p.kind {
+	case notAllowedKind:
+	case choiceKind:
+		this.alternatives(p, alts)
+	default:
+		*alts = append(*alts, p)
+	}
+}
+
+type patternsByKey struct {
+	ps []*pattern
+	in *inclusion
+}
+
+func (this patternsByKey) Len() int {
+	return len(this.ps)
+}
+
+func (this patternsByKey) Less(i, j int) bool {
+	return this.in.key(this.ps[i]) < this.in.key(this.ps[j])
+}
+
+func (this patternsByKey) Swap(i, j int) {
+	this.ps[i], this.ps[j] = this.ps[j], this.ps[i]
+}
+
+//key returns a string that is equal for equal patterns.
+func (this *inclusion) key(p *pattern) string {
+	if k, ok := this.keys[p]; ok {
+		return k
+	}
+	var k string
+	switch p.kind {
+	case notAllowedKind:
+		k = "notAllowed"
+	case emptyKind:
+		k = "empty"
+	case textKind:
+		k = "text"
+	case choiceKind:
+		k = "(" + this.key(p.left) + "|" + this.key(p.right) + ")"
+	case groupKind:
+		k = "(" + this.key(p.left) + "," + this.key(p.right) + ")"
+	case interleaveKind:
+		k = "(" + this.key(p.left) + "&" + this.key(p.right) + ")"
+	case oneOrMoreKind:
+		k = "(" + this.key(p.left) + ")+"
+	case listKind:
+		k = "list(" + this.key(p.left) + ")"
+	case dataKind:
+		k = "data(" + p.typ + ")"
+		if p.except != nil {
+			k += "-" + this.key(p.except)
+		}
+	case valueKind:
+		k = "value(" + p.typ + "," + strconv.Quote(p.value) + ")"
+	case attributeKind:
+		k = "attribute(" + nameClassString(p.name) + "," + this.key(p.left) + ")"
+	case refKind:
+		k = "ref(" + p.ref + ")"
+	}
+	this.keys[p] = k
+	return k
+}
+
+//collector collects the names and values that occur in grammars.
+type collector struct {
+	names      map[xml.Name]bool
+	nameList   []xml.Name
+	spaces     map[string]bool
+	spaceList  []string
+	locals     map[string]bool
+	values     map[string]bool
+	valueList  []string
+	lists      []*NameOrPattern
+	hasStrings bool
+	//datatypes are the xsd datatypes of data in the grammars.
+	datatypes []string
+}
+
+func (this *collector) nameClass(n *NameOrPattern) {
+	switch {
+	case n.Choice != nil:
+		this.nameClass(n.Choice.Left)
+		this.nameClass(n.Choice.Right)
+	case n.AnyName != nil:
+		if n.AnyName.Except != nil {
+			this.nameClass(n.AnyName.Except)
+		}
+	case n.NsName != nil:
+		this.space(n.NsName.Ns)
+		if n.NsName.Except != nil {
+			this.nameClass(n.NsName.Except)
+		}
+	case n.Name != nil:
+		name := xml.Name{Space: n.Name.Ns, Local: n.Name.Text}
+		this.space(name.Space)
+		this.locals[name.Local] = true
+		if !this.names[name] {
+			this.names[name] = true
+			this.nameList = append(this.nameList, name)
+		}
+	}
+}
+
+func (this *collector) space(ns string) {
+	if !this.spaces[ns] {
+		this.spaces[ns] = true
+		this.spaceList = append(this.spaceList, ns)
+	}
+}
+
+func (this *collector) value(v string) {
+	if !this.values[v] {
+		this.values[v] = true
+		this.valueList = append(this.valueList, v)
+	}
+}
+
+//xsdExamples are values in the lexical spaces of the xsd datatypes, that represent the datatypes.
+var xsdExamples = []string{"0", "1", "-1", "2", "true", "false", "2000-01-01", "2000-01-01T00:00:00", "00:00:00", "P1D",
+	"2000-01", "2000", "--01-01", "---01", "--01", "00", "AA==", "en", "a", "a:a"}
+
+//addExamples adds, for every xsd datatype, a value that is not a value in the grammars,
+//so that the datatype is represented, even if it accepts none of the values in the grammars.
+func (this *collector) addExamples() {
+	values := make(map[string]bool)
+	for v := range this.values {
+		values[v] = true
+	}
+	for _, typ := range this.datatypes {
+		for _, v := range xsdExamples {
+			if !values[v] && isXSDLexical(typ, v) {
+				this.value(v)
+				break
+			}
+		}
+	}
+}
+
+func (this *collector) pattern(p *NameOrPattern) {
+	switch {
+	case p.Value != nil:
+		this.value(p.Value.Text)
+		if p.Value.IsString() {
+			this.hasStrings = true
+		}
+	case p.Data != nil:
+		if p.Data.Except != nil {
+			this.pattern(p.Data.Except)
+		}
+		if p.Data.DatatypeLibrary == xsdDatatypes {
+			this.datatypes = append(this.datatypes, p.Data.Type)
+		}
+	case p.List != nil:
+		this.lists = append(this.lists, p.List.NameOrPattern)
+		this.pattern(p.List.NameOrPattern)
+	case p.Attribute != nil:
+		this.nameClass(p.Attribute.Left)
+		this.pattern(p.Attribute.Right)
+	case p.OneOrMore != nil:
+		this.pattern(p.OneOrMore.NameOrPattern)
+	case p.Choice != nil:
+		this.pattern(p.Choice.Left)
+		this.pattern(p.Choice.Right)
+	case p.Group != nil:
+		this.pattern(p.Group.Left)
+		this.pattern(p.Group.Right)
+	case p.Interleave != nil:
+		this.pattern(p.Interleave.Left)
+		this.pattern(p.Interleave.Right)
+	}
+}
+
+//representativeNames returns the names in the grammars,
+//followed by a name that is not in the grammars for every namespace and for a namespace that is not in the grammars.
+func (this *collector) representativeNames() []xml.Name {
+	names := append([]xml.Name(nil), this.nameList...)
+	local := "other"
+	for i := 1; this.locals[local]; i++ {
+		local = "other" + strconv.Itoa(i)
+	}
+	ns := "urn:other"
+	for i := 1; this.spaces[ns]; i++ {
+		ns = "urn:other" + strconv.Itoa(i)
+	}
+	for _, space := range append(this.spaceList, ns) {
+		names = append(names, xml.Name{Space: space, Local: local})
+	}
+	return names
+}
+
+//tokens returns the tokens in the values of the grammars and a token that is not in the grammars.
+func (this *collector) tokens() []string {
+	var tokens []string
+	seen := make(map[string]bool)
+	for _, v := range this.valueList {
+		for _, t := range strings.Fields(v) {
+			if !seen[t] {
+				seen[t] = true
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return append(tokens, this.other())
+}
+
+//other returns a value that is not in the grammars.
+func (this *collector) other() string {
+	used := make(map[string]bool)
+	for _, v := range this.valueList {
+		used[v] = true
+		for _, t := range strings.Fields(v) {
+			used[t] = true
+		}
+	}
+	other := "other"
+	for i := 1; used[other]; i++ {
+		other = "other" + strconv.Itoa(i)
+	}
+	return other
+}
+
+//representativeValues returns the text and the attribute values that represent all values, except those of lists.
+//These are the values in the grammars, with surrounding whitespace if string values are compared,
+//whitespace and a value that is not in the grammars.
+func (this *collector) representativeValues() (text []string, attrs []string) {
+	values := append([]string{""}, this.valueList...)
+	if this.hasStrings {
+		for _, v := range this.valueList {
+			if !isWhitespace(v) {
+				values = append(values, " "+v+" ")
+			}
+		}
+	}
+	values = append(values, " ", this.other())
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		attrs = append(attrs, v)
+		if len(v) > 0 {
+			text = append(text, v)
+		}
+	}
+	return text, attrs
+}
@@ -0,0 +1,211 @@
+package relaxng
+
+import (
+	"encoding/xml"
+	"path/filepath"
+	"testing"
+)
+
+//TestCompatibleSuite checks every pair of correct grammars.
+//A grammar has to be compatible with itself and, if it is compatible with another grammar,
+//that grammar has to accept its valid test documents.
+func TestCompatibleSuite(t *testing.T) {
+	type suiteGrammar struct {
+		num  string
+		g    *Grammar
+		xmls []xmlCase
+	}
+	var gs []suiteGrammar
+	for _, spec := range scanFiles() {
+		if len(spec.SimpleFilename) == 0 {
+			continue
+		}
+		g, err := SimplifyFile(filepath.Join(filepath.Dir(spec.Filename), "c.rng"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gs = append(gs, suiteGrammar{testNumber(spec.Filename), g, spec.Xmls})
+	}
+	compatible := 0
+	for _, old := range gs {
+		for _, new := range gs {
+			c, err := Compatible(old.g, new.g)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", old.num, new.num, err)
+			}
+			if c != nil {
+				if old.num == new.num {
+					t.Errorf("%s: expected a grammar to be compatible with itself, but got %s", old.num, c.Document)
+				}
+				continue
+			}
+			compatible++
+			for _, x := range old.xmls {
+				if findings, err := Diagnose(old.g, x.Content); err != nil || len(findings) > 0 {
+					continue
+				}
+				if findings, _ := Diagnose(new.g, x.Content); len(findings) > 0 {
+					t.Errorf("%s, %s: expected %s to be valid, since the grammars are compatible, but got %v", old.num, new.num, x.Content, findings)
+				}
+			}
+		}
+	}
+	t.Logf("%d pairs of grammars are compatible", compatible)
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		//counterexample is the expected counterexample, which is empty if the grammars are compatible.
+		counterexample string
+	}{
+		{
+			name: "optional element added",
+			old:  `<element name="a"><element name="b"><text/></element></element>`,
+			new:  `<element name="a"><element name="b"><text/></element><optional><element name="c"><empty/></element></optional></element>`,
+		},
+		{
+			name:           "required element added",
+			old:            `<element name="a"><element name="b"><text/></element></element>`,
+			new:            `<element name="a"><element name="b"><text/></element><element name="c"><empty/></element></element>`,
+			counterexample: `<a><b/></a>`,
+		},
+		{
+			name: "sequence relaxed to interleave",
+			old:  `<element name="a"><element name="b"><empty/></element><element name="c"><empty/></element></element>`,
+			new:  `<element name="a"><interleave><element name="b"><empty/></element><element name="c"><empty/></element></interleave></element>`,
+		},
+		{
+			name:           "interleave restricted to sequence",
+			old:            `<element name="a"><interleave><element name="b"><empty/></element><element name="c"><empty/></element></interleave></element>`,
+			new:            `<element name="a"><element name="b"><empty/></element><element name="c"><empty/></element></element>`,
+			counterexample: `<a><c/><b/></a>`,
+		},
+		{
+			name:           "attribute made required",
+			old:            `<element name="a"><optional><attribute name="id"/></optional><empty/></element>`,
+			new:            `<element name="a"><attribute name="id"/><empty/></element>`,
+			counterexample: `<a/>`,
+		},
+		{
+			name:           "value removed",
+			old:            `<element name="a"><attribute name="size"><choice><value>small</value><value>large</value></choice></attribute></element>`,
+			new:            `<element name="a"><attribute name="size"><value>small</value></attribute></element>`,
+			counterexample: `<a size="large"/>`,
+		},
+		{
+			name: "token value relaxed to text",
+			old:  `<element name="a"><value>x</value></element>`,
+			new:  `<element name="a"><text/></element>`,
+		},
+		{
+			name:           "token value restricted to string value",
+			old:            `<element name="a"><value>x</value></element>`,
+			new:            `<element name="a"><value type="string">x</value></element>`,
+			counterexample: `<a> x </a>`,
+		},
+		{
+			name:           "repetition bounded",
+			old:            `<element name="a"><oneOrMore><element name="b"><empty/></element></oneOrMore></element>`,
+			new:            `<element name="a"><element name="b"><empty/></element><optional><element name="b"><empty/></element></optional></element>`,
+			counterexample: `<a><b/><b/><b/></a>`,
+		},
+		{
+			name:           "nested element changed",
+			old:            `<element name="a"><zeroOrMore><element name="b"><element name="c"><text/></element></element></zeroOrMore></element>`,
+			new:            `<element name="a"><zeroOrMore><element name="b"><element name="c"><empty/></element></element></zeroOrMore></element>`,
+			counterexample: `<a><b><c>other</c></b></a>`,
+		},
+		{
+			name: "recursive grammars",
+			old: `<grammar>
+				<start><ref name="list"/></start>
+				<define name="list"><element name="list"><zeroOrMore><ref name="list"/></zeroOrMore></element></define>
+			</grammar>`,
+			new: `<grammar>
+				<start><ref name="list"/></start>
+				<define name="list"><element name="list"><zeroOrMore><choice><ref name="list"/><element name="item"><text/></element></choice></zeroOrMore></element></define>
+			</grammar>`,
+		},
+		{
+			name: "int relaxed to string",
+			old:  `<element name="a"><data type="int" datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes"/></element>`,
+			new:  `<element name="a"><data type="string" datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes"/></element>`,
+		},
+		{
+			name:           "int restricted to a value",
+			old:            `<element name="a"><data type="int" datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes"/></element>`,
+			new:            `<element name="a"><value>0</value></element>`,
+			counterexample: `<a>1</a>`,
+		},
+		{
+			name:           "namespace changed",
+			old:            `<element name="a" ns="urn:a"><empty/></element>`,
+			new:            `<element name="a" ns="urn:b"><empty/></element>`,
+			counterexample: `<a xmlns="urn:a"/>`,
+		},
+		{
+			name:           "wildcard restricted",
+			old:            `<element><anyName/><empty/></element>`,
+			new:            `<element><nsName ns=""/><empty/></element>`,
+			counterexample: `<other xmlns="urn:other"/>`,
+		},
+	}
+	for _, test := range tests {
+		parse := func(s string) *Grammar {
+			g, err := Simplify([]byte(`<grammar xmlns="http://relaxng.org/ns/structure/1.0"><start>` + s + `</start></grammar>`))
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			return g
+		}
+		old, new := parse(test.old), parse(test.new)
+		c, err := Compatible(old, new)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(test.counterexample) == 0 {
+			if c != nil {
+				t.Errorf("%s: expected compatible grammars, but got counterexample %s", test.name, c.Document)
+			}
+			continue
+		}
+		if c == nil {
+			t.Errorf("%s: expected counterexample %s", test.name, test.counterexample)
+			continue
+		}
+		if string(c.Document) != test.counterexample {
+			t.Errorf("%s: expected counterexample %s, but got %s", test.name, test.counterexample, c.Document)
+		}
+		if findings, _ := Diagnose(old, c.Document); len(findings) > 0 {
+			t.Errorf("%s: expected the counterexample to be valid against the old grammar, but got %v", test.name, findings)
+		}
+		if len(c.Findings) == 0 {
+			t.Errorf("%s: expected findings for the new grammar", test.name)
+		}
+	}
+}
+
+func TestCompatibleInvalidWitness(t *testing.T) {
+	oldGrammar, err := Simplify([]byte(`<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><element name="b"><empty/></element></element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newGrammar, err := Simplify([]byte(`<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><empty/></element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := &elementType{witness: &genElement{name: xml.Name{Local: "a"}, children: []interface{}{&genElement{name: xml.Name{Local: "b"}}}}}
+	c, err := counterexample(oldGrammar, newGrammar, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || string(c.Document) != "<a><b/></a>" {
+		t.Fatalf("expected the witness as a counterexample, but got %v", c)
+	}
+	invalid := &elementType{witness: &genElement{name: xml.Name{Local: "a"}, children: []interface{}{&genElement{name: xml.Name{Local: "c"}}}}}
+	if c, err := counterexample(oldGrammar, newGrammar, invalid); err == nil {
+		t.Fatalf("expected an error for a witness that is not valid against the old grammar, but got %v", c)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	xsdTimezone = `(Z|[+-]([01][0-9]|2[0-3]):[0-5][0-9])?`
+	xsdYear     = `-?([1-9][0-9]{4,}|[0-9]{4})`
+	xsdMonth    = `(0[1-9]|1[0-2])`
+	xsdDay      = `(0[1-9]|[12][0-9]|3[01])`
+	xsdTime     = `([01][0-9]|2[0-3]):[0-5][0-9]:[0-5][0-9](\.[0-9]+)?`
+	xsdNCName   = `[\pL_][\pL\pN._\-]*`
+)
+
+// xsdPatterns are the lexical spaces of the W3C XML Schema builtin datatypes, that are described by a regular expression.
+var xsdPatterns = map[string]*regexp.Regexp{
+	"boolean":      regexp.MustCompile(`^(true|false|1|0)$`),
+	"decimal":      regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`),
+	"float":        regexp.MustCompile(`^([+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?|-?INF|NaN)$`),
+	"double":       regexp.MustCompile(`^([+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?|-?INF|NaN)$`),
+	"duration":     regexp.MustCompile(`^-?P([0-9]+Y)?([0-9]+M)?([0-9]+D)?(T([0-9]+H)?([0-9]+M)?([0-9]+(\.[0-9]+)?S)?)?$`),
+	"dateTime":     regexp.MustCompile(`^` + xsdYear + `-` + xsdMonth + `-` + xsdDay + `T` + xsdTime + xsdTimezone + `$`),
+	"date":         regexp.MustCompile(`^` + xsdYear + `-` + xsdMonth + `-` + xsdDay + xsdTimezone + `$`),
+	"time":         regexp.MustCompile(`^` + xsdTime + xsdTimezone + `$`),
+	"gYearMonth":   regexp.MustCompile(`^` + xsdYear + `-` + xsdMonth + xsdTimezone + `$`),
+	"gYear":        regexp.MustCompile(`^` + xsdYear + xsdTimezone + `$`),
+	"gMonthDay":    regexp.MustCompile(`^--` + xsdMonth + `-` + xsdDay + xsdTimezone + `$`),
+	"gDay":         regexp.MustCompile(`^---` + xsdDay + xsdTimezone + `$`),
+	"gMonth":       regexp.MustCompile(`^--` + xsdMonth + xsdTimezone + `$`),
+	"hexBinary":    regexp.MustCompile(`^([0-9a-fA-F]{2})*$`),
+	"language":     regexp.MustCompile(`^[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*$`),
+	"Name":         regexp.MustCompile(`^[\pL_:][\pL\pN._:\-]*$`),
+	"NCName":       regexp.MustCompile(`^` + xsdNCName + `$`),
+	"ID":           regexp.MustCompile(`^` + xsdNCName + `$`),
+	"IDREF":        regexp.MustCompile(`^` + xsdNCName + `$`),
+	"ENTITY":       regexp.MustCompile(`^` + xsdNCName + `$`),
+	"IDREFS":       regexp.MustCompile(`^` + xsdNCName + `( ` + xsdNCName + `)*$`),
+	"ENTITIES":     regexp.MustCompile(`^` + xsdNCName + `( ` + xsdNCName + `)*$`),
+	"QName":        regexp.MustCompile(`^(` + xsdNCName + `:)?` + xsdNCName + `$`),
+	"NMTOKEN":      regexp.MustCompile(`^[\pL\pN._:\-]+$`),
+	"NMTOKENS":     regexp.MustCompile(`^[\pL\pN._:\-]+( [\pL\pN._:\-]+)*$`),
+	"base64Binary": regexp.MustCompile(`^(([A-Za-z0-9+/] ?){4})*(([A-Za-z0-9+/] ?){3}[A-Za-z0-9+/]|([A-Za-z0-9+/] ?){2}[AEIMQUYcgkosw048] ?=|[A-Za-z0-9+/] ?[AQgw] ?= ?=)?$`),
+}
+
+var xsdInteger = regexp.MustCompile(`^[+-]?[0-9]+$`)
+
+// xsdIntegerRanges are the inclusive bounds of the builtin datatypes that are derived from integer, where nil is unbounded.
+var xsdIntegerRanges = map[string][2]*big.Int{
+	"integer":            {nil, nil},
+	"nonPositiveInteger": {nil, big.NewInt(0)},
+	"negativeInteger":    {nil, big.NewInt(-1)},
+	"nonNegativeInteger": {big.NewInt(0), nil},
+	"positiveInteger":    {big.NewInt(1), nil},
+	"long":               {newBigInt("-9223372036854775808"), newBigInt("9223372036854775807")},
+	"int":                {big.NewInt(-2147483648), big.NewInt(2147483647)},
+	"short":              {big.NewInt(-32768), big.NewInt(32767)},
+	"byte":               {big.NewInt(-128), big.NewInt(127)},
+	"unsignedLong":       {big.NewInt(0), newBigInt("18446744073709551615")},
+	"unsignedInt":        {big.NewInt(0), big.NewInt(4294967295)},
+	"unsignedShort":      {big.NewInt(0), big.NewInt(65535)},
+	"unsignedByte":       {big.NewInt(0), big.NewInt(255)},
+}
+
+func newBigInt(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid integer " + strconv.Quote(s))
+	}
+	return i
+}
+
+// isXSDLexical returns whether the whitespace collapsed value is in the lexical space of the builtin W3C XML Schema datatype.
+// The string types and the builtin datatypes, that are not checked, like anyURI and NOTATION, accept any value.
+func isXSDLexical(typ string, value string) bool {
+	if r, ok := xsdIntegerRanges[typ]; ok {
+		if !xsdInteger.MatchString(value) {
+			return false
+		}
+		i := newBigInt(value)
+		return (r[0] == nil || i.Cmp(r[0]) >= 0) && (r[1] == nil || i.Cmp(r[1]) <= 0)
+	}
+	if typ == "duration" && (strings.HasSuffix(value, "P") || strings.HasSuffix(value, "T")) {
+		return false
+	}
+	if r, ok := xsdPatterns[typ]; ok {
+		return r.MatchString(value)
+	}
+	return true
+}
@@ -0,0 +1,606 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+//This file implements the derivative algorithm, described by James Clark in
+//http://www.thaiopensource.com/relaxng/derivative.html,
+//directly over a simplified RelaxNG Grammar.
+//Unlike the translation to Relapse it is able to tell where in a document,
+//and which pattern, validation failed.
+
+type kind int
+
+const (
+	notAllowedKind kind = iota
+	emptyKind
+	textKind
+	choiceKind
+	interleaveKind
+	groupKind
+	oneOrMoreKind
+	listKind
+	dataKind
+	valueKind
+	attributeKind
+	refKind
+	afterKind
+)
+
+type pattern struct {
+	kind        kind
+	left, right *pattern
+	//name is the name class of an attribute.
+	name *NameOrPattern
+	//ref is the name of the define of a referenced element or
+	//the define whose element content is on the left of an after.
+	ref string
+	//typ is the datatype of data and value and library is the datatype library of data.
+	typ     string
+	library string
+	value   string
+	//except is the except pattern of data.
+	except *pattern
+}
+
+var (
+	notAllowedPattern = &pattern{kind: notAllowedKind}
+	emptyPattern      = &pattern{kind: emptyKind}
+	textPattern       = &pattern{kind: textKind}
+)
+
+func equalPatterns(a, b *pattern) bool {
+	if a == b {
+		return true
+	}
+	if a.kind != b.kind || a.ref != b.ref || a.typ != b.typ || a.library != b.library || a.value != b.value || a.name != b.name {
+		return false
+	}
+	if (a.left == nil) != (b.left == nil) || (a.right == nil) != (b.right == nil) || (a.except == nil) != (b.except == nil) {
+		return false
+	}
+	if a.left != nil && !equalPatterns(a.left, b.left) {
+		return false
+	}
+	if a.right != nil && !equalPatterns(a.right, b.right) {
+		return false
+	}
+	if a.except != nil && !equalPatterns(a.except, b.except) {
+		return false
+	}
+	return true
+}
+
+func newChoice(left, right *pattern) *pattern {
+	if left.kind == notAllowedKind {
+		return right
+	}
+	if right.kind == notAllowedKind {
+		return left
+	}
+	if equalPatterns(left, right) {
+		return left
+	}
+	return &pattern{kind: choiceKind, left: left, right: right}
+}
+
+func newGroup(left, right *pattern) *pattern {
+	if left.kind == notAllowedKind || right.kind == notAllowedKind {
+		return notAllowedPattern
+	}
+	if left.kind == emptyKind {
+		return right
+	}
+	if right.kind == emptyKind {
+		return left
+	}
+	return &pattern{kind: groupKind, left: left, right: right}
+}
+
+func newInterleave(left, right *pattern) *pattern {
+	if left.kind == notAllowedKind || right.kind == notAllowedKind {
+		return notAllowedPattern
+	}
+	if left.kind == emptyKind {
+		return right
+	}
+	if right.kind == emptyKind {
+		return left
+	}
+	return &pattern{kind: interleaveKind, left: left, right: right}
+}
+
+func newAfter(left, right *pattern, ref string) *pattern {
+	if left.kind == notAllowedKind || right.kind == notAllowedKind {
+		return notAllowedPattern
+	}
+	return &pattern{kind: afterKind, left: left, right: right, ref: ref}
+}
+
+func newOneOrMore(p *pattern) *pattern {
+	if p.kind == notAllowedKind || p.kind == emptyKind {
+		return p
+	}
+	return &pattern{kind: oneOrMoreKind, left: p}
+}
+
+//deriver compiles the content of defines as they are referenced.
+type deriver struct {
+	defines map[string]*Define
+	content map[string]*pattern
+	start   *pattern
+}
+
+func newDeriver(g *Grammar) *deriver {
+	d := &deriver{
+		defines: make(map[string]*Define),
+		content: make(map[string]*pattern),
+	}
+	for i := range g.Define {
+		d.defines[g.Define[i].Name] = &g.Define[i]
+	}
+	d.start = compilePattern(g.Start)
+	return d
+}
+
+func compilePattern(p *NameOrPattern) *pattern {
+	switch {
+	case p.NotAllowed != nil:
+		return notAllowedPattern
+	case p.Empty != nil:
+		return emptyPattern
+	case p.Text != nil:
+		return textPattern
+	case p.Data != nil:
+		data := &pattern{kind: dataKind, typ: p.Data.Type, library: p.Data.DatatypeLibrary}
+		if p.Data.Except != nil {
+			data.except = compilePattern(p.Data.Except)
+		}
+		return data
+	case p.Value != nil:
+		return &pattern{kind: valueKind, typ: p.Value.Type, value: p.Value.Text}
+	case p.List != nil:
+		return &pattern{kind: listKind, left: compilePattern(p.List.NameOrPattern)}
+	case p.Attribute != nil:
+		return &pattern{kind: attributeKind, name: p.Attribute.Left, left: compilePattern(p.Attribute.Right)}
+	case p.Ref != nil:
+		return &pattern{kind: refKind, ref: p.Ref.Name}
+	case p.OneOrMore != nil:
+		return newOneOrMore(compilePattern(p.OneOrMore.NameOrPattern))
+	case p.Choice != nil:
+		return newChoice(compilePattern(p.Choice.Left), compilePattern(p.Choice.Right))
+	case p.Group != nil:
+		return newGroup(compilePattern(p.Group.Left), compilePattern(p.Group.Right))
+	case p.Interleave != nil:
+		return newInterleave(compilePattern(p.Interleave.Left), compilePattern(p.Interleave.Right))
+	}
+	panic(fmt.Sprintf("unreachable pattern %v", p))
+}
+
+//elementContent returns the compiled content of the element in the define.
+func (this *deriver) elementContent(name string) *pattern {
+	if p, ok := this.content[name]; ok {
+		return p
+	}
+	d, ok := this.defines[name]
+	if !ok {
+		panic(fmt.Sprintf("undefined reference %s", name))
+	}
+	p := compilePattern(d.Element.Right)
+	this.content[name] = p
+	return p
+}
+
+func (this *deriver) elementName(ref string) *NameOrPattern {
+	d, ok := this.defines[ref]
+	if !ok {
+		panic(fmt.Sprintf("undefined reference %s", ref))
+	}
+	return d.Element.Left
+}
+
+func nullable(p *pattern) bool {
+	switch p.kind {
+	case emptyKind, textKind:
+		return true
+	case choiceKind:
+		return nullable(p.left) || nullable(p.right)
+	case groupKind, interleaveKind:
+		return nullable(p.left) && nullable(p.right)
+	case oneOrMoreKind:
+		return nullable(p.left)
+	}
+	return false
+}
+
+func nameMatches(n *NameOrPattern, name xml.Name) bool {
+	switch {
+	case n.Choice != nil:
+		return nameMatches(n.Choice.Left, name) || nameMatches(n.Choice.Right, name)
+	case n.AnyName != nil:
+		return n.AnyName.Except == nil || !nameMatches(n.AnyName.Except, name)
+	case n.NsName != nil:
+		return n.NsName.Ns == name.Space && (n.NsName.Except == nil || !nameMatches(n.NsName.Except, name))
+	case n.Name != nil:
+		return n.Name.Text == name.Local && n.Name.Ns == name.Space
+	}
+	return false
+}
+
+func isWhitespace(s string) bool {
+	return len(strings.TrimSpace(s)) == 0
+}
+
+//normalizeWhitespace collapses the xml whitespace in the text, like the token function of the translation.
+func normalizeWhitespace(s string) string {
+	return strings.Join(tokenize(s), " ")
+}
+
+func valueMatches(typ, value, s string) bool {
+	if typ == "string" {
+		return value == s
+	}
+	return normalizeWhitespace(value) == normalizeWhitespace(s)
+}
+
+func (this *deriver) textDeriv(p *pattern, s string) *pattern {
+	switch p.kind {
+	case choiceKind:
+		return newChoice(this.textDeriv(p.left, s), this.textDeriv(p.right, s))
+	case interleaveKind:
+		return newChoice(
+			newInterleave(this.textDeriv(p.left, s), p.right),
+			newInterleave(p.left, this.textDeriv(p.right, s)),
+		)
+	case groupKind:
+		g := newGroup(this.textDeriv(p.left, s), p.right)
+		if nullable(p.left) {
+			return newChoice(g, this.textDeriv(p.right, s))
+		}
+		return g
+	case afterKind:
+		return newAfter(this.textDeriv(p.left, s), p.right, p.ref)
+	case oneOrMoreKind:
+		return newGroup(this.textDeriv(p.left, s), newChoice(p, emptyPattern))
+	case textKind:
+		return p
+	case valueKind:
+		if valueMatches(p.typ, p.value, s) {
+			return emptyPattern
+		}
+	case dataKind:
+		//Like the translation, only the lexical space of the xsd datatypes is checked.
+		if p.library == xsdDatatypes && !isXSDLexical(p.typ, normalizeWhitespace(s)) {
+			break
+		}
+		if p.except == nil || !nullable(this.textDeriv(p.except, s)) {
+			return emptyPattern
+		}
+	case listKind:
+		l := p.left
+		for _, token := range tokenize(s) {
+			l = this.textDeriv(l, token)
+		}
+		if nullable(l) {
+			return emptyPattern
+		}
+	}
+	return notAllowedPattern
+}
+
+//applyAfter applies f to the right hand side of all the afters in p.
+func applyAfter(f func(*pattern) *pattern, p *pattern) *pattern {
+	switch p.kind {
+	case afterKind:
+		return newAfter(p.left, f(p.right), p.ref)
+	case choiceKind:
+		return newChoice(applyAfter(f, p.left), applyAfter(f, p.right))
+	}
+	return notAllowedPattern
+}
+
+func (this *deriver) startTagOpenDeriv(p *pattern, name xml.Name) *pattern {
+	switch p.kind {
+	case refKind:
+		if nameMatches(this.elementName(p.ref), name) {
+			return newAfter(this.elementContent(p.ref), emptyPattern, p.ref)
+		}
+	case choiceKind:
+		return newChoice(this.startTagOpenDeriv(p.left, name), this.startTagOpenDeriv(p.right, name))
+	case interleaveKind:
+		return newChoice(
+			applyAfter(func(x *pattern) *pattern { return newInterleave(x, p.right) }, this.startTagOpenDeriv(p.left, name)),
+			applyAfter(func(x *pattern) *pattern { return newInterleave(p.left, x) }, this.startTagOpenDeriv(p.right, name)),
+		)
+	case oneOrMoreKind:
+		return applyAfter(func(x *pattern) *pattern {
+			return newGroup(x, newChoice(p, emptyPattern))
+		}, this.startTagOpenDeriv(p.left, name))
+	case groupKind:
+		x := applyAfter(func(x *pattern) *pattern { return newGroup(x, p.right) }, this.startTagOpenDeriv(p.left, name))
+		if nullable(p.left) {
+			return newChoice(x, this.startTagOpenDeriv(p.right, name))
+		}
+		return x
+	case afterKind:
+		return applyAfter(func(x *pattern) *pattern { return newAfter(x, p.right, p.ref) }, this.startTagOpenDeriv(p.left, name))
+	}
+	return notAllowedPattern
+}
+
+//attDeriv derives an attribute.
+//If recover is true, the value of an attribute with a matching name is assumed to be valid.
+func (this *deriver) attDeriv(p *pattern, attr xml.Attr, recover bool) *pattern {
+	switch p.kind {
+	case afterKind:
+		return newAfter(this.attDeriv(p.left, attr, recover), p.right, p.ref)
+	case choiceKind:
+		return newChoice(this.attDeriv(p.left, attr, recover), this.attDeriv(p.right, attr, recover))
+	case groupKind:
+		return newChoice(
+			newGroup(this.attDeriv(p.left, attr, recover), p.right),
+			newGroup(p.left, this.attDeriv(p.right, attr, recover)),
+		)
+	case interleaveKind:
+		return newChoice(
+			newInterleave(this.attDeriv(p.left, attr, recover), p.right),
+			newInterleave(p.left, this.attDeriv(p.right, attr, recover)),
+		)
+	case oneOrMoreKind:
+		return newGroup(this.attDeriv(p.left, attr, recover), newChoice(p, emptyPattern))
+	case attributeKind:
+		if nameMatches(p.name, attr.Name) && (recover || this.valueMatch(p.left, attr.Value)) {
+			return emptyPattern
+		}
+	}
+	return notAllowedPattern
+}
+
+func (this *deriver) valueMatch(p *pattern, s string) bool {
+	return (nullable(p) && isWhitespace(s)) || nullable(this.textDeriv(p, s))
+}
+
+//startTagCloseDeriv removes the attributes that did not occur.
+//If recover is true, missing attributes are assumed to be present.
+func startTagCloseDeriv(p *pattern, recover bool) *pattern {
+	switch p.kind {
+	case afterKind:
+		return newAfter(startTagCloseDeriv(p.left, recover), p.right, p.ref)
+	case choiceKind:
+		return newChoice(startTagCloseDeriv(p.left, recover), startTagCloseDeriv(p.right, recover))
+	case groupKind:
+		return newGroup(startTagCloseDeriv(p.left, recover), startTagCloseDeriv(p.right, recover))
+	case interleaveKind:
+		return newInterleave(startTagCloseDeriv(p.left, recover), startTagCloseDeriv(p.right, recover))
+	case oneOrMoreKind:
+		return newOneOrMore(startTagCloseDeriv(p.left, recover))
+	case attributeKind:
+		if recover {
+			return emptyPattern
+		}
+		return notAllowedPattern
+	}
+	return p
+}
+
+//endTagDeriv closes an element.
+//If recover is true, missing content is assumed to be present.
+func endTagDeriv(p *pattern, recover bool) *pattern {
+	switch p.kind {
+	case choiceKind:
+		return newChoice(endTagDeriv(p.left, recover), endTagDeriv(p.right, recover))
+	case afterKind:
+		if recover || nullable(p.left) {
+			return p.right
+		}
+	}
+	return notAllowedPattern
+}
+
+//childText derives text found in element content,
+//where whitespace is ignored if it is not allowed.
+func (this *deriver) childText(p *pattern, s string) *pattern {
+	d := this.textDeriv(p, s)
+	if isWhitespace(s) {
+		return newChoice(p, d)
+	}
+	return d
+}
+
+//currentDefines returns the names of the defines of the elements that are currently open.
+func currentDefines(p *pattern) []string {
+	switch p.kind {
+	case afterKind:
+		return []string{p.ref}
+	case choiceKind:
+		return appendUnique(currentDefines(p.left), currentDefines(p.right)...)
+	}
+	return nil
+}
+
+func appendUnique(list []string, ss ...string) []string {
+	for _, s := range ss {
+		if !nameConflict(list, s) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+//content returns the pattern that is expected inside the currently open element.
+func content(p *pattern) *pattern {
+	switch p.kind {
+	case afterKind:
+		return p.left
+	case choiceKind:
+		return newChoice(content(p.left), content(p.right))
+	}
+	return p
+}
+
+//firstElements returns the names of the elements that are allowed next.
+func (this *deriver) firstElements(p *pattern) []string {
+	switch p.kind {
+	case refKind:
+		return []string{nameClassString(this.elementName(p.ref))}
+	case choiceKind, interleaveKind:
+		return appendUnique(this.firstElements(p.left), this.firstElements(p.right)...)
+	case groupKind:
+		first := this.firstElements(p.left)
+		if nullable(p.left) {
+			first = appendUnique(first, this.firstElements(p.right)...)
+		}
+		return first
+	case oneOrMoreKind, afterKind:
+		return this.firstElements(p.left)
+	}
+	return nil
+}
+
+//hasAttribute returns whether an attribute with the name is expected, regardless of its value.
+func hasAttribute(p *pattern, name xml.Name) bool {
+	switch p.kind {
+	case attributeKind:
+		return nameMatches(p.name, name)
+	case choiceKind, groupKind, interleaveKind:
+		return hasAttribute(p.left, name) || hasAttribute(p.right, name)
+	case oneOrMoreKind, afterKind:
+		return hasAttribute(p.left, name)
+	}
+	return false
+}
+
+//missingAttributes returns the names of the attributes that are required, but did not occur.
+func missingAttributes(p *pattern) []string {
+	switch p.kind {
+	case attributeKind:
+		return []string{nameClassString(p.name)}
+	case choiceKind:
+		if l := missingAttributes(p.left); len(l) == 0 {
+			return nil
+		} else if r := missingAttributes(p.right); len(r) == 0 {
+			return nil
+		} else {
+			return appendUnique(l, r...)
+		}
+	case groupKind, interleaveKind:
+		return appendUnique(missingAttributes(p.left), missingAttributes(p.right)...)
+	case oneOrMoreKind, afterKind:
+		return missingAttributes(p.left)
+	}
+	return nil
+}
+
+func nameClassString(n *NameOrPattern) string {
+	switch {
+	case n.Choice != nil:
+		return nameClassString(n.Choice.Left) + "|" + nameClassString(n.Choice.Right)
+	case n.AnyName != nil:
+		if n.AnyName.Except != nil {
+			return "* - (" + nameClassString(n.AnyName.Except) + ")"
+		}
+		return "*"
+	case n.NsName != nil:
+		s := "{" + n.NsName.Ns + "}*"
+		if n.NsName.Except != nil {
+			s += " - (" + nameClassString(n.NsName.Except) + ")"
+		}
+		return s
+	case n.Name != nil:
+		if len(n.Name.Ns) > 0 {
+			return "{" + n.Name.Ns + "}" + n.Name.Text
+		}
+		return n.Name.Text
+	}
+	return ""
+}
+
+//maxPatternString is the length at which pattern strings are cut off.
+const maxPatternString = 200
+
+//patternString returns the pattern in a syntax close to the RelaxNG compact syntax.
+func (this *deriver) patternString(p *pattern) string {
+	s := this.compact(p)
+	if len(s) > maxPatternString {
+		s = s[:maxPatternString] + "..."
+	}
+	return s
+}
+
+func (this *deriver) compact(p *pattern) string {
+	switch p.kind {
+	case notAllowedKind:
+		return "notAllowed"
+	case emptyKind:
+		return "empty"
+	case textKind:
+		return "text"
+	case choiceKind:
+		if p.left.kind == emptyKind {
+			return this.compact(&pattern{kind: choiceKind, left: p.right, right: p.left})
+		}
+		if p.right.kind == emptyKind {
+			if p.left.kind == oneOrMoreKind {
+				return this.compactChild(p.left.left) + "*"
+			}
+			return this.compactChild(p.left) + "?"
+		}
+		return this.compactChild(p.left) + " | " + this.compactChild(p.right)
+	case groupKind:
+		return this.compactChild(p.left) + ", " + this.compactChild(p.right)
+	case interleaveKind:
+		return this.compactChild(p.left) + " & " + this.compactChild(p.right)
+	case oneOrMoreKind:
+		return this.compactChild(p.left) + "+"
+	case listKind:
+		return "list { " + this.compact(p.left) + " }"
+	case dataKind:
+		typ := p.typ
+		if len(typ) == 0 {
+			typ = "token"
+		}
+		if p.except != nil {
+			return typ + " - (" + this.compact(p.except) + ")"
+		}
+		return typ
+	case valueKind:
+		return fmt.Sprintf("%q", p.value)
+	case attributeKind:
+		return "attribute " + nameClassString(p.name) + " { " + this.compact(p.left) + " }"
+	case refKind:
+		return "element " + nameClassString(this.elementName(p.ref))
+	case afterKind:
+		return this.compact(p.left)
+	}
+	return ""
+}
+
+func (this *deriver) compactChild(p *pattern) string {
+	switch p.kind {
+	case choiceKind:
+		if p.left.kind == emptyKind || p.right.kind == emptyKind {
+			return this.compact(p)
+		}
+	case groupKind, interleaveKind:
+	default:
+		return this.compact(p)
+	}
+	return "(" + this.compact(p) + ")"
+}
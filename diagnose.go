@@ -0,0 +1,236 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+//Finding is a single violation of a grammar found in an xml document.
+type Finding struct {
+	//Line and Column are 1-based, where Column counts unicode code points.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	//Path is the path of the element in which the violation occured, for example /order[1]/item[2].
+	Path string `json:"path"`
+	//Rule is the name of the define whose element content was violated,
+	//start if the violation was found at the root of the document
+	//or schematron if an embedded Schematron assert failed.
+	Rule string `json:"rule"`
+	//Pattern is the pattern that was expected, in a syntax close to the RelaxNG compact syntax,
+	//or the test of the Schematron assert.
+	Pattern string `json:"pattern"`
+	Message string `json:"message"`
+}
+
+func (this *Finding) Error() string {
+	return fmt.Sprintf("%d:%d: %s: %s", this.Line, this.Column, this.Path, this.Message)
+}
+
+//Diagnose validates input xml against a simplified RelaxNG Grammar
+//and returns the violations that were found, with their positions in the xml.
+//After a violation it recovers, by ignoring the offending element, attribute or text,
+//so that more than one violation can be reported.
+//The Schematron rules that are embedded in the grammar are also checked and their failed asserts are reported after the violations.
+//An error is returned if the xml is not well formed.
+func Diagnose(g *Grammar, xmlContent []byte) ([]*Finding, error) {
+	schematron, err := NewSchematron(g)
+	return diagnose(g, schematron, err, xmlContent)
+}
+
+//diagnose is Diagnose with the Schematron rules of the grammar, that were already read,
+//or the error of reading them, which is returned after the xml is found to be well formed.
+func diagnose(g *Grammar, schematron *Schematron, schematronErr error, xmlContent []byte) (findings []*Finding, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			findings, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	d := newDeriver(g)
+	dec := xml.NewDecoder(bytes.NewReader(xmlContent))
+	diag := &diagnosis{deriver: d, content: xmlContent}
+	p := d.start
+	//counts holds the number of child elements, by name, seen so far in each open element.
+	counts := []map[string]int{make(map[string]int)}
+	//empty holds, for each open element, whether it had no children so far.
+	empty := []bool{}
+	var path []string
+	text := bytes.NewBuffer(nil)
+	textOffset := int64(0)
+	flushText := func() {
+		if text.Len() == 0 || len(empty) == 0 {
+			text.Reset()
+			return
+		}
+		s := text.String()
+		text.Reset()
+		empty[len(empty)-1] = false
+		next := d.childText(p, s)
+		if next.kind == notAllowedKind {
+			c := content(p)
+			diag.add(textOffset, path, p, c, fmt.Sprintf("text %q is not allowed here, expected %s", abbreviate(strings.TrimSpace(s)), d.patternString(c)))
+			return
+		}
+		p = next
+	}
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.CharData:
+			if text.Len() == 0 {
+				textOffset = offset
+			}
+			text.Write(tok)
+		case xml.StartElement:
+			flushText()
+			if len(empty) > 0 {
+				empty[len(empty)-1] = false
+			}
+			siblings := counts[len(counts)-1]
+			siblings[tok.Name.Local]++
+			elemPath := append(path, fmt.Sprintf("%s[%d]", tok.Name.Local, siblings[tok.Name.Local]))
+			next := d.startTagOpenDeriv(p, tok.Name)
+			if next.kind == notAllowedKind {
+				c := content(p)
+				msg := fmt.Sprintf("element %s is not allowed here", tok.Name.Local)
+				if first := d.firstElements(c); len(first) > 0 {
+					msg += ", expected " + strings.Join(first, " or ")
+				} else if len(path) > 0 {
+					msg += ", expected the end of " + path[len(path)-1]
+				}
+				diag.add(offset, path, p, c, msg)
+				if err := dec.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			p = next
+			for _, attr := range tok.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					continue
+				}
+				next := d.attDeriv(p, attr, false)
+				if next.kind == notAllowedKind {
+					c := content(p)
+					if !hasAttribute(c, attr.Name) {
+						diag.add(offset, elemPath, p, c, fmt.Sprintf("attribute %s is not allowed here", attr.Name.Local))
+						continue
+					}
+					diag.add(offset, elemPath, p, c, fmt.Sprintf("attribute %s has an invalid value %q", attr.Name.Local, abbreviate(attr.Value)))
+					next = d.attDeriv(p, attr, true)
+					if next.kind == notAllowedKind {
+						continue
+					}
+				}
+				p = next
+			}
+			next = startTagCloseDeriv(p, false)
+			if next.kind == notAllowedKind {
+				c := content(p)
+				diag.add(offset, elemPath, p, c, fmt.Sprintf("element %s is missing attribute %s", tok.Name.Local, strings.Join(missingAttributes(c), " or ")))
+				next = startTagCloseDeriv(p, true)
+			}
+			p = next
+			path = elemPath
+			counts = append(counts, make(map[string]int))
+			empty = append(empty, true)
+		case xml.EndElement:
+			flushText()
+			if empty[len(empty)-1] {
+				p = newChoice(p, d.textDeriv(p, ""))
+			}
+			next := endTagDeriv(p, false)
+			if next.kind == notAllowedKind {
+				c := content(p)
+				msg := fmt.Sprintf("element %s is incomplete, expected %s", tok.Name.Local, d.patternString(c))
+				if first := d.firstElements(c); len(first) > 0 {
+					msg = fmt.Sprintf("element %s is incomplete, expected %s", tok.Name.Local, strings.Join(first, " or "))
+				}
+				diag.add(offset, path, p, c, msg)
+				next = endTagDeriv(p, true)
+			}
+			p = next
+			path = path[:len(path)-1]
+			counts = counts[:len(counts)-1]
+			empty = empty[:len(empty)-1]
+		}
+	}
+	if len(counts[0]) == 0 {
+		diag.add(0, nil, p, p, "document has no root element")
+	}
+	if schematronErr != nil {
+		return nil, schematronErr
+	}
+	failed, err := schematron.Check(xmlContent)
+	if err != nil {
+		return nil, err
+	}
+	return append(diag.findings, failed...), nil
+}
+
+type diagnosis struct {
+	deriver  *deriver
+	content  []byte
+	findings []*Finding
+}
+
+//add records a finding at the offset, where p is the pattern at the time of the violation and c is the expected content.
+func (this *diagnosis) add(offset int64, path []string, p *pattern, c *pattern, msg string) {
+	line, column := lineColumn(this.content, offset)
+	rule := "start"
+	if defines := currentDefines(p); len(defines) > 0 {
+		rule = strings.Join(defines, "|")
+	}
+	this.findings = append(this.findings, &Finding{
+		Line:    line,
+		Column:  column,
+		Path:    "/" + strings.Join(path, "/"),
+		Rule:    rule,
+		Pattern: this.deriver.patternString(c),
+		Message: msg,
+	})
+}
+
+//lineColumn returns the 1-based line and column of the offset.
+func lineColumn(content []byte, offset int64) (int, int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	if i := bytes.LastIndexByte(before, '\n'); i >= 0 {
+		before = before[i+1:]
+	}
+	return line, utf8.RuneCount(before) + 1
+}
+
+func abbreviate(s string) string {
+	if utf8.RuneCountInString(s) > 40 {
+		return string([]rune(s)[:40]) + "..."
+	}
+	return s
+}
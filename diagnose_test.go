@@ -0,0 +1,76 @@
+package relaxng
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnoseSuite(t *testing.T) {
+	suite := scanFiles()
+	for _, spec := range suite {
+		if len(spec.SimpleFilename) == 0 || datatypeLibrary[testNumber(spec.Filename)] {
+			continue
+		}
+		g, err := SimplifyFile(filepath.Join(filepath.Dir(spec.Filename), "c.rng"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, x := range spec.Xmls {
+			findings, err := Diagnose(g, x.Content)
+			if err != nil {
+				t.Errorf("%s: %v", x.Filename, err)
+				continue
+			}
+			if x.expectError() && len(findings) == 0 {
+				t.Errorf("%s: expected findings", x.Filename)
+			}
+			if !x.expectError() && len(findings) > 0 {
+				t.Errorf("%s: unexpected findings %v", x.Filename, findings)
+			}
+		}
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+		<attribute name="status">
+			<choice>
+				<value>open</value>
+				<value>closed</value>
+			</choice>
+		</attribute>
+		<oneOrMore>
+			<element name="item"><text/></element>
+		</oneOrMore>
+	</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := Diagnose(g, []byte("<order status=\"pending\">\n  <item>a</item>\n  <note/>\n</order>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		t.Logf("%v %s %s", f, f.Rule, f.Pattern)
+	}
+	if len(findings) != 2 {
+		t.Fatalf("expected two findings, got %v", findings)
+	}
+	if f := findings[0]; f.Line != 1 || f.Column != 1 || f.Path != "/order[1]" || !strings.Contains(f.Message, "status") || !strings.Contains(f.Pattern, `"open"`) {
+		t.Fatalf("unexpected attribute finding %#v", f)
+	}
+	if f := findings[1]; f.Line != 3 || f.Column != 3 || f.Path != "/order[1]" || !strings.Contains(f.Message, "note") {
+		t.Fatalf("unexpected element finding %#v", f)
+	}
+	findings, err = Diagnose(g, []byte(`<order status="open"></order>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || !strings.Contains(findings[0].Message, "incomplete") {
+		t.Fatalf("expected missing content, got %v", findings)
+	}
+	if _, err := Diagnose(g, []byte(`<order>`)); err == nil {
+		t.Fatalf("expected a syntax error")
+	}
+}
@@ -0,0 +1,428 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//ChangeKind is the kind of difference that a Change describes.
+type ChangeKind string
+
+const (
+	ElementAdded       ChangeKind = "element-added"
+	ElementRemoved     ChangeKind = "element-removed"
+	AttributeAdded     ChangeKind = "attribute-added"
+	AttributeRemoved   ChangeKind = "attribute-removed"
+	CardinalityChanged ChangeKind = "cardinality-changed"
+	DatatypeChanged    ChangeKind = "datatype-changed"
+	EnumerationChanged ChangeKind = "enumeration-changed"
+)
+
+//Change is a difference between two grammars.
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	//Path is the path of the element, for example /order/item, or of the attribute, for example /order/@id.
+	Path string `json:"path"`
+	//Old and New are the cardinalities, datatypes or values before and after the change.
+	Old         string `json:"old,omitempty"`
+	New         string `json:"new,omitempty"`
+	Description string `json:"description"`
+}
+
+func (this *Change) String() string {
+	return this.Path + ": " + this.Description
+}
+
+//Diff compares two simplified grammars structurally.
+//Defines are matched by the name class of their element, starting at the root elements,
+//so that renamed or reordered defines are not reported.
+//Elements and attributes that were added or removed are reported,
+//together with changes in the cardinality of elements and attributes,
+//the datatypes of attributes and of text content and the values of enumerations.
+func Diff(oldGrammar, newGrammar *Grammar) (changes []*Change, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			changes, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	d := &differ{
+		grammars: [2]*deriver{newDeriver(oldGrammar), newDeriver(newGrammar)},
+		seen:     make(map[string]bool),
+	}
+	var roots [2]map[string][]string
+	var names [2][]string
+	for i, g := range []*Grammar{oldGrammar, newGrammar} {
+		roots[i], names[i] = d.elements(i, g.Start)
+	}
+	for _, name := range sortedUnion(names) {
+		old, new := roots[0][name], roots[1][name]
+		path := "/" + name
+		switch {
+		case len(new) == 0:
+			d.add(&Change{Kind: ElementRemoved, Path: path, Description: "removed root element " + name})
+		case len(old) == 0:
+			d.add(&Change{Kind: ElementAdded, Path: path, Description: "added root element " + name})
+		default:
+			d.element(path, name, old, new)
+		}
+	}
+	return d.changes, nil
+}
+
+type differ struct {
+	grammars [2]*deriver
+	seen     map[string]bool
+	changes  []*Change
+}
+
+func (this *differ) add(c *Change) {
+	this.changes = append(this.changes, c)
+}
+
+//elements returns the defines of the elements that are referenced in the pattern, by the name class of the element,
+//and the name classes in the order that they were found.
+func (this *differ) elements(grammar int, ps ...*NameOrPattern) (map[string][]string, []string) {
+	elements := make(map[string][]string)
+	var names []string
+	for _, p := range ps {
+		for _, ref := range refs(p) {
+			name := nameClassString(this.grammars[grammar].elementName(ref))
+			elements[name] = appendUnique(elements[name], ref)
+			names = appendUnique(names, name)
+		}
+	}
+	return elements, names
+}
+
+//element compares the old and new defines of an element, that is at the path.
+//Every combination of defines is only compared once, so that recursive grammars terminate.
+func (this *differ) element(path, name string, old, new []string) {
+	sort.Strings(old)
+	sort.Strings(new)
+	key := strings.Join(old, " ") + "|" + strings.Join(new, " ")
+	if this.seen[key] {
+		return
+	}
+	this.seen[key] = true
+	var contents [2][]*NameOrPattern
+	for i, defines := range [][]string{old, new} {
+		for _, ref := range defines {
+			contents[i] = append(contents[i], this.grammars[i].defines[ref].Element.Right)
+		}
+	}
+
+	var attrs [2]map[string][]*NameOrPattern
+	var attrNames [2][]string
+	for i := range contents {
+		attrs[i] = make(map[string][]*NameOrPattern)
+		for _, c := range contents[i] {
+			for _, a := range attributes(c) {
+				n := nameClassString(a.Attribute.Left)
+				attrs[i][n] = append(attrs[i][n], a.Attribute.Right)
+				attrNames[i] = appendUnique(attrNames[i], n)
+			}
+		}
+	}
+	for _, attrName := range sortedUnion(attrNames) {
+		attrPath := path + "/@" + attrName
+		desc := "attribute " + attrName
+		var cards [2]cardinality
+		for i := range contents {
+			cards[i] = contentCardinality(contents[i], func(p *NameOrPattern) bool {
+				return p.Attribute != nil && nameClassString(p.Attribute.Left) == attrName
+			})
+		}
+		switch {
+		case len(attrs[1][attrName]) == 0:
+			this.add(&Change{Kind: AttributeRemoved, Path: attrPath, Old: cards[0].String(), Description: "removed " + desc})
+			continue
+		case len(attrs[0][attrName]) == 0:
+			this.add(&Change{Kind: AttributeAdded, Path: attrPath, New: cards[1].String(), Description: "added " + cards[1].requirement() + " " + desc})
+			continue
+		case cards[0].requirement() != cards[1].requirement():
+			this.add(&Change{Kind: CardinalityChanged, Path: attrPath, Old: cards[0].String(), New: cards[1].String(),
+				Description: fmt.Sprintf("%s changed from %s to %s", desc, cards[0].requirement(), cards[1].requirement())})
+		case cards[0] != cards[1]:
+			this.add(&Change{Kind: CardinalityChanged, Path: attrPath, Old: cards[0].String(), New: cards[1].String(),
+				Description: fmt.Sprintf("cardinality of %s changed from %s to %s", desc, cards[0], cards[1])})
+		}
+		this.values(attrPath, desc, [2]*valueSet{newValueSet(attrs[0][attrName]...), newValueSet(attrs[1][attrName]...)})
+	}
+
+	var children [2]map[string][]string
+	var childNames [2][]string
+	for i := range contents {
+		children[i], childNames[i] = this.elements(i, contents[i]...)
+	}
+	for _, childName := range sortedUnion(childNames) {
+		childPath := path + "/" + childName
+		desc := "element " + childName
+		var cards [2]cardinality
+		for i := range contents {
+			names := children[i][childName]
+			cards[i] = contentCardinality(contents[i], func(p *NameOrPattern) bool {
+				return p.Ref != nil && nameConflict(names, p.Ref.Name)
+			})
+		}
+		switch {
+		case len(children[1][childName]) == 0:
+			this.add(&Change{Kind: ElementRemoved, Path: childPath, Old: cards[0].String(), Description: "removed " + desc})
+			continue
+		case len(children[0][childName]) == 0:
+			this.add(&Change{Kind: ElementAdded, Path: childPath, New: cards[1].String(), Description: "added " + cards[1].requirement() + " " + desc})
+			continue
+		case cards[0] != cards[1]:
+			this.add(&Change{Kind: CardinalityChanged, Path: childPath, Old: cards[0].String(), New: cards[1].String(),
+				Description: fmt.Sprintf("cardinality of %s changed from %s to %s", desc, cards[0], cards[1])})
+		}
+	}
+
+	this.values(path, "the text of element "+name, [2]*valueSet{newValueSet(contents[0]...), newValueSet(contents[1]...)})
+
+	for _, childName := range sortedUnion(childNames) {
+		if old, new := children[0][childName], children[1][childName]; len(old) > 0 && len(new) > 0 {
+			this.element(path+"/"+childName, childName, old, new)
+		}
+	}
+}
+
+//values compares the datatypes and enumerations of an attribute or of the text of an element.
+func (this *differ) values(path, desc string, vs [2]*valueSet) {
+	if old, new := vs[0].typesString(), vs[1].typesString(); old != new {
+		this.add(&Change{Kind: DatatypeChanged, Path: path, Old: old, New: new,
+			Description: fmt.Sprintf("datatype of %s changed from %s to %s", desc, old, new)})
+	}
+	added, removed := diffStrings(vs[0].values, vs[1].values)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	var diffs []string
+	if len(added) > 0 {
+		diffs = append(diffs, "added "+quoteAll(added))
+	}
+	if len(removed) > 0 {
+		diffs = append(diffs, "removed "+quoteAll(removed))
+	}
+	this.add(&Change{Kind: EnumerationChanged, Path: path, Old: quoteAll(vs[0].values), New: quoteAll(vs[1].values),
+		Description: fmt.Sprintf("values of %s changed: %s", desc, strings.Join(diffs, ", "))})
+}
+
+//attributes returns the attribute patterns in the content of an element.
+func attributes(p *NameOrPattern) []*NameOrPattern {
+	switch {
+	case p.Attribute != nil:
+		return []*NameOrPattern{p}
+	case p.OneOrMore != nil:
+		return attributes(p.OneOrMore.NameOrPattern)
+	case p.Choice != nil:
+		return append(attributes(p.Choice.Left), attributes(p.Choice.Right)...)
+	case p.Group != nil:
+		return append(attributes(p.Group.Left), attributes(p.Group.Right)...)
+	case p.Interleave != nil:
+		return append(attributes(p.Interleave.Left), attributes(p.Interleave.Right)...)
+	}
+	return nil
+}
+
+//unbounded is the maximum of a cardinality without an upper bound.
+const unbounded = -1
+
+//cardinality is the minimum and maximum number of times that an element or attribute occurs.
+type cardinality struct {
+	min, max int
+}
+
+func (this cardinality) String() string {
+	switch this {
+	case cardinality{1, 1}:
+		return "1"
+	case cardinality{0, 1}:
+		return "?"
+	case cardinality{0, unbounded}:
+		return "*"
+	case cardinality{1, unbounded}:
+		return "+"
+	}
+	if this.max == unbounded {
+		return fmt.Sprintf("{%d,}", this.min)
+	}
+	return fmt.Sprintf("{%d,%d}", this.min, this.max)
+}
+
+//requirement describes whether the cardinality is required or optional.
+func (this cardinality) requirement() string {
+	if this.min > 0 {
+		return "required"
+	}
+	return "optional"
+}
+
+//contentCardinality returns the cardinality of the patterns that match in the content of any of the defines.
+func contentCardinality(contents []*NameOrPattern, match func(*NameOrPattern) bool) cardinality {
+	c := patternCardinality(contents[0], match)
+	for _, content := range contents[1:] {
+		d := patternCardinality(content, match)
+		c = cardinality{minInt(c.min, d.min), maxCardinality(c.max, d.max)}
+	}
+	return c
+}
+
+func patternCardinality(p *NameOrPattern, match func(*NameOrPattern) bool) cardinality {
+	if match(p) {
+		return cardinality{1, 1}
+	}
+	switch {
+	case p.OneOrMore != nil:
+		c := patternCardinality(p.OneOrMore.NameOrPattern, match)
+		if c.max != 0 {
+			c.max = unbounded
+		}
+		return c
+	case p.Choice != nil:
+		l, r := patternCardinality(p.Choice.Left, match), patternCardinality(p.Choice.Right, match)
+		if p.Choice.Left.NotAllowed != nil {
+			return r
+		}
+		if p.Choice.Right.NotAllowed != nil {
+			return l
+		}
+		return cardinality{minInt(l.min, r.min), maxCardinality(l.max, r.max)}
+	case p.Group != nil:
+		return addCardinalities(patternCardinality(p.Group.Left, match), patternCardinality(p.Group.Right, match))
+	case p.Interleave != nil:
+		return addCardinalities(patternCardinality(p.Interleave.Left, match), patternCardinality(p.Interleave.Right, match))
+	}
+	return cardinality{0, 0}
+}
+
+func addCardinalities(a, b cardinality) cardinality {
+	c := cardinality{a.min + b.min, a.max + b.max}
+	if a.max == unbounded || b.max == unbounded {
+		c.max = unbounded
+	}
+	return c
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxCardinality(a, b int) int {
+	if a == unbounded || b == unbounded {
+		return unbounded
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+//valueSet is the datatypes and values of an attribute or of the text of an element.
+type valueSet struct {
+	types  []string
+	values []string
+}
+
+//newValueSet returns the datatypes and values in the patterns, excluding those of attributes and child elements.
+func newValueSet(ps ...*NameOrPattern) *valueSet {
+	v := &valueSet{}
+	for _, p := range ps {
+		v.add(p)
+	}
+	sort.Strings(v.types)
+	sort.Strings(v.values)
+	return v
+}
+
+func (this *valueSet) add(p *NameOrPattern) {
+	switch {
+	case p.Text != nil:
+		this.types = appendUnique(this.types, "text")
+	case p.Data != nil:
+		typ := p.Data.Type
+		if len(p.Data.Param) > 0 {
+			params := make([]string, len(p.Data.Param))
+			for i, param := range p.Data.Param {
+				params[i] = param.Name + " = " + strconv.Quote(param.Text)
+			}
+			typ += " { " + strings.Join(params, " ") + " }"
+		}
+		this.types = appendUnique(this.types, typ)
+	case p.Value != nil:
+		this.values = appendUnique(this.values, p.Value.Text)
+	case p.List != nil:
+		inner := newValueSet(p.List.NameOrPattern)
+		this.types = appendUnique(this.types, "list {"+inner.typesString()+"}")
+		this.values = appendUnique(this.values, inner.values...)
+	case p.OneOrMore != nil:
+		this.add(p.OneOrMore.NameOrPattern)
+	case p.Choice != nil:
+		this.add(p.Choice.Left)
+		this.add(p.Choice.Right)
+	case p.Group != nil:
+		this.add(p.Group.Left)
+		this.add(p.Group.Right)
+	case p.Interleave != nil:
+		this.add(p.Interleave.Left)
+		this.add(p.Interleave.Right)
+	}
+}
+
+func (this *valueSet) typesString() string {
+	if len(this.types) == 0 {
+		if len(this.values) > 0 {
+			return "enumeration"
+		}
+		return "none"
+	}
+	return strings.Join(this.types, " | ")
+}
+
+//diffStrings returns the strings in b that are not in a and the strings in a that are not in b.
+func diffStrings(a, b []string) (added, removed []string) {
+	for _, s := range b {
+		if !nameConflict(a, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !nameConflict(b, s) {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+func quoteAll(ss []string) string {
+	qs := make([]string, len(ss))
+	for i, s := range ss {
+		qs[i] = strconv.Quote(s)
+	}
+	return strings.Join(qs, ", ")
+}
+
+//sortedUnion returns the sorted strings that are in either list.
+func sortedUnion(lists [2][]string) []string {
+	union := appendUnique(append([]string(nil), lists[0]...), lists[1]...)
+	sort.Strings(union)
+	return union
+}
@@ -0,0 +1,129 @@
+package relaxng
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//TestDiffSuite checks that every correct grammar has no changes compared to itself.
+func TestDiffSuite(t *testing.T) {
+	for _, spec := range scanFiles() {
+		if len(spec.SimpleFilename) == 0 {
+			continue
+		}
+		num := testNumber(spec.Filename)
+		g, err := SimplifyFile(filepath.Join(filepath.Dir(spec.Filename), "c.rng"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes, err := Diff(g, g)
+		if err != nil {
+			t.Fatalf("%s: %v", num, err)
+		}
+		if len(changes) > 0 {
+			t.Errorf("%s: expected no changes, but got %v", num, changes)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old, err := Simplify([]byte(`<grammar xmlns="http://relaxng.org/ns/structure/1.0" datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+		<start><ref name="order"/></start>
+		<define name="order">
+			<element name="order">
+				<attribute name="id"><data type="int"/></attribute>
+				<optional><attribute name="status"><choice><value>open</value><value>closed</value></choice></attribute></optional>
+				<attribute name="currency"/>
+				<optional><ref name="note"/></optional>
+				<oneOrMore><ref name="item"/></oneOrMore>
+			</element>
+		</define>
+		<define name="note"><element name="note"><text/></element></define>
+		<define name="item">
+			<element name="item">
+				<element name="quantity"><data type="int"/></element>
+				<element name="price"><data type="decimal"/></element>
+			</element>
+		</define>
+	</grammar>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := Simplify([]byte(`<grammar xmlns="http://relaxng.org/ns/structure/1.0" datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+		<start><ref name="purchase-order"/></start>
+		<define name="line">
+			<element name="item">
+				<element name="price"><data type="decimal"/></element>
+				<element name="quantity"><data type="long"/></element>
+				<optional><element name="sku"><data type="token"><param name="maxLength">8</param></data></element></optional>
+			</element>
+		</define>
+		<define name="purchase-order">
+			<element name="order">
+				<attribute name="id"><data type="int"/></attribute>
+				<attribute name="status"><choice><value>open</value><value>shipped</value></choice></attribute>
+				<attribute name="priority"><data type="int"/></attribute>
+				<zeroOrMore><ref name="line"/></zeroOrMore>
+			</element>
+		</define>
+	</grammar>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Diff(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`/order/@currency: removed attribute currency`,
+		`/order/@priority: added required attribute priority`,
+		`/order/@status: attribute status changed from optional to required`,
+		`/order/@status: values of attribute status changed: added "shipped", removed "closed"`,
+		`/order/item: cardinality of element item changed from + to *`,
+		`/order/note: removed element note`,
+		`/order/item/sku: added optional element sku`,
+		`/order/item/quantity: datatype of the text of element quantity changed from int to long`,
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected\n%s\nbut got\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	data, err := json.Marshal(changes[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := `{"kind":"cardinality-changed","path":"/order/@status","old":"?","new":"1","description":"attribute status changed from optional to required"}`; string(data) != s {
+		t.Fatalf("expected %s, but got %s", s, data)
+	}
+}
+
+func TestDiffRenamed(t *testing.T) {
+	old, err := Simplify([]byte(`<grammar xmlns="http://relaxng.org/ns/structure/1.0">
+		<start><ref name="list"/></start>
+		<define name="list"><element name="list"><zeroOrMore><ref name="list"/></zeroOrMore><ref name="item"/></element></define>
+		<define name="item"><element name="item"><attribute name="id"/></element></define>
+	</grammar>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := Simplify([]byte(`<grammar xmlns="http://relaxng.org/ns/structure/1.0">
+		<define name="entry"><element name="item"><attribute name="id"/></element></define>
+		<define name="tree"><element name="list"><zeroOrMore><ref name="tree"/></zeroOrMore><ref name="entry"/></element></define>
+		<start><ref name="tree"/></start>
+	</grammar>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Diff(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) > 0 {
+		t.Fatalf("expected no changes for renamed and reordered defines, but got %v", changes)
+	}
+}
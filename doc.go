@@ -0,0 +1,345 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+//DocOptions configures the documentation that GenerateDoc renders.
+type DocOptions struct {
+	//Format is either html or markdown, where the default is html.
+	Format string
+	//Title is the title of the document, where the default is Schema.
+	Title string
+}
+
+//GenerateDoc renders documentation of the simplified grammar as a static HTML page or as Markdown.
+//Every element has its own section, with the text of its a:documentation annotations,
+//its content model, its attributes and their datatypes, its child elements and the elements that it is used in.
+func GenerateDoc(g *Grammar, opts DocOptions) ([]byte, error) {
+	var w docWriter
+	buf := bytes.NewBuffer(nil)
+	switch opts.Format {
+	case "", "html":
+		w = &htmlDoc{buf}
+	case "markdown":
+		w = &markdownDoc{buf}
+	default:
+		return nil, fmt.Errorf("unknown documentation format %q", opts.Format)
+	}
+	title := opts.Title
+	if len(title) == 0 {
+		title = "Schema"
+	}
+	elements, prefixes := newDocElements(g)
+
+	w.begin(title)
+	index := make([]docLink, len(elements))
+	for i, e := range elements {
+		index[i] = docLink{e.id, e.title()}
+	}
+	w.links("Elements", index)
+	for _, e := range elements {
+		w.heading(2, e.id, e.title())
+		for _, p := range e.documentation {
+			w.paragraph(p)
+		}
+		if e.root {
+			w.paragraph("This is a root element.")
+		}
+		w.heading(3, "", "Content model")
+		w.code(e.content)
+		if len(e.attrs) > 0 {
+			w.heading(3, "", "Attributes")
+			rows := make([][]string, len(e.attrs))
+			for i, a := range e.attrs {
+				rows[i] = []string{a.name, a.use, a.datatype, a.values, strings.Join(a.documentation, " ")}
+			}
+			w.table([]string{"Name", "Use", "Datatype", "Values", "Documentation"}, rows)
+		}
+		if len(e.text) > 0 {
+			w.paragraph("Text: " + e.text)
+		}
+		if len(e.children) > 0 {
+			w.links("Children", e.children)
+		}
+		if len(e.usedIn) > 0 {
+			w.links("Used in", e.usedIn)
+		}
+	}
+	if len(prefixes) > 0 {
+		w.heading(2, "", "Prefixes")
+		w.table([]string{"Prefix", "URI"}, prefixes)
+	}
+	w.end()
+	return buf.Bytes(), nil
+}
+
+//docElement is the documentation of the element of a define.
+type docElement struct {
+	//id is the name of the define, which is unique, unlike the name of the element.
+	id            string
+	name          string
+	documentation []string
+	root          bool
+	content       string
+	attrs         []*docAttribute
+	text          string
+	children      []docLink
+	usedIn        []docLink
+}
+
+func (this *docElement) title() string {
+	if this.name == this.id {
+		return this.name
+	}
+	return this.name + " (" + this.id + ")"
+}
+
+type docAttribute struct {
+	name          string
+	use           string
+	datatype      string
+	values        string
+	documentation []string
+}
+
+type docLink struct {
+	id   string
+	text string
+}
+
+//newDocElements returns the documentation of every define
+//and the prefixes, of namespaces and datatype libraries, that are used in the content models.
+func newDocElements(g *Grammar) ([]*docElement, [][]string) {
+	w := &compactWriter{
+		namespaces: map[string]string{xmlNs: "xml"},
+		libraries:  map[string]string{xsdDatatypes: "xsd"},
+	}
+	elements := make([]*docElement, len(g.Define))
+	byName := make(map[string]*docElement, len(g.Define))
+	for i, d := range g.Define {
+		e := &docElement{
+			id:            d.Name,
+			name:          nameClassString(d.Element.Left),
+			documentation: Documentation(d.Annotations),
+		}
+		elements[i] = e
+		byName[d.Name] = e
+	}
+	for _, ref := range refs(g.Start) {
+		if e, ok := byName[ref]; ok {
+			e.root = true
+		}
+	}
+	for i, d := range g.Define {
+		e := elements[i]
+		content := d.Element.Right
+		e.content = w.pattern(content)
+		e.attrs = docAttributes(content)
+		if text := newValueSet(content); len(text.types) > 0 || len(text.values) > 0 {
+			e.text = text.typesString()
+			if len(text.values) > 0 {
+				e.text += ": " + quoteAll(text.values)
+			}
+		}
+		var children []string
+		for _, ref := range refs(content) {
+			children = appendUnique(children, ref)
+		}
+		for _, ref := range children {
+			child, ok := byName[ref]
+			if !ok {
+				continue
+			}
+			e.children = append(e.children, docLink{child.id, child.title()})
+			child.usedIn = append(child.usedIn, docLink{e.id, e.title()})
+		}
+	}
+	var prefixes [][]string
+	for _, ns := range w.nsOrder {
+		prefixes = append(prefixes, []string{w.namespaces[ns], ns})
+	}
+	for _, lib := range w.libOrder {
+		prefixes = append(prefixes, []string{w.libraries[lib], lib})
+	}
+	//The xsd prefix is predeclared by the compact writer.
+	for _, e := range elements {
+		if strings.Contains(e.content, "xsd:") {
+			prefixes = append(prefixes, []string{"xsd", xsdDatatypes})
+			break
+		}
+	}
+	return elements, prefixes
+}
+
+//docAttributes returns the documentation of the attributes in the content of an element,
+//where attributes with the same name class are merged.
+func docAttributes(content *NameOrPattern) []*docAttribute {
+	var names []string
+	patterns := make(map[string][]*NameOrPattern)
+	docs := make(map[string][]string)
+	for _, a := range attributes(content) {
+		name := nameClassString(a.Attribute.Left)
+		names = appendUnique(names, name)
+		patterns[name] = append(patterns[name], a.Attribute.Right)
+		docs[name] = appendUnique(docs[name], Documentation(a.Annotations)...)
+	}
+	attrs := make([]*docAttribute, len(names))
+	for i, name := range names {
+		c := patternCardinality(content, func(p *NameOrPattern) bool {
+			return p.Attribute != nil && nameClassString(p.Attribute.Left) == name
+		})
+		values := newValueSet(patterns[name]...)
+		attrs[i] = &docAttribute{
+			name:          name,
+			use:           c.requirement(),
+			datatype:      values.typesString(),
+			values:        quoteAll(values.values),
+			documentation: docs[name],
+		}
+	}
+	return attrs
+}
+
+//docWriter writes the parts of the documentation in a specific format.
+type docWriter interface {
+	begin(title string)
+	//heading writes a heading, which is the target of links to the id, if the id is not empty.
+	heading(level int, id, text string)
+	paragraph(text string)
+	//links writes a paragraph with a label and links to sections.
+	links(label string, links []docLink)
+	code(text string)
+	table(header []string, rows [][]string)
+	end()
+}
+
+type htmlDoc struct {
+	buf *bytes.Buffer
+}
+
+func (this *htmlDoc) begin(title string) {
+	fmt.Fprintf(this.buf, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", html.EscapeString(title))
+	fmt.Fprintf(this.buf, "<h1>%s</h1>\n", html.EscapeString(title))
+}
+
+func (this *htmlDoc) heading(level int, id, text string) {
+	if len(id) > 0 {
+		fmt.Fprintf(this.buf, "<h%d id=\"%s\">%s</h%d>\n", level, html.EscapeString(id), html.EscapeString(text), level)
+		return
+	}
+	fmt.Fprintf(this.buf, "<h%d>%s</h%d>\n", level, html.EscapeString(text), level)
+}
+
+func (this *htmlDoc) paragraph(text string) {
+	fmt.Fprintf(this.buf, "<p>%s</p>\n", html.EscapeString(text))
+}
+
+func (this *htmlDoc) links(label string, links []docLink) {
+	as := make([]string, len(links))
+	for i, l := range links {
+		as[i] = fmt.Sprintf("<a href=\"#%s\">%s</a>", html.EscapeString(l.id), html.EscapeString(l.text))
+	}
+	fmt.Fprintf(this.buf, "<p>%s: %s</p>\n", html.EscapeString(label), strings.Join(as, ", "))
+}
+
+func (this *htmlDoc) code(text string) {
+	fmt.Fprintf(this.buf, "<pre>%s</pre>\n", html.EscapeString(text))
+}
+
+func (this *htmlDoc) table(header []string, rows [][]string) {
+	this.buf.WriteString("<table>\n<tr>")
+	for _, h := range header {
+		fmt.Fprintf(this.buf, "<th>%s</th>", html.EscapeString(h))
+	}
+	this.buf.WriteString("</tr>\n")
+	for _, row := range rows {
+		this.buf.WriteString("<tr>")
+		for _, cell := range row {
+			fmt.Fprintf(this.buf, "<td>%s</td>", html.EscapeString(cell))
+		}
+		this.buf.WriteString("</tr>\n")
+	}
+	this.buf.WriteString("</table>\n")
+}
+
+func (this *htmlDoc) end() {
+	this.buf.WriteString("</body>\n</html>\n")
+}
+
+type markdownDoc struct {
+	buf *bytes.Buffer
+}
+
+//markdownEscaper escapes the characters that would otherwise be read as Markdown or HTML.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `&lt;`, `|`, `\|`, `#`, `\#`,
+)
+
+func (this *markdownDoc) begin(title string) {
+	fmt.Fprintf(this.buf, "# %s\n", markdownEscaper.Replace(title))
+}
+
+func (this *markdownDoc) heading(level int, id, text string) {
+	this.buf.WriteString("\n")
+	if len(id) > 0 {
+		fmt.Fprintf(this.buf, "<a id=\"%s\"></a>\n", html.EscapeString(id))
+	}
+	fmt.Fprintf(this.buf, "%s %s\n", strings.Repeat("#", level), markdownEscaper.Replace(text))
+}
+
+func (this *markdownDoc) paragraph(text string) {
+	fmt.Fprintf(this.buf, "\n%s\n", markdownEscaper.Replace(text))
+}
+
+func (this *markdownDoc) links(label string, links []docLink) {
+	as := make([]string, len(links))
+	for i, l := range links {
+		as[i] = fmt.Sprintf("[%s](#%s)", markdownEscaper.Replace(l.text), l.id)
+	}
+	fmt.Fprintf(this.buf, "\n%s: %s\n", markdownEscaper.Replace(label), strings.Join(as, ", "))
+}
+
+func (this *markdownDoc) code(text string) {
+	fmt.Fprintf(this.buf, "\n    %s\n", text)
+}
+
+func (this *markdownDoc) table(header []string, rows [][]string) {
+	this.buf.WriteString("\n")
+	this.row(header)
+	separator := make([]string, len(header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	this.buf.WriteString("| " + strings.Join(separator, " | ") + " |\n")
+	for _, row := range rows {
+		this.row(row)
+	}
+}
+
+func (this *markdownDoc) row(cells []string) {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = markdownEscaper.Replace(cell)
+	}
+	this.buf.WriteString("| " + strings.Join(escaped, " | ") + " |\n")
+}
+
+func (this *markdownDoc) end() {}
@@ -0,0 +1,140 @@
+package relaxng
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDocSuite(t *testing.T) {
+	for _, spec := range scanFiles() {
+		if len(spec.SimpleFilename) == 0 {
+			continue
+		}
+		g, err := SimplifyFile(filepath.Join(filepath.Dir(spec.Filename), "c.rng"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []string{"html", "markdown"} {
+			if _, err := GenerateDoc(g, DocOptions{Format: format}); err != nil {
+				t.Fatalf("%s: %v", testNumber(spec.Filename), err)
+			}
+		}
+	}
+}
+
+var docGrammar = `<grammar xmlns="http://relaxng.org/ns/structure/1.0"
+	xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0"
+	datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+	<start><ref name="order"/></start>
+	<define name="order">
+		<a:documentation>An order of items.
+
+		Orders are never deleted.</a:documentation>
+		<element name="order">
+			<attribute name="id">
+				<a:documentation>The <b>unique</b> identifier.</a:documentation>
+				<data type="ID"/>
+			</attribute>
+			<optional>
+				<attribute name="status">
+					<choice><value type="token" datatypeLibrary="">open</value><value type="token" datatypeLibrary="">closed</value></choice>
+				</attribute>
+			</optional>
+			<oneOrMore><ref name="item"/></oneOrMore>
+		</element>
+	</define>
+	<define name="item">
+		<element name="item">
+			<a:documentation>A line of the order.</a:documentation>
+			<x:hint xmlns:x="urn:tool">ignored</x:hint>
+			<data type="string"/>
+		</element>
+	</define>
+</grammar>`
+
+func TestGenerateDocMarkdown(t *testing.T) {
+	g, err := Simplify([]byte(docGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := GenerateDoc(g, DocOptions{Format: "markdown", Title: "Orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Orders
+
+Elements: [order](#order), [item](#item)
+
+<a id="order"></a>
+## order
+
+An order of items.
+
+Orders are never deleted.
+
+This is a root element.
+
+### Content model
+
+    attribute id { xsd:ID }, attribute status { "open" | "closed" }?, item+
+
+### Attributes
+
+| Name | Use | Datatype | Values | Documentation |
+| --- | --- | --- | --- | --- |
+| id | required | ID |  | The unique identifier. |
+| status | optional | enumeration | "closed", "open" |  |
+
+Children: [item](#item)
+
+<a id="item"></a>
+## item
+
+A line of the order.
+
+### Content model
+
+    xsd:string
+
+Text: string
+
+Used in: [order](#order)
+
+## Prefixes
+
+| Prefix | URI |
+| --- | --- |
+| xsd | http://www.w3.org/2001/XMLSchema-datatypes |
+`
+	if string(doc) != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, doc)
+	}
+}
+
+func TestGenerateDocHTML(t *testing.T) {
+	g, err := Simplify([]byte(docGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := GenerateDoc(g, DocOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<title>Schema</title>`,
+		`<p>Elements: <a href="#order">order</a>, <a href="#item">item</a></p>`,
+		`<h2 id="order">order</h2>`,
+		`<p>Orders are never deleted.</p>`,
+		`<pre>attribute id { xsd:ID }, attribute status { &#34;open&#34; | &#34;closed&#34; }?, item+</pre>`,
+		`<tr><td>id</td><td>required</td><td>ID</td><td></td><td>The unique identifier.</td></tr>`,
+		`<p>Used in: <a href="#order">order</a></p>`,
+	} {
+		if !strings.Contains(string(doc), want) {
+			t.Fatalf("expected %s in\n%s", want, doc)
+		}
+	}
+	if _, err := GenerateDoc(g, DocOptions{Format: "pdf"}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,940 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	//compatibilityAnnotations is the namespace of the annotations of the RelaxNG DTD compatibility specification.
+	compatibilityAnnotations = "http://relaxng.org/ns/compatibility/annotations/1.0"
+	//compatibilityDatatypes is the datatype library, of the RelaxNG DTD compatibility specification, that contains ID, IDREF and IDREFS.
+	compatibilityDatatypes = "http://relaxng.org/ns/compatibility/datatypes/1.0"
+)
+
+//DTDOptions configures the import of a DTD.
+type DTDOptions struct {
+	//Root is the name of the document element.
+	//If it is empty, every declared element can be the document element.
+	Root string
+	//Filename is the name of the DTD file, relative to which external parameter entities are read.
+	//External parameter entities can only be referenced if the Filename is set.
+	Filename string
+}
+
+//DTDToRelaxNG converts a DTD into a full RelaxNG grammar.
+//Element declarations become defines, which are named after the element,
+//with the attributes declared by the attribute lists of the element.
+//Mixed content becomes a choice of text and elements, which can repeat, and ANY a choice of text and all declared elements.
+//Attribute defaults and fixed values are annotated with a:defaultValue, from the RelaxNG DTD compatibility annotations,
+//and ID, IDREF and IDREFS attributes use the DTD compatibility datatypes.
+//Parameter entities, conditional sections and namespaces, that are declared with fixed xmlns attributes, are supported.
+//NMTOKEN and ENTITY attributes are imported as token and NMTOKENS and ENTITIES as a list of tokens,
+//which also accept tokens that are not names, so the grammar, which is looser than the DTD,
+//is returned together with an *UnsupportedError that lists these attribute types.
+func DTDToRelaxNG(dtd []byte, opts DTDOptions) ([]byte, error) {
+	p := newDTDParser(dtd, opts.Filename)
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	g, err := p.grammar(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	writeNode(buf, g, 0)
+	if len(p.approximated) > 0 {
+		return buf.Bytes(), &UnsupportedError{Features: p.approximated}
+	}
+	return buf.Bytes(), nil
+}
+
+//FromDTD converts a DTD into a simplified Grammar.
+//See DTDToRelaxNG.
+func FromDTD(dtd []byte, opts DTDOptions) (*Grammar, error) {
+	rng, err := DTDToRelaxNG(dtd, opts)
+	unsupported, ok := err.(*UnsupportedError)
+	if err != nil && !ok {
+		return nil, err
+	}
+	g, err := Simplify(rng)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return g, unsupported
+	}
+	return g, nil
+}
+
+//dtdElement is an element declaration.
+type dtdElement struct {
+	name    string
+	content *rngNode
+}
+
+//dtdAttribute is an attribute definition of an attribute list declaration.
+type dtdAttribute struct {
+	name string
+	//typ is the attribute type or ( for enumerations.
+	typ    string
+	values []string
+	//use is #REQUIRED, #IMPLIED, #FIXED or empty for an attribute with a default value.
+	use   string
+	value string
+}
+
+//dtdInput is the text of the DTD or of a parameter entity that is being parsed.
+type dtdInput struct {
+	text   string
+	pos    int
+	entity string
+	dir    string
+}
+
+type dtdParser struct {
+	inputs    []*dtdInput
+	entities  map[string]string
+	externals map[string]string
+	elements  []*dtdElement
+	declared  map[string]*dtdElement
+	attlists  map[string][]*dtdAttribute
+	//referenced holds the element names that are used in content models.
+	referenced []string
+	//sections is the number of include sections that are open.
+	sections int
+	//approximated holds the attribute types that are imported as a looser type.
+	approximated []string
+}
+
+func newDTDParser(dtd []byte, filename string) *dtdParser {
+	dir := ""
+	if len(filename) > 0 {
+		dir = filepath.Dir(filename)
+	}
+	return &dtdParser{
+		inputs:    []*dtdInput{{text: string(dtd), dir: dir}},
+		entities:  make(map[string]string),
+		externals: make(map[string]string),
+		declared:  make(map[string]*dtdElement),
+		attlists:  make(map[string][]*dtdAttribute),
+	}
+}
+
+func (this *dtdParser) input() *dtdInput {
+	return this.inputs[len(this.inputs)-1]
+}
+
+//line returns the line number in the DTD, of the current position.
+func (this *dtdParser) line() int {
+	in := this.inputs[0]
+	return strings.Count(in.text[:in.pos], "\n") + 1
+}
+
+func (this *dtdParser) errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if in := this.input(); len(in.entity) > 0 {
+		return fmt.Errorf("line %d: in parameter entity %s: %s", this.line(), in.entity, msg)
+	}
+	return fmt.Errorf("line %d: %s", this.line(), msg)
+}
+
+//peek returns the next byte, after finishing the parameter entities that have been read, or 0 at the end of the DTD.
+func (this *dtdParser) peek() byte {
+	for {
+		in := this.input()
+		if in.pos < len(in.text) {
+			return in.text[in.pos]
+		}
+		if len(this.inputs) == 1 {
+			return 0
+		}
+		this.inputs = this.inputs[:len(this.inputs)-1]
+	}
+}
+
+func (this *dtdParser) next() byte {
+	c := this.peek()
+	if c != 0 {
+		this.input().pos++
+	}
+	return c
+}
+
+func (this *dtdParser) hasPrefix(s string) bool {
+	in := this.input()
+	return strings.HasPrefix(in.text[in.pos:], s)
+}
+
+func (this *dtdParser) expect(s string) error {
+	this.peek()
+	if !this.hasPrefix(s) {
+		return this.errorf("expected %s", s)
+	}
+	this.input().pos += len(s)
+	return nil
+}
+
+//skipUntil skips past the end string in the current input.
+func (this *dtdParser) skipUntil(end string) error {
+	this.peek()
+	in := this.input()
+	i := strings.Index(in.text[in.pos:], end)
+	if i < 0 {
+		return this.errorf("expected %s", end)
+	}
+	in.pos += i + len(end)
+	return nil
+}
+
+func isDTDSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == ':' || r == '.' || r == '-' ||
+		unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r) || r == '·'
+}
+
+//skipSpace skips white space and replaces parameter entity references with their replacement text.
+func (this *dtdParser) skipSpace() error {
+	for {
+		c := this.peek()
+		switch {
+		case isDTDSpace(c):
+			this.next()
+		case c == '%' && this.isReference():
+			this.next()
+			name := this.name()
+			if err := this.expect(";"); err != nil {
+				return err
+			}
+			if err := this.push(name); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+//isReference returns whether the % at the current position starts a parameter entity reference.
+func (this *dtdParser) isReference() bool {
+	in := this.input()
+	if in.pos+1 >= len(in.text) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(in.text[in.pos+1:])
+	return isNameRune(r)
+}
+
+//push starts reading the replacement text of the parameter entity, surrounded by spaces.
+func (this *dtdParser) push(name string) error {
+	text, dir, err := this.entity(name)
+	if err != nil {
+		return err
+	}
+	this.inputs = append(this.inputs, &dtdInput{text: " " + text + " ", entity: name, dir: dir})
+	return nil
+}
+
+//entity returns the replacement text of the parameter entity and the directory that it was read from.
+func (this *dtdParser) entity(name string) (string, string, error) {
+	for _, in := range this.inputs {
+		if in.entity == name {
+			return "", "", this.errorf("parameter entity %s references itself", name)
+		}
+	}
+	if text, ok := this.entities[name]; ok {
+		return text, this.input().dir, nil
+	}
+	filename, ok := this.externals[name]
+	if !ok {
+		return "", "", this.errorf("undeclared parameter entity %s", name)
+	}
+	if !filepath.IsAbs(filename) {
+		dir := this.input().dir
+		if len(dir) == 0 {
+			return "", "", this.errorf("external parameter entity %s can only be read if the filename of the DTD is known", name)
+		}
+		filename = filepath.Join(dir, filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", "", this.errorf("%v", err)
+	}
+	text := string(data)
+	if strings.HasPrefix(text, "<?xml") {
+		if end := strings.Index(text, "?>"); end >= 0 {
+			text = text[end+2:]
+		}
+	}
+	return text, filepath.Dir(filename), nil
+}
+
+//name reads a name from the current input.
+func (this *dtdParser) name() string {
+	this.peek()
+	in := this.input()
+	start := in.pos
+	for in.pos < len(in.text) {
+		r, size := utf8.DecodeRuneInString(in.text[in.pos:])
+		if !isNameRune(r) {
+			break
+		}
+		in.pos += size
+	}
+	return in.text[start:in.pos]
+}
+
+func (this *dtdParser) requireName(what string) (string, error) {
+	if err := this.skipSpace(); err != nil {
+		return "", err
+	}
+	name := this.name()
+	if len(name) == 0 {
+		return "", this.errorf("expected %s", what)
+	}
+	return name, nil
+}
+
+//literal reads a quoted string, where character references are replaced
+//and, if entityValue is true, parameter entity references are replaced.
+func (this *dtdParser) literal(entityValue bool) (string, error) {
+	if err := this.skipSpace(); err != nil {
+		return "", err
+	}
+	quote := this.peek()
+	if quote != '"' && quote != '\'' {
+		return "", this.errorf("expected a quoted string")
+	}
+	this.next()
+	in := this.input()
+	end := strings.IndexByte(in.text[in.pos:], quote)
+	if end < 0 {
+		return "", this.errorf("unterminated string")
+	}
+	s := in.text[in.pos : in.pos+end]
+	in.pos += end + 1
+	return this.expandLiteral(s, entityValue, 0)
+}
+
+func (this *dtdParser) expandLiteral(s string, entityValue bool, depth int) (string, error) {
+	if depth > 32 {
+		return "", this.errorf("parameter entities are nested too deeply")
+	}
+	buf := bytes.NewBuffer(nil)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "&#"):
+			end := strings.IndexByte(s[i:], ';')
+			if end < 0 {
+				return "", this.errorf("unterminated character reference")
+			}
+			ref := s[i+2 : i+end]
+			var r int64
+			var err error
+			if strings.HasPrefix(ref, "x") {
+				r, err = strconv.ParseInt(ref[1:], 16, 32)
+			} else {
+				r, err = strconv.ParseInt(ref, 10, 32)
+			}
+			if err != nil {
+				return "", this.errorf("invalid character reference &#%s;", ref)
+			}
+			buf.WriteRune(rune(r))
+			i += end
+		case entityValue && s[i] == '%':
+			end := strings.IndexByte(s[i:], ';')
+			if end < 0 {
+				return "", this.errorf("unterminated parameter entity reference")
+			}
+			text, _, err := this.entity(s[i+1 : i+end])
+			if err != nil {
+				return "", err
+			}
+			text, err = this.expandLiteral(text, true, depth+1)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(text)
+			i += end
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String(), nil
+}
+
+//parse reads all the markup declarations.
+func (this *dtdParser) parse() error {
+	for {
+		if err := this.skipSpace(); err != nil {
+			return err
+		}
+		var err error
+		switch {
+		case this.peek() == 0:
+			if this.sections > 0 {
+				return this.errorf("unterminated conditional section")
+			}
+			return nil
+		case this.hasPrefix("<!--"):
+			err = this.skipUntil("-->")
+		case this.hasPrefix("<?"):
+			err = this.skipUntil("?>")
+		case this.hasPrefix("<!ELEMENT"):
+			this.input().pos += len("<!ELEMENT")
+			err = this.element()
+		case this.hasPrefix("<!ATTLIST"):
+			this.input().pos += len("<!ATTLIST")
+			err = this.attlist()
+		case this.hasPrefix("<!ENTITY"):
+			this.input().pos += len("<!ENTITY")
+			err = this.entityDecl()
+		case this.hasPrefix("<!NOTATION"):
+			err = this.skipDecl()
+		case this.hasPrefix("<!["):
+			this.input().pos += len("<![")
+			err = this.conditional()
+		case this.hasPrefix("]]>") && this.sections > 0:
+			this.input().pos += len("]]>")
+			this.sections--
+		default:
+			return this.errorf("unexpected %q", this.peek())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//skipDecl skips to the end of a declaration, ignoring > in quoted strings.
+func (this *dtdParser) skipDecl() error {
+	var quote byte
+	for {
+		c := this.next()
+		switch {
+		case c == 0:
+			return this.errorf("unterminated declaration")
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return nil
+		}
+	}
+}
+
+func (this *dtdParser) end() error {
+	if err := this.skipSpace(); err != nil {
+		return err
+	}
+	return this.expect(">")
+}
+
+//conditional starts an include section or skips an ignore section.
+func (this *dtdParser) conditional() error {
+	keyword, err := this.requireName("INCLUDE or IGNORE")
+	if err != nil {
+		return err
+	}
+	if err := this.skipSpace(); err != nil {
+		return err
+	}
+	if err := this.expect("["); err != nil {
+		return err
+	}
+	switch keyword {
+	case "INCLUDE":
+		this.sections++
+		return nil
+	case "IGNORE":
+		in := this.input()
+		for depth := 1; depth > 0; {
+			open := strings.Index(in.text[in.pos:], "<![")
+			close := strings.Index(in.text[in.pos:], "]]>")
+			if close < 0 {
+				return this.errorf("unterminated ignore section")
+			}
+			if open >= 0 && open < close {
+				depth++
+				in.pos += open + 3
+				continue
+			}
+			depth--
+			in.pos += close + 3
+		}
+		return nil
+	}
+	return this.errorf("expected INCLUDE or IGNORE, but got %s", keyword)
+}
+
+func (this *dtdParser) entityDecl() error {
+	if err := this.skipSpace(); err != nil {
+		return err
+	}
+	parameter := false
+	if this.peek() == '%' {
+		this.next()
+		parameter = true
+	}
+	name, err := this.requireName("an entity name")
+	if err != nil {
+		return err
+	}
+	if err := this.skipSpace(); err != nil {
+		return err
+	}
+	if c := this.peek(); c == '"' || c == '\'' {
+		value, err := this.literal(parameter)
+		if err != nil {
+			return err
+		}
+		if _, ok := this.entities[name]; parameter && !ok {
+			if _, ok := this.externals[name]; !ok {
+				this.entities[name] = value
+			}
+		}
+		return this.end()
+	}
+	keyword := this.name()
+	switch keyword {
+	case "PUBLIC":
+		if _, err := this.literal(false); err != nil {
+			return err
+		}
+	case "SYSTEM":
+	default:
+		return this.errorf("expected an entity value, PUBLIC or SYSTEM, but got %q", keyword)
+	}
+	system, err := this.literal(false)
+	if err != nil {
+		return err
+	}
+	if _, ok := this.entities[name]; parameter && !ok {
+		if _, ok := this.externals[name]; !ok {
+			this.externals[name] = system
+		}
+	}
+	//General entities, with an NDATA notation, do not affect the grammar.
+	return this.skipDecl()
+}
+
+func (this *dtdParser) element() error {
+	name, err := this.requireName("an element name")
+	if err != nil {
+		return err
+	}
+	if _, ok := this.declared[name]; ok {
+		return this.errorf("element %s is declared more than once", name)
+	}
+	if err := this.skipSpace(); err != nil {
+		return err
+	}
+	var content *rngNode
+	switch {
+	case this.hasPrefix("EMPTY"):
+		this.input().pos += len("EMPTY")
+		content = newRngNode("empty")
+	case this.hasPrefix("ANY"):
+		this.input().pos += len("ANY")
+		//ANY is replaced with the choice of all elements when the grammar is created.
+		content = newRngNode("any")
+	case this.peek() == '(':
+		content, err = this.contentModel()
+		if err != nil {
+			return err
+		}
+	default:
+		return this.errorf("expected a content model for element %s", name)
+	}
+	e := &dtdElement{name: name, content: content}
+	this.declared[name] = e
+	this.elements = append(this.elements, e)
+	return this.end()
+}
+
+//contentModel reads mixed content or a choice or sequence of content particles.
+func (this *dtdParser) contentModel() (*rngNode, error) {
+	this.next()
+	if err := this.skipSpace(); err != nil {
+		return nil, err
+	}
+	if !this.hasPrefix("#PCDATA") {
+		return this.group()
+	}
+	this.input().pos += len("#PCDATA")
+	choices := []*rngNode{newRngNode("text")}
+	for {
+		if err := this.skipSpace(); err != nil {
+			return nil, err
+		}
+		if this.peek() == ')' {
+			this.next()
+			break
+		}
+		if err := this.expect("|"); err != nil {
+			return nil, err
+		}
+		name, err := this.requireName("an element name")
+		if err != nil {
+			return nil, err
+		}
+		choices = append(choices, this.ref(name))
+	}
+	if this.peek() == '*' {
+		this.next()
+	} else if len(choices) > 1 {
+		return nil, this.errorf("expected * after mixed content with elements")
+	}
+	if len(choices) == 1 {
+		return choices[0], nil
+	}
+	return newRngNode("zeroOrMore", newRngNode("choice", choices...)), nil
+}
+
+//group reads a choice or sequence after its opening bracket.
+func (this *dtdParser) group() (*rngNode, error) {
+	var particles []*rngNode
+	separator := byte(0)
+	for {
+		p, err := this.particle()
+		if err != nil {
+			return nil, err
+		}
+		particles = append(particles, p)
+		if err := this.skipSpace(); err != nil {
+			return nil, err
+		}
+		c := this.next()
+		if c == ')' {
+			break
+		}
+		if c != ',' && c != '|' {
+			return nil, this.errorf("expected , | or ) in content model")
+		}
+		if separator != 0 && c != separator {
+			return nil, this.errorf("content model mixes , and |")
+		}
+		separator = c
+	}
+	var g *rngNode
+	switch {
+	case len(particles) == 1:
+		g = particles[0]
+	case separator == '|':
+		g = newRngNode("choice", particles...)
+	default:
+		g = newRngNode("group", particles...)
+	}
+	return this.occurrence(g), nil
+}
+
+//particle reads a name or a bracketed group with its occurrence indicator.
+func (this *dtdParser) particle() (*rngNode, error) {
+	if err := this.skipSpace(); err != nil {
+		return nil, err
+	}
+	if this.peek() == '(' {
+		this.next()
+		return this.group()
+	}
+	name := this.name()
+	if len(name) == 0 {
+		return nil, this.errorf("expected an element name or ( in content model")
+	}
+	return this.occurrence(this.ref(name)), nil
+}
+
+func (this *dtdParser) occurrence(p *rngNode) *rngNode {
+	switch this.peek() {
+	case '?':
+		this.next()
+		return newRngNode("optional", p)
+	case '*':
+		this.next()
+		return newRngNode("zeroOrMore", p)
+	case '+':
+		this.next()
+		return newRngNode("oneOrMore", p)
+	}
+	return p
+}
+
+func (this *dtdParser) ref(name string) *rngNode {
+	this.referenced = append(this.referenced, name)
+	return refNode(dtdDefineName(name))
+}
+
+//dtdDefineName returns the define name for an element name, which could contain a colon.
+func dtdDefineName(name string) string {
+	return strings.Replace(name, ":", ".", -1)
+}
+
+var dtdAttributeTypes = map[string]bool{
+	"CDATA": true, "ID": true, "IDREF": true, "IDREFS": true, "ENTITY": true, "ENTITIES": true,
+	"NMTOKEN": true, "NMTOKENS": true, "NOTATION": true,
+}
+
+func (this *dtdParser) attlist() error {
+	element, err := this.requireName("an element name")
+	if err != nil {
+		return err
+	}
+	for {
+		if err := this.skipSpace(); err != nil {
+			return err
+		}
+		if this.peek() == '>' {
+			this.next()
+			return nil
+		}
+		a := &dtdAttribute{}
+		a.name, err = this.requireName("an attribute name")
+		if err != nil {
+			return err
+		}
+		if err := this.skipSpace(); err != nil {
+			return err
+		}
+		if this.peek() == '(' {
+			a.typ = "("
+		} else {
+			a.typ = this.name()
+			if !dtdAttributeTypes[a.typ] {
+				return this.errorf("unknown attribute type %q for %s", a.typ, a.name)
+			}
+			if err := this.skipSpace(); err != nil {
+				return err
+			}
+		}
+		if a.typ == "(" || a.typ == "NOTATION" {
+			if err := this.expect("("); err != nil {
+				return err
+			}
+			for {
+				value, err := this.requireName("an enumerated value")
+				if err != nil {
+					return err
+				}
+				a.values = append(a.values, value)
+				if err := this.skipSpace(); err != nil {
+					return err
+				}
+				if c := this.next(); c == ')' {
+					break
+				} else if c != '|' {
+					return this.errorf("expected | or ) in enumeration")
+				}
+			}
+		}
+		if err := this.skipSpace(); err != nil {
+			return err
+		}
+		if this.peek() == '#' {
+			this.next()
+			a.use = "#" + this.name()
+			switch a.use {
+			case "#REQUIRED", "#IMPLIED":
+			case "#FIXED":
+				if a.value, err = this.literal(false); err != nil {
+					return err
+				}
+			default:
+				return this.errorf("unknown attribute default %s", a.use)
+			}
+		} else if a.value, err = this.literal(false); err != nil {
+			return err
+		}
+		if this.attribute(element, a.name) == nil {
+			this.attlists[element] = append(this.attlists[element], a)
+		}
+	}
+}
+
+//attribute returns the first definition of the attribute of the element, which is the binding one.
+func (this *dtdParser) attribute(element, name string) *dtdAttribute {
+	for _, a := range this.attlists[element] {
+		if a.name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+//namespaces returns the namespaces that are declared with fixed xmlns attributes.
+func (this *dtdParser) namespaces() (map[string]string, error) {
+	ns := map[string]string{"xml": "http://www.w3.org/XML/1998/namespace"}
+	for _, e := range this.elements {
+		for _, a := range this.attlists[e.name] {
+			prefix := ""
+			if strings.HasPrefix(a.name, "xmlns:") {
+				prefix = a.name[len("xmlns:"):]
+			} else if a.name != "xmlns" {
+				continue
+			}
+			if a.use != "#FIXED" && len(a.value) == 0 {
+				continue
+			}
+			if uri, ok := ns[prefix]; ok && uri != a.value {
+				return nil, fmt.Errorf("%s is declared as both %s and %s", a.name, uri, a.value)
+			}
+			ns[prefix] = a.value
+		}
+	}
+	return ns, nil
+}
+
+//qualify returns the name and namespace for the name in the DTD.
+func qualify(name string, namespaces map[string]string, attribute bool) (map[string]string, error) {
+	prefix, local := "", name
+	if i := strings.Index(name, ":"); i >= 0 {
+		prefix, local = name[:i], name[i+1:]
+	}
+	if len(prefix) == 0 && attribute {
+		return map[string]string{"name": local, "ns": ""}, nil
+	}
+	ns, ok := namespaces[prefix]
+	if !ok && len(prefix) > 0 {
+		return nil, fmt.Errorf("prefix %s of %s is not declared with a fixed xmlns:%s attribute", prefix, name, prefix)
+	}
+	return map[string]string{"name": local, "ns": ns}, nil
+}
+
+//grammar returns the full RelaxNG grammar for the declarations.
+func (this *dtdParser) grammar(root string) (*rngNode, error) {
+	if len(this.elements) == 0 {
+		return nil, fmt.Errorf("no elements are declared")
+	}
+	for _, name := range this.referenced {
+		if _, ok := this.declared[name]; !ok {
+			return nil, fmt.Errorf("element %s is referenced, but not declared", name)
+		}
+	}
+	namespaces, err := this.namespaces()
+	if err != nil {
+		return nil, err
+	}
+	g := newRngNodeAttrs("grammar", map[string]string{"xmlns": relaxngNs, "xmlns:a": compatibilityAnnotations})
+	var start *rngNode
+	if len(root) > 0 {
+		if _, ok := this.declared[root]; !ok {
+			return nil, fmt.Errorf("root element %s is not declared", root)
+		}
+		start = refNode(dtdDefineName(root))
+	} else {
+		refs := make([]*rngNode, len(this.elements))
+		for i, e := range this.elements {
+			refs[i] = refNode(dtdDefineName(e.name))
+		}
+		start = choiceOf(refs)
+	}
+	g.children = append(g.children, newRngNode("start", start))
+	for _, e := range this.elements {
+		attrs, err := qualify(e.name, namespaces, false)
+		if err != nil {
+			return nil, err
+		}
+		element := newRngNodeAttrs("element", attrs)
+		for _, a := range this.attlists[e.name] {
+			if a.name == "xmlns" || strings.HasPrefix(a.name, "xmlns:") {
+				continue
+			}
+			attribute, err := this.attributePattern(a, namespaces)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %s of element %s: %v", a.name, e.name, err)
+			}
+			element.children = append(element.children, attribute)
+		}
+		content := e.content
+		if content.name == "any" {
+			choices := []*rngNode{newRngNode("text")}
+			for _, other := range this.elements {
+				choices = append(choices, refNode(dtdDefineName(other.name)))
+			}
+			content = newRngNode("zeroOrMore", newRngNode("choice", choices...))
+		}
+		element.children = append(element.children, content)
+		g.children = append(g.children, newRngNodeAttrs("define", map[string]string{"name": dtdDefineName(e.name)}, element))
+	}
+	return g, nil
+}
+
+//approximate records a feature of the DTD that the grammar accepts more loosely,
+//since the builtin datatypes are the only ones that can be translated.
+func (this *dtdParser) approximate(feature string) {
+	for _, f := range this.approximated {
+		if f == feature {
+			return
+		}
+	}
+	this.approximated = append(this.approximated, feature)
+}
+
+func (this *dtdParser) attributePattern(a *dtdAttribute, namespaces map[string]string) (*rngNode, error) {
+	attrs, err := qualify(a.name, namespaces, true)
+	if err != nil {
+		return nil, err
+	}
+	var value *rngNode
+	switch a.typ {
+	case "CDATA":
+		value = newRngNode("text")
+	case "ID", "IDREF", "IDREFS":
+		value = newRngNodeAttrs("data", map[string]string{"type": a.typ, "datatypeLibrary": compatibilityDatatypes})
+	case "ENTITY", "NMTOKEN":
+		this.approximate(a.typ + " attributes, which are imported as token")
+		value = newRngNodeAttrs("data", map[string]string{"type": "token", "datatypeLibrary": ""})
+	case "ENTITIES", "NMTOKENS":
+		this.approximate(a.typ + " attributes, which are imported as a list of tokens")
+		value = newRngNode("list", newRngNode("oneOrMore", newRngNodeAttrs("data", map[string]string{"type": "token", "datatypeLibrary": ""})))
+	case "(", "NOTATION":
+		values := make([]*rngNode, len(a.values))
+		for i, v := range a.values {
+			values[i] = newRngNodeAttrs("value", map[string]string{"type": "token", "datatypeLibrary": ""})
+			values[i].text = v
+		}
+		value = choiceOf(values)
+	}
+	if a.use == "#FIXED" {
+		typ := "token"
+		if a.typ == "CDATA" {
+			typ = "string"
+		}
+		value = newRngNodeAttrs("value", map[string]string{"type": typ, "datatypeLibrary": ""})
+		value.text = a.value
+	}
+	attribute := newRngNodeAttrs("attribute", attrs, value)
+	switch a.use {
+	case "#REQUIRED":
+		return attribute, nil
+	case "#IMPLIED":
+	default:
+		attribute.attrs["a:defaultValue"] = a.value
+	}
+	return newRngNode("optional", attribute), nil
+}
@@ -0,0 +1,155 @@
+package relaxng
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testDTD = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- orders -->
+<!ENTITY % status "(open|closed)">
+<!ENTITY % inline "b | i">
+<!ENTITY % optionalNote "INCLUDE">
+<!ENTITY copy "&#169;">
+<!ELEMENT order (customer, item+, (note | gift)?, paid)>
+<!ATTLIST order
+	id ID #REQUIRED
+	status %status; "open"
+	version CDATA #FIXED "1.0"
+	ref IDREF #IMPLIED>
+<!ELEMENT customer (#PCDATA)>
+<!ELEMENT item (#PCDATA | %inline;)*>
+<!ATTLIST item tags NMTOKENS #IMPLIED>
+<!ATTLIST item tags CDATA #REQUIRED>
+<![%optionalNote;[
+<!ELEMENT note EMPTY>
+]]>
+<![IGNORE[
+<!ELEMENT note (#PCDATA)>
+<![INCLUDE[ <!ELEMENT ignored EMPTY> ]]>
+]]>
+<!ELEMENT gift ANY>
+<!ELEMENT paid EMPTY>
+<!ELEMENT b (#PCDATA)>
+<!ELEMENT i (#PCDATA)>
+<!NOTATION gif SYSTEM "image/gif">
+`
+
+func TestFromDTD(t *testing.T) {
+	g, err := FromDTD([]byte(testDTD), DTDOptions{Root: "order"})
+	unsupported, ok := err.(*UnsupportedError)
+	if !ok {
+		t.Fatalf("expected an UnsupportedError, but got %v", err)
+	}
+	if len(unsupported.Features) != 1 || !strings.HasPrefix(unsupported.Features[0], "NMTOKENS attributes") {
+		t.Fatalf("expected the NMTOKENS approximation, but got %v", unsupported.Features)
+	}
+	want := `datatypes dt1 = "http://relaxng.org/ns/compatibility/datatypes/1.0"
+
+start = order
+order = element order { attribute id { dt1:ID }, attribute status { "open" | "closed" }?, attribute version { string "1.0" }?, attribute ref { dt1:IDREF }?, customer, item+, (note | gift)?, paid }
+customer = element customer { text }
+item = element item { attribute tags { list { token+ } }?, (text | b | i)* }
+note = element note { empty }
+gift = element gift { (text | order | customer | item | note | gift | paid | b | i)* }
+paid = element paid { empty }
+b = element b { text }
+i = element i { text }
+`
+	if got := g.Compact(); got != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, got)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := `<order id="o1" version="1.0"><customer>bob</customer><item tags="a b">x <b>y</b></item><gift><paid/>text</gift><paid/></order>`
+	if err := Validate(katydid, []byte(valid)); err != nil {
+		t.Fatalf("expected %s to be valid: %v", valid, err)
+	}
+	for _, invalid := range []string{
+		`<order><customer>bob</customer><item/><paid/></order>`,
+		`<order id="o1" status="pending"><customer>bob</customer><item/><paid/></order>`,
+		`<order id="o1" version="2.0"><customer>bob</customer><item/><paid/></order>`,
+		`<order id="o1"><customer>bob</customer><paid/></order>`,
+		`<customer>bob</customer>`,
+	} {
+		if err := Validate(katydid, []byte(invalid)); err == nil {
+			t.Errorf("expected %s to be invalid", invalid)
+		}
+	}
+}
+
+func TestDTDToRelaxNG(t *testing.T) {
+	rng, err := DTDToRelaxNG([]byte(testDTD), DTDOptions{})
+	if _, ok := err.(*UnsupportedError); !ok {
+		t.Fatalf("expected an UnsupportedError, but got %v", err)
+	}
+	for _, want := range []string{
+		`xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0"`,
+		`<attribute a:defaultValue="open" name="status" ns="">`,
+		`<attribute a:defaultValue="1.0" name="version" ns="">`,
+		`<data datatypeLibrary="http://relaxng.org/ns/compatibility/datatypes/1.0" type="ID"/>`,
+	} {
+		if !strings.Contains(string(rng), want) {
+			t.Fatalf("expected %s in\n%s", want, rng)
+		}
+	}
+	g, err := Simplify(rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(g.Compact(), "datatypes dt1 = \"http://relaxng.org/ns/compatibility/datatypes/1.0\"\n\nstart = order | customer | item") {
+		t.Fatalf("expected every element to be a root, but got\n%s", g.Compact())
+	}
+}
+
+func TestFromDTDNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relaxng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "common.ent"), []byte(`<!ENTITY % lang "xml:lang CDATA #IMPLIED">`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dtd := `<!ENTITY % common SYSTEM "common.ent">
+%common;
+<!ELEMENT feed (x:entry*)>
+<!ATTLIST feed xmlns CDATA #FIXED "urn:feed" xmlns:x CDATA #FIXED "urn:x" %lang;>
+<!ELEMENT x:entry EMPTY>
+<!ATTLIST x:entry x:id CDATA #REQUIRED>`
+	g, err := FromDTD([]byte(dtd), DTDOptions{Root: "feed", Filename: filepath.Join(dir, "feed.dtd")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := `<feed xmlns="urn:feed" xmlns:x="urn:x" xml:lang="en"><x:entry x:id="1"/></feed>`
+	if err := diagnoseDoc(g, valid); err != nil {
+		t.Fatalf("expected %s to be valid: %v", valid, err)
+	}
+	if _, err := FromDTD([]byte(dtd), DTDOptions{}); err == nil || !strings.Contains(err.Error(), "filename") {
+		t.Fatalf("expected an error for an external parameter entity without a filename, but got %v", err)
+	}
+}
+
+func TestFromDTDErrors(t *testing.T) {
+	for _, dtd := range []string{
+		``,
+		`<!ELEMENT a (b)>`,
+		`<!ELEMENT a (b, c | d)> <!ELEMENT b EMPTY> <!ELEMENT c EMPTY> <!ELEMENT d EMPTY>`,
+		`<!ELEMENT a (#PCDATA | b)>`,
+		`<!ELEMENT a EMPTY> <!ELEMENT a EMPTY>`,
+		`<!ELEMENT a EMPTY> <!ATTLIST a b BOGUS #IMPLIED>`,
+		`<!ELEMENT a %undeclared;>`,
+		`<!ENTITY % a "%a;"> <!ELEMENT a (%a;)>`,
+		`<!ELEMENT p:a EMPTY>`,
+		`<!ELEMENT a EMPTY`,
+	} {
+		if _, err := FromDTD([]byte(dtd), DTDOptions{}); err == nil {
+			t.Errorf("expected an error for %q", dtd)
+		}
+	}
+}
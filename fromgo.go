@@ -0,0 +1,430 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"encoding"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//FromGo returns a simplified Grammar for the xml that encoding/xml marshals from values of the type of v,
+//which is usually a struct or a pointer to a struct, with xml struct tags.
+//
+//Fields with an attr tag become attributes, fields with a chardata or cdata tag become text
+//and other fields become elements, where a>b>c tags become nested elements.
+//Slices become zeroOrMore, pointers and fields with omitempty become optional
+//and fields with an innerxml tag or of an interface type allow any content.
+//Fields with an any tag become elements with any name.
+//Numbers, booleans and time.Time become xsd datatypes.
+//A relaxng:"required" struct tag makes a slice oneOrMore and a pointer or omitempty field required.
+func FromGo(v interface{}) (*Grammar, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("cannot generate a grammar for nil")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name, ok := typeElementName(t)
+	if !ok {
+		return nil, fmt.Errorf("type %v has no name and no XMLName field with a name", t)
+	}
+	b := &fromGo{used: make(map[string]bool), elements: make(map[fromGoKey]string)}
+	start, err := b.element(t, name)
+	if err != nil {
+		return nil, err
+	}
+	return &Grammar{Start: start, Define: b.defines}, nil
+}
+
+type fromGo struct {
+	defines  []Define
+	used     map[string]bool
+	elements map[fromGoKey]string
+	//anyElement is the name of the define of an element with any name, attributes and content.
+	anyElement string
+}
+
+type fromGoKey struct {
+	typ  reflect.Type
+	name xml.Name
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	xmlNameType       = reflect.TypeOf(xml.Name{})
+	xmlAttrType       = reflect.TypeOf(xml.Attr{})
+	marshalerType     = reflect.TypeOf((*xml.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+//typeElementName returns the name of the element that encoding/xml uses for the type,
+//which is the name in the tag of the XMLName field or otherwise the name of the type.
+func typeElementName(t reflect.Type) (xml.Name, bool) {
+	if t.Kind() == reflect.Struct {
+		if f, ok := t.FieldByName("XMLName"); ok && f.Type == xmlNameType {
+			tag := strings.Split(f.Tag.Get("xml"), ",")[0]
+			if len(tag) > 0 {
+				return splitTagName(tag), true
+			}
+		}
+	}
+	if len(t.Name()) == 0 {
+		return xml.Name{}, false
+	}
+	return xml.Name{Local: t.Name()}, true
+}
+
+//splitTagName splits a tag name of the form "namespace local".
+func splitTagName(name string) xml.Name {
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		return xml.Name{Space: name[:i], Local: name[i+1:]}
+	}
+	return xml.Name{Local: name}
+}
+
+func newNamePattern(name xml.Name) *NameOrPattern {
+	return &NameOrPattern{Name: &NameNameClass{Ns: name.Space, Text: name.Local}}
+}
+
+func newRefPattern(name string) *NameOrPattern {
+	return &NameOrPattern{Ref: &Ref{Name: name}}
+}
+
+func newPair(left, right *NameOrPattern) *Pair {
+	return &Pair{Left: left, Right: right}
+}
+
+func newOptionalPattern(p *NameOrPattern) *NameOrPattern {
+	return &NameOrPattern{Choice: newPair(&NameOrPattern{Empty: &Empty{}}, p)}
+}
+
+func newOneOrMorePattern(p *NameOrPattern) *NameOrPattern {
+	return &NameOrPattern{OneOrMore: &OneOrMore{NameOrPattern: p}}
+}
+
+func newXsdDataPattern(typ string) *NameOrPattern {
+	return &NameOrPattern{Data: &Data{Type: typ, DatatypeLibrary: xsdDatatypes}}
+}
+
+//newGroupPattern returns a group of the patterns or empty if there are none.
+func newGroupPattern(ps []*NameOrPattern) *NameOrPattern {
+	if len(ps) == 0 {
+		return &NameOrPattern{Empty: &Empty{}}
+	}
+	p := ps[len(ps)-1]
+	for i := len(ps) - 2; i >= 0; i-- {
+		p = &NameOrPattern{Group: newPair(ps[i], p)}
+	}
+	return p
+}
+
+//define adds a define, named after the element, and returns its name.
+func (this *fromGo) define(name *NameOrPattern) string {
+	local := "element"
+	if name.Name != nil {
+		local = name.Name.Text
+	}
+	unique := local
+	for i := 1; this.used[unique]; i++ {
+		unique = local + "_" + strconv.Itoa(i)
+	}
+	this.used[unique] = true
+	this.defines = append(this.defines, Define{Name: unique, Element: Pair{Left: name}})
+	return unique
+}
+
+func (this *fromGo) setContent(define string, content *NameOrPattern) {
+	for i := range this.defines {
+		if this.defines[i].Name == define {
+			this.defines[i].Element.Right = content
+		}
+	}
+}
+
+//element returns a reference to the element, with the name, of which the content is the type.
+func (this *fromGo) element(t reflect.Type, name xml.Name) (*NameOrPattern, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		return newRefPattern(this.any()), nil
+	}
+	key := fromGoKey{t, name}
+	if define, ok := this.elements[key]; ok {
+		return newRefPattern(define), nil
+	}
+	var nameClass *NameOrPattern
+	if len(name.Local) == 0 {
+		nameClass = &NameOrPattern{AnyName: &AnyNameClass{}}
+	} else {
+		nameClass = newNamePattern(name)
+	}
+	define := this.define(nameClass)
+	this.elements[key] = define
+	content, err := this.content(t)
+	if err != nil {
+		return nil, err
+	}
+	this.setContent(define, content)
+	return newRefPattern(define), nil
+}
+
+//any returns the name of the define of an element with any name, attributes and content.
+func (this *fromGo) any() string {
+	if len(this.anyElement) > 0 {
+		return this.anyElement
+	}
+	this.anyElement = this.define(&NameOrPattern{AnyName: &AnyNameClass{}})
+	this.setContent(this.anyElement, this.anyContent(true))
+	return this.anyElement
+}
+
+//anyContent returns zero or more elements with any name and text, preceded, if attrs is true, by attributes with any name.
+func (this *fromGo) anyContent(attrs bool) *NameOrPattern {
+	p := newOptionalPattern(newOneOrMorePattern(&NameOrPattern{Choice: newPair(&NameOrPattern{Text: &Text{}}, newRefPattern(this.any()))}))
+	if attrs {
+		return newGroupPattern([]*NameOrPattern{anyAttributes(), p})
+	}
+	return p
+}
+
+//anyAttributes returns zero or more attributes with any name.
+func anyAttributes() *NameOrPattern {
+	anyAttr := &NameOrPattern{Attribute: newPair(&NameOrPattern{AnyName: &AnyNameClass{}}, &NameOrPattern{Text: &Text{}})}
+	return newOptionalPattern(newOneOrMorePattern(anyAttr))
+}
+
+//content returns the pattern for the attributes and content of an element, which is marshaled from the type.
+func (this *fromGo) content(t reflect.Type) (*NameOrPattern, error) {
+	if t.Kind() != reflect.Struct || t == timeType || implements(t, marshalerType) || implements(t, textMarshalerType) {
+		return this.value(t)
+	}
+	fields, err := this.fields(t)
+	if err != nil {
+		return nil, err
+	}
+	return this.nest(fields), nil
+}
+
+func implements(t reflect.Type, i reflect.Type) bool {
+	return t.Implements(i) || reflect.PtrTo(t).Implements(i)
+}
+
+//value returns the pattern for text that is marshaled from a type, which is not a struct.
+func (this *fromGo) value(t reflect.Type) (*NameOrPattern, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return newXsdDataPattern("dateTime"), nil
+	case implements(t, marshalerType):
+		return this.anyContent(true), nil
+	case implements(t, textMarshalerType):
+		return &NameOrPattern{Text: &Text{}}, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &NameOrPattern{Text: &Text{}}, nil
+	case reflect.Bool:
+		return newXsdDataPattern("boolean"), nil
+	case reflect.Int8:
+		return newXsdDataPattern("byte"), nil
+	case reflect.Int16:
+		return newXsdDataPattern("short"), nil
+	case reflect.Int32:
+		return newXsdDataPattern("int"), nil
+	case reflect.Int, reflect.Int64:
+		return newXsdDataPattern("long"), nil
+	case reflect.Uint8:
+		return newXsdDataPattern("unsignedByte"), nil
+	case reflect.Uint16:
+		return newXsdDataPattern("unsignedShort"), nil
+	case reflect.Uint32:
+		return newXsdDataPattern("unsignedInt"), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return newXsdDataPattern("unsignedLong"), nil
+	case reflect.Float32:
+		return newXsdDataPattern("float"), nil
+	case reflect.Float64:
+		return newXsdDataPattern("double"), nil
+	case reflect.Interface:
+		return this.anyContent(false), nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &NameOrPattern{Text: &Text{}}, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
+
+//fromGoField is the pattern of a field, inside the elements of its parents.
+type fromGoField struct {
+	parents  []xml.Name
+	pattern  *NameOrPattern
+	chardata bool
+}
+
+//fields returns the patterns of the fields of the struct in order.
+func (this *fromGo) fields(t reflect.Type) ([]fromGoField, error) {
+	var fields []fromGoField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("xml")
+		if len(f.PkgPath) > 0 && !f.Anonymous || tag == "-" || f.Name == "XMLName" {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && len(tag) == 0 && ft.Kind() == reflect.Struct {
+			embedded, err := this.fields(ft)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, embedded...)
+			continue
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		field, err := this.field(f, tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		if field != nil {
+			fields = append(fields, *field)
+		}
+	}
+	return fields, nil
+}
+
+//field returns the pattern of the struct field with its parents or nil if the field is not marshaled.
+func (this *fromGo) field(f reflect.StructField, tag string) (*fromGoField, error) {
+	tokens := strings.Split(tag, ",")
+	flags := make(map[string]bool)
+	for _, flag := range tokens[1:] {
+		flags[flag] = true
+	}
+	required := f.Tag.Get("relaxng") == "required"
+	ft := f.Type
+	optional := ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Interface || flags["omitempty"]
+	switch {
+	case flags["comment"]:
+		return nil, nil
+	case flags["innerxml"]:
+		return &fromGoField{pattern: this.anyContent(false)}, nil
+	case flags["chardata"], flags["cdata"]:
+		p, err := this.value(ft)
+		if err != nil {
+			return nil, err
+		}
+		return &fromGoField{pattern: p, chardata: true}, nil
+	case flags["attr"] && flags["any"] && (ft == xmlAttrType || ft.Kind() == reflect.Slice && ft.Elem() == xmlAttrType):
+		return &fromGoField{pattern: anyAttributes()}, nil
+	case flags["attr"]:
+		name := xml.Name{Local: f.Name}
+		if len(tokens[0]) > 0 {
+			name = splitTagName(tokens[0])
+		}
+		p, err := this.value(ft)
+		if err != nil {
+			return nil, err
+		}
+		p = &NameOrPattern{Attribute: newPair(newNamePattern(name), p)}
+		if optional && !required {
+			p = newOptionalPattern(p)
+		}
+		return &fromGoField{pattern: p}, nil
+	}
+	var parents []xml.Name
+	names := strings.Split(tokens[0], ">")
+	for _, parent := range names[:len(names)-1] {
+		parents = append(parents, splitTagName(parent))
+	}
+	repeated := ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 || ft.Kind() == reflect.Array && ft.Elem().Kind() != reflect.Uint8
+	if repeated {
+		ft = ft.Elem()
+	}
+	elemType := ft
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	var name xml.Name
+	switch {
+	case flags["any"]:
+	case len(names[len(names)-1]) > 0:
+		name = splitTagName(names[len(names)-1])
+	default:
+		name = xml.Name{Local: f.Name}
+		if n, ok := typeElementName(elemType); ok && elemType.Kind() == reflect.Struct && elemType != timeType {
+			if _, ok := elemType.FieldByName("XMLName"); ok {
+				name = n
+			}
+		}
+	}
+	p, err := this.element(elemType, name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case repeated && required:
+		p = newOneOrMorePattern(p)
+	case repeated:
+		p = newOptionalPattern(newOneOrMorePattern(p))
+	case optional && !required:
+		p = newOptionalPattern(p)
+	}
+	return &fromGoField{parents: parents, pattern: p}, nil
+}
+
+//nest groups the fields, where consecutive fields with the same parent are placed inside a single parent element.
+//Character data, next to elements, becomes text, since datatypes cannot be grouped with elements.
+func (this *fromGo) nest(fields []fromGoField) *NameOrPattern {
+	mixed := false
+	for _, f := range fields {
+		if len(f.parents) > 0 || !f.chardata && hasElements(f.pattern) {
+			mixed = true
+		}
+	}
+	var ps []*NameOrPattern
+	for i := 0; i < len(fields); {
+		if len(fields[i].parents) == 0 {
+			p := fields[i].pattern
+			if mixed && fields[i].chardata {
+				p = &NameOrPattern{Text: &Text{}}
+			}
+			ps = append(ps, p)
+			i++
+			continue
+		}
+		parent := fields[i].parents[0]
+		var children []fromGoField
+		for ; i < len(fields) && len(fields[i].parents) > 0 && fields[i].parents[0] == parent; i++ {
+			children = append(children, fromGoField{parents: fields[i].parents[1:], pattern: fields[i].pattern})
+		}
+		define := this.define(newNamePattern(parent))
+		this.setContent(define, this.nest(children))
+		ps = append(ps, newRefPattern(define))
+	}
+	return newGroupPattern(ps)
+}
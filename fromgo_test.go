@@ -0,0 +1,121 @@
+package relaxng
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+type goOrder struct {
+	XMLName  xml.Name  `xml:"order"`
+	ID       string    `xml:"id,attr"`
+	Priority *int      `xml:"priority,attr"`
+	Note     string    `xml:"note,attr,omitempty"`
+	Customer goParty   `xml:"customer"`
+	Items    []goItem  `xml:"items>item" relaxng:"required"`
+	Tags     []string  `xml:"tag"`
+	Shipped  *bool     `xml:"shipped"`
+	Created  time.Time `xml:"created"`
+	Extra    goExtra   `xml:"extra"`
+	ignored  string
+	Skipped  string `xml:"-"`
+	Comment  string `xml:",comment"`
+}
+
+type goParty struct {
+	Name  string     `xml:",chardata"`
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+type goItem struct {
+	Quantity uint32   `xml:"quantity,attr"`
+	Price    float64  `xml:",chardata"`
+	Parts    []goItem `xml:"part"`
+}
+
+type goExtra struct {
+	Inner string `xml:",innerxml"`
+}
+
+func TestFromGo(t *testing.T) {
+	g, err := FromGo(&goOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	priority, shipped := 2, true
+	valid := []*goOrder{
+		{ID: "1", Customer: goParty{Name: "bob"}, Items: []goItem{{Quantity: 1, Price: 1.5}}},
+		{ID: "2", Priority: &priority, Note: "fragile",
+			Customer: goParty{Name: "alice", Attrs: []xml.Attr{{Name: xml.Name{Local: "vip"}, Value: "yes"}}},
+			Items:    []goItem{{Quantity: 1, Price: 2, Parts: []goItem{{Quantity: 3, Price: 1}}}, {Quantity: 2, Price: 3}},
+			Tags:     []string{"a", "b"}, Shipped: &shipped, Created: time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+			Extra: goExtra{Inner: `<any thing="1">text<more/></any>`}, Comment: "comment"},
+	}
+	for _, v := range valid {
+		data, err := xml.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		findings, err := Diagnose(g, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(findings) > 0 {
+			t.Errorf("expected %s to be valid, but got %v\n%s", data, findings, g.Compact())
+		}
+	}
+	invalid := []string{
+		`<order><customer/><items><item quantity="1">1</item></items><created/><extra/></order>`,
+		`<order id="1"><customer/><items></items><created/><extra/></order>`,
+		`<order id="1"><customer/><items><item quantity="1">1</item></items><tag/><created/><extra/><unknown/></order>`,
+	}
+	for _, doc := range invalid {
+		findings, err := Diagnose(g, []byte(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(findings) == 0 {
+			t.Errorf("expected %s to be invalid", doc)
+		}
+	}
+}
+
+func TestFromGoCompact(t *testing.T) {
+	type Item struct {
+		Name  string  `xml:"name,attr"`
+		Price float32 `xml:"http://example.com/price price"`
+	}
+	g, err := FromGo(Item{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `namespace ns1 = "http://example.com/price"
+
+start = Item
+Item = element Item { attribute name { text }, price }
+price = element ns1:price { xsd:float }
+`
+	if got := g.Compact(); got != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, got)
+	}
+	if !strings.Contains(g.String(), `<name ns="http://example.com/price">price</name>`) {
+		t.Fatalf("unexpected xml %s", g.String())
+	}
+}
+
+func TestFromGoErrors(t *testing.T) {
+	type unsupported struct {
+		XMLName xml.Name          `xml:"a"`
+		M       map[string]string `xml:"m"`
+	}
+	if _, err := FromGo(unsupported{}); err == nil {
+		t.Fatal("expected an error for a map")
+	}
+	if _, err := FromGo(nil); err == nil {
+		t.Fatal("expected an error for nil")
+	}
+	if _, err := FromGo(struct{ A string }{}); err == nil {
+		t.Fatal("expected an error for a type without a name")
+	}
+}
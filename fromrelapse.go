@@ -0,0 +1,494 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/katydid/katydid/relapse/ast"
+)
+
+//FromRelapse translates a Katydid Relapse Grammar, that validates xml parsed by NewXMLParser,
+//back into a simplified RelaxNG Grammar.
+//Tree nodes with elem_ names become elements and tree nodes with attr_ names become attributes.
+//The anytext, whitespace, text and token functions, that Translate uses,
+//and eq or == with a text_ prefixed string become text, empty and value patterns.
+//A tree node named attr_xmlns, with a text value, becomes the namespace of its element.
+//
+//Relapse features that have no RelaxNG equivalent, like functions over numbers,
+//negation, intersection and names without a prefix, are reported as an error.
+//Lists are also reported as an error, since Translate writes them as a regular expression.
+//References to patterns that are not a single element are inlined.
+func FromRelapse(g *ast.Grammar) (grammar *Grammar, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ferr, ok := r.(*fromRelapseError)
+			if !ok {
+				panic(r)
+			}
+			grammar, err = nil, ferr
+		}
+	}()
+	refs := ast.NewRefLookup(g)
+	main, ok := refs["main"]
+	if !ok {
+		return nil, fmt.Errorf("relapse grammar has no main pattern")
+	}
+	this := &fromRelapse{
+		refs:     refs,
+		elements: make(map[*ast.TreeNode]string),
+		defined:  make(map[string]bool),
+		used:     make(map[string]bool),
+		inlining: make(map[string]bool),
+	}
+	for name := range refs {
+		this.used[name] = true
+	}
+	//A reference to a pattern that is a single element becomes a reference to a define with the same name.
+	for name, p := range refs {
+		if tree := this.elementDecl(p); tree != nil && name != "main" {
+			this.elements[tree] = name
+		}
+	}
+	start := this.pattern(main, false)
+	return &Grammar{Start: start, Define: this.defines}, nil
+}
+
+//noText is the Empty of the Relapse empty pattern, which, unlike whitespace, does not match any text.
+var noText = &Empty{}
+
+//fromRelapseError is a Relapse feature without a RelaxNG equivalent.
+type fromRelapseError struct {
+	msg string
+}
+
+func (this *fromRelapseError) Error() string {
+	return this.msg
+}
+
+func unsupportedRelapse(format string, args ...interface{}) {
+	panic(&fromRelapseError{fmt.Sprintf(format, args...) + " has no RelaxNG equivalent"})
+}
+
+type fromRelapse struct {
+	refs    ast.RefLookup
+	defines []Define
+	//elements are the names of the defines of the elements of tree nodes.
+	elements map[*ast.TreeNode]string
+	defined  map[string]bool
+	//used are the names of the defines and of the Relapse patterns.
+	used map[string]bool
+	//inlining holds the references that are being inlined, to detect recursion without an element in between.
+	inlining map[string]bool
+	//ns is the namespace of the element whose content is being translated, which is set by its attr_xmlns tree node.
+	ns *string
+	//any is the name of the define of an element with any name, attributes and content.
+	any string
+}
+
+//elementDecl returns the tree node, if the pattern is a single element,
+//optionally interleaved with whitespace, as Translate writes defines.
+func (this *fromRelapse) elementDecl(p *ast.Pattern) *ast.TreeNode {
+	if p.Interleave != nil && this.isWhitespace(p.Interleave.RightPattern) {
+		p = p.Interleave.LeftPattern
+	}
+	if p.TreeNode == nil || !isElementName(p.TreeNode.Name) {
+		return nil
+	}
+	return p.TreeNode
+}
+
+//isWhitespace returns whether the pattern only matches whitespace, like (@ws)*.
+func (this *fromRelapse) isWhitespace(p *ast.Pattern) bool {
+	switch {
+	case p.ZeroOrMore != nil:
+		return this.isWhitespace(p.ZeroOrMore.Pattern)
+	case p.Reference != nil:
+		ref, ok := this.refs[p.Reference.Name]
+		return ok && ref.Reference == nil && this.isWhitespace(ref)
+	case p.LeafNode != nil:
+		f := p.LeafNode.Expr.Function
+		return f != nil && f.Name == "whitespace"
+	}
+	return false
+}
+
+//isElementName returns whether all the names in the name expression are element names or any name.
+func isElementName(n *ast.NameExpr) bool {
+	switch {
+	case n.Name != nil:
+		s := n.Name.StringValue
+		return s != nil && strings.HasPrefix(*s, "elem_")
+	case n.NameChoice != nil:
+		return isElementName(n.NameChoice.Left) && isElementName(n.NameChoice.Right)
+	case n.AnyNameExcept != nil:
+		return isElementName(n.AnyNameExcept.Except)
+	}
+	return n.AnyName != nil
+}
+
+func (this *fromRelapse) newName(name string) string {
+	for this.used[name] {
+		name += "1"
+	}
+	this.used[name] = true
+	return name
+}
+
+//pattern translates a pattern, where attr is whether it is the value of an attribute.
+func (this *fromRelapse) pattern(p *ast.Pattern, attr bool) *NameOrPattern {
+	switch {
+	case p.Empty != nil:
+		//An empty attribute value does not match whitespace, like empty does in RelaxNG.
+		if attr {
+			return &NameOrPattern{Value: &Value{Type: "string"}}
+		}
+		return &NameOrPattern{Empty: noText}
+	case p.ZAny != nil:
+		if attr {
+			return &NameOrPattern{Text: &Text{}}
+		}
+		return this.anyContent()
+	case p.TreeNode != nil:
+		if attr {
+			unsupportedRelapse("a tree node %s inside an attribute", p.TreeNode.Name)
+		}
+		return this.treeNode(p.TreeNode)
+	case p.LeafNode != nil:
+		return leafValue(p.LeafNode.Expr)
+	case p.Concat != nil:
+		//Translate writes oneOrMore as the pattern followed by zero or more of the same pattern.
+		if more := p.Concat.RightPattern.ZeroOrMore; more != nil && (more.Pattern == p.Concat.LeftPattern || more.Pattern.String() == p.Concat.LeftPattern.String()) {
+			return this.oneOrMore(this.pattern(p.Concat.LeftPattern, attr))
+		}
+		return this.group(this.pattern(p.Concat.LeftPattern, attr), this.pattern(p.Concat.RightPattern, attr))
+	case p.Or != nil:
+		return this.choice(this.pattern(p.Or.LeftPattern, attr), this.pattern(p.Or.RightPattern, attr))
+	case p.And != nil:
+		return this.and(p.And, attr)
+	case p.ZeroOrMore != nil:
+		return this.choice(&NameOrPattern{Empty: &Empty{}}, this.oneOrMore(this.pattern(p.ZeroOrMore.Pattern, attr)))
+	case p.Optional != nil:
+		return this.choice(&NameOrPattern{Empty: &Empty{}}, this.pattern(p.Optional.Pattern, attr))
+	case p.Interleave != nil:
+		return this.interleave(this.pattern(p.Interleave.LeftPattern, attr), this.pattern(p.Interleave.RightPattern, attr))
+	case p.Reference != nil:
+		return this.reference(p.Reference.Name, attr)
+	case p.Not != nil:
+		if p.Not.Pattern.ZAny != nil {
+			return &NameOrPattern{NotAllowed: &NotAllowed{}}
+		}
+		unsupportedRelapse("negation !(%s)", p.Not.Pattern)
+	case p.Contains != nil:
+		if attr {
+			unsupportedRelapse("contains .%s inside an attribute", p.Contains.Pattern)
+		}
+		return this.interleave(this.pattern(p.Contains.Pattern, attr), this.anyContent())
+	}
+	panic(fmt.Sprintf("unreachable relapse pattern %v", p))
+}
+
+func (this *fromRelapse) reference(name string, attr bool) *NameOrPattern {
+	ref, ok := this.refs[name]
+	if !ok {
+		panic(&fromRelapseError{fmt.Sprintf("reference to undefined pattern %s", name)})
+	}
+	if tree := this.elementDecl(ref); tree != nil && !attr {
+		elemNames, _ := splitNames(tree.Name)
+		return &NameOrPattern{Ref: &Ref{Name: this.element(tree, elemNames)}}
+	}
+	if this.inlining[name] {
+		unsupportedRelapse("recursion through @%s without an element in between", name)
+	}
+	this.inlining[name] = true
+	p := this.pattern(ref, attr)
+	delete(this.inlining, name)
+	return p
+}
+
+//and translates the intersection of text and the negation of values, as Translate writes data with an except,
+//which is the only intersection that has a RelaxNG equivalent.
+func (this *fromRelapse) and(p *ast.And, attr bool) *NameOrPattern {
+	if not := p.RightPattern.Not; not != nil && not.Pattern.ZAny == nil {
+		left := this.pattern(p.LeftPattern, attr)
+		switch {
+		case not.Pattern.Empty != nil && left.Data != nil && left.Data.Except != nil:
+			//Translate only excludes empty text, when the except already includes the empty value.
+			return left
+		case left.Text != nil:
+			if except := this.pattern(not.Pattern, attr); isValueChoice(except) {
+				return &NameOrPattern{Data: &Data{Type: "string", Except: except}}
+			}
+		}
+	}
+	unsupportedRelapse("intersection (%s&%s)", p.LeftPattern, p.RightPattern)
+	return nil
+}
+
+func hasValue(p *NameOrPattern, v *Value) bool {
+	if p.Choice != nil {
+		return hasValue(p.Choice.Left, v) || hasValue(p.Choice.Right, v)
+	}
+	return p.Value != nil && *p.Value == *v
+}
+
+func isValueChoice(p *NameOrPattern) bool {
+	if p.Choice != nil {
+		return isValueChoice(p.Choice.Left) && isValueChoice(p.Choice.Right)
+	}
+	return p.Value != nil
+}
+
+//treeNode returns the pattern of a tree node, which is a reference to an element, an attribute or a choice between them.
+func (this *fromRelapse) treeNode(tree *ast.TreeNode) *NameOrPattern {
+	elemNames, attrNames := splitNames(tree.Name)
+	if attrNames != nil && attrNames.Name != nil && attrNames.Name.Text == "xmlns" {
+		return this.xmlns(tree.Pattern)
+	}
+	var p *NameOrPattern
+	if attrNames != nil {
+		p = &NameOrPattern{Attribute: newPair(attrNames, this.pattern(tree.Pattern, true))}
+	}
+	if elemNames != nil {
+		ref := &NameOrPattern{Ref: &Ref{Name: this.element(tree, elemNames)}}
+		if p == nil {
+			return ref
+		}
+		return this.choice(p, ref)
+	}
+	return p
+}
+
+//xmlns translates an attr_xmlns tree node, which is not an attribute in RelaxNG,
+//but the namespace of the element, if its value is a single text value.
+func (this *fromRelapse) xmlns(value *ast.Pattern) *NameOrPattern {
+	if value.LeafNode != nil && this.ns != nil {
+		if v := leafValue(value.LeafNode.Expr); v.Value != nil {
+			*this.ns = v.Value.Text
+		}
+	}
+	return &NameOrPattern{Empty: &Empty{}}
+}
+
+//element returns the name of the define of the element of the tree node, which is added if it does not exist yet.
+func (this *fromRelapse) element(tree *ast.TreeNode, names *NameOrPattern) string {
+	name, ok := this.elements[tree]
+	if ok && this.defined[name] {
+		return name
+	}
+	if !ok {
+		local := "anyElement"
+		if names.Name != nil {
+			local = names.Name.Text
+		}
+		name = this.newName(local)
+		this.elements[tree] = name
+	}
+	this.defined[name] = true
+	i := len(this.defines)
+	this.defines = append(this.defines, Define{Name: name})
+	parentNs := this.ns
+	ns := ""
+	this.ns = &ns
+	content := this.pattern(tree.Pattern, false)
+	this.ns = parentNs
+	setNs(names, ns)
+	this.defines[i].Element = Pair{Left: names, Right: content}
+	return name
+}
+
+func setNs(n *NameOrPattern, ns string) {
+	switch {
+	case n.Name != nil:
+		n.Name.Ns = ns
+	case n.Choice != nil:
+		setNs(n.Choice.Left, ns)
+		setNs(n.Choice.Right, ns)
+	case n.AnyName != nil && n.AnyName.Except != nil:
+		setNs(n.AnyName.Except, ns)
+	}
+}
+
+//splitNames splits a name expression into the name class of the elements and the name class of the attributes,
+//either of which is nil if the name expression does not match any.
+func splitNames(n *ast.NameExpr) (elems *NameOrPattern, attrs *NameOrPattern) {
+	switch {
+	case n.Name != nil:
+		if n.Name.StringValue == nil {
+			unsupportedRelapse("name %s, that is not a string,", n)
+		}
+		s := *n.Name.StringValue
+		switch {
+		case strings.HasPrefix(s, "elem_"):
+			return &NameOrPattern{Name: &NameNameClass{Text: strings.TrimPrefix(s, "elem_")}}, nil
+		case strings.HasPrefix(s, "attr_"):
+			return nil, &NameOrPattern{Name: &NameNameClass{Text: strings.TrimPrefix(s, "attr_")}}
+		}
+		unsupportedRelapse("name %q, without the elem_ or attr_ prefix,", s)
+	case n.AnyName != nil:
+		return &NameOrPattern{AnyName: &AnyNameClass{}}, &NameOrPattern{AnyName: &AnyNameClass{}}
+	case n.AnyNameExcept != nil:
+		elemExcept, attrExcept := splitNames(n.AnyNameExcept.Except)
+		return &NameOrPattern{AnyName: &AnyNameClass{Except: elemExcept}}, &NameOrPattern{AnyName: &AnyNameClass{Except: attrExcept}}
+	case n.NameChoice != nil:
+		le, la := splitNames(n.NameChoice.Left)
+		re, ra := splitNames(n.NameChoice.Right)
+		return nameChoice(le, re), nameChoice(la, ra)
+	}
+	panic(fmt.Sprintf("unreachable relapse name %v", n))
+}
+
+func nameChoice(l, r *NameOrPattern) *NameOrPattern {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	return &NameOrPattern{Choice: newPair(l, r)}
+}
+
+//leafValue translates the expression of a leaf, which validates text.
+func leafValue(e *ast.Expr) *NameOrPattern {
+	if e.BuiltIn != nil {
+		if e.BuiltIn.Symbol != nil && e.BuiltIn.Symbol.Value == "==" {
+			return textValue(e.BuiltIn.Expr, "string")
+		}
+		unsupportedRelapse("function %s", e)
+	}
+	f := e.Function
+	if f == nil {
+		unsupportedRelapse("value %s", e)
+	}
+	if len(f.Params) == 0 || f.Params[0].Terminal == nil || f.Params[0].Terminal.Variable == nil {
+		unsupportedRelapse("function %s", e)
+	}
+	switch {
+	case f.Name == "anytext" && len(f.Params) == 1:
+		return &NameOrPattern{Text: &Text{}}
+	case f.Name == "whitespace" && len(f.Params) == 1:
+		return &NameOrPattern{Empty: &Empty{}}
+	case f.Name == "text" && len(f.Params) == 2:
+		return stringValue(f.Params[1], "string")
+	case f.Name == "token" && len(f.Params) == 2:
+		return stringValue(f.Params[1], "token")
+	case f.Name == "eq" && len(f.Params) == 2:
+		return textValue(f.Params[1], "string")
+	case f.Name == "list":
+		panic(&fromRelapseError{fmt.Sprintf("the regular expression of %s cannot be translated back into a list", e)})
+	}
+	unsupportedRelapse("function %s", e)
+	return nil
+}
+
+//stringValue returns a value pattern for the string constant.
+func stringValue(e *ast.Expr, typ string) *NameOrPattern {
+	if e.Terminal == nil || e.Terminal.StringValue == nil {
+		unsupportedRelapse("value %s, that is not a string constant,", e)
+	}
+	return &NameOrPattern{Value: &Value{Type: typ, Text: *e.Terminal.StringValue}}
+}
+
+//textValue returns a value pattern for a string constant that is compared to the value that NewXMLParser returns, with its text_ prefix.
+func textValue(e *ast.Expr, typ string) *NameOrPattern {
+	v := stringValue(e, typ)
+	if !strings.HasPrefix(v.Value.Text, "text_") {
+		unsupportedRelapse("comparison to %q, without the text_ prefix,", v.Value.Text)
+	}
+	v.Value.Text = strings.TrimPrefix(v.Value.Text, "text_")
+	return v
+}
+
+//anyContent returns a pattern that matches any attributes, text and elements.
+func (this *fromRelapse) anyContent() *NameOrPattern {
+	if len(this.any) == 0 {
+		this.any = this.newName("anyElement")
+		this.defined[this.any] = true
+		this.defines = append(this.defines, Define{Name: this.any})
+		i := len(this.defines) - 1
+		this.defines[i].Element = Pair{
+			Left:  &NameOrPattern{AnyName: &AnyNameClass{}},
+			Right: this.anyContent(),
+		}
+	}
+	return this.choice(&NameOrPattern{Empty: &Empty{}}, this.oneOrMore(&NameOrPattern{Choice: newPair(
+		&NameOrPattern{Attribute: newPair(&NameOrPattern{AnyName: &AnyNameClass{}}, &NameOrPattern{Text: &Text{}})},
+		&NameOrPattern{Choice: newPair(&NameOrPattern{Text: &Text{}}, &NameOrPattern{Ref: &Ref{Name: this.any}})},
+	)}))
+}
+
+//The following constructors remove the empty and notAllowed patterns that whitespace and xmlns leave behind.
+
+func (this *fromRelapse) choice(l, r *NameOrPattern) *NameOrPattern {
+	switch {
+	case l.NotAllowed != nil:
+		return r
+	case r.NotAllowed != nil:
+		return l
+	case l.Empty != nil && r.Empty != nil:
+		if l.Empty == noText {
+			return r
+		}
+		return l
+	case l.Value != nil && hasValue(r, l.Value):
+		return l
+	case r.Value != nil && hasValue(l, r.Value):
+		return r
+	case r.Empty != nil:
+		return this.choice(r, l)
+	case l.Empty == noText && isValueChoice(r):
+		//Unlike empty, which also matches whitespace, no text or an empty string is the same as an empty string value.
+		return this.choice(r, &NameOrPattern{Value: &Value{Type: "string"}})
+	case l.Empty != nil && (r.Text != nil || r.Choice != nil && r.Choice.Left.Empty != nil):
+		//Text and optional patterns already match empty.
+		return r
+	}
+	return &NameOrPattern{Choice: newPair(l, r)}
+}
+
+func (this *fromRelapse) group(l, r *NameOrPattern) *NameOrPattern {
+	switch {
+	case l.NotAllowed != nil || r.Empty != nil:
+		return l
+	case r.NotAllowed != nil || l.Empty != nil:
+		return r
+	}
+	return &NameOrPattern{Group: newPair(l, r)}
+}
+
+func (this *fromRelapse) interleave(l, r *NameOrPattern) *NameOrPattern {
+	switch {
+	case l.NotAllowed != nil || r.Empty != nil:
+		return l
+	case r.NotAllowed != nil || l.Empty != nil:
+		return r
+	}
+	return &NameOrPattern{Interleave: newPair(l, r)}
+}
+
+func (this *fromRelapse) oneOrMore(p *NameOrPattern) *NameOrPattern {
+	if p.Empty != nil || p.NotAllowed != nil {
+		return p
+	}
+	//zeroOrMore is a choice between empty and oneOrMore, which is the same as oneOrMore of the choice.
+	if p.Choice != nil && p.Choice.Left.Empty != nil {
+		return this.choice(p.Choice.Left, this.oneOrMore(p.Choice.Right))
+	}
+	if p.OneOrMore != nil || p.Text != nil {
+		return p
+	}
+	return newOneOrMorePattern(p)
+}
@@ -0,0 +1,85 @@
+package relaxng
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/katydid/katydid/relapse/ast"
+	c "github.com/katydid/katydid/relapse/combinator"
+)
+
+func TestFromRelapseSuite(t *testing.T) {
+	for _, spec := range scanFiles() {
+		num := testNumber(spec.Filename)
+		if len(spec.SimpleFilename) == 0 || namespaces[num] || datatypeLibrary[num] {
+			continue
+		}
+		g, err := ParseGrammar(spec.SimpleContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		katydid, err := Translate(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := FromRelapse(katydid)
+		if err != nil {
+			if strings.Contains(err.Error(), "cannot be translated back into a list") {
+				continue
+			}
+			t.Fatalf("%s: %v", num, err)
+		}
+		again, err := Translate(back)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", num, err, back)
+		}
+		for _, xml := range spec.Xmls {
+			want := Validate(katydid, xml.Content) == nil
+			if got := Validate(again, xml.Content) == nil; got != want {
+				t.Fatalf("%s: %s: expected valid %v after translating back to\n%s", num, xml.Filename, want, back.Compact())
+			}
+		}
+	}
+}
+
+func TestFromRelapse(t *testing.T) {
+	refs := ast.RefLookup{
+		"main": ast.NewReference("order"),
+		"order": ast.NewInterleave(
+			ast.NewTreeNode(ast.NewStringName("elem_order"), ast.NewConcat(
+				ast.NewTreeNode(ast.NewStringName("attr_xmlns"), newTextValue("urn:orders", TranslateOptions{})),
+				ast.NewConcat(
+					ast.NewOptional(ast.NewTreeNode(ast.NewStringName("attr_id"), ast.NewZeroOrMore(ast.NewReference("text")))),
+					ast.NewZeroOrMore(ast.NewTreeNode(ast.NewStringName("elem_item"), ast.NewOr(newTokenValue("a", TranslateOptions{}), c.Value(ast.NewFunction("eq", c.StringVar(), c.StringConst("text_b")))))),
+				),
+			)),
+			ast.NewZeroOrMore(ast.NewReference("ws")),
+		),
+		"ws":   newWhitespace(TranslateOptions{}),
+		"text": newAnyValue(TranslateOptions{}),
+	}
+	g, err := FromRelapse(ast.NewGrammar(refs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `namespace ns1 = "urn:orders"
+
+start = order
+order = element ns1:order { attribute id { text }?, item* }
+item = element item { "a" | string "b" }
+`
+	if got := g.Compact(); got != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, got)
+	}
+
+	unsupported := []*ast.Pattern{
+		ast.NewTreeNode(ast.NewStringName("elem_a"), c.Value(ast.NewFunction("hasPrefix", c.StringVar(), c.StringConst("text_a")))),
+		ast.NewTreeNode(ast.NewStringName("elem_a"), ast.NewNot(ast.NewEmpty())),
+		ast.NewTreeNode(ast.NewStringName("a"), ast.NewEmpty()),
+	}
+	for _, p := range unsupported {
+		if _, err := FromRelapse(ast.NewGrammar(ast.RefLookup{"main": p})); err == nil || !strings.Contains(err.Error(), "has no RelaxNG equivalent") {
+			t.Fatalf("expected an error for %s, but got %v", p, err)
+		}
+	}
+}
@@ -11,10 +11,10 @@
 //  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 //  See the License for the specific language governing permissions and
 //  limitations under the License.
-
 package relaxng
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -25,46 +25,154 @@ import (
 	"github.com/katydid/katydid/relapse/funcs"
 )
 
-func stripTextPrefix(s string) (string, error) {
-	if !strings.HasPrefix(s, "text_") {
+func stripTextPrefix(s string, prefix string) (string, error) {
+	if !strings.HasPrefix(s, prefix) {
 		return "", fmt.Errorf("%q is not of type text", s)
 	}
-	return strings.Replace(s, "text_", "", 1), nil
+	return strings.TrimPrefix(s, prefix), nil
+}
+
+//textVar is the text value that the functions below validate.
+//It is either a $string, that is prefixed with text_ or the prefix that is given as the last parameter,
+//or a $[]byte, that the parser of TypedText returns for text and only for text.
+type textVar struct {
+	S      funcs.String
+	B      funcs.Bytes
+	param  funcs.ConstString
+	prefix string
+}
+
+func newStringText(S funcs.String, Prefix funcs.ConstString) (textVar, error) {
+	if Prefix == nil {
+		return textVar{S: S, prefix: defaultTextPrefix}, nil
+	}
+	prefix, err := Prefix.Eval()
+	if err != nil {
+		return textVar{}, err
+	}
+	return textVar{S: S, param: Prefix, prefix: prefix}, nil
+}
+
+func newBytesText(B funcs.Bytes) textVar {
+	return textVar{B: B}
+}
+
+//variable returns the $string or $[]byte variable.
+func (this textVar) variable() funcs.Comparable {
+	if this.B != nil {
+		return this.B
+	}
+	return this.S
 }
 
-func newTokenValue(t string) *ast.Pattern {
-	return c.Value(ast.NewFunction("token", c.StringVar(), c.StringConst(t)))
+//evalString returns the text without its prefix and false, if the value is not text.
+func (this textVar) evalString() (string, bool) {
+	s, err := this.S.Eval()
+	if err != nil {
+		return "", false
+	}
+	s, err = stripTextPrefix(s, this.prefix)
+	return s, err == nil
+}
+
+//evalBytes returns the text and false, if the value is not text.
+func (this textVar) evalBytes() ([]byte, bool) {
+	b, err := this.B.Eval()
+	return b, err == nil
+}
+
+//hash returns the hash of the function with its parameters, followed by the prefix parameter, if it was given.
+func (this textVar) hash(name string, hs ...funcs.Hashable) uint64 {
+	if this.param != nil {
+		hs = append(hs, this.param)
+	}
+	return funcs.Hash(name, hs...)
+}
+
+func (this textVar) compare(that textVar) int {
+	if c := this.variable().Compare(that.variable()); c != 0 {
+		return c
+	}
+	return strings.Compare(this.prefix, that.prefix)
+}
+
+func (this textVar) hasVariable() bool {
+	return this.variable().HasVariable()
+}
+
+//paramString returns the prefix parameter, with a leading comma, if it was given.
+func (this textVar) paramString() string {
+	if this.param == nil {
+		return ""
+	}
+	return "," + this.param.String()
+}
+
+//textFunction returns a function over text values.
+//With TypedText the text is a $[]byte, otherwise it is a $string,
+//where the prefix parameter is only added, if it is not text_.
+func textFunction(name string, opts TranslateOptions, params ...*ast.Expr) *ast.Pattern {
+	if opts.TypedText {
+		return c.Value(ast.NewFunction(name, append([]*ast.Expr{c.BytesVar()}, params...)...))
+	}
+	params = append([]*ast.Expr{c.StringVar()}, params...)
+	if len(opts.TextPrefix) > 0 && opts.TextPrefix != defaultTextPrefix {
+		params = append(params, c.StringConst(opts.TextPrefix))
+	}
+	return c.Value(ast.NewFunction(name, params...))
+}
+
+func newTokenValue(t string, opts TranslateOptions) *ast.Pattern {
+	return textFunction("token", opts, c.StringConst(t))
 }
 
 // token is a function used in relapse to validate values as described here
 // http://books.xmlschemata.org/relaxng/relax-CHP-7-SECT-4.html
 type token struct {
-	S           funcs.String
+	text        textVar
 	c           string
 	hash        uint64
 	hasVariable bool
 }
 
 func Token(S funcs.String, C funcs.ConstString) (funcs.Bool, error) {
+	return TokenWithPrefix(S, C, nil)
+}
+
+//TokenWithPrefix is token for text values with the given prefix, instead of text_.
+func TokenWithPrefix(S funcs.String, C funcs.ConstString, Prefix funcs.ConstString) (funcs.Bool, error) {
+	text, err := newStringText(S, Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newToken(text, C)
+}
+
+//TokenBytes is token for the typed text values of the parser of TypedText.
+func TokenBytes(B funcs.Bytes, C funcs.ConstString) (funcs.Bool, error) {
+	return newToken(newBytesText(B), C)
+}
+
+func newToken(text textVar, C funcs.ConstString) (funcs.Bool, error) {
 	c, err := C.Eval()
 	if err != nil {
 		return nil, err
 	}
 	return funcs.TrimBool(&token{
-		S:           S,
+		text:        text,
 		c:           c,
-		hash:        funcs.Hash("token", C, S),
-		hasVariable: S.HasVariable(),
+		hash:        text.hash("token", C, text.variable()),
+		hasVariable: text.hasVariable(),
 	}), nil
 }
 
 func (this *token) Eval() (bool, error) {
-	s, err := this.S.Eval()
-	if err != nil {
-		return false, nil
+	if this.text.B != nil {
+		b, ok := this.text.evalBytes()
+		return ok && string(normalizeSpace(b)) == this.c, nil
 	}
-	s, err = stripTextPrefix(s)
-	if err != nil {
+	s, ok := this.text.evalString()
+	if !ok {
 		return false, nil
 	}
 	ss := tokenize(s)
@@ -80,13 +188,10 @@ func (this *token) Compare(that funcs.Comparable) int {
 		return 1
 	}
 	if other, ok := that.(*token); ok {
-		if c := this.S.Compare(other.S); c != 0 {
+		if c := this.text.compare(other.text); c != 0 {
 			return c
 		}
-		if c := strings.Compare(this.c, other.c); c != 0 {
-			return c
-		}
-		return 0
+		return strings.Compare(this.c, other.c)
 	}
 	return strings.Compare(this.String(), that.String())
 }
@@ -96,7 +201,7 @@ func (this *token) HasVariable() bool {
 }
 
 func (this *token) String() string {
-	return "token(" + this.S.String() + "," + strconv.Quote(this.c) + ")"
+	return "token(" + this.text.variable().String() + "," + strconv.Quote(this.c) + this.text.paramString() + ")"
 }
 
 func (this *token) Hash() uint64 {
@@ -124,31 +229,79 @@ func tokenize(s string) []string {
 	return ss
 }
 
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t'
+}
+
+//normalizeSpace returns the tokens of the text separated by a single space, like tokenize,
+//which is the text itself, without an allocation, if it is already normalized.
+func normalizeSpace(b []byte) []byte {
+	normalized := true
+	for i := range b {
+		if isSpace(b[i]) && (b[i] != ' ' || i == 0 || i == len(b)-1 || b[i-1] == ' ') {
+			normalized = false
+			break
+		}
+	}
+	if normalized {
+		return b
+	}
+	n := make([]byte, 0, len(b))
+	for _, f := range bytes.FieldsFunc(b, func(r rune) bool { return r < 0x80 && isSpace(byte(r)) }) {
+		if len(n) > 0 {
+			n = append(n, ' ')
+		}
+		n = append(n, f...)
+	}
+	return n
+}
+
 func init() {
 	funcs.Register("token", Token)
+	funcs.Register("token", TokenWithPrefix)
+	funcs.Register("token", TokenBytes)
 }
 
 type whitespace struct {
-	S           funcs.String
+	text        textVar
 	hash        uint64
 	hasVariable bool
 }
 
 func Whitespace(S funcs.String) funcs.Bool {
+	w, _ := WhitespaceWithPrefix(S, nil)
+	return w
+}
+
+//WhitespaceWithPrefix is whitespace for text values with the given prefix, instead of text_.
+func WhitespaceWithPrefix(S funcs.String, Prefix funcs.ConstString) (funcs.Bool, error) {
+	text, err := newStringText(S, Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newWhitespaceFunc(text), nil
+}
+
+//WhitespaceBytes is whitespace for the typed text values of the parser of TypedText.
+func WhitespaceBytes(B funcs.Bytes) funcs.Bool {
+	return newWhitespaceFunc(newBytesText(B))
+}
+
+func newWhitespaceFunc(text textVar) funcs.Bool {
 	return funcs.TrimBool(&whitespace{
-		S:           S,
-		hash:        funcs.Hash("whitespace", S),
-		hasVariable: S.HasVariable(),
+		text:        text,
+		hash:        text.hash("whitespace", text.variable()),
+		hasVariable: text.hasVariable(),
 	})
 }
 
 func (this *whitespace) Eval() (bool, error) {
-	s, err := this.S.Eval()
-	if err != nil {
-		return false, nil
+	if this.text.B != nil {
+		b, ok := this.text.evalBytes()
+		return ok && len(bytes.TrimSpace(b)) == 0, nil
 	}
-	s, err = stripTextPrefix(s)
-	if err != nil {
+	s, ok := this.text.evalString()
+	if !ok {
 		return false, nil
 	}
 	return len(strings.TrimSpace(s)) == 0, nil
@@ -162,10 +315,7 @@ func (this *whitespace) Compare(that funcs.Comparable) int {
 		return 1
 	}
 	if other, ok := that.(*whitespace); ok {
-		if c := this.S.Compare(other.S); c != 0 {
-			return c
-		}
-		return 0
+		return this.text.compare(other.text)
 	}
 	return strings.Compare(this.String(), that.String())
 }
@@ -175,7 +325,7 @@ func (this *whitespace) HasVariable() bool {
 }
 
 func (this *whitespace) String() string {
-	return "whitespace(" + this.S.String() + ")"
+	return "whitespace(" + this.text.variable().String() + this.text.paramString() + ")"
 }
 
 func (this *whitespace) Hash() uint64 {
@@ -184,32 +334,50 @@ func (this *whitespace) Hash() uint64 {
 
 func init() {
 	funcs.Register("whitespace", Whitespace)
+	funcs.Register("whitespace", WhitespaceWithPrefix)
+	funcs.Register("whitespace", WhitespaceBytes)
 }
 
 type anytext struct {
-	S           funcs.String
+	text        textVar
 	hash        uint64
 	hasVariable bool
 }
 
 func AnyText(S funcs.String) funcs.Bool {
+	a, _ := AnyTextWithPrefix(S, nil)
+	return a
+}
+
+//AnyTextWithPrefix is anytext for text values with the given prefix, instead of text_.
+func AnyTextWithPrefix(S funcs.String, Prefix funcs.ConstString) (funcs.Bool, error) {
+	text, err := newStringText(S, Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newAnyText(text), nil
+}
+
+//AnyTextBytes is anytext for the typed text values of the parser of TypedText.
+func AnyTextBytes(B funcs.Bytes) funcs.Bool {
+	return newAnyText(newBytesText(B))
+}
+
+func newAnyText(text textVar) funcs.Bool {
 	return funcs.TrimBool(&anytext{
-		S:           S,
-		hash:        funcs.Hash("anytext", S),
-		hasVariable: S.HasVariable(),
+		text:        text,
+		hash:        text.hash("anytext", text.variable()),
+		hasVariable: text.hasVariable(),
 	})
 }
 
 func (this *anytext) Eval() (bool, error) {
-	s, err := this.S.Eval()
-	if err != nil {
-		return false, nil
+	if this.text.B != nil {
+		_, ok := this.text.evalBytes()
+		return ok, nil
 	}
-	_, err = stripTextPrefix(s)
-	if err != nil {
-		return false, nil
-	}
-	return true, nil
+	_, ok := this.text.evalString()
+	return ok, nil
 }
 
 func (this *anytext) Compare(that funcs.Comparable) int {
@@ -220,10 +388,7 @@ func (this *anytext) Compare(that funcs.Comparable) int {
 		return 1
 	}
 	if other, ok := that.(*anytext); ok {
-		if c := this.S.Compare(other.S); c != 0 {
-			return c
-		}
-		return 0
+		return this.text.compare(other.text)
 	}
 	return strings.Compare(this.String(), that.String())
 }
@@ -233,7 +398,7 @@ func (this *anytext) HasVariable() bool {
 }
 
 func (this *anytext) String() string {
-	return "anytext(" + this.S.String() + ")"
+	return "anytext(" + this.text.variable().String() + this.text.paramString() + ")"
 }
 
 func (this *anytext) Hash() uint64 {
@@ -242,54 +407,158 @@ func (this *anytext) Hash() uint64 {
 
 func init() {
 	funcs.Register("anytext", AnyText)
+	funcs.Register("anytext", AnyTextWithPrefix)
+	funcs.Register("anytext", AnyTextBytes)
+}
+
+func newWhitespace(opts TranslateOptions) *ast.Pattern {
+	return textFunction("whitespace", opts)
+}
+
+func newEmptyValue(opts TranslateOptions) *ast.Pattern {
+	return textFunction("whitespace", opts)
+}
+
+func newDatatypeValue(typ string, opts TranslateOptions) *ast.Pattern {
+	return textFunction("datatype", opts, c.StringConst(typ))
 }
 
-func newWhitespace() *ast.Pattern {
-	return c.Value(ast.NewFunction("whitespace", c.StringVar()))
+//datatype is a function used in relapse to validate text values against the lexical space of a builtin W3C XML Schema datatype.
+//Facets are not checked.
+type datatype struct {
+	text        textVar
+	typ         string
+	hash        uint64
+	hasVariable bool
 }
 
-func newEmptyValue() *ast.Pattern {
-	return c.Value(ast.NewFunction("whitespace", c.StringVar()))
+func Datatype(S funcs.String, Type funcs.ConstString) (funcs.Bool, error) {
+	return DatatypeWithPrefix(S, Type, nil)
 }
 
-func newAnyValue() *ast.Pattern {
-	return c.Value(ast.NewFunction("anytext", c.StringVar()))
+//DatatypeWithPrefix is datatype for text values with the given prefix, instead of text_.
+func DatatypeWithPrefix(S funcs.String, Type funcs.ConstString, Prefix funcs.ConstString) (funcs.Bool, error) {
+	text, err := newStringText(S, Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newDatatype(text, Type)
 }
 
-func newTextValue(value string) *ast.Pattern {
-	return c.Value(ast.NewFunction("text", c.StringVar(), c.StringConst(value)))
+//DatatypeBytes is datatype for the typed text values of the parser of TypedText.
+func DatatypeBytes(B funcs.Bytes, Type funcs.ConstString) (funcs.Bool, error) {
+	return newDatatype(newBytesText(B), Type)
+}
+
+func newDatatype(text textVar, Type funcs.ConstString) (funcs.Bool, error) {
+	typ, err := Type.Eval()
+	if err != nil {
+		return nil, err
+	}
+	return funcs.TrimBool(&datatype{
+		text:        text,
+		typ:         typ,
+		hash:        text.hash("datatype", Type, text.variable()),
+		hasVariable: text.hasVariable(),
+	}), nil
+}
+
+func (this *datatype) Eval() (bool, error) {
+	if this.text.B != nil {
+		b, ok := this.text.evalBytes()
+		return ok && isXSDLexical(this.typ, string(normalizeSpace(b))), nil
+	}
+	s, ok := this.text.evalString()
+	return ok && isXSDLexical(this.typ, strings.Join(tokenize(s), " ")), nil
+}
+
+func (this *datatype) Compare(that funcs.Comparable) int {
+	if this.Hash() != that.Hash() {
+		if this.Hash() < that.Hash() {
+			return -1
+		}
+		return 1
+	}
+	if other, ok := that.(*datatype); ok {
+		if c := this.text.compare(other.text); c != 0 {
+			return c
+		}
+		return strings.Compare(this.typ, other.typ)
+	}
+	return strings.Compare(this.String(), that.String())
+}
+
+func (this *datatype) HasVariable() bool {
+	return this.hasVariable
+}
+
+func (this *datatype) String() string {
+	return "datatype(" + this.text.variable().String() + "," + strconv.Quote(this.typ) + this.text.paramString() + ")"
+}
+
+func (this *datatype) Hash() uint64 {
+	return this.hash
+}
+
+func init() {
+	funcs.Register("datatype", Datatype)
+	funcs.Register("datatype", DatatypeWithPrefix)
+	funcs.Register("datatype", DatatypeBytes)
+}
+
+func newAnyValue(opts TranslateOptions) *ast.Pattern {
+	return textFunction("anytext", opts)
+}
+
+func newTextValue(value string, opts TranslateOptions) *ast.Pattern {
+	return textFunction("text", opts, c.StringConst(value))
 }
 
 type text struct {
-	S           funcs.String
+	text        textVar
 	c           string
 	hash        uint64
 	hasVariable bool
 }
 
 func TextFunc(S funcs.String, C funcs.ConstString) (funcs.Bool, error) {
+	return TextFuncWithPrefix(S, C, nil)
+}
+
+//TextFuncWithPrefix is text for text values with the given prefix, instead of text_.
+func TextFuncWithPrefix(S funcs.String, C funcs.ConstString, Prefix funcs.ConstString) (funcs.Bool, error) {
+	text, err := newStringText(S, Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newText(text, C)
+}
+
+//TextFuncBytes is text for the typed text values of the parser of TypedText.
+func TextFuncBytes(B funcs.Bytes, C funcs.ConstString) (funcs.Bool, error) {
+	return newText(newBytesText(B), C)
+}
+
+func newText(t textVar, C funcs.ConstString) (funcs.Bool, error) {
 	c, err := C.Eval()
 	if err != nil {
 		return nil, err
 	}
 	return funcs.TrimBool(&text{
-		S:           S,
+		text:        t,
 		c:           c,
-		hash:        funcs.Hash("token", C, S),
-		hasVariable: S.HasVariable(),
+		hash:        t.hash("token", C, t.variable()),
+		hasVariable: t.hasVariable(),
 	}), nil
 }
 
 func (this *text) Eval() (bool, error) {
-	s, err := this.S.Eval()
-	if err != nil {
-		return false, nil
+	if this.text.B != nil {
+		b, ok := this.text.evalBytes()
+		return ok && string(b) == this.c, nil
 	}
-	s, err = stripTextPrefix(s)
-	if err != nil {
-		return false, nil
-	}
-	return s == this.c, nil
+	s, ok := this.text.evalString()
+	return ok && s == this.c, nil
 }
 
 func (this *text) Compare(that funcs.Comparable) int {
@@ -300,13 +569,10 @@ func (this *text) Compare(that funcs.Comparable) int {
 		return 1
 	}
 	if other, ok := that.(*text); ok {
-		if c := this.S.Compare(other.S); c != 0 {
-			return c
-		}
-		if c := strings.Compare(this.c, other.c); c != 0 {
+		if c := this.text.compare(other.text); c != 0 {
 			return c
 		}
-		return 0
+		return strings.Compare(this.c, other.c)
 	}
 	return strings.Compare(this.String(), that.String())
 }
@@ -316,7 +582,7 @@ func (this *text) HasVariable() bool {
 }
 
 func (this *text) String() string {
-	return "text(" + this.S.String() + "," + strconv.Quote(this.c) + ")"
+	return "text(" + this.text.variable().String() + "," + strconv.Quote(this.c) + this.text.paramString() + ")"
 }
 
 func (this *text) Hash() uint64 {
@@ -325,17 +591,37 @@ func (this *text) Hash() uint64 {
 
 func init() {
 	funcs.Register("text", TextFunc)
+	funcs.Register("text", TextFuncWithPrefix)
+	funcs.Register("text", TextFuncBytes)
 }
 
 type list struct {
 	r           *regexp.Regexp
-	S           funcs.String
+	text        textVar
 	Expr        funcs.ConstString
 	hash        uint64
 	hasVariable bool
 }
 
 func ListFunc(S funcs.String, Expr funcs.ConstString) (funcs.Bool, error) {
+	return ListFuncWithPrefix(S, Expr, nil)
+}
+
+//ListFuncWithPrefix is list for text values with the given prefix, instead of text_.
+func ListFuncWithPrefix(S funcs.String, Expr funcs.ConstString, Prefix funcs.ConstString) (funcs.Bool, error) {
+	text, err := newStringText(S, Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newListFunc(text, Expr)
+}
+
+//ListFuncBytes is list for the typed text values of the parser of TypedText.
+func ListFuncBytes(B funcs.Bytes, Expr funcs.ConstString) (funcs.Bool, error) {
+	return newListFunc(newBytesText(B), Expr)
+}
+
+func newListFunc(text textVar, Expr funcs.ConstString) (funcs.Bool, error) {
 	e, err := Expr.Eval()
 	if err != nil {
 		return nil, err
@@ -345,21 +631,21 @@ func ListFunc(S funcs.String, Expr funcs.ConstString) (funcs.Bool, error) {
 		return nil, err
 	}
 	return funcs.TrimBool(&list{
-		S:           S,
+		text:        text,
 		r:           r,
 		Expr:        Expr,
-		hash:        funcs.Hash("list", S, Expr),
-		hasVariable: S.HasVariable(),
+		hash:        text.hash("list", text.variable(), Expr),
+		hasVariable: text.hasVariable(),
 	}), nil
 }
 
 func (this *list) Eval() (bool, error) {
-	s, err := this.S.Eval()
-	if err != nil {
-		return false, nil
+	if this.text.B != nil {
+		b, ok := this.text.evalBytes()
+		return ok && this.r.Match(normalizeSpace(b)), nil
 	}
-	s, err = stripTextPrefix(s)
-	if err != nil {
+	s, ok := this.text.evalString()
+	if !ok {
 		return false, nil
 	}
 	ss := tokenize(s)
@@ -375,13 +661,10 @@ func (this *list) Compare(that funcs.Comparable) int {
 		return 1
 	}
 	if other, ok := that.(*list); ok {
-		if c := this.S.Compare(other.S); c != 0 {
-			return c
-		}
-		if c := this.Expr.Compare(other.Expr); c != 0 {
+		if c := this.text.compare(other.text); c != 0 {
 			return c
 		}
-		return 0
+		return this.Expr.Compare(other.Expr)
 	}
 	return strings.Compare(this.String(), that.String())
 }
@@ -391,7 +674,7 @@ func (this *list) HasVariable() bool {
 }
 
 func (this *list) String() string {
-	return "list(" + this.S.String() + "," + this.Expr.String() + ")"
+	return "list(" + this.text.variable().String() + "," + this.Expr.String() + this.text.paramString() + ")"
 }
 
 func (this *list) Hash() uint64 {
@@ -400,14 +683,16 @@ func (this *list) Hash() uint64 {
 
 func init() {
 	funcs.Register("list", ListFunc)
+	funcs.Register("list", ListFuncWithPrefix)
+	funcs.Register("list", ListFuncBytes)
 }
 
-func newList(nameOrPattern *NameOrPattern) *ast.Pattern {
+func newList(nameOrPattern *NameOrPattern, opts TranslateOptions) *ast.Pattern {
 	regexStr, nullable, err := listToRegex(nameOrPattern)
 	if err != nil {
 		return ast.NewNot(ast.NewZAny())
 	}
-	val := c.Value(ast.NewFunction("list", c.StringVar(), c.StringConst("^"+regexStr+"$")))
+	val := textFunction("list", opts, c.StringConst("^"+regexStr+"$"))
 	if !nullable {
 		return val
 	}
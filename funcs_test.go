@@ -17,6 +17,7 @@ package relaxng
 import (
 	"testing"
 
+	"github.com/katydid/katydid/parser"
 	"github.com/katydid/katydid/parser/debug"
 	"github.com/katydid/katydid/relapse/ast"
 	c "github.com/katydid/katydid/relapse/combinator"
@@ -152,3 +153,179 @@ func TestList(t *testing.T) {
 		t.Fatalf("expected false")
 	}
 }
+
+func TestDatatype(t *testing.T) {
+	tests := []struct {
+		typ   string
+		value string
+		want  bool
+	}{
+		{"int", "text_ +42 ", true},
+		{"int", "text_2147483648", false},
+		{"int", "text_4.2", false},
+		{"unsignedByte", "text_-1", false},
+		{"decimal", "text_-4.20", true},
+		{"boolean", "text_yes", false},
+		{"date", "text_2018-02-28+02:00", true},
+		{"date", "text_2018-2-28", false},
+		{"dateTime", "text_2018-02-28T13:20:00.5Z", true},
+		{"duration", "text_P", false},
+		{"duration", "text_P1DT2H", true},
+		{"anyURI", "text_anything", true},
+		{"int", "42", false},
+	}
+	for _, test := range tests {
+		b, err := compose.NewBool(ast.NewFunction("datatype", c.StringVar(), c.StringConst(test.typ)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := compose.NewBoolFunc(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := f.Eval(debug.NewStringValue(test.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != test.want {
+			t.Errorf("datatype %s of %q: expected %v, but got %v", test.typ, test.value, test.want, r)
+		}
+	}
+}
+
+func TestTextWithTextPrefix(t *testing.T) {
+	expr := ast.NewFunction("text", c.StringVar(), c.StringConst("text_a"))
+	b, err := compose.NewBool(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := compose.NewBoolFunc(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.Eval(debug.NewStringValue("text_text_a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != true {
+		t.Fatalf("expected true")
+	}
+	r, err = f.Eval(debug.NewStringValue("text_a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != false {
+		t.Fatalf("expected false")
+	}
+}
+
+func TestTokenWithPrefix(t *testing.T) {
+	expr := ast.NewFunction("token", c.StringVar(), c.StringConst("TheStreet"), c.StringConst("t:"))
+	b, err := compose.NewBool(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := compose.NewBoolFunc(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.Eval(debug.NewStringValue("t: TheStreet "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != true {
+		t.Fatalf("expected true")
+	}
+	r, err = f.Eval(debug.NewStringValue("text_TheStreet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != false {
+		t.Fatalf("expected false")
+	}
+}
+
+func TestTypedText(t *testing.T) {
+	tests := []struct {
+		expr  *ast.Expr
+		value string
+		want  bool
+	}{
+		{ast.NewFunction("token", c.BytesVar(), c.StringConst("The Street")), " The \n Street\t", true},
+		{ast.NewFunction("token", c.BytesVar(), c.StringConst("The Street")), "TheStreet", false},
+		{ast.NewFunction("whitespace", c.BytesVar()), " \r\n\t", true},
+		{ast.NewFunction("whitespace", c.BytesVar()), " a ", false},
+		{ast.NewFunction("anytext", c.BytesVar()), "text_", true},
+		{ast.NewFunction("text", c.BytesVar(), c.StringConst("text_a")), "text_a", true},
+		{ast.NewFunction("text", c.BytesVar(), c.StringConst("a")), "text_a", false},
+		{ast.NewFunction("list", c.BytesVar(), c.StringConst("^a b$")), "  a\tb ", true},
+		{ast.NewFunction("list", c.BytesVar(), c.StringConst("^a b$")), "a c", false},
+		{ast.NewFunction("datatype", c.BytesVar(), c.StringConst("int")), " 42\n", true},
+		{ast.NewFunction("datatype", c.BytesVar(), c.StringConst("int")), "4 2", false},
+	}
+	for _, test := range tests {
+		b, err := compose.NewBool(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := compose.NewBoolFunc(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := f.Eval(debug.NewBytesValue([]byte(test.value)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != test.want {
+			t.Fatalf("%s on %q: expected %v", test.expr, test.value, test.want)
+		}
+		r, err = f.Eval(debug.NewStringValue(test.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r {
+			t.Fatalf("%s: expected a string to not be text", test.expr)
+		}
+	}
+}
+
+func benchmarkText(b *testing.B, expr *ast.Expr, value interface{}) {
+	e, err := compose.NewBool(expr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	f, err := compose.NewBoolFunc(e)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var v parser.Value
+	switch value := value.(type) {
+	case string:
+		v = debug.NewStringValue(value)
+	case []byte:
+		v = debug.NewBytesValue(value)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if r, err := f.Eval(v); err != nil || !r {
+			b.Fatalf("expected true, but got %v, %v", r, err)
+		}
+	}
+}
+
+func BenchmarkTokenString(b *testing.B) {
+	benchmarkText(b, ast.NewFunction("token", c.StringVar(), c.StringConst("plain text")), "text_plain text")
+}
+
+func BenchmarkTokenBytes(b *testing.B) {
+	benchmarkText(b, ast.NewFunction("token", c.BytesVar(), c.StringConst("plain text")), []byte("plain text"))
+}
+
+func BenchmarkTextString(b *testing.B) {
+	benchmarkText(b, ast.NewFunction("text", c.StringVar(), c.StringConst("plain text")), "text_plain text")
+}
+
+func BenchmarkTextBytes(b *testing.B) {
+	benchmarkText(b, ast.NewFunction("text", c.BytesVar(), c.StringConst("plain text")), []byte("plain text"))
+}
@@ -0,0 +1,476 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+//GenerateOptions bounds the random documents that are generated.
+type GenerateOptions struct {
+	Seed int64
+	//MaxDepth is the depth of elements after which the smallest content is generated.
+	//Zero means 8.
+	MaxDepth int
+	//MaxRepeat is the maximum number of times that the content of a oneOrMore is repeated.
+	//Zero means 3.
+	MaxRepeat int
+	//MaxElements is the number of elements after which the smallest content is generated.
+	//Zero means 100.
+	MaxElements int
+}
+
+//GenerateMinimal generates a valid document, for the simplified Grammar, with as few elements as possible.
+//Values are picked from the value elements and synthesized for data elements.
+func GenerateMinimal(g *Grammar) ([]byte, error) {
+	gen, err := newGenerator(g, nil, GenerateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return gen.generate()
+}
+
+//GenerateRandom generates a random valid document for the simplified Grammar.
+//The same seed and Grammar always results in the same document.
+func GenerateRandom(g *Grammar, opts GenerateOptions) ([]byte, error) {
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = 8
+	}
+	if opts.MaxRepeat == 0 {
+		opts.MaxRepeat = 3
+	}
+	if opts.MaxElements == 0 {
+		opts.MaxElements = 100
+	}
+	gen, err := newGenerator(g, rand.New(rand.NewSource(opts.Seed)), opts)
+	if err != nil {
+		return nil, err
+	}
+	return gen.generate()
+}
+
+//infinite is the cost of patterns that do not match any document.
+const infinite = math.MaxInt32
+
+type generator struct {
+	grammar *Grammar
+	defines map[string]*Define
+	//costs holds the smallest number of elements that the content of each define can be generated with.
+	costs    map[string]int
+	rand     *rand.Rand
+	opts     GenerateOptions
+	elements int
+	deriver  *deriver
+}
+
+type genElement struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []interface{}
+}
+
+func newGenerator(g *Grammar, r *rand.Rand, opts GenerateOptions) (*generator, error) {
+	gen := &generator{
+		grammar: g,
+		defines: make(map[string]*Define),
+		costs:   make(map[string]int),
+		rand:    r,
+		opts:    opts,
+	}
+	for i := range g.Define {
+		gen.defines[g.Define[i].Name] = &g.Define[i]
+		gen.costs[g.Define[i].Name] = infinite
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, d := range g.Define {
+			cost := add(1, gen.cost(d.Element.Right))
+			if cost < gen.costs[d.Name] {
+				gen.costs[d.Name] = cost
+				changed = true
+			}
+		}
+	}
+	if gen.cost(g.Start) == infinite {
+		return nil, errors.New("the grammar does not match any document")
+	}
+	return gen, nil
+}
+
+func add(a, b int) int {
+	if a == infinite || b == infinite {
+		return infinite
+	}
+	return a + b
+}
+
+//cost returns the smallest number of elements that the pattern can be generated with.
+func (this *generator) cost(p *NameOrPattern) int {
+	switch {
+	case p.NotAllowed != nil:
+		return infinite
+	case p.Ref != nil:
+		c, ok := this.costs[p.Ref.Name]
+		if !ok {
+			return infinite
+		}
+		return c
+	case p.OneOrMore != nil:
+		return this.cost(p.OneOrMore.NameOrPattern)
+	case p.List != nil:
+		return this.cost(p.List.NameOrPattern)
+	case p.Attribute != nil:
+		return this.cost(p.Attribute.Right)
+	case p.Choice != nil:
+		l, r := this.cost(p.Choice.Left), this.cost(p.Choice.Right)
+		if l < r {
+			return l
+		}
+		return r
+	case p.Group != nil:
+		return add(this.cost(p.Group.Left), this.cost(p.Group.Right))
+	case p.Interleave != nil:
+		return add(this.cost(p.Interleave.Left), this.cost(p.Interleave.Right))
+	}
+	return 0
+}
+
+func (this *generator) generate() ([]byte, error) {
+	root := &genElement{}
+	this.pattern(this.grammar.Start, root, 0)
+	if len(root.children) != 1 {
+		return nil, fmt.Errorf("expected a single root element, but generated %d nodes", len(root.children))
+	}
+	e, ok := root.children[0].(*genElement)
+	if !ok {
+		return nil, errors.New("expected a root element, but generated text")
+	}
+	buf := bytes.NewBuffer(nil)
+	writeElement(buf, e, "")
+	findings, err := Diagnose(this.grammar, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(findings) > 0 {
+		return nil, fmt.Errorf("could not generate a valid document: %v", findings[0])
+	}
+	return buf.Bytes(), nil
+}
+
+//minimal returns whether the smallest content should be generated.
+func (this *generator) minimal(depth int) bool {
+	return this.rand == nil || depth >= this.opts.MaxDepth || this.elements >= this.opts.MaxElements
+}
+
+func (this *generator) pattern(p *NameOrPattern, e *genElement, depth int) {
+	switch {
+	case p.Empty != nil, p.NotAllowed != nil:
+	case p.Text != nil:
+		if !this.minimal(depth) && this.rand.Intn(2) == 0 {
+			e.children = append(e.children, this.word())
+		}
+	case p.Data != nil:
+		e.children = append(e.children, this.data(p.Data))
+	case p.Value != nil:
+		e.children = append(e.children, p.Value.Text)
+	case p.List != nil:
+		list := &genElement{}
+		this.pattern(p.List.NameOrPattern, list, depth)
+		tokens := make([]string, 0, len(list.children))
+		for _, c := range list.children {
+			if s, ok := c.(string); ok && len(strings.TrimSpace(s)) > 0 {
+				tokens = append(tokens, strings.TrimSpace(s))
+			}
+		}
+		e.children = append(e.children, strings.Join(tokens, " "))
+	case p.Attribute != nil:
+		value := &genElement{}
+		this.pattern(p.Attribute.Right, value, depth)
+		s := ""
+		for _, c := range value.children {
+			if t, ok := c.(string); ok {
+				s += t
+			}
+		}
+		e.attrs = append(e.attrs, xml.Attr{Name: this.name(p.Attribute.Left, e.attrs), Value: s})
+	case p.Ref != nil:
+		d := this.defines[p.Ref.Name]
+		child := &genElement{name: this.name(d.Element.Left, nil)}
+		this.elements++
+		this.pattern(d.Element.Right, child, depth+1)
+		e.children = append(e.children, child)
+	case p.OneOrMore != nil:
+		n := 1
+		if !this.minimal(depth) && !hasAttr(p.OneOrMore.NameOrPattern) {
+			n += this.rand.Intn(this.opts.MaxRepeat)
+		}
+		for i := 0; i < n; i++ {
+			this.pattern(p.OneOrMore.NameOrPattern, e, depth)
+		}
+	case p.Choice != nil:
+		l, r := this.cost(p.Choice.Left), this.cost(p.Choice.Right)
+		left := l <= r
+		if !this.minimal(depth) && l != infinite && r != infinite {
+			left = this.rand.Intn(2) == 0
+		}
+		if left {
+			this.pattern(p.Choice.Left, e, depth)
+		} else {
+			this.pattern(p.Choice.Right, e, depth)
+		}
+	case p.Group != nil:
+		this.pattern(p.Group.Left, e, depth)
+		this.pattern(p.Group.Right, e, depth)
+	case p.Interleave != nil:
+		left, right := &genElement{}, &genElement{}
+		this.pattern(p.Interleave.Left, left, depth)
+		this.pattern(p.Interleave.Right, right, depth)
+		e.attrs = append(append(e.attrs, left.attrs...), right.attrs...)
+		e.children = append(e.children, this.shuffle(left.children, right.children)...)
+	}
+}
+
+//shuffle merges the two lists, keeping the order within each list.
+func (this *generator) shuffle(left, right []interface{}) []interface{} {
+	if this.rand == nil {
+		return append(left, right...)
+	}
+	merged := make([]interface{}, 0, len(left)+len(right))
+	for len(left) > 0 || len(right) > 0 {
+		if len(right) == 0 || len(left) > 0 && this.rand.Intn(2) == 0 {
+			merged = append(merged, left[0])
+			left = left[1:]
+		} else {
+			merged = append(merged, right[0])
+			right = right[1:]
+		}
+	}
+	return merged
+}
+
+//name returns a name in the name class, that is not one of the attributes.
+func (this *generator) name(n *NameOrPattern, attrs []xml.Attr) xml.Name {
+	for _, name := range this.names(n) {
+		used := false
+		for _, a := range attrs {
+			used = used || a.Name == name
+		}
+		if !used {
+			return name
+		}
+	}
+	return xml.Name{Local: "unknown"}
+}
+
+//names returns candidate names that match the name class.
+func (this *generator) names(n *NameOrPattern) []xml.Name {
+	var candidates []xml.Name
+	switch {
+	case n.Name != nil:
+		return []xml.Name{{Space: n.Name.Ns, Local: n.Name.Text}}
+	case n.Choice != nil:
+		l, r := this.names(n.Choice.Left), this.names(n.Choice.Right)
+		if this.rand != nil && this.rand.Intn(2) == 0 {
+			return append(r, l...)
+		}
+		return append(l, r...)
+	case n.AnyName != nil:
+		candidates = append(exceptNames(n.AnyName.Except), anyNames("")...)
+		candidates = append(candidates, anyNames(anyNs)...)
+	case n.NsName != nil:
+		candidates = append(exceptNames(n.NsName.Except), anyNames(n.NsName.Ns)...)
+	}
+	names := candidates[:0]
+	for _, c := range candidates {
+		if nameMatches(n, c) {
+			names = append(names, c)
+		}
+	}
+	return names
+}
+
+//anyNs is the namespace of names that are generated for anyName.
+const anyNs = "http://example.com/any"
+
+func anyNames(ns string) []xml.Name {
+	names := make([]xml.Name, 10)
+	for i := range names {
+		names[i] = xml.Name{Space: ns, Local: "any" + strconv.Itoa(i)}
+	}
+	return names
+}
+
+//exceptNames returns the names in the except name class,
+//since these are the only names that are allowed when the except itself has an except.
+func exceptNames(n *NameOrPattern) []xml.Name {
+	if n == nil {
+		return nil
+	}
+	switch {
+	case n.Name != nil:
+		return []xml.Name{{Space: n.Name.Ns, Local: n.Name.Text}}
+	case n.Choice != nil:
+		return append(exceptNames(n.Choice.Left), exceptNames(n.Choice.Right)...)
+	case n.AnyName != nil:
+		return exceptNames(n.AnyName.Except)
+	case n.NsName != nil:
+		return exceptNames(n.NsName.Except)
+	}
+	return nil
+}
+
+func (this *generator) word() string {
+	if this.rand == nil {
+		return "a"
+	}
+	letters := make([]byte, 1+this.rand.Intn(8))
+	for i := range letters {
+		letters[i] = byte('a' + this.rand.Intn(26))
+	}
+	return string(letters)
+}
+
+//datatypeValues holds valid values for datatypes that are not strings.
+var datatypeValues = map[string][]string{
+	"boolean":            {"true", "false", "1", "0"},
+	"decimal":            {"0", "1.5", "-2.25"},
+	"float":              {"0", "1.5", "-2.25"},
+	"double":             {"0", "1.5", "-2.25"},
+	"integer":            {"0", "1", "42", "-7"},
+	"long":               {"0", "1", "42", "-7"},
+	"int":                {"0", "1", "42", "-7"},
+	"short":              {"0", "1", "42", "-7"},
+	"byte":               {"0", "1", "42", "-7"},
+	"nonNegativeInteger": {"0", "1", "42"},
+	"positiveInteger":    {"1", "42"},
+	"nonPositiveInteger": {"0", "-1", "-42"},
+	"negativeInteger":    {"-1", "-42"},
+	"unsignedLong":       {"0", "1", "42"},
+	"unsignedInt":        {"0", "1", "42"},
+	"unsignedShort":      {"0", "1", "42"},
+	"unsignedByte":       {"0", "1", "42"},
+	"date":               {"2018-01-02"},
+	"dateTime":           {"2018-01-02T03:04:05"},
+	"time":               {"03:04:05"},
+	"gYear":              {"2018"},
+	"gYearMonth":         {"2018-01"},
+	"duration":           {"P1D", "PT1H"},
+	"anyURI":             {"http://example.com/"},
+	"base64Binary":       {"AAAA"},
+	"hexBinary":          {"00ff"},
+	"language":           {"en", "af"},
+}
+
+//data returns a value of the datatype that satisfies the length params and does not match the except pattern.
+func (this *generator) data(d *Data) string {
+	for i := 0; ; i++ {
+		s := this.dataValue(d)
+		if i > 0 {
+			s += strconv.Itoa(i)
+		}
+		if d.Except == nil {
+			return s
+		}
+		if this.deriver == nil {
+			this.deriver = newDeriver(this.grammar)
+		}
+		if !nullable(this.deriver.textDeriv(compilePattern(d.Except), s)) {
+			return s
+		}
+	}
+}
+
+func (this *generator) dataValue(d *Data) string {
+	if values, ok := datatypeValues[d.Type]; ok {
+		if this.rand == nil {
+			return values[0]
+		}
+		return values[this.rand.Intn(len(values))]
+	}
+	s := this.word()
+	min, max := 0, -1
+	for _, p := range d.Param {
+		n, err := strconv.Atoi(strings.TrimSpace(p.Text))
+		if err != nil {
+			continue
+		}
+		switch p.Name {
+		case "length":
+			min, max = n, n
+		case "minLength":
+			min = n
+		case "maxLength":
+			max = n
+		}
+	}
+	for len(s) < min {
+		s += "a"
+	}
+	if max >= 0 && len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+//writeElement writes the element, declaring its namespace if it differs from the default namespace.
+func writeElement(buf *bytes.Buffer, e *genElement, defaultNs string) {
+	buf.WriteString("<" + e.name.Local)
+	if e.name.Space != defaultNs {
+		buf.WriteString(` xmlns="`)
+		xml.EscapeText(buf, []byte(e.name.Space))
+		buf.WriteString(`"`)
+	}
+	prefixes := make(map[string]string)
+	for _, a := range e.attrs {
+		name := a.Name.Local
+		if a.Name.Space == xmlNs {
+			name = "xml:" + name
+		} else if len(a.Name.Space) > 0 {
+			prefix, ok := prefixes[a.Name.Space]
+			if !ok {
+				prefix = "ns" + strconv.Itoa(len(prefixes)+1)
+				prefixes[a.Name.Space] = prefix
+				buf.WriteString(" xmlns:" + prefix + `="`)
+				xml.EscapeText(buf, []byte(a.Name.Space))
+				buf.WriteString(`"`)
+			}
+			name = prefix + ":" + name
+		}
+		buf.WriteString(" " + name + `="`)
+		xml.EscapeText(buf, []byte(a.Value))
+		buf.WriteString(`"`)
+	}
+	if len(e.children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	for _, c := range e.children {
+		switch c := c.(type) {
+		case string:
+			xml.EscapeText(buf, []byte(c))
+		case *genElement:
+			writeElement(buf, c, e.name.Space)
+		}
+	}
+	buf.WriteString("</" + e.name.Local + ">")
+}
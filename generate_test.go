@@ -0,0 +1,118 @@
+package relaxng
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//TestGenerateSuite checks that every generated document validates.
+func TestGenerateSuite(t *testing.T) {
+	suite := scanFiles()
+	generated := 0
+	for _, spec := range suite {
+		if len(spec.SimpleFilename) == 0 {
+			continue
+		}
+		num := testNumber(spec.Filename)
+		g, err := SimplifyFile(filepath.Join(filepath.Dir(spec.Filename), "c.rng"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs := [][]byte{}
+		doc, err := GenerateMinimal(g)
+		if err != nil {
+			//The grammar does not match any document or the generator does not find one.
+			t.Logf("%s: %v", num, err)
+			continue
+		}
+		docs = append(docs, doc)
+		for seed := int64(0); seed < 10; seed++ {
+			doc, err := GenerateRandom(g, GenerateOptions{Seed: seed})
+			if err != nil {
+				t.Fatalf("%s: %v", num, err)
+			}
+			docs = append(docs, doc)
+		}
+		for _, doc := range docs {
+			generated++
+			findings, err := Diagnose(g, doc)
+			if err != nil {
+				t.Fatalf("%s: %v", num, err)
+			}
+			if len(findings) > 0 {
+				t.Errorf("%s: generated invalid document %s: %v", num, doc, findings)
+			}
+			if namespaces[num] || datatypeLibrary[num] {
+				continue
+			}
+			katydid, err := Translate(g)
+			if err != nil {
+				t.Fatalf("%s: %v", num, err)
+			}
+			if err := Validate(katydid, doc); err != nil {
+				t.Errorf("%s: generated document %s does not validate: %v", num, doc, err)
+			}
+		}
+	}
+	t.Logf("generated %d documents", generated)
+}
+
+func TestGenerate(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" xmlns="http://relaxng.org/ns/structure/1.0"
+		datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+		<attribute name="status">
+			<choice>
+				<value>open</value>
+				<value>closed</value>
+			</choice>
+		</attribute>
+		<zeroOrMore>
+			<element name="item">
+				<attribute name="quantity"><data type="positiveInteger"/></attribute>
+				<data type="string"><param name="minLength">3</param></data>
+			</element>
+		</zeroOrMore>
+	</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := GenerateMinimal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(doc) != `<order status="open"/>` {
+		t.Fatalf("unexpected minimal document %s", doc)
+	}
+	opts := GenerateOptions{Seed: 1, MaxRepeat: 5}
+	doc, err = GenerateRandom(g, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := GenerateRandom(g, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(doc) != string(again) {
+		t.Fatalf("expected the same document for the same seed, got %s and %s", doc, again)
+	}
+	items := 0
+	for seed := int64(0); seed < 20; seed++ {
+		doc, err := GenerateRandom(g, GenerateOptions{Seed: seed, MaxElements: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(doc), "<item"); n > items {
+			items = n
+		}
+		if findings, err := Diagnose(g, doc); err != nil || len(findings) > 0 {
+			t.Fatalf("generated invalid document %s: %v %v", doc, findings, err)
+		}
+	}
+	if items == 0 || items > 3 {
+		t.Fatalf("expected between 1 and 3 items, got %d", items)
+	}
+	if _, err := GenerateMinimal(&Grammar{Start: &NameOrPattern{NotAllowed: &NotAllowed{}}}); err == nil {
+		t.Fatalf("expected an error for a grammar without documents")
+	}
+}
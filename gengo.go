@@ -0,0 +1,502 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//GenerateGo generates Go source code, for the named package, with a struct type
+//for every element in the simplified Grammar, that can be marshaled and unmarshaled using encoding/xml.
+//
+//Attributes become fields with an attr tag and child elements become fields with the element's name as the tag.
+//Patterns inside oneOrMore become slices and optional patterns, that are a choice with empty, become pointers.
+//A choice between elements becomes a tagged variant: an embedded struct with a pointer field for every alternative, of which only one should be set.
+//A choice between values becomes a string type with a constant for every value
+//and xsd datatypes become the corresponding Go types.
+//Elements with any name, also at the root, become an AnyElement, that keeps the name, attributes and inner xml.
+func GenerateGo(g *Grammar, pkg string) ([]byte, error) {
+	gen := newGoGenerator(g)
+	defines := refs(g.Start)
+	for _, d := range g.Define {
+		defines = append(defines, d.Name)
+	}
+	for _, define := range defines {
+		if gen.defines[define].Element.Left.Name != nil {
+			gen.element(define)
+		}
+	}
+	//A root element with any name is unmarshaled into an AnyElement.
+	for _, define := range refs(g.Start) {
+		if gen.defines[define].Element.Left.Name == nil {
+			gen.needsAny = true
+		}
+	}
+	gen.pointers()
+	src := gen.source(pkg)
+	formatted, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("%v in generated source:\n%s", err, src)
+	}
+	return formatted, nil
+}
+
+type multiplicity int
+
+const (
+	one multiplicity = iota
+	optional
+	many
+)
+
+func (this multiplicity) optional() multiplicity {
+	if this == one {
+		return optional
+	}
+	return this
+}
+
+type fieldKind int
+
+const (
+	attrField fieldKind = iota
+	anyAttrField
+	elemField
+	anyElemField
+	textField
+	embeddedField
+)
+
+type goField struct {
+	kind fieldKind
+	name string
+	typ  string
+	//xmlName is the name of the attribute or element in the tag.
+	xmlName string
+	//define is the define of an element field.
+	define  string
+	mult    multiplicity
+	pointer bool
+}
+
+type goStruct struct {
+	name string
+	//xmlName is the name of the element in the tag of the XMLName field or empty if the struct is a variant.
+	xmlName string
+	doc     string
+	fields  []*goField
+}
+
+type goEnum struct {
+	name   string
+	values []string
+}
+
+type goGenerator struct {
+	defines  map[string]*Define
+	structs  map[string]*goStruct
+	order    []*goStruct
+	enums    []*goEnum
+	used     map[string]bool
+	needsAny bool
+}
+
+func newGoGenerator(g *Grammar) *goGenerator {
+	gen := &goGenerator{
+		defines: make(map[string]*Define),
+		structs: make(map[string]*goStruct),
+		used:    map[string]bool{"AnyElement": true},
+	}
+	for i := range g.Define {
+		gen.defines[g.Define[i].Name] = &g.Define[i]
+	}
+	return gen
+}
+
+//goName converts an xml name into an exported Go identifier.
+func goName(name string) string {
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[i+1:]
+	}
+	buf := bytes.NewBuffer(nil)
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		buf.WriteRune(r)
+	}
+	s := buf.String()
+	if len(s) == 0 || !unicode.IsLetter([]rune(s)[0]) {
+		s = "X" + s
+	}
+	return s
+}
+
+//typeName returns a unique type name.
+func (this *goGenerator) typeName(name string) string {
+	unique := name
+	for i := 2; this.used[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	this.used[unique] = true
+	return unique
+}
+
+//element returns the struct of the element in the define, which must have a single name.
+func (this *goGenerator) element(define string) *goStruct {
+	if s, ok := this.structs[define]; ok {
+		return s
+	}
+	d := this.defines[define]
+	n := d.Element.Left
+	s := &goStruct{}
+	this.structs[define] = s
+	s.name = this.typeName(goName(n.Name.Text))
+	s.xmlName = xmlTag(n.Name)
+	s.doc = fmt.Sprintf("%s is the element %s.", s.name, nameClassString(n))
+	this.order = append(this.order, s)
+	if enum := this.enum(s.name, d.Element.Right); len(enum) > 0 {
+		this.addField(s, &goField{kind: textField, name: "Value", typ: enum})
+		return s
+	}
+	this.collect(d.Element.Right, one, s)
+	return s
+}
+
+func xmlTag(n *NameNameClass) string {
+	if len(n.Ns) > 0 {
+		return n.Ns + " " + n.Text
+	}
+	return n.Text
+}
+
+//values returns the values of a choice between values.
+func values(p *NameOrPattern) []string {
+	switch {
+	case p.Value != nil:
+		return []string{p.Value.Text}
+	case p.Choice != nil:
+		l, r := values(p.Choice.Left), values(p.Choice.Right)
+		if l == nil || r == nil {
+			return nil
+		}
+		return append(l, r...)
+	}
+	return nil
+}
+
+//enum returns a new string type, with a constant for each value, if the pattern is a choice between values.
+func (this *goGenerator) enum(name string, p *NameOrPattern) string {
+	vs := values(p)
+	if len(vs) < 2 {
+		return ""
+	}
+	e := &goEnum{name: this.typeName(name), values: vs}
+	this.enums = append(this.enums, e)
+	return e.name
+}
+
+//collect adds the fields for the pattern to the struct.
+func (this *goGenerator) collect(p *NameOrPattern, mult multiplicity, s *goStruct) {
+	switch {
+	case p.Empty != nil, p.NotAllowed != nil:
+	case p.Text != nil, p.Value != nil, p.List != nil:
+		this.addField(s, &goField{kind: textField, name: "Text", typ: "string", mult: one})
+	case p.Data != nil:
+		this.addField(s, &goField{kind: textField, name: "Text", typ: this.dataType(p.Data), mult: one})
+	case p.Attribute != nil:
+		n := p.Attribute.Left
+		if n.Name == nil {
+			this.addField(s, &goField{kind: anyAttrField, name: "AnyAttrs", typ: "xml.Attr", mult: many})
+			return
+		}
+		name := goName(n.Name.Text)
+		typ := this.enum(s.name+name, p.Attribute.Right)
+		if len(typ) == 0 {
+			typ = this.valueType(p.Attribute.Right)
+		}
+		this.addField(s, &goField{kind: attrField, name: name, typ: typ, xmlName: xmlTag(n.Name), mult: mult})
+	case p.Ref != nil:
+		n := this.defines[p.Ref.Name].Element.Left
+		if n.Name == nil {
+			this.needsAny = true
+			this.addField(s, &goField{kind: anyElemField, name: "Any", typ: "AnyElement", mult: many})
+			return
+		}
+		this.addField(s, &goField{kind: elemField, name: goName(n.Name.Text), xmlName: xmlTag(n.Name), define: p.Ref.Name, mult: mult})
+		this.element(p.Ref.Name)
+	case p.OneOrMore != nil:
+		this.collect(p.OneOrMore.NameOrPattern, many, s)
+	case p.Choice != nil:
+		if p.Choice.Left.Empty != nil {
+			this.collect(p.Choice.Right, mult.optional(), s)
+			return
+		}
+		if p.Choice.Right.Empty != nil {
+			this.collect(p.Choice.Left, mult.optional(), s)
+			return
+		}
+		if values(p) != nil {
+			this.addField(s, &goField{kind: textField, name: "Text", typ: "string", mult: one})
+			return
+		}
+		if mult == one && len(s.xmlName) > 0 && hasElements(p) {
+			v := &goStruct{name: this.typeName(s.name + "Choice")}
+			v.doc = fmt.Sprintf("%s is a choice, of which only one alternative should be set.", v.name)
+			this.order = append(this.order, v)
+			this.collectChoice(p, v)
+			this.addField(s, &goField{kind: embeddedField, name: v.name, typ: v.name})
+			return
+		}
+		this.collect(p.Choice.Left, mult.optional(), s)
+		this.collect(p.Choice.Right, mult.optional(), s)
+	case p.Group != nil:
+		this.collect(p.Group.Left, mult, s)
+		this.collect(p.Group.Right, mult, s)
+	case p.Interleave != nil:
+		this.collect(p.Interleave.Left, mult, s)
+		this.collect(p.Interleave.Right, mult, s)
+	}
+}
+
+func (this *goGenerator) collectChoice(p *NameOrPattern, v *goStruct) {
+	if p.Choice != nil {
+		this.collectChoice(p.Choice.Left, v)
+		this.collectChoice(p.Choice.Right, v)
+		return
+	}
+	this.collect(p, optional, v)
+}
+
+//refs returns the names of the defines that are referenced by the pattern, in order.
+func refs(p *NameOrPattern) []string {
+	switch {
+	case p.Ref != nil:
+		return []string{p.Ref.Name}
+	case p.OneOrMore != nil:
+		return refs(p.OneOrMore.NameOrPattern)
+	case p.Choice != nil:
+		return append(refs(p.Choice.Left), refs(p.Choice.Right)...)
+	case p.Group != nil:
+		return append(refs(p.Group.Left), refs(p.Group.Right)...)
+	case p.Interleave != nil:
+		return append(refs(p.Interleave.Left), refs(p.Interleave.Right)...)
+	}
+	return nil
+}
+
+func hasElements(p *NameOrPattern) bool {
+	switch {
+	case p.Ref != nil:
+		return true
+	case p.OneOrMore != nil:
+		return hasElements(p.OneOrMore.NameOrPattern)
+	case p.Choice != nil:
+		return hasElements(p.Choice.Left) || hasElements(p.Choice.Right)
+	case p.Group != nil:
+		return hasElements(p.Group.Left) || hasElements(p.Group.Right)
+	case p.Interleave != nil:
+		return hasElements(p.Interleave.Left) || hasElements(p.Interleave.Right)
+	}
+	return false
+}
+
+//addField adds the field, or if a field for the same attribute, element or text already exists,
+//changes the existing field to be a slice.
+func (this *goGenerator) addField(s *goStruct, f *goField) {
+	for _, other := range s.fields {
+		if other.kind != f.kind || other.xmlName != f.xmlName || other.typ != f.typ {
+			continue
+		}
+		if f.kind == textField || f.kind == anyElemField || f.kind == anyAttrField {
+			return
+		}
+		if f.kind == attrField {
+			other.mult = optional
+		} else {
+			other.mult = many
+		}
+		return
+	}
+	name := f.name
+	for i := 2; fieldNameUsed(s, name); i++ {
+		name = f.name + strconv.Itoa(i)
+	}
+	f.name = name
+	s.fields = append(s.fields, f)
+}
+
+func fieldNameUsed(s *goStruct, name string) bool {
+	if name == "XMLName" {
+		return true
+	}
+	for _, f := range s.fields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//valueType returns the Go type of the content of an attribute.
+func (this *goGenerator) valueType(p *NameOrPattern) string {
+	if p.Data != nil {
+		return this.dataType(p.Data)
+	}
+	return "string"
+}
+
+//dataTypes maps xsd datatypes to Go types, that encoding/xml can decode without losing the value.
+//Other datatypes, like decimal, which would lose precision as a float64, and base64Binary, which encoding/xml does not decode, stay a string.
+var dataTypes = map[string]string{
+	"boolean":            "bool",
+	"float":              "float32",
+	"double":             "float64",
+	"integer":            "int64",
+	"nonNegativeInteger": "uint64",
+	"positiveInteger":    "uint64",
+	"nonPositiveInteger": "int64",
+	"negativeInteger":    "int64",
+	"long":               "int64",
+	"int":                "int32",
+	"short":              "int16",
+	"byte":               "int8",
+	"unsignedLong":       "uint64",
+	"unsignedInt":        "uint32",
+	"unsignedShort":      "uint16",
+	"unsignedByte":       "uint8",
+}
+
+func (this *goGenerator) dataType(d *Data) string {
+	if typ, ok := dataTypes[d.Type]; ok && d.DatatypeLibrary == xsdDatatypes {
+		return typ
+	}
+	return "string"
+}
+
+//pointers makes required element fields pointers, where the element would otherwise contain itself.
+func (this *goGenerator) pointers() {
+	for _, s := range this.structs {
+		for _, f := range s.fields {
+			if f.kind == elemField && f.mult == one && this.contains(this.element(f.define), s, nil) {
+				f.pointer = true
+			}
+		}
+	}
+}
+
+//contains returns whether the struct from contains the struct to, through fields that are not pointers or slices.
+func (this *goGenerator) contains(from, to *goStruct, seen []*goStruct) bool {
+	if from == to {
+		return true
+	}
+	for _, s := range seen {
+		if s == from {
+			return false
+		}
+	}
+	seen = append(seen, from)
+	for _, f := range from.fields {
+		if f.kind == elemField && f.mult == one && !f.pointer && this.contains(this.element(f.define), to, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *goGenerator) source(pkg string) []byte {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "// Code generated by relaxng gen-go. DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package %s\n\n", pkg)
+	if len(this.order) > 0 || this.needsAny {
+		fmt.Fprintf(buf, "import \"encoding/xml\"\n\n")
+	}
+	for _, s := range this.order {
+		fmt.Fprintf(buf, "// %s\n", s.doc)
+		fmt.Fprintf(buf, "type %s struct {\n", s.name)
+		if len(s.xmlName) > 0 {
+			fmt.Fprintf(buf, "XMLName xml.Name `xml:%q`\n", s.xmlName)
+		}
+		for _, f := range s.fields {
+			this.writeField(buf, f)
+		}
+		fmt.Fprintf(buf, "}\n\n")
+	}
+	for _, e := range this.enums {
+		fmt.Fprintf(buf, "// %s is one of the values: %s.\n", e.name, strings.Join(e.values, ", "))
+		fmt.Fprintf(buf, "type %s string\n\n", e.name)
+		fmt.Fprintf(buf, "const (\n")
+		used := make(map[string]bool)
+		for i, v := range e.values {
+			name := e.name + goName(v)
+			if used[name] || len(strings.TrimSpace(v)) == 0 {
+				name = e.name + strconv.Itoa(i+1)
+			}
+			used[name] = true
+			fmt.Fprintf(buf, "%s %s = %q\n", name, e.name, v)
+		}
+		fmt.Fprintf(buf, ")\n\n")
+	}
+	if this.needsAny {
+		fmt.Fprintf(buf, "// AnyElement is an element with any name.\n")
+		fmt.Fprintf(buf, "type AnyElement struct {\n")
+		fmt.Fprintf(buf, "XMLName xml.Name\n")
+		fmt.Fprintf(buf, "Attrs []xml.Attr `xml:\",any,attr\"`\n")
+		fmt.Fprintf(buf, "InnerXML string `xml:\",innerxml\"`\n")
+		fmt.Fprintf(buf, "}\n")
+	}
+	return buf.Bytes()
+}
+
+func (this *goGenerator) writeField(buf *bytes.Buffer, f *goField) {
+	typ := f.typ
+	if f.kind == elemField {
+		typ = this.element(f.define).name
+	}
+	switch {
+	case f.kind == embeddedField:
+		fmt.Fprintf(buf, "%s\n", typ)
+		return
+	case f.mult == many:
+		typ = "[]" + typ
+	case f.mult == optional && f.kind != textField, f.pointer:
+		typ = "*" + typ
+	}
+	var tag string
+	switch f.kind {
+	case attrField:
+		tag = f.xmlName + ",attr"
+	case anyAttrField:
+		tag = ",any,attr"
+	case elemField:
+		tag = f.xmlName
+	case anyElemField:
+		tag = ",any"
+	case textField:
+		tag = ",chardata"
+	}
+	fmt.Fprintf(buf, "%s %s `xml:%q`\n", f.name, typ, tag)
+}
@@ -0,0 +1,128 @@
+package relaxng
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	gotoken "go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//TestGenerateGoSuite checks that Go source, that type checks, is generated for every correct grammar.
+func TestGenerateGoSuite(t *testing.T) {
+	conf := types.Config{Importer: importer.Default()}
+	for _, spec := range scanFiles() {
+		if len(spec.SimpleFilename) == 0 {
+			continue
+		}
+		num := testNumber(spec.Filename)
+		g, err := SimplifyFile(filepath.Join(filepath.Dir(spec.Filename), "c.rng"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := GenerateGo(g, "schema")
+		if err != nil {
+			t.Fatalf("%s: %v", num, err)
+		}
+		fset := gotoken.NewFileSet()
+		f, err := parser.ParseFile(fset, "schema.go", src, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", num, err)
+		}
+		if _, err := conf.Check("schema", fset, []*ast.File{f}, nil); err != nil {
+			t.Fatalf("%s: %v in:\n%s", num, err, src)
+		}
+	}
+}
+
+func TestGenerateGo(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" xmlns="http://relaxng.org/ns/structure/1.0"
+		datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+		<attribute name="status">
+			<choice>
+				<value>open</value>
+				<value>closed</value>
+			</choice>
+		</attribute>
+		<optional>
+			<attribute name="priority"><data type="int"/></attribute>
+		</optional>
+		<optional>
+			<element name="note"><text/></element>
+		</optional>
+		<oneOrMore>
+			<element name="item">
+				<attribute name="quantity"><data type="positiveInteger"/></attribute>
+				<attribute name="price"><data type="decimal"/></attribute>
+				<attribute name="signature"><data type="base64Binary"/></attribute>
+				<data type="string"/>
+			</element>
+		</oneOrMore>
+		<choice>
+			<element name="card"><attribute name="number"/></element>
+			<element name="cash"><empty/></element>
+		</choice>
+		<element name="paid"><data type="boolean"/></element>
+	</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := GenerateGo(g, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(strings.Fields(string(src)), " ")
+	for _, want := range []string{
+		"package orders",
+		"type Order struct { XMLName xml.Name `xml:\"order\"`",
+		"Status OrderStatus `xml:\"status,attr\"`",
+		"Priority *int32 `xml:\"priority,attr\"`",
+		"Note *Note `xml:\"note\"`",
+		"Item []Item `xml:\"item\"`",
+		"OrderChoice Paid Paid `xml:\"paid\"` }",
+		"type OrderChoice struct { Card *Card `xml:\"card\"` Cash *Cash `xml:\"cash\"` }",
+		"Quantity uint64 `xml:\"quantity,attr\"`",
+		"Price string `xml:\"price,attr\"`",
+		"Signature string `xml:\"signature,attr\"`",
+		"Text string `xml:\",chardata\"`",
+		"Text bool `xml:\",chardata\"`",
+		"type OrderStatus string",
+		"OrderStatusOpen OrderStatus = \"open\"",
+		"OrderStatusClosed OrderStatus = \"closed\"",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in:\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerateGoRecursive(t *testing.T) {
+	g, err := Simplify([]byte(`<grammar xmlns="http://relaxng.org/ns/structure/1.0">
+		<start><ref name="node"/></start>
+		<define name="node">
+			<element name="node">
+				<choice>
+					<element name="leaf"><empty/></element>
+					<group>
+						<ref name="node"/>
+						<ref name="node"/>
+					</group>
+				</choice>
+			</element>
+		</define>
+	</grammar>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := GenerateGo(g, "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(strings.Fields(string(src)), " ")
+	if !strings.Contains(got, "Node []Node `xml:\"node\"`") {
+		t.Errorf("expected a slice of nodes in:\n%s", src)
+	}
+}
@@ -18,10 +18,10 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
-	"reflect"
 )
 
 //Parses simplified RelaxNG XML into a Grammar structure.
+//Elements and attributes outside of the RelaxNG namespace are kept as annotations.
 func ParseGrammar(buf []byte) (*Grammar, error) {
 	g := &Grammar{}
 	err := xml.Unmarshal(buf, g)
@@ -67,6 +67,11 @@ type Grammar struct {
 	XMLName xml.Name       `xml:"grammar"`
 	Start   *NameOrPattern `xml:"start"`
 	Define  []Define       `xml:"define"`
+
+	//Annotations are the foreign elements inside the grammar.
+	Annotations []Annotation `xml:"-"`
+	//ForeignAttrs are the attributes of the grammar that are not in the RelaxNG namespace.
+	ForeignAttrs []xml.Attr `xml:"-"`
 }
 
 //The define RelaxNG grammar element
@@ -74,6 +79,12 @@ type Define struct {
 	Name string `xml:"name,attr"`
 	//Left is Name and Right is Pattern
 	Element Pair `xml:"element"`
+	//Annotations are the foreign elements inside the element or inside a define with only the element.
+	Annotations []Annotation `xml:"-"`
+	//ForeignAttrs are the foreign attributes of the define.
+	ForeignAttrs []xml.Attr `xml:"-"`
+	//ElementForeignAttrs are the foreign attributes of the element.
+	ElementForeignAttrs []xml.Attr `xml:"-"`
 }
 
 //One of the name or pattern RelaxNG grammar elements
@@ -101,6 +112,11 @@ type NameOrPattern struct {
 	AnyName *AnyNameClass  `xml:"anyName"`
 	NsName  *NsNameClass   `xml:"nsName"`
 	Name    *NameNameClass `xml:"name"`
+
+	//Annotations are the foreign elements inside the pattern.
+	Annotations []Annotation `xml:"-"`
+	//ForeignAttrs are the attributes of the pattern that are not in the RelaxNG namespace.
+	ForeignAttrs []xml.Attr `xml:"-"`
 }
 
 func (this *NameOrPattern) IsPattern() bool {
@@ -128,39 +144,146 @@ func (this *NameOrPattern) String() string {
 	return string(buf.Bytes())
 }
 
-func (this *NameOrPattern) unmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	t := reflect.TypeOf(this).Elem()
-	numFields := t.NumField()
-	v := reflect.ValueOf(this).Elem()
-	for i := 0; i < numFields; i++ {
-		f := t.Field(i)
-		xmlTag := f.Tag.Get("xml")
-		if xmlTag == start.Name.Local {
-			n := reflect.New(f.Type)
-			err := d.DecodeElement(n.Interface(), &start)
-			if err != nil {
-				return err
-			}
-			v.Field(i).Set(n.Elem())
-			return nil
+//UnmarshalXML decodes a simplified grammar, keeping the foreign elements and attributes as annotations.
+func (this *Grammar) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	n, err := decodeRng(d, &start, "")
+	if err != nil {
+		return err
+	}
+	g, err := toGrammar(n)
+	if err != nil {
+		return err
+	}
+	*this = *g
+	this.XMLName = start.Name
+	return nil
+}
+
+//MarshalXML encodes the grammar, with its annotations, in the namespace of its XMLName.
+func (this *Grammar) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	w := &annotationWriter{e: e, defaultNs: this.XMLName.Space}
+	name := this.XMLName
+	if len(name.Local) == 0 {
+		name.Local = "grammar"
+	}
+	if err := w.start(name, nil, this.ForeignAttrs, this.Annotations); err != nil {
+		return err
+	}
+	if this.Start != nil {
+		if err := w.start(xml.Name{Local: "start"}, nil, nil, nil); err != nil {
+			return err
 		}
+		if err := this.Start.marshal(w); err != nil {
+			return err
+		}
+		if err := w.end("start"); err != nil {
+			return err
+		}
+	}
+	for i := range this.Define {
+		if err := this.Define[i].marshal(w); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(xml.EndElement{Name: name})
+}
+
+//marshal encodes the define with its annotations inside the element.
+func (this *Define) marshal(w *annotationWriter) error {
+	if err := w.start(xml.Name{Local: "define"}, []xml.Attr{attr("name", this.Name)}, this.ForeignAttrs, nil); err != nil {
+		return err
+	}
+	if err := this.Element.marshal(w, "element", this.ElementForeignAttrs, this.Annotations); err != nil {
+		return err
 	}
-	return fmt.Errorf("unknown pattern " + start.Name.Local)
+	return w.end("define")
+}
+
+func attr(name, value string) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: name}, Value: value}
 }
 
 func (this *NameOrPattern) marshalXML(e *xml.Encoder, start xml.StartElement) error {
-	v := reflect.ValueOf(this).Elem()
-	t := reflect.TypeOf(this).Elem()
-	numFields := v.NumField()
-	for i := 0; i < numFields; i++ {
-		if !v.Field(i).IsNil() {
-			newStart := xml.StartElement{
-				Name: xml.Name{
-					Local: t.Field(i).Tag.Get("xml"),
-				},
+	return this.marshal(&annotationWriter{e: e})
+}
+
+//marshal encodes the pattern or name class, with its annotations as its first children.
+func (this *NameOrPattern) marshal(w *annotationWriter) error {
+	element := func(name string, attrs []xml.Attr, children ...*NameOrPattern) error {
+		if err := w.start(xml.Name{Local: name}, attrs, this.ForeignAttrs, this.Annotations); err != nil {
+			return err
+		}
+		for _, c := range children {
+			if err := c.marshal(w); err != nil {
+				return err
 			}
-			return e.EncodeElement(v.Field(i).Interface(), newStart)
 		}
+		return w.end(name)
+	}
+	text := func(name string, attrs []xml.Attr, text string) error {
+		if err := w.start(xml.Name{Local: name}, attrs, this.ForeignAttrs, this.Annotations); err != nil {
+			return err
+		}
+		if err := w.e.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+		return w.end(name)
+	}
+	except := func(name string, attrs []xml.Attr, except *NameOrPattern) error {
+		if err := w.start(xml.Name{Local: name}, attrs, this.ForeignAttrs, this.Annotations); err != nil {
+			return err
+		}
+		if except != nil {
+			if err := w.except(except); err != nil {
+				return err
+			}
+		}
+		return w.end(name)
+	}
+	switch {
+	case this.NotAllowed != nil:
+		return element("notAllowed", nil)
+	case this.Empty != nil:
+		return element("empty", nil)
+	case this.Text != nil:
+		return element("text", nil)
+	case this.Data != nil:
+		if err := w.start(xml.Name{Local: "data"}, []xml.Attr{attr("type", this.Data.Type), attr("datatypeLibrary", this.Data.DatatypeLibrary)}, this.ForeignAttrs, this.Annotations); err != nil {
+			return err
+		}
+		for _, p := range this.Data.Param {
+			if err := w.e.EncodeElement(p, xml.StartElement{Name: xml.Name{Local: "param"}}); err != nil {
+				return err
+			}
+		}
+		if this.Data.Except != nil {
+			if err := w.except(this.Data.Except); err != nil {
+				return err
+			}
+		}
+		return w.end("data")
+	case this.Value != nil:
+		return text("value", []xml.Attr{attr("datatypeLibrary", this.Value.DatatypeLibrary), attr("type", this.Value.Type), attr("ns", this.Value.Ns)}, this.Value.Text)
+	case this.List != nil:
+		return element("list", nil, this.List.NameOrPattern)
+	case this.Attribute != nil:
+		return this.Attribute.marshal(w, "attribute", this.ForeignAttrs, this.Annotations)
+	case this.Ref != nil:
+		return element("ref", []xml.Attr{attr("name", this.Ref.Name)})
+	case this.OneOrMore != nil:
+		return element("oneOrMore", nil, this.OneOrMore.NameOrPattern)
+	case this.Choice != nil:
+		return this.Choice.marshal(w, "choice", this.ForeignAttrs, this.Annotations)
+	case this.Group != nil:
+		return this.Group.marshal(w, "group", this.ForeignAttrs, this.Annotations)
+	case this.Interleave != nil:
+		return this.Interleave.marshal(w, "interleave", this.ForeignAttrs, this.Annotations)
+	case this.AnyName != nil:
+		return except("anyName", nil, this.AnyName.Except)
+	case this.NsName != nil:
+		return except("nsName", []xml.Attr{attr("ns", this.NsName.Ns)}, this.NsName.Except)
+	case this.Name != nil:
+		return text("name", []xml.Attr{attr("ns", this.Name.Ns)}, this.Name.Text)
 	}
 	return fmt.Errorf("unset pattern")
 }
@@ -186,7 +309,7 @@ type Text struct {
 //Even though katydid could easily support more types,
 //only Type string and token are currently supported.
 //This also means that Param is not currently supported.
-//DatatypeLibrary is not supported.
+//DatatypeLibrary is not supported, except for the DTD compatibility datatypes ID, IDREF and IDREFS.
 type Data struct {
 	XMLName         xml.Name       `xml:"data"`
 	Type            string         `xml:"type,attr"`
@@ -248,69 +371,41 @@ type Pair struct {
 	Right *NameOrPattern
 }
 
-func skipToStart(d *xml.Decoder) (*xml.StartElement, error) {
-	for {
-		t, err := d.Token()
-		if err != nil {
-			return nil, err
-		}
-		s, ok := t.(xml.StartElement)
-		if ok {
-			return &s, nil
-		}
-	}
-}
-
+//UnmarshalXML decodes the two children of a RelaxNG element, ignoring its annotations.
 func (this *Pair) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	s, err := skipToStart(d)
+	n, err := decodeRng(d, &start, "")
 	if err != nil {
 		return err
 	}
-	this.Left = &NameOrPattern{}
-	if err := this.Left.unmarshalXML(d, *s); err != nil {
-		return err
-	}
-	s, err = skipToStart(d)
+	p, err := toPair(n)
 	if err != nil {
 		return err
 	}
-	this.Right = &NameOrPattern{}
-	if err := this.Right.unmarshalXML(d, *s); err != nil {
-		return err
-	}
-	for {
-		t, err := d.Token()
-		if err != nil {
-			return err
-		}
-
-		e, ok := t.(xml.EndElement)
-		if ok && e.Name.Local == start.Name.Local {
-			break
-		}
-	}
+	*this = *p
 	return nil
 }
 
 func (this *Pair) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
-	if err := e.EncodeToken(start); err != nil {
-		return err
-	}
-	if err := this.Left.marshalXML(e, start); err != nil {
+	return this.marshal(&annotationWriter{e: e}, start.Name.Local, nil, nil)
+}
+
+//marshal encodes the pair as the children of the named element with the foreign attributes and annotations.
+func (this *Pair) marshal(w *annotationWriter, name string, foreignAttrs []xml.Attr, annotations []Annotation) error {
+	if err := w.start(xml.Name{Local: name}, nil, foreignAttrs, annotations); err != nil {
 		return err
 	}
-	if err := this.Right.marshalXML(e, start); err != nil {
+	if err := this.Left.marshal(w); err != nil {
 		return err
 	}
-	if err := e.EncodeToken(start.End()); err != nil {
+	if err := this.Right.marshal(w); err != nil {
 		return err
 	}
-	return nil
+	return w.end(name)
 }
 
 //The param RelaxNG grammar element.
 type Param struct {
-	Name string `xml:",attr"`
+	Name string `xml:"name,attr"`
 	Text string `xml:",chardata"`
 }
 
@@ -0,0 +1,485 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//InferOptions are the options for Infer.
+type InferOptions struct {
+	//Datatypes infers xsd datatypes, like integer, decimal, boolean, date and dateTime, for text and attribute values.
+	//Translate does not support datatype libraries, so these grammars can only be used with Diagnose.
+	Datatypes bool
+	//MaxEnumeration is the maximum number of distinct values, for text or an attribute,
+	//that is inferred as an enumeration. The default is 8 and a negative value disables enumerations.
+	MaxEnumeration int
+	//MinEnumerationSamples is the minimum number of times, that each distinct value should occur on average,
+	//for the values to be inferred as an enumeration. The default is 2.
+	MinEnumerationSamples int
+}
+
+func (this InferOptions) withDefaults() InferOptions {
+	if this.MaxEnumeration == 0 {
+		this.MaxEnumeration = 8
+	}
+	if this.MinEnumerationSamples == 0 {
+		this.MinEnumerationSamples = 2
+	}
+	return this
+}
+
+//Infer returns a simplified Grammar that all the documents are valid against.
+//Every element name gets a single define, of which the content model is inferred from all its occurrences.
+//Children that always appear in the same relative order become a group, otherwise an interleave,
+//with each child being optional, repeated or both, depending on how often it appeared.
+//Attributes that do not appear on every occurrence are optional,
+//elements with text between their children have mixed content
+//and a small number of distinct values, that each occur multiple times, become an enumeration.
+//An error is returned if a document is not well formed.
+func Infer(docs [][]byte, opts InferOptions) (*Grammar, error) {
+	opts = opts.withDefaults()
+	inf := &inferrer{opts: opts, elements: make(map[xml.Name]*inferElement)}
+	var roots []xml.Name
+	for i, doc := range docs {
+		root, err := inf.parse(doc)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i+1, err)
+		}
+		roots = appendName(roots, root)
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("no documents to infer a grammar from")
+	}
+	g := inf.grammar(roots)
+	for i, doc := range docs {
+		findings, err := Diagnose(g, doc)
+		if err != nil {
+			return nil, err
+		}
+		if len(findings) > 0 {
+			return nil, fmt.Errorf("document %d is not valid against the inferred grammar: %v", i+1, findings[0])
+		}
+	}
+	return g, nil
+}
+
+type inferrer struct {
+	opts     InferOptions
+	elements map[xml.Name]*inferElement
+	//order holds the element names in the order in which they were first seen.
+	order []xml.Name
+}
+
+//inferElement holds what was seen in all the occurrences of elements with the same name.
+type inferElement struct {
+	occurrences int
+	attrs       map[xml.Name]*inferValues
+	attrOrder   []xml.Name
+	//children holds, for every occurrence, the names of the child elements.
+	children [][]xml.Name
+	//mixed is true if text was seen next to child elements.
+	mixed bool
+	text  *inferValues
+}
+
+//inferValues holds the values of text or an attribute.
+type inferValues struct {
+	count  int
+	values map[string]int
+	order  []string
+}
+
+func newInferValues() *inferValues {
+	return &inferValues{values: make(map[string]int)}
+}
+
+func (this *inferValues) add(value string) {
+	this.count++
+	if this.values[value] == 0 {
+		this.order = append(this.order, value)
+	}
+	this.values[value]++
+}
+
+func appendName(names []xml.Name, name xml.Name) []xml.Name {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+func (this *inferrer) element(name xml.Name) *inferElement {
+	e, ok := this.elements[name]
+	if !ok {
+		e = &inferElement{attrs: make(map[xml.Name]*inferValues), text: newInferValues()}
+		this.elements[name] = e
+		this.order = append(this.order, name)
+	}
+	return e
+}
+
+//inferOpen is an element that is being parsed.
+type inferOpen struct {
+	element  *inferElement
+	children []xml.Name
+	text     *bytes.Buffer
+	hasText  bool
+}
+
+//parse records the elements of the document and returns the name of the root.
+func (this *inferrer) parse(doc []byte) (xml.Name, error) {
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	var root xml.Name
+	var stack []*inferOpen
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return root, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 {
+				root = tok.Name
+			} else {
+				top := stack[len(stack)-1]
+				top.children = append(top.children, tok.Name)
+				if top.hasText {
+					top.element.mixed = true
+				}
+			}
+			e := this.element(tok.Name)
+			e.occurrences++
+			for _, attr := range tok.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					continue
+				}
+				values, ok := e.attrs[attr.Name]
+				if !ok {
+					values = newInferValues()
+					e.attrs[attr.Name] = values
+					e.attrOrder = append(e.attrOrder, attr.Name)
+				}
+				values.add(attr.Value)
+			}
+			stack = append(stack, &inferOpen{element: e, text: bytes.NewBuffer(nil)})
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			top := stack[len(stack)-1]
+			top.text.Write(tok)
+			if len(strings.TrimSpace(string(tok))) > 0 {
+				top.hasText = true
+				if len(top.children) > 0 {
+					top.element.mixed = true
+				}
+			}
+		case xml.EndElement:
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			top.element.children = append(top.element.children, top.children)
+			if len(top.children) == 0 {
+				top.element.text.add(top.text.String())
+			}
+		}
+	}
+	if len(root.Local) == 0 {
+		return root, errors.New("document has no root element")
+	}
+	return root, nil
+}
+
+func (this *inferrer) grammar(roots []xml.Name) *Grammar {
+	names := make(map[xml.Name]string)
+	used := make(map[string]bool)
+	g := &Grammar{}
+	for _, name := range this.order {
+		unique := name.Local
+		for i := 1; used[unique]; i++ {
+			unique = name.Local + "_" + strconv.Itoa(i)
+		}
+		used[unique] = true
+		names[name] = unique
+	}
+	for _, name := range this.order {
+		e := this.elements[name]
+		g.Define = append(g.Define, Define{
+			Name:    names[name],
+			Element: Pair{Left: newNamePattern(name), Right: this.content(e, names)},
+		})
+	}
+	g.Start = newRefPattern(names[roots[0]])
+	for _, root := range roots[1:] {
+		g.Start = &NameOrPattern{Choice: newPair(g.Start, newRefPattern(names[root]))}
+	}
+	return g
+}
+
+//content returns the pattern of the attributes and content of the element.
+func (this *inferrer) content(e *inferElement, names map[xml.Name]string) *NameOrPattern {
+	var ps []*NameOrPattern
+	for _, name := range e.attrOrder {
+		values := e.attrs[name]
+		p := &NameOrPattern{Attribute: newPair(newNamePattern(name), this.values(values, true))}
+		if values.count < e.occurrences {
+			p = newOptionalPattern(p)
+		}
+		ps = append(ps, p)
+	}
+	hasChildren := false
+	for _, children := range e.children {
+		if len(children) > 0 {
+			hasChildren = true
+		}
+	}
+	//hasText is true if an occurrence without children had text.
+	hasText := false
+	for _, v := range e.text.order {
+		if len(strings.TrimSpace(v)) > 0 {
+			hasText = true
+		}
+	}
+	switch {
+	case !hasChildren:
+		ps = append(ps, this.values(e.text, false))
+	case e.mixed || hasText:
+		ps = append(ps, this.mixed(e, names))
+	default:
+		ps = append(ps, this.children(e, names))
+	}
+	return newGroupPattern(ps)
+}
+
+//mixed returns text interleaved with any number of the child elements.
+func (this *inferrer) mixed(e *inferElement, names map[xml.Name]string) *NameOrPattern {
+	var children []xml.Name
+	for _, occurrence := range e.children {
+		for _, child := range occurrence {
+			children = appendName(children, child)
+		}
+	}
+	choice := newRefPattern(names[children[0]])
+	for _, child := range children[1:] {
+		choice = &NameOrPattern{Choice: newPair(choice, newRefPattern(names[child]))}
+	}
+	return &NameOrPattern{Interleave: newPair(&NameOrPattern{Text: &Text{}}, newOptionalPattern(newOneOrMorePattern(choice)))}
+}
+
+//children returns the child elements in a group, if they are always in the same relative order, otherwise in an interleave.
+func (this *inferrer) children(e *inferElement, names map[xml.Name]string) *NameOrPattern {
+	//min and max are the minimum and maximum number of times that a child occurs in an occurrence.
+	min, max := make(map[xml.Name]int), make(map[xml.Name]int)
+	var all []xml.Name
+	inOrder := true
+	//before holds the children that have to appear before a child.
+	before := make(map[xml.Name][]xml.Name)
+	for _, occurrence := range e.children {
+		counts := make(map[xml.Name]int)
+		var runs []xml.Name
+		for i, child := range occurrence {
+			all = appendName(all, child)
+			counts[child]++
+			if i > 0 && occurrence[i-1] == child {
+				continue
+			}
+			for _, run := range runs {
+				if run == child {
+					//The child appears in two places, separated by another child.
+					inOrder = false
+				}
+				before[child] = appendName(before[child], run)
+			}
+			runs = append(runs, child)
+		}
+		for child, count := range counts {
+			if count > max[child] {
+				max[child] = count
+			}
+		}
+	}
+	for _, child := range all {
+		min[child] = -1
+	}
+	for _, occurrence := range e.children {
+		counts := make(map[xml.Name]int)
+		for _, child := range occurrence {
+			counts[child]++
+		}
+		for _, child := range all {
+			if min[child] == -1 || counts[child] < min[child] {
+				min[child] = counts[child]
+			}
+		}
+	}
+	order, ok := topologicalOrder(all, before)
+	if !ok {
+		inOrder = false
+		order = all
+	}
+	var ps []*NameOrPattern
+	for _, child := range order {
+		p := newRefPattern(names[child])
+		if max[child] > 1 {
+			p = newOneOrMorePattern(p)
+		}
+		if min[child] == 0 {
+			p = newOptionalPattern(p)
+		}
+		ps = append(ps, p)
+	}
+	if inOrder {
+		return newGroupPattern(ps)
+	}
+	p := ps[len(ps)-1]
+	for i := len(ps) - 2; i >= 0; i-- {
+		p = &NameOrPattern{Interleave: newPair(ps[i], p)}
+	}
+	return p
+}
+
+//topologicalOrder orders the names, so that every name comes after the names that have to appear before it,
+//preferring the order in which the names were first seen.
+//It returns false if there is no such order.
+func topologicalOrder(names []xml.Name, before map[xml.Name][]xml.Name) ([]xml.Name, bool) {
+	var order []xml.Name
+	done := make(map[xml.Name]bool)
+	for len(order) < len(names) {
+		found := false
+		for _, name := range names {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for _, b := range before[name] {
+				if !done[b] {
+					ready = false
+				}
+			}
+			if ready {
+				order = append(order, name)
+				done[name] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return order, true
+}
+
+var (
+	integerRegexp = regexp.MustCompile(`^[+-]?[0-9]+$`)
+	decimalRegexp = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+	dateRegexp    = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}(Z|[+-][0-9]{2}:[0-9]{2})?$`)
+)
+
+func isDateTime(s string) bool {
+	if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return true
+	}
+	_, err := time.Parse("2006-01-02T15:04:05.999999999", s)
+	return err == nil
+}
+
+//inferDatatypes are the datatypes that are tried in order, with a function that checks whether a value is valid.
+var inferDatatypes = []struct {
+	typ   string
+	valid func(string) bool
+}{
+	{"boolean", func(s string) bool { return s == "true" || s == "false" }},
+	{"integer", integerRegexp.MatchString},
+	{"decimal", decimalRegexp.MatchString},
+	{"date", func(s string) bool {
+		if !dateRegexp.MatchString(s) {
+			return false
+		}
+		_, err := time.Parse("2006-01-02", s[:10])
+		return err == nil
+	}},
+	{"dateTime", isDateTime},
+}
+
+//values returns the pattern for the values of text or an attribute.
+func (this *inferrer) values(values *inferValues, attr bool) *NameOrPattern {
+	var nonEmpty []string
+	hasEmpty := false
+	for _, v := range values.order {
+		if len(strings.TrimSpace(v)) == 0 {
+			hasEmpty = true
+		} else {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		if attr {
+			return &NameOrPattern{Text: &Text{}}
+		}
+		return &NameOrPattern{Empty: &Empty{}}
+	}
+	var p *NameOrPattern
+	if this.opts.Datatypes {
+		for _, datatype := range inferDatatypes {
+			valid := true
+			for _, v := range nonEmpty {
+				if !datatype.valid(strings.TrimSpace(v)) {
+					valid = false
+					break
+				}
+			}
+			if valid {
+				p = newXsdDataPattern(datatype.typ)
+				break
+			}
+		}
+	}
+	if p == nil && len(values.order) <= this.opts.MaxEnumeration && values.count >= this.opts.MinEnumerationSamples*len(values.order) {
+		for _, v := range values.order {
+			value := &NameOrPattern{Value: &Value{Type: "token", Text: normalizeWhitespace(v)}}
+			if p == nil {
+				p = value
+			} else {
+				p = &NameOrPattern{Choice: newPair(p, value)}
+			}
+		}
+		return p
+	}
+	if p == nil {
+		return &NameOrPattern{Text: &Text{}}
+	}
+	if hasEmpty {
+		empty := &NameOrPattern{Value: &Value{Type: "token"}}
+		if !attr {
+			empty = &NameOrPattern{Empty: &Empty{}}
+		}
+		p = &NameOrPattern{Choice: newPair(empty, p)}
+	}
+	return p
+}
@@ -0,0 +1,96 @@
+package relaxng
+
+import (
+	"strings"
+	"testing"
+)
+
+//TestInferSuite checks that the grammar, inferred from the valid documents of each test, validates them.
+func TestInferSuite(t *testing.T) {
+	inferred := 0
+	for _, spec := range scanFiles() {
+		var docs [][]byte
+		for _, x := range spec.Xmls {
+			if !x.expectError() {
+				docs = append(docs, x.Content)
+			}
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		num := testNumber(spec.Filename)
+		for _, opts := range []InferOptions{{}, {Datatypes: true, MinEnumerationSamples: 1}} {
+			g, err := Infer(docs, opts)
+			if err != nil {
+				t.Fatalf("%s: %v", num, err)
+			}
+			inferred++
+			//Translate does not support datatype libraries or namespaces.
+			if opts.Datatypes || namespaces[num] || strings.HasPrefix(g.Compact(), "namespace") {
+				continue
+			}
+			katydid, err := Translate(g)
+			if err != nil {
+				t.Fatalf("%s: %v", num, err)
+			}
+			for _, doc := range docs {
+				if err := Validate(katydid, doc); err != nil {
+					if anyNameAttributes[num] {
+						continue
+					}
+					t.Errorf("%s: %s is not valid against the inferred grammar %s: %v", num, doc, g.Compact(), err)
+				}
+			}
+		}
+	}
+	t.Logf("inferred %d grammars", inferred)
+}
+
+func TestInfer(t *testing.T) {
+	docs := []string{
+		`<order id="1" status="open"><customer>bob</customer><item price="1.5">a</item><item price="2">b</item><paid>true</paid></order>`,
+		`<order id="2" status="closed" rush="yes"><customer>alice</customer><item price="3">c</item><note>fast <b>please</b></note><paid>false</paid></order>`,
+		`<order id="3" status="open"><customer>eve</customer><paid>2018-01-01</paid><item price="1">d</item></order>`,
+		`<order id="4" status="closed"><customer>mallory</customer><item price="1">d</item></order>`,
+	}
+	var input [][]byte
+	for _, doc := range docs {
+		input = append(input, []byte(doc))
+	}
+	g, err := Infer(input, InferOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `start = order
+order = element order { attribute id { text }, attribute status { "open" | "closed" }, attribute rush { text }?, (customer & item+ & paid? & note?) }
+customer = element customer { text }
+item = element item { attribute price { text }, text }
+paid = element paid { text }
+note = element note { text & b* }
+b = element b { text }
+`
+	if got := g.Compact(); got != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, got)
+	}
+	g, err = Infer(input[:2], InferOptions{Datatypes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `start = order
+order = element order { attribute id { xsd:integer }, attribute status { text }, attribute rush { text }?, customer, item+, note?, paid }
+customer = element customer { text }
+item = element item { attribute price { xsd:decimal }, text }
+paid = element paid { xsd:boolean }
+note = element note { text & b* }
+b = element b { text }
+`
+	if got := g.Compact(); got != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, got)
+	}
+	if _, err := Infer([][]byte{[]byte(`<a>`)}, InferOptions{}); err == nil {
+		t.Fatal("expected an error for a document that is not well formed")
+	}
+	if _, err := Infer(nil, InferOptions{}); err == nil {
+		t.Fatal("expected an error without documents")
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/katydid/katydid/parser"
+	"github.com/katydid/katydid/relapse/ast"
+)
+
+//JSONOptions are the options of the mapping from json to xml.
+type JSONOptions struct {
+	//TranslateOptions are the options that the grammar was translated with.
+	TranslateOptions
+	//AttrKey is the prefix of the keys of attributes, where the default is @.
+	AttrKey string
+	//TextKey is the key of text, where the default is #text.
+	TextKey string
+}
+
+func (this JSONOptions) withDefaults() JSONOptions {
+	this.TranslateOptions = this.TranslateOptions.withDefaults()
+	if len(this.AttrKey) == 0 {
+		this.AttrKey = "@"
+	}
+	if len(this.TextKey) == 0 {
+		this.TextKey = "#text"
+	}
+	return this
+}
+
+//JSONParser is a katydid parser for json, that is mapped to xml.
+type JSONParser interface {
+	parser.Interface
+	Init([]byte) error
+}
+
+type jsonParser struct {
+	treeParser
+	opts JSONOptions
+}
+
+//NewJSONParser returns a parser that maps json to the same labels as the xml parser of the options,
+//so that a grammar, that is translated from RelaxNG, can validate json:
+//
+//	{"order": {"@id": "1", "item": [{"#text": "a"}, "b"], "note": null}}
+//
+//is validated as
+//
+//	<order id="1"><item>a</item><item>b</item><note/></order>
+//
+//The document is an object with a single key, which is the root element.
+//The value of an element is an object, a string, number or boolean, which is its text, or null, which is empty.
+//In an object the keys, that start with @, are attributes, #text is text and the other keys are elements,
+//where an array is an element for each of its items.
+//Attributes come before the content of their element, like in xml, and the rest keeps the order of the keys.
+//Numbers and booleans are text, as they are written, and an empty string is no text.
+func NewJSONParser(opts JSONOptions) JSONParser {
+	opts = opts.withDefaults()
+	return &jsonParser{treeParser: newTreeParser(opts.TranslateOptions), opts: opts}
+}
+
+func (this *jsonParser) Init(buf []byte) error {
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.UseNumber()
+	if err := expectDelim(d, '{'); err != nil {
+		return err
+	}
+	if !d.More() {
+		return errors.New("json document without a root element")
+	}
+	name, err := this.key(d)
+	if err != nil {
+		return err
+	}
+	if this.isAttr(name) || name == this.opts.TextKey {
+		return fmt.Errorf("json document with %q instead of a root element", name)
+	}
+	elems, err := this.elements(d, name)
+	if err != nil {
+		return err
+	}
+	if len(elems) != 1 || d.More() {
+		return errors.New("json document with more than one root element")
+	}
+	if err := expectDelim(d, '}'); err != nil {
+		return err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return errors.New("json document with data after the root element")
+	}
+	this.init(&labelNode{children: elems})
+	return nil
+}
+
+func expectDelim(d *json.Decoder, delim json.Delim) error {
+	t, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("expected %v in json, but got %v", delim, t)
+	}
+	return nil
+}
+
+func (this *jsonParser) key(d *json.Decoder) (string, error) {
+	t, err := d.Token()
+	if err != nil {
+		return "", err
+	}
+	return t.(string), nil
+}
+
+func (this *jsonParser) isAttr(key string) bool {
+	return strings.HasPrefix(key, this.opts.AttrKey)
+}
+
+//elements returns the elements of the value of the key, which is one element, unless the value is an array.
+func (this *jsonParser) elements(d *json.Decoder, name string) ([]*labelNode, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	if t != json.Delim('[') {
+		elem, err := this.element(d, name, t)
+		if err != nil {
+			return nil, err
+		}
+		return []*labelNode{elem}, nil
+	}
+	var elems []*labelNode
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if t == json.Delim('[') {
+			return nil, fmt.Errorf("json array in an array for %q", name)
+		}
+		elem, err := this.element(d, name, t)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, expectDelim(d, ']')
+}
+
+//element returns the element, where t is the first token of its value.
+func (this *jsonParser) element(d *json.Decoder, name string, t json.Token) (*labelNode, error) {
+	elem := this.newElement(localName(name), nil)
+	if t == nil {
+		return elem, nil
+	}
+	if t == json.Delim('{') {
+		return elem, this.content(d, elem)
+	}
+	s, ok := jsonText(t)
+	if !ok {
+		return nil, fmt.Errorf("json %v is not the value of element %q", t, name)
+	}
+	elem.children = this.addText(elem.children, []byte(s))
+	return elem, nil
+}
+
+//content adds the attributes, text and elements of the object to the element.
+func (this *jsonParser) content(d *json.Decoder, elem *labelNode) error {
+	var attrs, children []*labelNode
+	for d.More() {
+		key, err := this.key(d)
+		if err != nil {
+			return err
+		}
+		switch {
+		case key == this.opts.TextKey:
+			s, err := this.text(d, key)
+			if err != nil {
+				return err
+			}
+			children = this.addText(children, []byte(s))
+		case this.isAttr(key):
+			s, err := this.text(d, key)
+			if err != nil {
+				return err
+			}
+			attrs = append(attrs, this.newAttr(localName(strings.TrimPrefix(key, this.opts.AttrKey)), s))
+		default:
+			elems, err := this.elements(d, key)
+			if err != nil {
+				return err
+			}
+			children = append(children, elems...)
+		}
+	}
+	elem.children = append(attrs, children...)
+	return expectDelim(d, '}')
+}
+
+//text returns the value of the key, which has to be a string, number or boolean.
+func (this *jsonParser) text(d *json.Decoder, key string) (string, error) {
+	t, err := d.Token()
+	if err != nil {
+		return "", err
+	}
+	s, ok := jsonText(t)
+	if !ok {
+		return "", fmt.Errorf("json %v is not the text of %q", t, key)
+	}
+	return s, nil
+}
+
+func jsonText(t json.Token) (string, bool) {
+	switch t := t.(type) {
+	case string:
+		return t, true
+	case json.Number:
+		return string(t), true
+	case bool:
+		return strconv.FormatBool(t), true
+	}
+	return "", false
+}
+
+//localName returns the name without its namespace prefix, like the xml parser.
+func localName(name string) string {
+	if i := strings.Index(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+//ValidateJSON validates json, that is mapped to xml by NewJSONParser, against a Katydid Relapse Grammar.
+func ValidateJSON(katydid *ast.Grammar, jsonContent []byte, opts JSONOptions) error {
+	p := NewJSONParser(opts)
+	if err := p.Init(jsonContent); err != nil {
+		return err
+	}
+	return validateParser(katydid, p)
+}
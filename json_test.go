@@ -0,0 +1,73 @@
+package relaxng
+
+import (
+	"testing"
+)
+
+var jsonGrammar = `<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+	<attribute name="id"><data type="token"/></attribute>
+	<optional><attribute name="express"><choice><value>true</value><value>false</value></choice></attribute></optional>
+	<oneOrMore>
+		<element name="item">
+			<optional><attribute name="quantity"/></optional>
+			<text/>
+		</element>
+	</oneOrMore>
+	<optional><element name="note"><empty/></element></optional>
+</element>`
+
+func TestJSON(t *testing.T) {
+	g, err := Simplify([]byte(jsonGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		json string
+		xml  string
+	}{
+		{`{"order": {"@id": "1", "item": "a"}}`, `<order id="1"><item>a</item></order>`},
+		{`{"order": {"item": [{"#text": "a", "@quantity": 2}, "b"], "@id": 1, "note": null}}`, `<order id="1"><item quantity="2">a</item><item>b</item><note/></order>`},
+		{`{"order": {"@id": "1", "@express": true, "item": ""}}`, `<order id="1" express="true"><item></item></order>`},
+		{`{"order": {"@id": "1", "@express": "yes", "item": "a"}}`, `<order id="1" express="yes"><item>a</item></order>`},
+		{`{"order": {"@id": "1"}}`, `<order id="1"/>`},
+		{`{"order": {"@id": "1", "item": "a", "note": "n"}}`, `<order id="1"><item>a</item><note>n</note></order>`},
+		{`{"order": {"@id": "1", "note": null, "item": "a"}}`, `<order id="1"><note/><item>a</item></order>`},
+		{`{"order": {"@id": "1", "item": {"item": "a"}}}`, `<order id="1"><item><item>a</item></item></order>`},
+		{`{"order": {"@id": "1", "#text": "a", "item": "a"}}`, `<order id="1">a<item>a</item></order>`},
+	}
+	for _, opts := range []JSONOptions{{}, {TranslateOptions: TranslateOptions{TypedText: true, ElemPrefix: "e:"}}} {
+		katydid, err := TranslateWithOptions(g, opts.TranslateOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			want := ValidateWithOptions(katydid, []byte(test.xml), opts.TranslateOptions) == nil
+			if got := ValidateJSON(katydid, []byte(test.json), opts) == nil; got != want {
+				t.Fatalf("%s: expected valid %v, like %s", test.json, want, test.xml)
+			}
+		}
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateJSON(katydid, []byte(`{"order": {"_id": "1", "item": {"$": "a"}}}`), JSONOptions{AttrKey: "_", TextKey: "$"}); err != nil {
+		t.Fatalf("expected valid with other keys, but got %v", err)
+	}
+	invalid := []string{
+		`[]`,
+		`{}`,
+		`{"@id": "1"}`,
+		`{"order": [{"@id": "1"}, {"@id": "2"}]}`,
+		`{"order": {"@id": "1"}, "other": {}}`,
+		`{"order": {"@id": {"a": "1"}}}`,
+		`{"order": {"@id": "1", "item": [["a"]]}}`,
+		`{"order": {"@id": "1"}} {}`,
+		`{"order": {"@id": "1"`,
+	}
+	for _, json := range invalid {
+		if err := NewJSONParser(JSONOptions{}).Init([]byte(json)); err == nil {
+			t.Fatalf("%s: expected an error", json)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//MutationKind is the kind of change that a Mutation made to a document.
+type MutationKind string
+
+const (
+	DropAttribute        MutationKind = "drop-attribute"
+	AddAttribute         MutationKind = "add-attribute"
+	ChangeAttributeValue MutationKind = "change-attribute-value"
+	DropElement          MutationKind = "drop-element"
+	InsertElement        MutationKind = "insert-element"
+	DuplicateElement     MutationKind = "duplicate-element"
+	SwapElements         MutationKind = "swap-elements"
+	ChangeText           MutationKind = "change-text"
+	InsertText           MutationKind = "insert-text"
+)
+
+//Mutation is an invalid document that was made by making a single change to a valid document.
+type Mutation struct {
+	Kind MutationKind `json:"kind"`
+	//Path is the path of the element that was changed, for example /order[1]/item[2].
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	Document    []byte `json:"document"`
+	//Findings are the violations that Diagnose found in the Document.
+	Findings []*Finding `json:"findings"`
+}
+
+//Mutate returns near miss documents, that each differ from the valid document in a single labelled way,
+//for example a dropped attribute, an inserted element or elements that are swapped.
+//Only changes that result in documents, which violate the simplified Grammar, are returned.
+//An error is returned if the document is not well formed or not valid.
+func Mutate(g *Grammar, xmlContent []byte) ([]*Mutation, error) {
+	findings, err := Diagnose(g, xmlContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(findings) > 0 {
+		return nil, fmt.Errorf("document is not valid: %v", findings[0])
+	}
+	doc, err := parseMutElement(xmlContent)
+	if err != nil {
+		return nil, err
+	}
+	var mutations []*Mutation
+	seen := map[string]bool{string(doc.bytes()): true}
+	doc.walk(nil, "", func(index []int, path string, e *mutElement) {
+		for _, c := range e.candidates() {
+			clone := doc.clone()
+			desc := c.apply(clone.find(index))
+			mutated := clone.bytes()
+			if seen[string(mutated)] {
+				continue
+			}
+			seen[string(mutated)] = true
+			findings, err := Diagnose(g, mutated)
+			if err != nil || len(findings) == 0 {
+				continue
+			}
+			mutations = append(mutations, &Mutation{
+				Kind:        c.kind,
+				Path:        path,
+				Description: desc,
+				Document:    mutated,
+				Findings:    findings,
+			})
+		}
+	})
+	return mutations, nil
+}
+
+//mutElement is an element, with its raw names, of which the children are
+//*mutElement, xml.CharData, xml.Comment, xml.ProcInst or xml.Directive.
+//The document itself is an element without a name.
+type mutElement struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []interface{}
+}
+
+func parseMutElement(xmlContent []byte) (*mutElement, error) {
+	doc := &mutElement{}
+	stack := []*mutElement{doc}
+	d := xml.NewDecoder(bytes.NewReader(xmlContent))
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			e := &mutElement{name: tok.Name, attrs: tok.Attr}
+			top.children = append(top.children, e)
+			stack = append(stack, e)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, errors.New("unexpected end element")
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			top.children = append(top.children, xml.CopyToken(tok))
+		}
+	}
+	return doc, nil
+}
+
+func (this *mutElement) clone() *mutElement {
+	c := &mutElement{
+		name:     this.name,
+		attrs:    append([]xml.Attr(nil), this.attrs...),
+		children: make([]interface{}, len(this.children)),
+	}
+	for i, child := range this.children {
+		if e, ok := child.(*mutElement); ok {
+			c.children[i] = e.clone()
+		} else {
+			c.children[i] = child
+		}
+	}
+	return c
+}
+
+//walk calls f for every element with the indexes of the children that lead to it and its path.
+func (this *mutElement) walk(index []int, path string, f func([]int, string, *mutElement)) {
+	counts := make(map[string]int)
+	for i, child := range this.children {
+		e, ok := child.(*mutElement)
+		if !ok {
+			continue
+		}
+		counts[e.name.Local]++
+		childIndex := append(append([]int(nil), index...), i)
+		childPath := fmt.Sprintf("%s/%s[%d]", path, e.name.Local, counts[e.name.Local])
+		f(childIndex, childPath, e)
+		e.walk(childIndex, childPath, f)
+	}
+}
+
+func (this *mutElement) find(index []int) *mutElement {
+	e := this
+	for _, i := range index {
+		e = e.children[i].(*mutElement)
+	}
+	return e
+}
+
+func (this *mutElement) elements() []int {
+	var is []int
+	for i, child := range this.children {
+		if _, ok := child.(*mutElement); ok {
+			is = append(is, i)
+		}
+	}
+	return is
+}
+
+func (this *mutElement) text() string {
+	buf := bytes.NewBuffer(nil)
+	for _, child := range this.children {
+		if c, ok := child.(xml.CharData); ok {
+			buf.Write(c)
+		}
+	}
+	return buf.String()
+}
+
+func isNamespaceAttr(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns"
+}
+
+func qname(n xml.Name) string {
+	if len(n.Space) > 0 {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+type candidate struct {
+	kind  MutationKind
+	apply func(*mutElement) string
+}
+
+//candidates returns the changes that can be made to the element.
+func (this *mutElement) candidates() []candidate {
+	var cs []candidate
+	for i, a := range this.attrs {
+		if isNamespaceAttr(a) {
+			continue
+		}
+		i, name, value := i, qname(a.Name), a.Value
+		cs = append(cs, candidate{DropAttribute, func(e *mutElement) string {
+			e.attrs = append(e.attrs[:i], e.attrs[i+1:]...)
+			return fmt.Sprintf("dropped attribute %s", name)
+		}}, candidate{ChangeAttributeValue, func(e *mutElement) string {
+			e.attrs[i].Value = "invalid-" + value
+			return fmt.Sprintf("changed the value of attribute %s from %q to %q", name, value, e.attrs[i].Value)
+		}})
+	}
+	cs = append(cs, candidate{AddAttribute, func(e *mutElement) string {
+		e.attrs = append(e.attrs, xml.Attr{Name: xml.Name{Local: "unexpected"}, Value: "unexpected"})
+		return "added attribute unexpected"
+	}}, candidate{InsertElement, func(e *mutElement) string {
+		e.children = append([]interface{}{&mutElement{name: xml.Name{Local: "unexpected"}}}, e.children...)
+		return "inserted element unexpected"
+	}})
+	elements := this.elements()
+	for j, i := range elements {
+		i, name := i, qname(this.children[i].(*mutElement).name)
+		cs = append(cs, candidate{DropElement, func(e *mutElement) string {
+			e.children = append(e.children[:i], e.children[i+1:]...)
+			return fmt.Sprintf("dropped element %s", name)
+		}}, candidate{DuplicateElement, func(e *mutElement) string {
+			dup := e.children[i].(*mutElement).clone()
+			e.children = append(e.children[:i+1], append([]interface{}{dup}, e.children[i+1:]...)...)
+			return fmt.Sprintf("duplicated element %s", name)
+		}})
+		if j+1 == len(elements) {
+			continue
+		}
+		next := elements[j+1]
+		nextName := qname(this.children[next].(*mutElement).name)
+		if name == nextName {
+			continue
+		}
+		cs = append(cs, candidate{SwapElements, func(e *mutElement) string {
+			e.children[i], e.children[next] = e.children[next], e.children[i]
+			return fmt.Sprintf("swapped elements %s and %s", name, nextName)
+		}})
+	}
+	if text := strings.TrimSpace(this.text()); len(text) > 0 {
+		cs = append(cs, candidate{ChangeText, func(e *mutElement) string {
+			children := e.children[:0]
+			for _, child := range e.children {
+				if _, ok := child.(xml.CharData); !ok {
+					children = append(children, child)
+				}
+			}
+			e.children = append(children, xml.CharData("invalid-"+text))
+			return fmt.Sprintf("changed the text from %q to %q", text, "invalid-"+text)
+		}})
+	} else {
+		cs = append(cs, candidate{InsertText, func(e *mutElement) string {
+			e.children = append(e.children, xml.CharData("unexpected"))
+			return "inserted text unexpected"
+		}})
+	}
+	return cs
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func (this *mutElement) bytes() []byte {
+	buf := bytes.NewBuffer(nil)
+	this.write(buf)
+	return buf.Bytes()
+}
+
+func (this *mutElement) write(buf *bytes.Buffer) {
+	if len(this.name.Local) > 0 {
+		buf.WriteString("<" + qname(this.name))
+		for _, a := range this.attrs {
+			buf.WriteString(" " + qname(a.Name) + `="` + attrEscaper.Replace(a.Value) + `"`)
+		}
+		if len(this.children) == 0 {
+			buf.WriteString("/>")
+			return
+		}
+		buf.WriteString(">")
+	}
+	for _, child := range this.children {
+		switch c := child.(type) {
+		case *mutElement:
+			c.write(buf)
+		case xml.CharData:
+			buf.WriteString(textEscaper.Replace(string(c)))
+		case xml.Comment:
+			buf.WriteString("<!--" + string(c) + "-->")
+		case xml.ProcInst:
+			buf.WriteString("<?" + c.Target)
+			if len(c.Inst) > 0 {
+				buf.WriteString(" " + string(c.Inst))
+			}
+			buf.WriteString("?>")
+		case xml.Directive:
+			buf.WriteString("<!" + string(c) + ">")
+		}
+	}
+	if len(this.name.Local) > 0 {
+		buf.WriteString("</" + qname(this.name) + ">")
+	}
+}
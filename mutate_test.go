@@ -0,0 +1,101 @@
+package relaxng
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//Translate uses anyName for attributes with an anyName name class,
+//which also matches elements and text.
+var anyNameAttributes = map[string]bool{
+	"332": true,
+	"333": true,
+	"345": true,
+}
+
+//TestMutateSuite checks that every mutation of a valid document is rejected by Validate.
+func TestMutateSuite(t *testing.T) {
+	suite := scanFiles()
+	mutated := 0
+	for _, spec := range suite {
+		num := testNumber(spec.Filename)
+		if len(spec.SimpleFilename) == 0 || namespaces[num] || datatypeLibrary[num] {
+			continue
+		}
+		g, err := SimplifyFile(filepath.Join(filepath.Dir(spec.Filename), "c.rng"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		katydid, err := Translate(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, x := range spec.Xmls {
+			if x.expectError() {
+				continue
+			}
+			mutations, err := Mutate(g, x.Content)
+			if err != nil {
+				t.Fatalf("%s: %v", x.Filename, err)
+			}
+			for _, m := range mutations {
+				mutated++
+				if anyNameAttributes[num] && (m.Kind == InsertElement || m.Kind == InsertText) {
+					continue
+				}
+				if err := Validate(katydid, m.Document); err == nil {
+					t.Errorf("%s: expected %s to be rejected: %s", x.Filename, m.Description, m.Document)
+				}
+			}
+		}
+	}
+	t.Logf("mutated %d documents", mutated)
+}
+
+func TestMutate(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+		<attribute name="status">
+			<choice>
+				<value>open</value>
+				<value>closed</value>
+			</choice>
+		</attribute>
+		<element name="customer"><text/></element>
+		<oneOrMore>
+			<element name="item"><text/></element>
+		</oneOrMore>
+	</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := "<!-- order -->\n<order status=\"open\">\n  <customer>a &amp; b</customer>\n  <item>c</item>\n</order>"
+	mutations, err := Mutate(g, []byte(valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[MutationKind]bool)
+	for _, m := range mutations {
+		t.Logf("%s %s: %s", m.Kind, m.Path, m.Description)
+		kinds[m.Kind] = true
+		if len(m.Findings) == 0 {
+			t.Fatalf("expected findings for %s", m.Description)
+		}
+	}
+	for _, kind := range []MutationKind{DropAttribute, AddAttribute, ChangeAttributeValue, DropElement, InsertElement, DuplicateElement, SwapElements, InsertText} {
+		if !kinds[kind] {
+			t.Errorf("expected a %s mutation", kind)
+		}
+	}
+	if kinds[ChangeText] {
+		t.Errorf("did not expect text to be changed, since any text is valid")
+	}
+	for _, m := range mutations {
+		if m.Kind == SwapElements && !strings.Contains(string(m.Document), "<!-- order -->\n<order status=\"open\">\n  <item>c</item>\n  <customer>a &amp; b</customer>") {
+			t.Fatalf("unexpected swapped document %s", m.Document)
+		}
+	}
+	if _, err := Mutate(g, []byte(`<order/>`)); err == nil {
+		t.Fatalf("expected an error for an invalid document")
+	}
+}
@@ -1,6 +1,7 @@
 package relaxng
 
 import (
+	"encoding/xml"
 	"strings"
 	"testing"
 )
@@ -108,3 +109,72 @@ func TestSimpleParse94(t *testing.T) {
 		t.Fatalf("expected attribute")
 	}
 }
+
+func TestParseAnnotations(t *testing.T) {
+	annotated := `<grammar xmlns="http://relaxng.org/ns/structure/1.0"
+	xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0"
+	xmlns:sch="http://purl.oclc.org/dsdl/schematron" xmlns:t="urn:tool" t:version="2">
+  <a:documentation>Cards</a:documentation>
+  <sch:pattern>
+    <sch:rule context="card"><sch:assert test="@kind">a card has a kind</sch:assert></sch:rule>
+  </sch:pattern>
+  <start>
+    <ref name="card"/>
+  </start>
+  <define name="card" t:hint="table">
+    <element t:hint="row">
+      <a:documentation>A <b xmlns="">business</b> card.</a:documentation>
+      <name ns="">card</name>
+      <attribute a:defaultValue="home">
+        <name ns="">kind</name>
+        <data type="token" datatypeLibrary=""><t:widget/></data>
+      </attribute>
+    </element>
+  </define>
+</grammar>`
+	g, err := ParseGrammar([]byte(annotated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Annotations) != 2 || g.Annotations[1].Name.Local != "pattern" || len(g.ForeignAttrs) != 1 {
+		t.Fatalf("expected the grammar annotations, but got %v and %v", g.Annotations, g.ForeignAttrs)
+	}
+	d := g.Define[0]
+	if docs := Documentation(d.Annotations); len(docs) != 1 || docs[0] != "A business card." {
+		t.Fatalf("expected the documentation of the define, but got %v", docs)
+	}
+	if len(d.ForeignAttrs) != 1 || d.ForeignAttrs[0].Value != "table" {
+		t.Fatalf("expected the foreign attribute of the define, but got %v", d.ForeignAttrs)
+	}
+	if len(d.ElementForeignAttrs) != 1 || d.ElementForeignAttrs[0].Value != "row" {
+		t.Fatalf("expected the foreign attribute of the element, but got %v", d.ElementForeignAttrs)
+	}
+	attr := d.Element.Right
+	if len(attr.ForeignAttrs) != 1 || attr.ForeignAttrs[0].Name.Local != "defaultValue" || attr.ForeignAttrs[0].Value != "home" {
+		t.Fatalf("expected a:defaultValue on the attribute, but got %v", attr.ForeignAttrs)
+	}
+	if widget := attr.Attribute.Right.Annotations; len(widget) != 1 || widget[0].Name != (xml.Name{Space: "urn:tool", Local: "widget"}) {
+		t.Fatalf("expected an annotation on the data, but got %v", widget)
+	}
+	s := g.String()
+	for _, want := range []string{
+		`<grammar xmlns="http://relaxng.org/ns/structure/1.0" ns1:version="2" xmlns:ns1="urn:tool">`,
+		`<a:documentation xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0">Cards</a:documentation>`,
+		`<sch:assert test="@kind">a card has a kind</sch:assert>`,
+		`<define name="card" ns1:hint="table" xmlns:ns1="urn:tool">`,
+		`<element ns1:hint="row" xmlns:ns1="urn:tool">`,
+		`<b xmlns="">business</b>`,
+		`<attribute a:defaultValue="home" xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0">`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("expected %s in\n%s", want, s)
+		}
+	}
+	reparsed, err := ParseGrammar([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparsed.String() != s {
+		t.Fatalf("expected the grammar to be written the same after parsing it again, but got\n%s\ninstead of\n%s", reparsed.String(), s)
+	}
+}
@@ -16,16 +16,89 @@
 package relaxng
 
 import (
-	"errors"
+	"fmt"
 	"github.com/katydid/katydid/parser/xml"
 	"github.com/katydid/katydid/relapse/ast"
-	"github.com/katydid/katydid/relapse/interp"
 	"reflect"
 )
 
 //Translates a parsed RelaxNG Grammar into a Katydid Relapse Grammar.
 func Translate(g *Grammar) (*ast.Grammar, error) {
-	return translate(g)
+	return translate(g, TranslateOptions{})
+}
+
+const (
+	defaultElemPrefix = "elem_"
+	defaultAttrPrefix = "attr_"
+	defaultTextPrefix = "text_"
+)
+
+//TranslateOptions are the options for TranslateWithOptions and NewXMLParserWithOptions,
+//which have to be the same, for the parser to match the labels in the translated grammar.
+type TranslateOptions struct {
+	//ElemPrefix, AttrPrefix and TextPrefix are the prefixes that the xml parser adds to
+	//the names of elements, the names of attributes and text values.
+	//The defaults are elem_, attr_ and text_.
+	ElemPrefix string
+	AttrPrefix string
+	TextPrefix string
+	//TypedText makes the xml parser return text as a []byte without a prefix, instead of as a prefixed string,
+	//which the translated grammar validates with the $[]byte versions of its functions.
+	//Text then never matches the name of an element or an attribute and is validated without an allocation.
+	//The TextPrefix is not used.
+	TypedText bool
+	//NoWhitespace does not allow whitespace text around elements and inside empty elements.
+	NoWhitespace bool
+	//NoXmlns does not allow xmlns attributes on elements with any name, which otherwise allow any xmlns attribute.
+	NoXmlns bool
+	//WhitespaceDefine and TextDefine are the names of the patterns, that the translated grammar uses for
+	//whitespace and any text, where the defaults are ws and text.
+	//A name is extended, if a define in the grammar already has it.
+	WhitespaceDefine string
+	TextDefine       string
+}
+
+func (this TranslateOptions) withDefaults() TranslateOptions {
+	if len(this.ElemPrefix) == 0 {
+		this.ElemPrefix = defaultElemPrefix
+	}
+	if len(this.AttrPrefix) == 0 {
+		this.AttrPrefix = defaultAttrPrefix
+	}
+	if len(this.TextPrefix) == 0 {
+		this.TextPrefix = defaultTextPrefix
+	}
+	if len(this.WhitespaceDefine) == 0 {
+		this.WhitespaceDefine = "ws"
+	}
+	if len(this.TextDefine) == 0 {
+		this.TextDefine = "text"
+	}
+	return this
+}
+
+//TranslateWithOptions translates a parsed RelaxNG Grammar into a Katydid Relapse Grammar,
+//with the label prefixes, whitespace handling and reserved names of the options.
+func TranslateWithOptions(g *Grammar, opts TranslateOptions) (*ast.Grammar, error) {
+	return translate(g, opts)
+}
+
+//Returns a copy of the simplified Grammar with a start pattern that references the named define,
+//so that fragments of documents can be validated against the define.
+//The defines are shared with the original Grammar.
+func Fragment(g *Grammar, define string) (*Grammar, error) {
+	for _, d := range g.Define {
+		if d.Name == define {
+			return &Grammar{
+				XMLName:      g.XMLName,
+				Start:        &NameOrPattern{Ref: &Ref{Name: define}},
+				Define:       g.Define,
+				Annotations:  g.Annotations,
+				ForeignAttrs: g.ForeignAttrs,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("grammar has no define named %s", define)
 }
 
 //The function removes the ns attributes with value TODO.
@@ -37,21 +110,28 @@ func RemoveTODOs(g *Grammar) {
 }
 
 func NewXMLParser() xml.XMLParser {
-	return xml.NewXMLParser(xml.WithAttrPrefix("attr_"), xml.WithElemPrefix("elem_"), xml.WithTextPrefix("text_"))
+	return NewXMLParserWithOptions(TranslateOptions{})
+}
+
+//NewXMLParserWithOptions returns an xml parser with the label prefixes of the options.
+func NewXMLParserWithOptions(opts TranslateOptions) xml.XMLParser {
+	opts = opts.withDefaults()
+	if opts.TypedText {
+		return newTypedParser(opts)
+	}
+	return xml.NewXMLParser(xml.WithAttrPrefix(opts.AttrPrefix), xml.WithElemPrefix(opts.ElemPrefix), xml.WithTextPrefix(opts.TextPrefix))
 }
 
 //Validates input xml against a Katydid Relapse Grammar.
 func Validate(katydid *ast.Grammar, xmlContent []byte) error {
-	p := NewXMLParser()
+	return ValidateWithOptions(katydid, xmlContent, TranslateOptions{})
+}
+
+//ValidateWithOptions validates the xml with a Relapse Grammar, that was translated with the same options.
+func ValidateWithOptions(katydid *ast.Grammar, xmlContent []byte, opts TranslateOptions) error {
+	p := NewXMLParserWithOptions(opts)
 	if err := p.Init(xmlContent); err != nil {
 		return err
 	}
-	valid, err := interp.Interpret(katydid, p)
-	if err != nil {
-		return err
-	}
-	if !valid {
-		return errors.New("not valid")
-	}
-	return nil
+	return validateParser(katydid, p)
 }
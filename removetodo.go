@@ -13,7 +13,7 @@ func removeTODOs(v reflect.Value) {
 				removeTODOs(f.Elem())
 			}
 		}
-		if f.Type().Kind() == reflect.Slice {
+		if f.Type().Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct {
 			for i := 0; i < f.Len(); i++ {
 				removeTODOs(f.Index(i))
 			}
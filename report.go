@@ -0,0 +1,312 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/katydid/katydid/relapse/ast"
+)
+
+//wellFormedRule is the rule of findings for xml that is not well formed.
+const wellFormedRule = "well-formed"
+
+//validatorErrorRule is the rule of findings for errors of the validator itself,
+//such as a Schematron rule that could not be evaluated, where the document might still be well formed.
+const validatorErrorRule = "validator-error"
+
+//Report is the result of validating a single xml document.
+type Report struct {
+	File     string     `json:"file"`
+	Valid    bool       `json:"valid"`
+	Findings []*Finding `json:"findings,omitempty"`
+}
+
+//ValidateReport validates input xml against a Katydid Relapse Grammar
+//and, if it is not valid, uses the RelaxNG Grammar, from which it was translated,
+//to find where and which pattern failed.
+//The Schematron rules that are embedded in the RelaxNG Grammar are checked as well.
+func ValidateReport(g *Grammar, katydid *ast.Grammar, filename string, xmlContent []byte) *Report {
+	return newReporter(g, katydid).report(filename, xmlContent)
+}
+
+//reporter reports on documents against a grammar, where the embedded Schematron rules are read only once.
+type reporter struct {
+	g             *Grammar
+	katydid       *ast.Grammar
+	schematron    *Schematron
+	schematronErr error
+}
+
+func newReporter(g *Grammar, katydid *ast.Grammar) *reporter {
+	schematron, err := NewSchematron(g)
+	return &reporter{g: g, katydid: katydid, schematron: schematron, schematronErr: err}
+}
+
+func (this *reporter) report(filename string, xmlContent []byte) *Report {
+	r := &Report{File: filename}
+	verr := Validate(this.katydid, xmlContent)
+	if verr == nil && !this.hasSchematron() {
+		r.Valid = true
+		return r
+	}
+	findings, err := diagnose(this.g, this.schematron, this.schematronErr, xmlContent)
+	if err != nil {
+		f := &Finding{Line: 1, Column: 1, Path: "/", Rule: validatorErrorRule, Message: err.Error()}
+		if serr, ok := err.(*xml.SyntaxError); ok {
+			f.Rule = wellFormedRule
+			f.Line = serr.Line
+		}
+		r.Findings = []*Finding{f}
+		return r
+	}
+	if verr == nil {
+		//Schematron rules are not translated, so only their findings remain.
+		r.Findings = schematronFindings(findings)
+		r.Valid = len(r.Findings) == 0
+		return r
+	} else if len(findings) == 0 {
+		//The translation and the grammar disagree, so the position is unknown.
+		findings = []*Finding{{Line: 1, Column: 1, Path: "/", Rule: "start", Message: verr.Error()}}
+	}
+	r.Findings = findings
+	return r
+}
+
+//hasSchematron returns whether the grammar has embedded Schematron rules, or rules that are not supported,
+//which Diagnose reports as an error.
+func (this *reporter) hasSchematron() bool {
+	return this.schematronErr != nil || len(this.schematron.lets) > 0 || len(this.schematron.patterns) > 0
+}
+
+func schematronFindings(findings []*Finding) []*Finding {
+	var fs []*Finding
+	for _, f := range findings {
+		if f.Rule == schematronRule {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+//WriteJSONLines writes each report as a json object on its own line.
+func WriteJSONLines(w io.Writer, reports []*Report) error {
+	enc := json.NewEncoder(w)
+	for _, r := range reports {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failures  []junitResult `xml:"failure,omitempty"`
+	Errors    []junitResult `xml:"error,omitempty"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+//WriteJUnit writes the reports as a JUnit XML test suite with the given name,
+//where every document is a test case and every finding a failure.
+//Documents that are not well formed, or that the validator failed on, are reported as errors.
+func WriteJUnit(w io.Writer, name string, reports []*Report) error {
+	suite := junitTestSuite{Name: name, Tests: len(reports)}
+	for _, r := range reports {
+		c := junitTestCase{Name: r.File, ClassName: name}
+		for _, f := range r.Findings {
+			text := fmt.Sprintf("%s:%d:%d: %s\npath: %s\n", r.File, f.Line, f.Column, f.Message, f.Path)
+			if len(f.Pattern) > 0 {
+				text += fmt.Sprintf("expected: %s\n", f.Pattern)
+			}
+			result := junitResult{Message: f.Message, Type: f.Rule, Text: text}
+			if f.Rule == wellFormedRule || f.Rule == validatorErrorRule {
+				c.Errors = append(c.Errors, result)
+			} else {
+				c.Failures = append(c.Failures, result)
+			}
+		}
+		if len(c.Errors) > 0 {
+			suite.Errors++
+		} else if len(c.Failures) > 0 {
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	RuleIndex  int               `json:"ruleIndex"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+//WriteSARIF writes the findings of the reports as a SARIF 2.1.0 log,
+//where the rules are the defines of the grammar, named schema, whose content was violated.
+func WriteSARIF(w io.Writer, schema string, reports []*Report) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "relaxng",
+			InformationURI: "https://github.com/katydid/relaxng",
+			Rules:          []sarifRule{},
+		}},
+		ColumnKind: "unicodeCodePoints",
+		Results:    []sarifResult{},
+	}
+	rules := make(map[string]int)
+	for _, r := range reports {
+		for _, f := range r.Findings {
+			index, ok := rules[f.Rule]
+			if !ok {
+				index = len(run.Tool.Driver.Rules)
+				rules[f.Rule] = index
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+					ID:               f.Rule,
+					ShortDescription: sarifMessage{Text: ruleDescription(schema, f.Rule)},
+				})
+			}
+			result := sarifResult{
+				RuleID:    f.Rule,
+				RuleIndex: index,
+				Level:     "error",
+				Message:   sarifMessage{Text: f.Message},
+				Locations: []sarifLocation{{
+					PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(r.File)},
+						Region:           sarifRegion{StartLine: f.Line, StartColumn: f.Column},
+					},
+					LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: f.Path, Kind: "element"}},
+				}},
+			}
+			if len(f.Pattern) > 0 {
+				result.Properties = map[string]string{"pattern": f.Pattern}
+			}
+			run.Results = append(run.Results, result)
+		}
+	}
+	enc := json.NewEncoder(w)
+	return enc.Encode(sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}})
+}
+
+func ruleDescription(schema string, rule string) string {
+	switch rule {
+	case wellFormedRule:
+		return "The document must be well formed xml."
+	case validatorErrorRule:
+		return fmt.Sprintf("The validator must be able to check the document against %s.", schema)
+	case "start":
+		return fmt.Sprintf("The document must match the start of %s.", schema)
+	case schematronRule:
+		return fmt.Sprintf("The document must satisfy the Schematron rules embedded in %s.", schema)
+	}
+	return fmt.Sprintf("The content of the element must match define %s of %s.", strings.Replace(rule, "|", " or ", -1), schema)
+}
@@ -0,0 +1,124 @@
+package relaxng
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func testReports(t *testing.T) []*Report {
+	g, err := Simplify([]byte(`<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+		<oneOrMore>
+			<element name="item"><text/></element>
+		</oneOrMore>
+	</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*Report{
+		ValidateReport(g, katydid, "valid.xml", []byte(`<order><item/></order>`)),
+		ValidateReport(g, katydid, "invalid.xml", []byte("<order>\n\t<note/>\n</order>")),
+		ValidateReport(g, katydid, "malformed.xml", []byte("<order>\n<item></order>")),
+	}
+}
+
+func TestValidateReport(t *testing.T) {
+	reports := testReports(t)
+	if !reports[0].Valid || len(reports[0].Findings) != 0 {
+		t.Fatalf("expected valid, got %#v", reports[0])
+	}
+	if reports[1].Valid || len(reports[1].Findings) != 2 {
+		t.Fatalf("expected two findings, got %v", reports[1].Findings)
+	}
+	if f := reports[1].Findings[0]; f.Line != 2 || f.Column != 2 || f.Rule != "order" || f.Pattern != "element item+" {
+		t.Fatalf("unexpected finding %#v", f)
+	}
+	if reports[2].Valid || reports[2].Findings[0].Rule != wellFormedRule || reports[2].Findings[0].Line != 2 {
+		t.Fatalf("expected a well-formedness finding, got %#v", reports[2].Findings[0])
+	}
+}
+
+func TestValidateReportValidatorError(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" xmlns="http://relaxng.org/ns/structure/1.0"
+		xmlns:sch="http://purl.oclc.org/dsdl/schematron">
+		<sch:pattern>
+			<sch:rule context="count(item)"><sch:assert test="true()"/></sch:rule>
+		</sch:pattern>
+		<zeroOrMore>
+			<element name="item"><text/></element>
+		</zeroOrMore>
+	</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := ValidateReport(g, katydid, "order.xml", []byte(`<order><item/></order>`))
+	if r.Valid || len(r.Findings) != 1 || r.Findings[0].Rule != validatorErrorRule {
+		t.Fatalf("expected a validator error, got %#v", r.Findings)
+	}
+}
+
+func TestWriteJSONLines(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := WriteJSONLines(buf, testReports(t)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a line per report, got %s", buf.String())
+	}
+	r := &Report{}
+	if err := json.Unmarshal([]byte(lines[1]), r); err != nil {
+		t.Fatal(err)
+	}
+	if r.File != "invalid.xml" || r.Findings[0].Line != 2 {
+		t.Fatalf("unexpected report %#v", r)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := WriteJUnit(buf, "order.rng", testReports(t)); err != nil {
+		t.Fatal(err)
+	}
+	suites := &junitTestSuites{}
+	if err := xml.Unmarshal(buf.Bytes(), suites); err != nil {
+		t.Fatal(err)
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 3 || suite.Failures != 1 || suite.Errors != 1 {
+		t.Fatalf("unexpected suite %s", buf.String())
+	}
+	if len(suite.Cases[1].Failures) != 2 || suite.Cases[1].Failures[0].Type != "order" {
+		t.Fatalf("unexpected failures %s", buf.String())
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := WriteSARIF(buf, "order.rng", testReports(t)); err != nil {
+		t.Fatal(err)
+	}
+	log := &sarifLog{}
+	if err := json.Unmarshal(buf.Bytes(), log); err != nil {
+		t.Fatal(err)
+	}
+	run := log.Runs[0]
+	if len(run.Results) != 3 || len(run.Tool.Driver.Rules) != 2 {
+		t.Fatalf("unexpected sarif %s", buf.String())
+	}
+	result := run.Results[0]
+	region := result.Locations[0].PhysicalLocation.Region
+	if result.RuleID != "order" || region.StartLine != 2 || region.StartColumn != 2 || result.Properties["pattern"] != "element item+" {
+		t.Fatalf("unexpected result %#v", result)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"fmt"
+	"strings"
+)
+
+//schematronNs is the namespace of ISO Schematron.
+const schematronNs = "http://purl.oclc.org/dsdl/schematron"
+
+//schematronRule is the rule of findings for failed Schematron asserts and successful reports.
+const schematronRule = "schematron"
+
+//Schematron is the ISO Schematron rules that are embedded in the annotations of a grammar.
+//Rules are written as sch:pattern elements, with sch:rule elements that contain sch:assert and sch:report elements.
+//Prefixes in XPath expressions are declared with sch:ns and variables with sch:let.
+//Abstract rules and patterns are not supported.
+type Schematron struct {
+	lets     []*schLet
+	patterns []*schPattern
+}
+
+type schPattern struct {
+	lets  []*schLet
+	rules []*schRule
+}
+
+type schRule struct {
+	context string
+	match   xpathExpr
+	lets    []*schLet
+	checks  []*schCheck
+}
+
+type schLet struct {
+	name  string
+	value xpathExpr
+}
+
+//schCheck is an assert, which fails if its test is false, or a report, which fails if its test is true.
+type schCheck struct {
+	report  bool
+	test    string
+	expr    xpathExpr
+	message []schMessagePart
+}
+
+//schMessagePart is text or, if expr is not nil, the value of an sch:value-of or sch:name.
+type schMessagePart struct {
+	text string
+	expr xpathExpr
+	name bool
+}
+
+//NewSchematron extracts the Schematron rules from the annotations of the grammar and compiles their XPath expressions.
+func NewSchematron(g *Grammar) (*Schematron, error) {
+	var annotations []*Annotation
+	seen := make(map[*Annotation]bool)
+	add := func(as []Annotation) {
+		for i := range as {
+			if a := &as[i]; !seen[a] {
+				seen[a] = true
+				annotations = append(annotations, a)
+			}
+		}
+	}
+	add(g.Annotations)
+	var walk func(p *NameOrPattern)
+	walkPair := func(p *Pair) {
+		if p != nil {
+			walk(p.Left)
+			walk(p.Right)
+		}
+	}
+	walk = func(p *NameOrPattern) {
+		if p == nil {
+			return
+		}
+		add(p.Annotations)
+		if p.Data != nil {
+			walk(p.Data.Except)
+		}
+		if p.List != nil {
+			walk(p.List.NameOrPattern)
+		}
+		if p.OneOrMore != nil {
+			walk(p.OneOrMore.NameOrPattern)
+		}
+		if p.AnyName != nil {
+			walk(p.AnyName.Except)
+		}
+		if p.NsName != nil {
+			walk(p.NsName.Except)
+		}
+		walkPair(p.Attribute)
+		walkPair(p.Choice)
+		walkPair(p.Group)
+		walkPair(p.Interleave)
+	}
+	walk(g.Start)
+	for i := range g.Define {
+		add(g.Define[i].Annotations)
+		walkPair(&g.Define[i].Element)
+	}
+
+	namespaces := make(map[string]string)
+	for _, a := range annotations {
+		if isSchematron(a, "ns") {
+			namespaces[attrValue(a, "prefix")] = attrValue(a, "uri")
+		}
+	}
+	s := &Schematron{}
+	for _, a := range annotations {
+		switch {
+		case isSchematron(a, "let"):
+			let, err := newSchLet(a, namespaces)
+			if err != nil {
+				return nil, err
+			}
+			s.lets = append(s.lets, let)
+		case isSchematron(a, "pattern"):
+			if attrValue(a, "abstract") == "true" || len(attrValue(a, "is-a")) > 0 {
+				return nil, fmt.Errorf("abstract Schematron patterns are not supported")
+			}
+			p := &schPattern{}
+			for i := range a.Children {
+				c := &a.Children[i]
+				switch {
+				case isSchematron(c, "let"):
+					let, err := newSchLet(c, namespaces)
+					if err != nil {
+						return nil, err
+					}
+					p.lets = append(p.lets, let)
+				case isSchematron(c, "rule"):
+					r, err := newSchRule(c, namespaces)
+					if err != nil {
+						return nil, err
+					}
+					p.rules = append(p.rules, r)
+				}
+			}
+			s.patterns = append(s.patterns, p)
+		case isSchematron(a, "rule"):
+			//A rule outside of a pattern is a pattern on its own.
+			r, err := newSchRule(a, namespaces)
+			if err != nil {
+				return nil, err
+			}
+			s.patterns = append(s.patterns, &schPattern{rules: []*schRule{r}})
+		}
+	}
+	return s, nil
+}
+
+func isSchematron(a *Annotation, local string) bool {
+	return a.Name.Space == schematronNs && a.Name.Local == local
+}
+
+func attrValue(a *Annotation, local string) string {
+	for _, attr := range a.Attrs {
+		if len(attr.Name.Space) == 0 && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func newSchLet(a *Annotation, namespaces map[string]string) (*schLet, error) {
+	value, err := compileXPath(attrValue(a, "value"), namespaces)
+	if err != nil {
+		return nil, err
+	}
+	return &schLet{name: attrValue(a, "name"), value: value}, nil
+}
+
+func newSchRule(a *Annotation, namespaces map[string]string) (*schRule, error) {
+	if attrValue(a, "abstract") == "true" {
+		return nil, fmt.Errorf("abstract Schematron rules are not supported")
+	}
+	context := attrValue(a, "context")
+	match, err := compileXPath(context, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	r := &schRule{context: context, match: rootedPattern(match)}
+	for i := range a.Children {
+		c := &a.Children[i]
+		switch {
+		case isSchematron(c, "extends"):
+			return nil, fmt.Errorf("Schematron extends is not supported")
+		case isSchematron(c, "let"):
+			let, err := newSchLet(c, namespaces)
+			if err != nil {
+				return nil, err
+			}
+			r.lets = append(r.lets, let)
+		case isSchematron(c, "assert"), isSchematron(c, "report"):
+			test := attrValue(c, "test")
+			expr, err := compileXPath(test, namespaces)
+			if err != nil {
+				return nil, err
+			}
+			message, err := newSchMessage(c, namespaces)
+			if err != nil {
+				return nil, err
+			}
+			r.checks = append(r.checks, &schCheck{report: c.Name.Local == "report", test: test, expr: expr, message: message})
+		}
+	}
+	return r, nil
+}
+
+//newSchMessage returns the parts of the message of an assert or report.
+//Other elements, like sch:emph, contribute their text.
+func newSchMessage(a *Annotation, namespaces map[string]string) ([]schMessagePart, error) {
+	var parts []schMessagePart
+	for i := range a.Children {
+		c := &a.Children[i]
+		switch {
+		case isSchematron(c, "value-of"):
+			expr, err := compileXPath(attrValue(c, "select"), namespaces)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, schMessagePart{expr: expr})
+		case isSchematron(c, "name"):
+			path := attrValue(c, "path")
+			if len(path) == 0 {
+				path = "."
+			}
+			expr, err := compileXPath(path, namespaces)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, schMessagePart{expr: expr, name: true})
+		default:
+			parts = append(parts, schMessagePart{text: c.TextContent()})
+		}
+	}
+	return parts, nil
+}
+
+//Check evaluates the rules over the xml and returns a finding for every assert that failed and every report that fired.
+//In each pattern a node is only checked by the first rule whose context matches it.
+//An error is returned if the xml is not well formed or an expression could not be evaluated.
+func (this *Schematron) Check(xmlContent []byte) ([]*Finding, error) {
+	root, err := parseXTree(xmlContent)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]interface{})
+	if err := bind(vars, this.lets, root); err != nil {
+		return nil, err
+	}
+	var findings []*Finding
+	for _, p := range this.patterns {
+		patternVars := copyVars(vars)
+		if err := bind(patternVars, p.lets, root); err != nil {
+			return nil, err
+		}
+		fired := make(map[*xnode]bool)
+		for _, r := range p.rules {
+			v, err := evalXPath(r.match, root, patternVars)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %v", r.context, err)
+			}
+			nodes, ok := v.([]*xnode)
+			if !ok {
+				return nil, fmt.Errorf("rule %s does not select nodes", r.context)
+			}
+			for _, n := range nodes {
+				if fired[n] {
+					continue
+				}
+				fired[n] = true
+				ruleVars := copyVars(patternVars)
+				if err := bind(ruleVars, r.lets, n); err != nil {
+					return nil, err
+				}
+				for _, c := range r.checks {
+					f, err := c.check(n, ruleVars, xmlContent)
+					if err != nil {
+						return nil, fmt.Errorf("rule %s: %v", r.context, err)
+					}
+					if f != nil {
+						findings = append(findings, f)
+					}
+				}
+			}
+		}
+	}
+	return findings, nil
+}
+
+func copyVars(vars map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(vars))
+	for name, v := range vars {
+		c[name] = v
+	}
+	return c
+}
+
+//bind evaluates the lets, in order, with the node as context and adds their values to the variables.
+func bind(vars map[string]interface{}, lets []*schLet, n *xnode) error {
+	for _, let := range lets {
+		v, err := evalXPath(let.value, n, vars)
+		if err != nil {
+			return fmt.Errorf("let %s: %v", let.name, err)
+		}
+		vars[let.name] = v
+	}
+	return nil
+}
+
+//check returns a finding if the check fails for the node, otherwise nil.
+func (this *schCheck) check(n *xnode, vars map[string]interface{}, xmlContent []byte) (*Finding, error) {
+	v, err := evalXPath(this.expr, n, vars)
+	if err != nil {
+		return nil, err
+	}
+	if xpathBoolean(v) != this.report {
+		return nil, nil
+	}
+	var ss []string
+	for _, part := range this.message {
+		if part.expr == nil {
+			ss = append(ss, part.text)
+			continue
+		}
+		v, err := evalXPath(part.expr, n, vars)
+		if err != nil {
+			return nil, err
+		}
+		if !part.name {
+			ss = append(ss, xpathString(v))
+		} else if nodes, ok := v.([]*xnode); ok && len(nodes) > 0 {
+			ss = append(ss, nodes[0].name.Local)
+		}
+	}
+	msg := strings.Join(strings.Fields(strings.Join(ss, "")), " ")
+	if len(msg) == 0 {
+		if this.report {
+			msg = "report: " + this.test
+		} else {
+			msg = "assertion failed: " + this.test
+		}
+	}
+	line, column := lineColumn(xmlContent, n.offset)
+	path := n.path
+	if n.kind == xattributeNode {
+		path += "/@" + n.name.Local
+	}
+	return &Finding{
+		Line:    line,
+		Column:  column,
+		Path:    path,
+		Rule:    schematronRule,
+		Pattern: this.test,
+		Message: msg,
+	}, nil
+}
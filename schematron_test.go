@@ -0,0 +1,135 @@
+package relaxng
+
+import (
+	"testing"
+)
+
+var schematronGrammar = `<grammar xmlns="http://relaxng.org/ns/structure/1.0"
+	xmlns:sch="http://purl.oclc.org/dsdl/schematron">
+	<start><ref name="order"/></start>
+	<define name="order">
+		<element name="order">
+			<sch:pattern>
+				<sch:let name="max" value="10"/>
+				<sch:rule context="order">
+					<sch:assert test="sum(item/@quantity) &lt;= $max">an order has at most <sch:value-of select="$max"/> items, not <sch:value-of select="sum(item/@quantity)"/></sch:assert>
+					<sch:report test="count(item) = 0">an order without items</sch:report>
+				</sch:rule>
+				<sch:rule context="item[@quantity = 0]">
+					<sch:assert test="false()"><sch:name/> <sch:value-of select="@sku"/> has no quantity</sch:assert>
+				</sch:rule>
+				<sch:rule context="item">
+					<sch:assert test="not(preceding-sibling::item/@sku = @sku)"/>
+				</sch:rule>
+			</sch:pattern>
+			<zeroOrMore>
+				<element name="item">
+					<attribute name="sku"/>
+					<attribute name="quantity"/>
+					<empty/>
+				</element>
+			</zeroOrMore>
+		</element>
+	</define>
+</grammar>`
+
+func TestSchematron(t *testing.T) {
+	g, err := Simplify([]byte(schematronGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := ValidateReport(g, katydid, "valid.xml", []byte(`<order><item sku="a" quantity="1"/><item sku="b" quantity="2"/></order>`))
+	if !valid.Valid || len(valid.Findings) != 0 {
+		t.Fatalf("expected valid, but got %v", valid.Findings)
+	}
+	r := ValidateReport(g, katydid, "invalid.xml", []byte(`<order>
+	<item sku="a" quantity="9"/>
+	<item sku="b" quantity="0"/>
+	<item sku="a" quantity="3"/>
+</order>`))
+	if r.Valid {
+		t.Fatal("expected the failed asserts to make the document invalid")
+	}
+	want := []Finding{
+		{Line: 1, Column: 1, Path: "/order[1]", Rule: schematronRule, Pattern: "sum(item/@quantity) <= $max", Message: "an order has at most 10 items, not 12"},
+		{Line: 3, Column: 2, Path: "/order[1]/item[2]", Rule: schematronRule, Pattern: "false()", Message: "item b has no quantity"},
+		{Line: 4, Column: 2, Path: "/order[1]/item[3]", Rule: schematronRule, Pattern: "not(preceding-sibling::item/@sku = @sku)", Message: "assertion failed: not(preceding-sibling::item/@sku = @sku)"},
+	}
+	if len(r.Findings) != len(want) {
+		t.Fatalf("expected %d findings, but got %v", len(want), r.Findings)
+	}
+	for i, f := range r.Findings {
+		if *f != want[i] {
+			t.Fatalf("expected %#v, but got %#v", want[i], *f)
+		}
+	}
+	empty := ValidateReport(g, katydid, "empty.xml", []byte(`<order/>`))
+	if len(empty.Findings) != 1 || empty.Findings[0].Message != "an order without items" {
+		t.Fatalf("expected a report, but got %v", empty.Findings)
+	}
+	invalid, err := Diagnose(g, []byte(`<order><note/></order>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 2 || invalid[0].Rule != "order" || invalid[1].Rule != schematronRule {
+		t.Fatalf("expected a RelaxNG violation followed by a Schematron finding, but got %v", invalid)
+	}
+}
+
+func TestXPath(t *testing.T) {
+	root, err := parseXTree([]byte(`<a x="1" xmlns:n="urn:n"><n:b/><b id="1">one</b><!-- c --><b id="2">t<i>w</i>o</b><c/><b id="3">  three  </b></a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		`count(//b)`:                              "3",
+		`/a/b[2]`:                                 "two",
+		`//b[last()]/@id`:                         "3",
+		`//b[@id > 1][1]`:                         "two",
+		`count(/a/*)`:                             "5",
+		`count(/a/n:*) + count(//n:b)`:            "2",
+		`count(//b | /a/b[1] | //c)`:              "4",
+		`name(/a/b[1]/following-sibling::*[2])`:   "c",
+		`name(//i/ancestor::*[last()])`:           "a",
+		`//c/preceding::b[1]/@id`:                 "2",
+		`count(//i/following::node())`:            "4",
+		`normalize-space(//b[3])`:                 "three",
+		`substring("12345", 1.5, 2.6)`:            "234",
+		`substring-after("a=b", "=")`:             "b",
+		`translate("abc", "abc", "AB")`:           "AB",
+		`string(1 div 0)`:                         "Infinity",
+		`number("1e3")`:                           "NaN",
+		`7 mod -3 + -7 mod 3`:                     "0",
+		`round(2.5) * 2 - 1`:                      "5",
+		`//b = "two" and not(//b = "four")`:       "true",
+		`//b[@id = 2] != //b`:                     "true",
+		`/a/@x = true()`:                          "true",
+		`sum(//b/@id) div count(//b)`:             "2",
+		`string-length(//b[1]) + count(//text())`: "8",
+		`boolean(//b[1]/self::node()/../c)`:       "true",
+		`count(descendant-or-self::*)`:            "7",
+		`local-name((//b)[2]/*)`:                  "i",
+	}
+	for expr, want := range tests {
+		e, err := compileXPath(expr, map[string]string{"n": "urn:n"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := evalXPath(e, root, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if got := xpathString(v); got != want {
+			t.Fatalf("%s: expected %q, but got %q", expr, want, got)
+		}
+	}
+	for _, expr := range []string{`a[`, `p:a`, `unknown()`, `namespace::*`, `count()`, `"open`} {
+		if _, err := compileXPath(expr, nil); err == nil {
+			t.Fatalf("expected an error for %s", expr)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+//Session validates a document against a simplified RelaxNG Grammar, as its events are pushed,
+//by taking the derivative of the grammar for each event, like Diagnose.
+//The first violation is returned by the event and every event after it, so that a document can be rejected,
+//before it has fully arrived.
+//Names are expanded, with the namespace in Space, like the names of the tokens of an xml.Decoder.
+//Datatypes are checked like Validate checks them, so that the lexical space of xsd datatypes is checked, but not their parameters.
+//Embedded Schematron rules are not checked, since they need the whole document.
+type Session struct {
+	deriver *deriver
+	p       *pattern
+	//names are the names of the open elements.
+	names []xml.Name
+	//path is the path of the open elements and counts the number of child elements, by name, in each open element.
+	path   []string
+	counts []map[string]int
+	//empty holds, for each open element, whether it had no children so far.
+	empty []bool
+	text  []string
+	root  bool
+	err   error
+}
+
+//ValidationError is the first violation that a Session found.
+type ValidationError struct {
+	//Path is the path of the element in which the violation occurred, for example /order[1]/item[2].
+	Path string
+	//Tags is the path of the element in the syntax of the struct tags of encoding/xml, for example order>item.
+	Tags    string
+	Message string
+}
+
+func (this *ValidationError) Error() string {
+	return this.Path + ": " + this.Message
+}
+
+//NewSession returns a session that validates a single document against the grammar.
+func NewSession(g *Grammar) *Session {
+	d := newDeriver(g)
+	return &Session{
+		deriver: d,
+		p:       d.start,
+		counts:  []map[string]int{make(map[string]int)},
+	}
+}
+
+//fail records the violation in the open element and returns it.
+func (this *Session) fail(format string, args ...interface{}) error {
+	tags := make([]string, len(this.names))
+	for i, name := range this.names {
+		tags[i] = name.Local
+	}
+	this.err = &ValidationError{
+		Path:    "/" + strings.Join(this.path, "/"),
+		Tags:    strings.Join(tags, ">"),
+		Message: fmt.Sprintf(format, args...),
+	}
+	return this.err
+}
+
+//StartElement opens an element with the attributes, where xmlns attributes are ignored.
+func (this *Session) StartElement(name xml.Name, attrs []xml.Attr) error {
+	if this.err != nil {
+		return this.err
+	}
+	if err := this.flushText(); err != nil {
+		return err
+	}
+	if len(this.names) == 0 && this.root {
+		return this.fail("element %s is a second root element", name.Local)
+	}
+	this.root = true
+	if len(this.empty) > 0 {
+		this.empty[len(this.empty)-1] = false
+	}
+	next := this.deriver.startTagOpenDeriv(this.p, name)
+	if next.kind == notAllowedKind {
+		return this.fail("element %s is not allowed here", name.Local)
+	}
+	siblings := this.counts[len(this.counts)-1]
+	siblings[name.Local]++
+	this.path = append(this.path, fmt.Sprintf("%s[%d]", name.Local, siblings[name.Local]))
+	this.counts = append(this.counts, make(map[string]int))
+	this.names = append(this.names, name)
+	this.empty = append(this.empty, true)
+	this.p = next
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		next := this.deriver.attDeriv(this.p, attr, false)
+		if next.kind == notAllowedKind {
+			return this.fail("attribute %s is not allowed here or has an invalid value %q", attr.Name.Local, abbreviate(attr.Value))
+		}
+		this.p = next
+	}
+	next = startTagCloseDeriv(this.p, false)
+	if next.kind == notAllowedKind {
+		return this.fail("element %s is missing attribute %s", name.Local, strings.Join(missingAttributes(content(this.p)), " or "))
+	}
+	this.p = next
+	return nil
+}
+
+//Text adds text to the open element, where adjacent text is validated as a whole, when the next element starts or ends.
+func (this *Session) Text(s string) error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.names) == 0 {
+		if !isWhitespace(s) {
+			return this.fail("text %q is outside of the root element", abbreviate(strings.TrimSpace(s)))
+		}
+		return nil
+	}
+	this.text = append(this.text, s)
+	return nil
+}
+
+func (this *Session) flushText() error {
+	if len(this.text) == 0 {
+		return nil
+	}
+	s := strings.Join(this.text, "")
+	this.text = this.text[:0]
+	this.empty[len(this.empty)-1] = false
+	next := this.deriver.childText(this.p, s)
+	if next.kind == notAllowedKind {
+		return this.fail("text %q is not allowed here", abbreviate(strings.TrimSpace(s)))
+	}
+	this.p = next
+	return nil
+}
+
+//EndElement closes the element that was opened last.
+func (this *Session) EndElement() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.names) == 0 {
+		return this.fail("end of an element that was not started")
+	}
+	if err := this.flushText(); err != nil {
+		return err
+	}
+	last := len(this.names) - 1
+	if this.empty[last] {
+		this.p = newChoice(this.p, this.deriver.textDeriv(this.p, ""))
+	}
+	next := endTagDeriv(this.p, false)
+	if next.kind == notAllowedKind {
+		return this.fail("element %s is incomplete, expected %s", this.names[last].Local, this.deriver.patternString(content(this.p)))
+	}
+	this.p = next
+	this.names = this.names[:last]
+	this.path = this.path[:last]
+	this.counts = this.counts[:last+1]
+	this.empty = this.empty[:last]
+	return nil
+}
+
+//Token pushes the event of a token from an xml.Decoder, where comments, processing instructions and directives are ignored.
+func (this *Session) Token(t xml.Token) error {
+	switch t := t.(type) {
+	case xml.StartElement:
+		return this.StartElement(t.Name, t.Attr)
+	case xml.EndElement:
+		return this.EndElement()
+	case xml.CharData:
+		return this.Text(string(t))
+	}
+	return this.err
+}
+
+//Close ends the document and returns nil if it is valid, otherwise the first violation.
+func (this *Session) Close() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.names) > 0 {
+		return this.fail("element %s is not closed", this.names[len(this.names)-1].Local)
+	}
+	if !this.root {
+		return this.fail("document has no root element")
+	}
+	if !nullable(this.p) {
+		return this.fail("document is incomplete")
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSessionSuite(t *testing.T) {
+	for _, spec := range scanFiles() {
+		num := testNumber(spec.Filename)
+		if len(spec.SimpleFilename) == 0 {
+			continue
+		}
+		g, err := ParseGrammar(spec.SimpleContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, x := range spec.Xmls {
+			findings, err := Diagnose(g, x.Content)
+			want := err == nil && len(findings) == 0
+			s := NewSession(g)
+			dec := xml.NewDecoder(bytes.NewReader(x.Content))
+			for err == nil {
+				var tok xml.Token
+				tok, err = dec.Token()
+				if err == nil {
+					err = s.Token(tok)
+				}
+			}
+			if err == io.EOF {
+				err = s.Close()
+			}
+			if got := err == nil; got != want {
+				t.Fatalf("%s: %s: expected valid %v, but got %v", num, x.Filename, want, err)
+			}
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" xmlns="http://relaxng.org/ns/structure/1.0">
+	<attribute name="id"/>
+	<oneOrMore><element name="item"><value>ab</value></element></oneOrMore>
+</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, item := xml.Name{Local: "order"}, xml.Name{Local: "item"}
+	id := []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "1"}}
+	s := NewSession(g)
+	events := []func() error{
+		func() error { return s.Text("\n") },
+		func() error { return s.StartElement(order, id) },
+		func() error { return s.StartElement(item, nil) },
+		func() error { return s.Text("a") },
+		func() error { return s.Text("b") },
+		func() error { return s.EndElement() },
+		func() error { return s.EndElement() },
+		func() error { return s.Close() },
+	}
+	for i, event := range events {
+		if err := event(); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+
+	s = NewSession(g)
+	if err := s.StartElement(order, id); err != nil {
+		t.Fatal(err)
+	}
+	invalid := s.StartElement(xml.Name{Local: "note"}, nil)
+	if invalid == nil || !strings.Contains(invalid.Error(), "/order[1]: element note is not allowed here") {
+		t.Fatalf("expected note to not be allowed, but got %v", invalid)
+	}
+	if err := s.Text("more"); err != invalid {
+		t.Fatalf("expected the first violation, but got %v", err)
+	}
+	if err := s.Close(); err != invalid {
+		t.Fatalf("expected the first violation, but got %v", err)
+	}
+
+	violations := map[string][]func(s *Session) error{
+		"missing attribute id": {
+			func(s *Session) error { return s.StartElement(order, nil) },
+		},
+		"element order is incomplete": {
+			func(s *Session) error { return s.StartElement(order, id) },
+			func(s *Session) error { return s.EndElement() },
+		},
+		"text \"ac\" is not allowed here": {
+			func(s *Session) error { return s.StartElement(order, id) },
+			func(s *Session) error { return s.StartElement(item, nil) },
+			func(s *Session) error { return s.Text("a") },
+			func(s *Session) error { return s.Text("c") },
+			func(s *Session) error { return s.EndElement() },
+		},
+		"element order is not closed": {
+			func(s *Session) error { return s.StartElement(order, id) },
+		},
+		"no root element": {},
+	}
+	for want, events := range violations {
+		s := NewSession(g)
+		var err error
+		for _, event := range events {
+			if err = event(s); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = s.Close()
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %s, but got %v", want, err)
+		}
+	}
+}
+
+func sessionValidate(g *Grammar, buf []byte) error {
+	s := NewSession(g)
+	dec := xml.NewDecoder(bytes.NewReader(buf))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return s.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.Token(tok); err != nil {
+			return err
+		}
+	}
+}
+
+func TestSessionAgreesWithValidate(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" xmlns="http://relaxng.org/ns/structure/1.0"
+	datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+	<attribute name="quantity"><data type="int"/></attribute>
+	<element name="status"><value type="token">open now</value></element>
+</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`<order quantity="3"><status> open	now </status></order>`, true},
+		{`<order quantity="three"><status>open now</status></order>`, false},
+		{`<order quantity="3000000000"><status>open now</status></order>`, false},
+		{"<order quantity=\"3\"><status>open\u00a0now</status></order>", false},
+	}
+	for _, test := range tests {
+		want := Validate(katydid, []byte(test.doc)) == nil
+		if want != test.valid {
+			t.Fatalf("expected Validate of %s to be %v", test.doc, test.valid)
+		}
+		if got := sessionValidate(g, []byte(test.doc)) == nil; got != want {
+			t.Errorf("expected the session to agree with Validate, that %s is valid %v", test.doc, want)
+		}
+	}
+}
@@ -0,0 +1,1338 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	relaxngNs = "http://relaxng.org/ns/structure/1.0"
+	xmlNs     = "http://www.w3.org/XML/1998/namespace"
+)
+
+//Simplifies a full RelaxNG grammar, written in the XML syntax, into a simplified Grammar
+//as specified in section 4 of http://relaxng.org/spec-20011203.html
+//The href attributes of externalRef and include elements are resolved relative to the working directory.
+func Simplify(buf []byte) (*Grammar, error) {
+	return newSimplifier().simplify(buf, "")
+}
+
+//Simplifies the full RelaxNG grammar, written in the XML syntax, that is stored in the file.
+//The href attributes of externalRef and include elements are resolved relative to the file.
+func SimplifyFile(filename string) (*Grammar, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newSimplifier().simplify(buf, filename)
+}
+
+//SimplifyFragment simplifies a full RelaxNG grammar, like Simplify,
+//but replaces its start pattern with a reference to the named define,
+//so that fragments of documents can be validated against the define.
+func SimplifyFragment(buf []byte, define string) (*Grammar, error) {
+	s := newSimplifier()
+	s.fragment = define
+	return s.simplify(buf, "")
+}
+
+//SimplifyFragmentFile simplifies the full RelaxNG grammar that is stored in the file,
+//like SimplifyFile, but replaces its start pattern with a reference to the named define.
+func SimplifyFragmentFile(filename string, define string) (*Grammar, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := newSimplifier()
+	s.fragment = define
+	return s.simplify(buf, filename)
+}
+
+//A node in a full RelaxNG grammar.
+//Foreign elements and attributes are kept separately from the RelaxNG children and attributes.
+type rngNode struct {
+	name         string
+	attrs        map[string]string
+	children     []*rngNode
+	text         string
+	prefixes     map[string]string
+	base         string
+	annotations  []Annotation
+	foreignAttrs []xml.Attr
+}
+
+func newRngNode(name string, children ...*rngNode) *rngNode {
+	return &rngNode{name: name, attrs: make(map[string]string), children: children}
+}
+
+func (this *rngNode) attr(name string) (string, bool) {
+	v, ok := this.attrs[name]
+	return v, ok
+}
+
+func (this *rngNode) copy() *rngNode {
+	c := &rngNode{
+		name:         this.name,
+		attrs:        make(map[string]string, len(this.attrs)),
+		children:     make([]*rngNode, len(this.children)),
+		text:         this.text,
+		prefixes:     this.prefixes,
+		base:         this.base,
+		annotations:  this.annotations,
+		foreignAttrs: this.foreignAttrs,
+	}
+	for k, v := range this.attrs {
+		c.attrs[k] = v
+	}
+	for i, child := range this.children {
+		c.children[i] = child.copy()
+	}
+	return c
+}
+
+func (this *rngNode) String() string {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("<" + this.name)
+	for k, v := range this.attrs {
+		buf.WriteString(" " + k + "=" + strconv.Quote(v))
+	}
+	buf.WriteString(">")
+	for _, c := range this.children {
+		buf.WriteString(c.String())
+	}
+	buf.WriteString(this.text)
+	buf.WriteString("</" + this.name + ">")
+	return buf.String()
+}
+
+var rngElements = map[string]bool{
+	"grammar": true, "start": true, "define": true, "div": true, "include": true,
+	"externalRef": true, "parentRef": true, "ref": true,
+	"element": true, "attribute": true, "group": true, "interleave": true, "choice": true,
+	"optional": true, "zeroOrMore": true, "oneOrMore": true, "list": true, "mixed": true,
+	"empty": true, "text": true, "notAllowed": true, "data": true, "value": true, "param": true,
+	"except": true, "name": true, "anyName": true, "nsName": true,
+}
+
+//Elements whose character content is significant.
+var textElements = map[string]bool{
+	"value": true, "param": true, "name": true,
+}
+
+//parseRng parses a RelaxNG grammar, separating the annotations from the patterns as described in section 4.1.
+//If the document element is not in the RelaxNG namespace,
+//then elements without a namespace are treated as RelaxNG elements.
+func parseRng(buf []byte, base string) (*rngNode, error) {
+	return decodeRng(xml.NewDecoder(bytes.NewReader(buf)), nil, base)
+}
+
+//decodeRng decodes the RelaxNG element, that starts with the start token, or the first element, if start is nil.
+func decodeRng(d *xml.Decoder, start *xml.StartElement, base string) (*rngNode, error) {
+	var root *rngNode
+	var stack []*rngNode
+	ns := ""
+	for {
+		var t xml.Token
+		if start != nil {
+			t, start = *start, nil
+		} else {
+			var err error
+			t, err = d.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch tok := t.(type) {
+		case xml.StartElement:
+			if root == nil && tok.Name.Space == relaxngNs {
+				ns = relaxngNs
+			}
+			if tok.Name.Space != ns {
+				if root == nil {
+					return nil, fmt.Errorf("%s is not a RelaxNG element", tok.Name.Local)
+				}
+				parent := stack[len(stack)-1]
+				if textElements[parent.name] {
+					return nil, fmt.Errorf("unexpected element %s in %s", tok.Name.Local, parent.name)
+				}
+				a, err := decodeAnnotation(d, tok)
+				if err != nil {
+					return nil, err
+				}
+				parent.annotations = append(parent.annotations, a)
+				continue
+			}
+			if !rngElements[tok.Name.Local] {
+				return nil, fmt.Errorf("unknown RelaxNG element %s", tok.Name.Local)
+			}
+			n := newRngNode(tok.Name.Local)
+			n.base = base
+			n.prefixes = map[string]string{"xml": xmlNs}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				n.base = parent.base
+				for k, v := range parent.prefixes {
+					n.prefixes[k] = v
+				}
+			}
+			for _, a := range tok.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					n.prefixes[a.Name.Local] = a.Value
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					n.prefixes[""] = a.Value
+				case a.Name.Space == xmlNs && a.Name.Local == "base":
+					n.base = resolveHref(n.base, a.Value)
+				case a.Name.Space == "":
+					v := a.Value
+					//4.2 Whitespace
+					if a.Name.Local == "name" || a.Name.Local == "type" || a.Name.Local == "combine" {
+						v = strings.TrimSpace(v)
+					}
+					n.attrs[a.Name.Local] = v
+				default:
+					n.foreignAttrs = append(n.foreignAttrs, a)
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return root, nil
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				n := stack[len(stack)-1]
+				if textElements[n.name] {
+					n.text += string(tok)
+				} else if len(strings.TrimSpace(string(tok))) > 0 {
+					return nil, fmt.Errorf("unexpected text %q in %s", strings.TrimSpace(string(tok)), n.name)
+				}
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("empty grammar")
+	}
+	return root, nil
+}
+
+//resolveHref resolves a reference relative to the base path.
+func resolveHref(base, href string) string {
+	if u, err := url.Parse(href); err == nil && u.Scheme == "file" {
+		return filepath.FromSlash(u.Path)
+	}
+	href = filepath.FromSlash(href)
+	if filepath.IsAbs(href) || len(base) == 0 {
+		return href
+	}
+	return filepath.Join(filepath.Dir(base), href)
+}
+
+type simplifier struct {
+	//fragment is the name of the define that replaces the start pattern, if it is not empty.
+	fragment string
+	readFile func(string) ([]byte, error)
+	loading  []string
+	used     map[string]bool
+	defs     map[string]*rngNode
+	order    []string
+}
+
+func newSimplifier() *simplifier {
+	return &simplifier{
+		readFile: ioutil.ReadFile,
+		used:     make(map[string]bool),
+		defs:     make(map[string]*rngNode),
+	}
+}
+
+func (this *simplifier) simplify(buf []byte, filename string) (*Grammar, error) {
+	root, err := this.load(buf, filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := nameAttributes(root); err != nil {
+		return nil, err
+	}
+	inheritNs(root, "")
+	if err := qualifiedNames(root); err != nil {
+		return nil, err
+	}
+	root = removeDivs(root)
+	if err := childElements(root); err != nil {
+		return nil, err
+	}
+	annotated := root
+	if root.name != "grammar" {
+		root = newRngNode("grammar", newRngNode("start", root))
+	}
+	start, err := this.flattenGrammar(root, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(this.fragment) > 0 {
+		if _, ok := this.defs[this.fragment]; !ok {
+			return nil, fmt.Errorf("grammar has no define named %s", this.fragment)
+		}
+		start = newRngNode("ref")
+		start.attrs["name"] = this.fragment
+	}
+	start, err = this.defines(start)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPaths(start, this.defs); err != nil {
+		return nil, err
+	}
+	g, err := this.grammar(start)
+	if err != nil {
+		return nil, err
+	}
+	if annotated.name == "grammar" {
+		g.Annotations, g.ForeignAttrs = annotated.annotations, annotated.foreignAttrs
+	}
+	return g, nil
+}
+
+//load parses a document and resolves its externalRef and include elements.
+//Sections 4.3 to 4.7
+func (this *simplifier) load(buf []byte, filename string) (*rngNode, error) {
+	for _, l := range this.loading {
+		if l == filename {
+			return nil, fmt.Errorf("%s is recursively referenced", filename)
+		}
+	}
+	this.loading = append(this.loading, filename)
+	defer func() {
+		this.loading = this.loading[:len(this.loading)-1]
+	}()
+	root, err := parseRng(buf, filename)
+	if err != nil {
+		if len(filename) > 0 {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return nil, err
+	}
+	if err := checkSyntax(root); err != nil {
+		if len(filename) > 0 {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return nil, err
+	}
+	inheritDatatypeLibrary(root, "")
+	return this.resolveHrefs(root)
+}
+
+//The attributes, other than ns and datatypeLibrary, that are allowed on each element.
+var rngAttributes = map[string][]string{
+	"element":     {"name"},
+	"attribute":   {"name"},
+	"define":      {"name", "combine"},
+	"start":       {"combine"},
+	"ref":         {"name"},
+	"parentRef":   {"name"},
+	"externalRef": {"href"},
+	"include":     {"href"},
+	"data":        {"type"},
+	"value":       {"type"},
+	"param":       {"name"},
+}
+
+//The child elements that are allowed on each element.
+//Elements that are not listed may contain patterns.
+var rngChildren = map[string][]string{
+	"grammar":     {"start", "define", "div", "include"},
+	"div":         {"start", "define", "div", "include"},
+	"include":     {"start", "define", "div"},
+	"empty":       {},
+	"text":        {},
+	"notAllowed":  {},
+	"ref":         {},
+	"parentRef":   {},
+	"externalRef": {},
+	"name":        {},
+	"value":       {},
+	"param":       {},
+	"data":        {"param", "except"},
+	"anyName":     {"except"},
+	"nsName":      {"except"},
+}
+
+//checkSyntax checks the attributes, children and names of the RelaxNG elements.
+func checkSyntax(n *rngNode) error {
+	for name, value := range n.attrs {
+		allowed := name == "ns" || name == "datatypeLibrary"
+		for _, a := range rngAttributes[n.name] {
+			allowed = allowed || a == name
+		}
+		if !allowed {
+			return fmt.Errorf("attribute %s is not allowed on %s", name, n.name)
+		}
+		switch {
+		case name == "datatypeLibrary":
+			if err := checkDatatypeLibrary(value); err != nil {
+				return err
+			}
+		case name == "name" && (n.name == "element" || n.name == "attribute"):
+			if !isQName(value) {
+				return fmt.Errorf("%s name %q is not a QName", n.name, value)
+			}
+		case name == "name" && n.name != "param":
+			if !isNCName(value) {
+				return fmt.Errorf("%s name %q is not an NCName", n.name, value)
+			}
+		}
+	}
+	if n.name == "name" && !isQName(strings.TrimSpace(n.text)) {
+		return fmt.Errorf("name %q is not a QName", strings.TrimSpace(n.text))
+	}
+	if children, ok := rngChildren[n.name]; ok {
+		for _, c := range n.children {
+			allowed := false
+			for _, name := range children {
+				allowed = allowed || c.name == name
+			}
+			if !allowed {
+				return fmt.Errorf("%s is not allowed inside %s", c.name, n.name)
+			}
+		}
+	} else {
+		for _, c := range n.children {
+			switch c.name {
+			case "start", "define", "div", "include", "param":
+				return fmt.Errorf("%s is not allowed inside %s", c.name, n.name)
+			}
+		}
+	}
+	for _, c := range n.children {
+		if err := checkSyntax(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checkDatatypeLibrary checks that the datatypeLibrary is empty or an absolute URI without a fragment identifier.
+func checkDatatypeLibrary(dl string) error {
+	if len(dl) == 0 {
+		return nil
+	}
+	u, err := url.Parse(dl)
+	if err != nil {
+		return fmt.Errorf("datatypeLibrary %q: %v", dl, err)
+	}
+	if !u.IsAbs() || len(u.Opaque) == 0 && len(u.Host) == 0 && len(u.Path) == 0 {
+		return fmt.Errorf("datatypeLibrary %q is not an absolute URI", dl)
+	}
+	if strings.Contains(dl, "#") {
+		return fmt.Errorf("datatypeLibrary %q contains a fragment identifier", dl)
+	}
+	return nil
+}
+
+func isNCName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i, r := range s {
+		if unicode.IsLetter(r) || r == '_' {
+			continue
+		}
+		if i > 0 && (unicode.IsDigit(r) || r == '.' || r == '-' || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Lm)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func isQName(s string) bool {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return isNCName(s[:i]) && isNCName(s[i+1:])
+	}
+	return isNCName(s)
+}
+
+func (this *simplifier) loadHref(n *rngNode) (*rngNode, error) {
+	href, ok := n.attr("href")
+	if !ok {
+		return nil, fmt.Errorf("%s without href", n.name)
+	}
+	filename := resolveHref(n.base, strings.TrimSpace(href))
+	buf, err := this.readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	root, err := this.load(buf, filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := root.attr("ns"); !ok {
+		if ns, ok := n.attr("ns"); ok {
+			root.attrs["ns"] = ns
+		}
+	}
+	return root, nil
+}
+
+//4.3 datatypeLibrary attribute and 4.4 type attribute of value element
+func inheritDatatypeLibrary(n *rngNode, dl string) {
+	if v, ok := n.attr("datatypeLibrary"); ok {
+		dl = v
+	}
+	switch n.name {
+	case "data", "value":
+		n.attrs["datatypeLibrary"] = dl
+		if _, ok := n.attr("type"); !ok && n.name == "value" {
+			n.attrs["type"] = "token"
+			n.attrs["datatypeLibrary"] = ""
+		}
+	default:
+		delete(n.attrs, "datatypeLibrary")
+	}
+	for _, c := range n.children {
+		inheritDatatypeLibrary(c, dl)
+	}
+}
+
+//4.5 href attribute, 4.6 externalRef element and 4.7 include element
+func (this *simplifier) resolveHrefs(n *rngNode) (*rngNode, error) {
+	switch n.name {
+	case "externalRef":
+		return this.loadHref(n)
+	case "include":
+		g, err := this.loadHref(n)
+		if err != nil {
+			return nil, err
+		}
+		if g.name != "grammar" {
+			return nil, fmt.Errorf("included %s is not a grammar", n.attrs["href"])
+		}
+		overrideStart := false
+		overrides := make(map[string]bool)
+		walkComponents(n, func(c *rngNode) {
+			if c.name == "start" {
+				overrideStart = true
+			} else if c.name == "define" {
+				overrides[c.attrs["name"]] = true
+			}
+		})
+		found := make(map[string]bool)
+		removeComponents(g, func(c *rngNode) bool {
+			if c.name == "start" {
+				return overrideStart
+			}
+			if c.name == "define" && overrides[c.attrs["name"]] {
+				found[c.attrs["name"]] = true
+				return true
+			}
+			return false
+		})
+		for name := range overrides {
+			if !found[name] {
+				return nil, fmt.Errorf("include overrides define %s which is not in the included grammar", name)
+			}
+		}
+		inner := newRngNode("div", g.children...)
+		if ns, ok := g.attr("ns"); ok {
+			inner.attrs["ns"] = ns
+		}
+		div := newRngNode("div", inner)
+		for k, v := range n.attrs {
+			if k != "href" {
+				div.attrs[k] = v
+			}
+		}
+		div.prefixes = n.prefixes
+		div.base = n.base
+		for _, c := range n.children {
+			c, err := this.resolveHrefs(c)
+			if err != nil {
+				return nil, err
+			}
+			div.children = append(div.children, c)
+		}
+		return div, nil
+	}
+	for i, c := range n.children {
+		c, err := this.resolveHrefs(c)
+		if err != nil {
+			return nil, err
+		}
+		n.children[i] = c
+	}
+	return n, nil
+}
+
+//walkComponents calls f for every start, define and include that is a component of the grammar or include,
+//looking through div elements.
+func walkComponents(n *rngNode, f func(*rngNode)) {
+	for _, c := range n.children {
+		if c.name == "div" {
+			walkComponents(c, f)
+		} else {
+			f(c)
+		}
+	}
+}
+
+func removeComponents(n *rngNode, remove func(*rngNode) bool) {
+	children := n.children[:0]
+	for _, c := range n.children {
+		if c.name == "div" {
+			removeComponents(c, remove)
+		} else if remove(c) {
+			continue
+		}
+		children = append(children, c)
+	}
+	n.children = children
+}
+
+//4.8 name attribute of element and attribute elements
+func nameAttributes(n *rngNode) error {
+	if n.name == "element" || n.name == "attribute" {
+		if name, ok := n.attr("name"); ok {
+			nameNode := newRngNode("name")
+			nameNode.text = name
+			nameNode.prefixes = n.prefixes
+			if ns, ok := n.attr("ns"); ok {
+				nameNode.attrs["ns"] = ns
+			} else if n.name == "attribute" {
+				nameNode.attrs["ns"] = ""
+			}
+			delete(n.attrs, "name")
+			n.children = append([]*rngNode{nameNode}, n.children...)
+		}
+	}
+	for _, c := range n.children {
+		if err := nameAttributes(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//4.9 ns attribute
+func inheritNs(n *rngNode, ns string) {
+	if v, ok := n.attr("ns"); ok {
+		ns = v
+	}
+	switch n.name {
+	case "name", "nsName", "value":
+		n.attrs["ns"] = ns
+	default:
+		delete(n.attrs, "ns")
+	}
+	for _, c := range n.children {
+		inheritNs(c, ns)
+	}
+}
+
+//4.10 QNames
+func qualifiedNames(n *rngNode) error {
+	if n.name == "name" {
+		n.text = strings.TrimSpace(n.text)
+		if i := strings.Index(n.text, ":"); i >= 0 {
+			prefix := n.text[:i]
+			ns, ok := n.prefixes[prefix]
+			if !ok {
+				return fmt.Errorf("undeclared namespace prefix %s", prefix)
+			}
+			n.attrs["ns"] = ns
+			n.text = n.text[i+1:]
+		}
+	}
+	for _, c := range n.children {
+		if err := qualifiedNames(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//4.11 div element
+func removeDivs(n *rngNode) *rngNode {
+	var children []*rngNode
+	for _, c := range n.children {
+		c = removeDivs(c)
+		if c.name == "div" {
+			children = append(children, c.children...)
+		} else {
+			children = append(children, c)
+		}
+	}
+	n.children = children
+	return n
+}
+
+//binary nests the children of a choice, group or interleave to the left.
+func binary(name string, children []*rngNode) *rngNode {
+	n := children[0]
+	for _, c := range children[1:] {
+		n = newRngNode(name, n, c)
+	}
+	return n
+}
+
+//childElements normalizes the number of child elements (4.12)
+//and removes the mixed (4.13), optional (4.14) and zeroOrMore (4.15) elements.
+func childElements(n *rngNode) error {
+	for _, c := range n.children {
+		if err := childElements(c); err != nil {
+			return err
+		}
+	}
+	switch n.name {
+	case "define", "oneOrMore", "zeroOrMore", "optional", "list", "mixed", "start":
+		if len(n.children) == 0 {
+			return fmt.Errorf("%s without a pattern", n.name)
+		}
+		if len(n.children) > 1 {
+			if n.name == "start" {
+				return fmt.Errorf("start with more than one pattern")
+			}
+			n.children = []*rngNode{binary("group", n.children)}
+		}
+	case "element":
+		if len(n.children) < 2 {
+			return fmt.Errorf("element without a name class and pattern")
+		}
+		if len(n.children) > 2 {
+			n.children = []*rngNode{n.children[0], binary("group", n.children[1:])}
+		}
+	case "attribute":
+		if len(n.children) == 0 {
+			return fmt.Errorf("attribute without a name class")
+		}
+		if len(n.children) == 1 {
+			n.children = append(n.children, newRngNode("text"))
+		}
+		if len(n.children) > 2 {
+			return fmt.Errorf("attribute with more than one pattern")
+		}
+	case "except":
+		if len(n.children) == 0 {
+			return fmt.Errorf("except without a child")
+		}
+		if len(n.children) > 1 {
+			n.children = []*rngNode{binary("choice", n.children)}
+		}
+	case "choice", "group", "interleave":
+		if len(n.children) == 0 {
+			return fmt.Errorf("%s without a child", n.name)
+		}
+		if len(n.children) == 1 {
+			*n = *n.children[0]
+			return nil
+		}
+		n.children = binary(n.name, n.children).children
+	case "ref", "parentRef":
+		if _, ok := n.attr("name"); !ok {
+			return fmt.Errorf("%s without a name", n.name)
+		}
+	}
+	switch n.name {
+	case "mixed":
+		n.name = "interleave"
+		n.children = append(n.children, newRngNode("text"))
+	case "optional":
+		n.name = "choice"
+		n.children = append(n.children, newRngNode("empty"))
+	case "zeroOrMore":
+		n.name = "choice"
+		n.children = []*rngNode{newRngNode("oneOrMore", n.children...), newRngNode("empty")}
+	}
+	return nil
+}
+
+type scope struct {
+	names  map[string]string
+	parent *scope
+}
+
+//uniqueName returns a define name that has not been used yet.
+func (this *simplifier) uniqueName(name string) string {
+	unique := name
+	for i := 1; this.used[unique]; i++ {
+		unique = name + "_" + strconv.Itoa(i)
+	}
+	this.used[unique] = true
+	return unique
+}
+
+//combine merges the patterns of the start or defines with the same name (4.17).
+func combine(name string, components []*rngNode) (*rngNode, error) {
+	method := ""
+	withoutCombine := 0
+	patterns := make([]*rngNode, len(components))
+	for i, c := range components {
+		patterns[i] = c.children[0]
+		//The annotations of a define annotate its pattern.
+		patterns[i].annotations = append(c.annotations, patterns[i].annotations...)
+		patterns[i].foreignAttrs = append(c.foreignAttrs, patterns[i].foreignAttrs...)
+		m, ok := c.attr("combine")
+		if !ok {
+			withoutCombine++
+			continue
+		}
+		if m != "choice" && m != "interleave" {
+			return nil, fmt.Errorf("invalid combine %q for %s", m, name)
+		}
+		if len(method) > 0 && method != m {
+			return nil, fmt.Errorf("conflicting combine methods for %s", name)
+		}
+		method = m
+	}
+	if withoutCombine > 1 {
+		return nil, fmt.Errorf("%s is defined more than once without a combine attribute", name)
+	}
+	if len(patterns) == 1 {
+		return patterns[0], nil
+	}
+	if len(method) == 0 {
+		return nil, fmt.Errorf("%s is defined more than once without a combine attribute", name)
+	}
+	return binary(method, patterns), nil
+}
+
+//flattenGrammar moves all the defines of the grammar and its nested grammars to the top level,
+//renaming them to be unique, and returns the resolved start pattern (4.17 and 4.18).
+func (this *simplifier) flattenGrammar(g *rngNode, parent *scope) (*rngNode, error) {
+	var starts []*rngNode
+	var defineNames []string
+	defines := make(map[string][]*rngNode)
+	for _, c := range g.children {
+		switch c.name {
+		case "start":
+			starts = append(starts, c)
+		case "define":
+			name, ok := c.attr("name")
+			if !ok {
+				return nil, fmt.Errorf("define without a name")
+			}
+			if _, ok := defines[name]; !ok {
+				defineNames = append(defineNames, name)
+			}
+			defines[name] = append(defines[name], c)
+		default:
+			return nil, fmt.Errorf("unexpected %s in grammar", c.name)
+		}
+	}
+	if len(starts) == 0 {
+		return nil, fmt.Errorf("grammar without a start")
+	}
+	s := &scope{names: make(map[string]string), parent: parent}
+	for _, name := range defineNames {
+		s.names[name] = this.uniqueName(name)
+	}
+	start, err := combine("start", starts)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range defineNames {
+		p, err := combine(name, defines[name])
+		if err != nil {
+			return nil, err
+		}
+		p, err = this.resolveRefs(p, s)
+		if err != nil {
+			return nil, err
+		}
+		unique := s.names[name]
+		this.defs[unique] = p
+		this.order = append(this.order, unique)
+	}
+	return this.resolveRefs(start, s)
+}
+
+func (this *simplifier) resolveRefs(n *rngNode, s *scope) (*rngNode, error) {
+	switch n.name {
+	case "ref":
+		name, ok := s.names[n.attrs["name"]]
+		if !ok {
+			return nil, fmt.Errorf("reference to undefined %s", n.attrs["name"])
+		}
+		n.attrs["name"] = name
+		return n, nil
+	case "parentRef":
+		if s.parent == nil {
+			return nil, fmt.Errorf("parentRef %s outside of a nested grammar", n.attrs["name"])
+		}
+		name, ok := s.parent.names[n.attrs["name"]]
+		if !ok {
+			return nil, fmt.Errorf("parentRef to undefined %s", n.attrs["name"])
+		}
+		ref := newRngNode("ref")
+		ref.attrs["name"] = name
+		return ref, nil
+	case "grammar":
+		return this.flattenGrammar(n, s)
+	}
+	for i, c := range n.children {
+		c, err := this.resolveRefs(c, s)
+		if err != nil {
+			return nil, err
+		}
+		n.children[i] = c
+	}
+	return n, nil
+}
+
+//defines gives every element its own define and expands all references to defines that are not elements (4.19).
+//Afterwards the notAllowed (4.20) and empty (4.21) elements are simplified and unreachable defines are removed.
+func (this *simplifier) defines(start *rngNode) (*rngNode, error) {
+	for _, name := range this.order {
+		d := this.defs[name]
+		if d.name == "element" {
+			d.children[1] = this.extractElements(d.children[1])
+		} else {
+			this.defs[name] = this.extractElements(d)
+		}
+	}
+	start = this.extractElements(start)
+	var err error
+	start, err = this.expandRefs(start, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range this.order {
+		d := this.defs[name]
+		if d.name != "element" {
+			continue
+		}
+		d.children[1], err = this.expandRefs(d.children[1], nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	start = simplifyPattern(start)
+	for _, name := range this.order {
+		d := this.defs[name]
+		if d.name == "element" {
+			d.children[1] = simplifyPattern(d.children[1])
+		}
+	}
+	reachable := make(map[string]bool)
+	this.reach(start, reachable)
+	order := this.order[:0]
+	for _, name := range this.order {
+		if reachable[name] {
+			order = append(order, name)
+		} else {
+			delete(this.defs, name)
+		}
+	}
+	this.order = order
+	return start, nil
+}
+
+func (this *simplifier) extractElements(n *rngNode) *rngNode {
+	if n.name == "element" {
+		n.children[1] = this.extractElements(n.children[1])
+		name := "element"
+		if n.children[0].name == "name" {
+			name = n.children[0].text
+		}
+		unique := this.uniqueName(name)
+		this.defs[unique] = n
+		this.order = append(this.order, unique)
+		ref := newRngNode("ref")
+		ref.attrs["name"] = unique
+		return ref
+	}
+	for i, c := range n.children {
+		n.children[i] = this.extractElements(c)
+	}
+	return n
+}
+
+func (this *simplifier) expandRefs(n *rngNode, expanding []string) (*rngNode, error) {
+	if n.name == "ref" {
+		name := n.attrs["name"]
+		d := this.defs[name]
+		if d.name == "element" {
+			return n, nil
+		}
+		for _, e := range expanding {
+			if e == name {
+				return nil, fmt.Errorf("%s is recursive without passing through an element", name)
+			}
+		}
+		return this.expandRefs(d.copy(), append(expanding, name))
+	}
+	for i, c := range n.children {
+		c, err := this.expandRefs(c, expanding)
+		if err != nil {
+			return nil, err
+		}
+		n.children[i] = c
+	}
+	return n, nil
+}
+
+func (this *simplifier) reach(n *rngNode, reachable map[string]bool) {
+	if n.name == "ref" {
+		name := n.attrs["name"]
+		if reachable[name] {
+			return
+		}
+		reachable[name] = true
+		this.reach(this.defs[name], reachable)
+		return
+	}
+	for _, c := range n.children {
+		this.reach(c, reachable)
+	}
+}
+
+//simplifyPattern simplifies the notAllowed (4.20) and empty (4.21) elements.
+func simplifyPattern(n *rngNode) *rngNode {
+	if n.name == "element" {
+		return n
+	}
+	for i, c := range n.children {
+		n.children[i] = simplifyPattern(c)
+	}
+	is := func(i int, name string) bool {
+		return n.children[i].name == name
+	}
+	switch n.name {
+	case "attribute", "list", "oneOrMore":
+		if is(len(n.children)-1, "notAllowed") {
+			return newRngNode("notAllowed")
+		}
+		if n.name == "oneOrMore" && is(0, "empty") {
+			return newRngNode("empty")
+		}
+	case "group", "interleave":
+		if is(0, "notAllowed") || is(1, "notAllowed") {
+			return newRngNode("notAllowed")
+		}
+		if is(0, "empty") {
+			return n.children[1]
+		}
+		if is(1, "empty") {
+			return n.children[0]
+		}
+	case "choice":
+		if is(0, "notAllowed") {
+			return n.children[1]
+		}
+		if is(1, "notAllowed") {
+			return n.children[0]
+		}
+		if is(0, "empty") && is(1, "empty") {
+			return n.children[0]
+		}
+		if is(1, "empty") {
+			n.children[0], n.children[1] = n.children[1], n.children[0]
+		}
+	case "data":
+		last := len(n.children) - 1
+		if last >= 0 && is(last, "except") && n.children[last].children[0].name == "notAllowed" {
+			n.children = n.children[:last]
+		}
+	}
+	return n
+}
+
+//Prohibited paths as specified in section 7.1
+var prohibited = map[string][]string{
+	"attribute": {"attribute", "ref"},
+	"list":      {"list", "ref", "attribute", "text", "interleave"},
+	"except":    {"attribute", "ref", "text", "list", "group", "interleave", "oneOrMore", "empty"},
+	"start":     {"attribute", "data", "value", "text", "list", "group", "interleave", "oneOrMore", "empty"},
+}
+
+func checkPaths(start *rngNode, defs map[string]*rngNode) error {
+	if err := checkPath(newRngNode("start", start), nil); err != nil {
+		return err
+	}
+	for _, d := range defs {
+		if err := checkNameClass(d.children[0], false, false); err != nil {
+			return err
+		}
+		if err := checkPath(d.children[1], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPath(n *rngNode, ancestors []string) error {
+	for i, a := range ancestors {
+		for _, p := range prohibited[a] {
+			if p == n.name {
+				return fmt.Errorf("%s is not allowed inside %s", n.name, a)
+			}
+		}
+		if n.name == "attribute" && a == "oneOrMore" {
+			for _, b := range ancestors[i+1:] {
+				if b == "group" || b == "interleave" {
+					return fmt.Errorf("attribute is not allowed inside oneOrMore//%s", b)
+				}
+			}
+		}
+	}
+	if n.name == "element" {
+		return nil
+	}
+	if n.name == "attribute" {
+		if err := checkAttributeName(n.children[0]); err != nil {
+			return err
+		}
+	}
+	switch n.name {
+	case "anyName", "nsName", "name":
+		return checkNameClass(n, false, false)
+	}
+	if n.name == "except" && len(ancestors) > 0 && ancestors[len(ancestors)-1] != "data" {
+		return nil
+	}
+	for _, c := range n.children {
+		if err := checkPath(c, append(ancestors, n.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checkNameClass checks the constraints on except in name classes (4.16).
+func checkNameClass(n *rngNode, inAnyNameExcept, inNsNameExcept bool) error {
+	switch n.name {
+	case "anyName":
+		if inAnyNameExcept || inNsNameExcept {
+			return fmt.Errorf("anyName is not allowed inside except")
+		}
+		inAnyNameExcept = true
+	case "nsName":
+		if inNsNameExcept {
+			return fmt.Errorf("nsName is not allowed inside nsName//except")
+		}
+		inNsNameExcept = true
+	}
+	for _, c := range n.children {
+		if err := checkNameClass(c, inAnyNameExcept, inNsNameExcept); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checkAttributeName checks that the attribute name class does not include xmlns (4.16).
+func checkAttributeName(n *rngNode) error {
+	switch n.name {
+	case "name":
+		if n.text == "xmlns" && n.attrs["ns"] == "" {
+			return fmt.Errorf("attribute name xmlns is not allowed")
+		}
+		if n.attrs["ns"] == "http://www.w3.org/2000/xmlns" {
+			return fmt.Errorf("attribute namespace %s is not allowed", n.attrs["ns"])
+		}
+	case "nsName":
+		if n.attrs["ns"] == "http://www.w3.org/2000/xmlns" {
+			return fmt.Errorf("attribute namespace %s is not allowed", n.attrs["ns"])
+		}
+	}
+	for _, c := range n.children {
+		if err := checkAttributeName(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//grammar converts the simplified nodes into a Grammar.
+func (this *simplifier) grammar(start *rngNode) (*Grammar, error) {
+	g := &Grammar{}
+	var err error
+	g.Start, err = toNameOrPattern(start)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range this.order {
+		d := this.defs[name]
+		left, err := toNameOrPattern(d.children[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := toNameOrPattern(d.children[1])
+		if err != nil {
+			return nil, err
+		}
+		g.Define = append(g.Define, Define{
+			Name:                name,
+			Element:             Pair{Left: left, Right: right},
+			Annotations:         d.annotations,
+			ElementForeignAttrs: d.foreignAttrs,
+		})
+	}
+	return g, nil
+}
+
+//toGrammar converts the nodes of a grammar, that is already simplified, into a Grammar.
+func toGrammar(n *rngNode) (*Grammar, error) {
+	if n.name != "grammar" {
+		return nil, fmt.Errorf("expected a grammar, but got %s", n.name)
+	}
+	g := &Grammar{Annotations: n.annotations, ForeignAttrs: n.foreignAttrs}
+	for _, c := range n.children {
+		if len(c.children) != 1 {
+			return nil, fmt.Errorf("%s requires one child", c.name)
+		}
+		switch c.name {
+		case "start":
+			start, err := toNameOrPattern(c.children[0])
+			if err != nil {
+				return nil, err
+			}
+			start.Annotations = append(c.annotations, start.Annotations...)
+			start.ForeignAttrs = append(c.foreignAttrs, start.ForeignAttrs...)
+			g.Start = start
+		case "define":
+			e := c.children[0]
+			if e.name != "element" {
+				return nil, fmt.Errorf("define %s without an element", c.attrs["name"])
+			}
+			element, err := toPair(e)
+			if err != nil {
+				return nil, err
+			}
+			g.Define = append(g.Define, Define{
+				Name:                c.attrs["name"],
+				Element:             *element,
+				Annotations:         append(c.annotations, e.annotations...),
+				ForeignAttrs:        c.foreignAttrs,
+				ElementForeignAttrs: e.foreignAttrs,
+			})
+		default:
+			return nil, fmt.Errorf("unexpected %s in grammar", c.name)
+		}
+	}
+	if g.Start == nil {
+		return nil, fmt.Errorf("grammar without a start")
+	}
+	return g, nil
+}
+
+func toPair(n *rngNode) (*Pair, error) {
+	if len(n.children) != 2 {
+		return nil, fmt.Errorf("%s requires two children", n.name)
+	}
+	left, err := toNameOrPattern(n.children[0])
+	if err != nil {
+		return nil, err
+	}
+	right, err := toNameOrPattern(n.children[1])
+	if err != nil {
+		return nil, err
+	}
+	return &Pair{Left: left, Right: right}, nil
+}
+
+func toExcept(n *rngNode) (*NameOrPattern, error) {
+	for _, c := range n.children {
+		if c.name == "except" {
+			return toNameOrPattern(c.children[0])
+		}
+	}
+	return nil, nil
+}
+
+func toNameOrPattern(n *rngNode) (*NameOrPattern, error) {
+	p := &NameOrPattern{}
+	var err error
+	switch n.name {
+	case "notAllowed":
+		p.NotAllowed = &NotAllowed{}
+	case "empty":
+		p.Empty = &Empty{}
+	case "text":
+		p.Text = &Text{}
+	case "data":
+		p.Data = &Data{Type: n.attrs["type"], DatatypeLibrary: n.attrs["datatypeLibrary"]}
+		for _, c := range n.children {
+			if c.name == "param" {
+				p.Data.Param = append(p.Data.Param, Param{Name: strings.TrimSpace(c.attrs["name"]), Text: c.text})
+			}
+		}
+		p.Data.Except, err = toExcept(n)
+	case "value":
+		p.Value = &Value{
+			Type:            n.attrs["type"],
+			DatatypeLibrary: n.attrs["datatypeLibrary"],
+			Ns:              n.attrs["ns"],
+			Text:            n.text,
+		}
+		//The built-in datatypes do not depend on the namespace context.
+		if len(p.Value.DatatypeLibrary) == 0 {
+			p.Value.Ns = ""
+		}
+	case "list":
+		var inside *NameOrPattern
+		inside, err = toNameOrPattern(n.children[0])
+		p.List = &List{NameOrPattern: inside}
+	case "attribute":
+		p.Attribute, err = toPair(n)
+	case "ref":
+		p.Ref = &Ref{Name: n.attrs["name"]}
+	case "oneOrMore":
+		var inside *NameOrPattern
+		inside, err = toNameOrPattern(n.children[0])
+		p.OneOrMore = &OneOrMore{NameOrPattern: inside}
+	case "choice":
+		p.Choice, err = toPair(n)
+	case "group":
+		p.Group, err = toPair(n)
+	case "interleave":
+		p.Interleave, err = toPair(n)
+	case "anyName":
+		p.AnyName = &AnyNameClass{}
+		p.AnyName.Except, err = toExcept(n)
+	case "nsName":
+		p.NsName = &NsNameClass{Ns: n.attrs["ns"]}
+		p.NsName.Except, err = toExcept(n)
+	case "name":
+		p.Name = &NameNameClass{Ns: n.attrs["ns"], Text: n.text}
+	default:
+		return nil, fmt.Errorf("unexpected %s", n.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Annotations, p.ForeignAttrs = n.annotations, n.foreignAttrs
+	return p, nil
+}
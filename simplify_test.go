@@ -0,0 +1,181 @@
+package relaxng
+
+import (
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestSimplifySuite(t *testing.T) {
+	suite := scanFiles()
+	passed := 0
+	rejected := 0
+	incorrect := 0
+	for _, spec := range suite {
+		num := testNumber(spec.Filename)
+		dir := filepath.Dir(spec.Filename)
+		if len(spec.SimpleFilename) == 0 {
+			incorrect++
+			if _, err := SimplifyFile(filepath.Join(dir, "i.rng")); err != nil {
+				rejected++
+			}
+			continue
+		}
+		t.Run(num, func(t *testing.T) {
+			if namespaces[num] {
+				t.Skip("namespaces not supported")
+			}
+			if datatypeLibrary[num] {
+				t.Skip("datatypeLibrary not supported")
+			}
+			debugStr := ""
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("%srecover for %s: %v: %s", debugStr, spec.Filename, r, debug.Stack())
+				}
+			}()
+			filename := filepath.Join(dir, "c.rng")
+			g, err := SimplifyFile(filename)
+			if err != nil {
+				t.Fatalf("%sunexpected error <%s> for %s", debugStr, err, filename)
+			}
+			if testGrammar(t, spec, g, debugStr, false) {
+				passed++
+			} else {
+				t.Fatal()
+			}
+		})
+	}
+	t.Logf("passed: %d, incorrect grammars rejected: %d of %d", passed, rejected, incorrect)
+}
+
+func TestSimplify(t *testing.T) {
+	full := `<element name="addressBook" xmlns="http://relaxng.org/ns/structure/1.0"
+		xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0">
+		<a:documentation>An address book</a:documentation>
+		<zeroOrMore>
+			<element name="card">
+				<element name="name"><text/></element>
+				<optional>
+					<element name="email"><text/></element>
+				</optional>
+				<attribute name="kind">
+					<choice>
+						<value>home</value>
+						<value>work</value>
+					</choice>
+				</attribute>
+			</element>
+		</zeroOrMore>
+	</element>`
+	g, err := Simplify([]byte(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := g.String()
+	t.Logf("%s", s)
+	if !strings.Contains(s, `<a:documentation xmlns:a="http://relaxng.org/ns/compatibility/annotations/1.0">An address book</a:documentation>`) {
+		t.Fatalf("expected the annotation to be kept")
+	}
+	if strings.Contains(s, "zeroOrMore") || strings.Contains(s, "optional") {
+		t.Fatalf("expected zeroOrMore and optional to be simplified")
+	}
+	if len(g.Define) != 4 {
+		t.Fatalf("expected a define for every element, but got %d", len(g.Define))
+	}
+	relapse, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(relapse, []byte(`<addressBook><card kind="home"><name>a</name></card></addressBook>`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(relapse, []byte(`<addressBook><card kind="other"><name>a</name></card></addressBook>`)); err == nil {
+		t.Fatalf("expected kind to be invalid")
+	}
+}
+
+func TestSimplifyErrors(t *testing.T) {
+	incorrect := map[string]string{
+		"undefined":  `<grammar xmlns="http://relaxng.org/ns/structure/1.0"><start><ref name="a"/></start></grammar>`,
+		"duplicate":  `<grammar xmlns="http://relaxng.org/ns/structure/1.0"><start><ref name="a"/></start><define name="a"><empty/></define><define name="a"><text/></define></grammar>`,
+		"recursive":  `<grammar xmlns="http://relaxng.org/ns/structure/1.0"><start><element name="a"><ref name="b"/></element></start><define name="b"><choice><ref name="b"/><empty/></choice></define></grammar>`,
+		"attribute":  `<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><attribute name="b"><element name="c"><empty/></element></attribute></element>`,
+		"start":      `<grammar xmlns="http://relaxng.org/ns/structure/1.0"><start><text/></start></grammar>`,
+		"parentRef":  `<grammar xmlns="http://relaxng.org/ns/structure/1.0"><start><parentRef name="a"/></start></grammar>`,
+		"unknown":    `<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><foo/></element>`,
+		"no pattern": `<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"/>`,
+		"attr":       `<element name="a" extra="b" xmlns="http://relaxng.org/ns/structure/1.0"><empty/></element>`,
+		"children":   `<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><empty><text/></empty></element>`,
+		"ncname":     `<element name="a b" xmlns="http://relaxng.org/ns/structure/1.0"><empty/></element>`,
+		"library":    `<element name="a" datatypeLibrary="relative" xmlns="http://relaxng.org/ns/structure/1.0"><empty/></element>`,
+	}
+	for name, rng := range incorrect {
+		if _, err := Simplify([]byte(rng)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestFragment(t *testing.T) {
+	full := `<grammar xmlns="http://relaxng.org/ns/structure/1.0">
+		<start>
+			<element name="feed">
+				<zeroOrMore><ref name="record"/></zeroOrMore>
+			</element>
+		</start>
+		<define name="record">
+			<choice>
+				<ref name="item"/>
+				<element name="deleted"><empty/></element>
+			</choice>
+		</define>
+		<define name="item">
+			<element name="item">
+				<attribute name="id"/>
+				<text/>
+			</element>
+		</define>
+	</grammar>`
+	validate := func(g *Grammar, xml string) error {
+		relapse, err := Translate(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Validate(relapse, []byte(xml))
+	}
+	g, err := SimplifyFragment([]byte(full), "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validate(g, `<item id="1">a</item>`); err != nil {
+		t.Fatalf("expected item to be a valid record, got %v", err)
+	}
+	if err := validate(g, `<deleted/>`); err != nil {
+		t.Fatalf("expected deleted to be a valid record, got %v", err)
+	}
+	if err := validate(g, `<feed/>`); err == nil {
+		t.Fatalf("expected feed not to be a valid record")
+	}
+	if _, err := SimplifyFragment([]byte(full), "missing"); err == nil {
+		t.Fatalf("expected an error for a missing define")
+	}
+	g, err = Simplify([]byte(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := Fragment(g, "item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validate(item, `<item>a</item>`); err == nil {
+		t.Fatalf("expected item without an id to be invalid")
+	}
+	if err := validate(item, `<item id="2"/>`); err != nil {
+		t.Fatalf("expected item to be valid, got %v", err)
+	}
+	if _, err := Fragment(g, "record"); err == nil {
+		t.Fatalf("expected an error, since record is not a define of the simplified grammar")
+	}
+}
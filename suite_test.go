@@ -142,6 +142,10 @@ func testSimple(t *testing.T, spec testCase, debugParser bool) bool {
 		t.Fatalf("%sunparsable %s", debugStr, spec.SimpleFilename)
 	}
 	RemoveTODOs(g)
+	return testGrammar(t, spec, g, debugStr, debugParser)
+}
+
+func testGrammar(t *testing.T, spec testCase, g *Grammar, debugStr string, debugParser bool) bool {
 	debugStr += fmt.Sprintf("Parsed:\n%s\n", g.String())
 	katydid, err := Translate(g)
 	if err != nil {
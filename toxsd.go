@@ -0,0 +1,947 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+//Approximation is a construct of a grammar that could not be exported exactly.
+type Approximation struct {
+	//Define is the name of the define in which the construct occurs.
+	Define string
+	//Message describes the construct and how it was approximated.
+	Message string
+	//Looser is true if the export accepts documents that the grammar rejects
+	//and false if the export rejects documents that the grammar accepts.
+	Looser bool
+}
+
+func (this *Approximation) String() string {
+	strictness := "stricter"
+	if this.Looser {
+		strictness = "looser"
+	}
+	return fmt.Sprintf("%s: %s: %s", this.Define, strictness, this.Message)
+}
+
+//ToXSD exports a simplified Grammar as a W3C XML Schema.
+//Every define becomes a named type and the elements referenced by the start pattern become global elements.
+//The target namespace is the namespace of the first element in the start pattern.
+//Constructs that XSD cannot express, like interleave of repeated elements, choices between attributes and elements,
+//elements in other namespaces and content models that are not deterministic,
+//are approximated and returned as Approximations.
+func ToXSD(g *Grammar) ([]byte, []*Approximation, error) {
+	e := &toXSD{defines: make(map[string]*Define), typeNames: make(map[string]bool)}
+	for i := range g.Define {
+		e.defines[g.Define[i].Name] = &g.Define[i]
+		e.typeNames[g.Define[i].Name] = true
+	}
+	roots := refs(g.Start)
+	if len(roots) == 0 {
+		return nil, nil, errors.New("the start pattern does not reference any elements")
+	}
+	for _, root := range roots {
+		if d, ok := e.defines[root]; ok && d.Element.Left.Name != nil {
+			e.tns = d.Element.Left.Name.Ns
+			break
+		}
+	}
+	schema := newRngNodeAttrs("xs:schema", map[string]string{"xmlns:xs": xsdNamespace})
+	if len(e.tns) > 0 {
+		schema.attrs["targetNamespace"] = e.tns
+		schema.attrs["xmlns"] = e.tns
+		schema.attrs["elementFormDefault"] = "qualified"
+	}
+	var elements []*rngNode
+	global := make(map[string]bool)
+	for _, root := range roots {
+		e.define = root
+		d, ok := e.defines[root]
+		if !ok {
+			return nil, nil, fmt.Errorf("undefined reference %s", root)
+		}
+		if d.Element.Left.Name == nil {
+			e.approximate(false, "a root element with a name class is omitted")
+			continue
+		}
+		name := d.Element.Left.Name
+		if name.Ns != e.tns {
+			e.approximate(false, "the root element %s, in namespace %q, is omitted", name.Text, name.Ns)
+			continue
+		}
+		if global[name.Text] {
+			e.approximate(false, "the root element %s is declared by more than one define", name.Text)
+			continue
+		}
+		global[name.Text] = true
+		elements = append(elements, newRngNodeAttrs("xs:element", map[string]string{"name": name.Text, "type": root}))
+	}
+	if len(elements) == 0 {
+		return nil, e.approximations, errors.New("none of the root elements can be exported")
+	}
+	for _, d := range g.Define {
+		e.define = d.Name
+		if !isNameChoice(d.Element.Left) {
+			continue
+		}
+		e.types = append(e.types, e.typ(&d))
+	}
+	if e.importsXML {
+		schema.children = append(schema.children, newRngNodeAttrs("xs:import", map[string]string{
+			"namespace": xmlNs, "schemaLocation": "http://www.w3.org/2001/xml.xsd",
+		}))
+	}
+	schema.children = append(schema.children, elements...)
+	schema.children = append(schema.children, e.types...)
+	buf := bytes.NewBuffer(nil)
+	writeNode(buf, schema, 0)
+	return buf.Bytes(), e.approximations, nil
+}
+
+type toXSD struct {
+	defines        map[string]*Define
+	typeNames      map[string]bool
+	tns            string
+	define         string
+	importsXML     bool
+	types          []*rngNode
+	approximations []*Approximation
+}
+
+func (this *toXSD) approximate(looser bool, format string, args ...interface{}) {
+	a := &Approximation{Define: this.define, Message: fmt.Sprintf(format, args...), Looser: looser}
+	for _, other := range this.approximations {
+		if *other == *a {
+			return
+		}
+	}
+	this.approximations = append(this.approximations, a)
+}
+
+//isNameChoice returns whether the name class is a name or a choice of names.
+func isNameChoice(n *NameOrPattern) bool {
+	if n.Choice != nil {
+		return isNameChoice(n.Choice.Left) && isNameChoice(n.Choice.Right)
+	}
+	return n.Name != nil
+}
+
+//uniqueType returns a type name, that is not a define name, with the prefix.
+func (this *toXSD) uniqueType(prefix string) string {
+	name := prefix
+	for i := 1; this.typeNames[name]; i++ {
+		name = prefix + "_" + strconv.Itoa(i)
+	}
+	this.typeNames[name] = true
+	return name
+}
+
+//xsdContent is the exported content of an element.
+type xsdContent struct {
+	attrs    []*rngNode
+	names    map[xml.Name]*rngNode
+	wildcard []string
+	mixed    bool
+}
+
+//typ returns the named simple or complex type for the define.
+func (this *toXSD) typ(d *Define) *rngNode {
+	c := &xsdContent{names: make(map[xml.Name]*rngNode)}
+	content := this.strip(d.Element.Right, true, c)
+	if len(c.wildcard) > 0 {
+		c.attrs = append(c.attrs, this.anyAttribute(c.wildcard))
+	}
+	if isSimple(content) {
+		s := this.simpleType(content)
+		if len(c.attrs) == 0 {
+			return namedSimpleType(d.Name, s)
+		}
+		base := s.base
+		if len(base) == 0 {
+			base = this.uniqueType(d.Name + "-value")
+			this.types = append(this.types, namedSimpleType(base, s))
+		}
+		extension := newRngNodeAttrs("xs:extension", map[string]string{"base": base}, c.attrs...)
+		return newRngNodeAttrs("xs:complexType", map[string]string{"name": d.Name}, newRngNode("xs:simpleContent", extension))
+	}
+	t := newRngNodeAttrs("xs:complexType", map[string]string{"name": d.Name})
+	p := this.particle(content, true, c)
+	if p != nil {
+		this.consistent(p)
+		if !deterministic(p) {
+			this.approximate(true, "the content model is not deterministic and is replaced with a repeated choice of its elements")
+			p = repeatedChoice(p)
+		}
+		if p.kind == "element" || p.kind == "any" {
+			p = &xsdParticle{kind: "sequence", min: 1, max: 1, children: []*xsdParticle{p}}
+		}
+		t.children = append(t.children, p.node())
+	}
+	if c.mixed {
+		t.attrs["mixed"] = "true"
+	}
+	t.children = append(t.children, c.attrs...)
+	return t
+}
+
+//strip removes the attributes from the pattern and adds them to the content.
+func (this *toXSD) strip(p *NameOrPattern, required bool, c *xsdContent) *NameOrPattern {
+	switch {
+	case p.Attribute != nil:
+		this.attribute(p.Attribute, required, c)
+		return &NameOrPattern{Empty: &Empty{}}
+	case p.Group != nil && hasAttr(p):
+		return pairOf("group", this.strip(p.Group.Left, required, c), this.strip(p.Group.Right, required, c))
+	case p.Interleave != nil && hasAttr(p):
+		return pairOf("interleave", this.strip(p.Interleave.Left, required, c), this.strip(p.Interleave.Right, required, c))
+	case p.OneOrMore != nil && hasAttr(p):
+		inner := p.OneOrMore.NameOrPattern
+		if inner.Attribute == nil && hasContent(inner) {
+			this.approximate(true, "repeated attributes and content are exported as optional attributes")
+		}
+		inner = this.strip(inner, required, c)
+		if inner.Empty != nil {
+			return inner
+		}
+		return &NameOrPattern{OneOrMore: &OneOrMore{NameOrPattern: inner}}
+	case p.Choice != nil && hasAttr(p):
+		if opt, ok := optionalPattern(p); ok && (opt.Attribute != nil || opt.OneOrMore != nil && opt.OneOrMore.Attribute != nil) {
+			return this.strip(opt, false, c)
+		}
+		if opt, ok := optionalPattern(p); ok && !hasContent(opt) {
+			this.approximate(true, "attributes that occur together are exported as independent optional attributes")
+			return this.strip(opt, false, c)
+		}
+		this.approximate(true, "a choice between attributes, or between attributes and content, is exported as optional attributes")
+		return pairOf("choice", this.strip(p.Choice.Left, false, c), this.strip(p.Choice.Right, false, c))
+	}
+	return p
+}
+
+//pairOf returns the group, interleave or choice of the patterns, where empty operands of groups and interleaves are left out.
+func pairOf(kind string, left, right *NameOrPattern) *NameOrPattern {
+	switch {
+	case kind == "choice" && left.Empty != nil && right.Empty != nil:
+		return left
+	case kind == "choice":
+		return &NameOrPattern{Choice: newPair(left, right)}
+	case left.Empty != nil:
+		return right
+	case right.Empty != nil:
+		return left
+	case kind == "group":
+		return &NameOrPattern{Group: newPair(left, right)}
+	}
+	return &NameOrPattern{Interleave: newPair(left, right)}
+}
+
+//hasContent returns whether the pattern contains something other than attributes and empty.
+func hasContent(p *NameOrPattern) bool {
+	switch {
+	case p.Attribute != nil, p.Empty != nil:
+		return false
+	case p.OneOrMore != nil:
+		return hasContent(p.OneOrMore.NameOrPattern)
+	case p.Choice != nil:
+		return hasContent(p.Choice.Left) || hasContent(p.Choice.Right)
+	case p.Group != nil:
+		return hasContent(p.Group.Left) || hasContent(p.Group.Right)
+	case p.Interleave != nil:
+		return hasContent(p.Interleave.Left) || hasContent(p.Interleave.Right)
+	}
+	return true
+}
+
+//attribute adds an attribute declaration or wildcard to the content.
+func (this *toXSD) attribute(a *Pair, required bool, c *xsdContent) {
+	if a.Left.Name == nil {
+		this.attributeWildcard(a, required, c)
+		return
+	}
+	name := xml.Name{Space: a.Left.Name.Ns, Local: a.Left.Name.Text}
+	if existing, ok := c.names[name]; ok {
+		if !required {
+			delete(existing.attrs, "use")
+		}
+		return
+	}
+	var decl *rngNode
+	switch {
+	case len(name.Space) == 0:
+		decl = newRngNodeAttrs("xs:attribute", map[string]string{"name": name.Local})
+	case name.Space == this.tns:
+		decl = newRngNodeAttrs("xs:attribute", map[string]string{"name": name.Local, "form": "qualified"})
+	case name.Space == xmlNs:
+		this.importsXML = true
+		c.names[name] = newRngNodeAttrs("xs:attribute", map[string]string{"ref": "xml:" + name.Local})
+		c.attrs = append(c.attrs, c.names[name])
+		if required {
+			c.names[name].attrs["use"] = "required"
+		}
+		return
+	default:
+		this.approximate(true, "attribute %s, in namespace %q, is exported as an attribute wildcard", name.Local, name.Space)
+		c.wildcard = append(c.wildcard, name.Space)
+		return
+	}
+	if required {
+		decl.attrs["use"] = "required"
+	}
+	if a.Right.Text == nil {
+		this.simpleType(a.Right).set(decl)
+	}
+	c.names[name] = decl
+	c.attrs = append(c.attrs, decl)
+}
+
+func (this *toXSD) attributeWildcard(a *Pair, required bool, c *xsdContent) {
+	if required {
+		this.approximate(true, "a required attribute with a name class is exported as optional")
+	}
+	if a.Right.Text == nil {
+		this.approximate(true, "the values of attributes with a name class are not restricted")
+	}
+	switch {
+	case a.Left.AnyName != nil:
+		if a.Left.AnyName.Except != nil {
+			this.approximate(true, "the except of an attribute name class is ignored")
+		}
+		c.wildcard = append(c.wildcard, "##any")
+	case a.Left.NsName != nil:
+		if a.Left.NsName.Except != nil {
+			this.approximate(true, "the except of an attribute name class is ignored")
+		}
+		c.wildcard = append(c.wildcard, a.Left.NsName.Ns)
+	case a.Left.Choice != nil:
+		this.attributeWildcard(newPair(a.Left.Choice.Left, a.Right), false, c)
+		this.attributeWildcard(newPair(a.Left.Choice.Right, a.Right), false, c)
+	}
+}
+
+func (this *toXSD) anyAttribute(namespaces []string) *rngNode {
+	return newRngNodeAttrs("xs:anyAttribute", map[string]string{"namespace": wildcardNamespace(namespaces), "processContents": "skip"})
+}
+
+//wildcardNamespace returns the namespace constraint of a wildcard for the namespaces, where ##any allows all.
+func wildcardNamespace(namespaces []string) string {
+	seen := make(map[string]bool)
+	var list []string
+	for _, ns := range namespaces {
+		if ns == "##any" {
+			return "##any"
+		}
+		if len(ns) == 0 {
+			ns = "##local"
+		}
+		if !seen[ns] {
+			seen[ns] = true
+			list = append(list, ns)
+		}
+	}
+	s := ""
+	for i, ns := range list {
+		if i > 0 {
+			s += " "
+		}
+		s += ns
+	}
+	return s
+}
+
+//xsdParticle is an element, wildcard or model group of a content model.
+type xsdParticle struct {
+	kind     string
+	name     xml.Name
+	typeName string
+	//namespace is the namespace constraint of a wildcard.
+	namespace string
+	min       int
+	//max is -1 for unbounded.
+	max      int
+	children []*xsdParticle
+}
+
+func (this *xsdParticle) node() *rngNode {
+	var n *rngNode
+	switch this.kind {
+	case "element":
+		n = newRngNodeAttrs("xs:element", map[string]string{"name": this.name.Local})
+		if len(this.typeName) > 0 {
+			n.attrs["type"] = this.typeName
+		}
+	case "any":
+		n = newRngNodeAttrs("xs:any", map[string]string{"namespace": this.namespace, "processContents": "lax"})
+	default:
+		n = newRngNode("xs:" + this.kind)
+		for _, c := range this.children {
+			n.children = append(n.children, c.node())
+		}
+	}
+	if this.min != 1 {
+		n.attrs["minOccurs"] = strconv.Itoa(this.min)
+	}
+	if this.max == -1 {
+		n.attrs["maxOccurs"] = "unbounded"
+	} else if this.max != 1 {
+		n.attrs["maxOccurs"] = strconv.Itoa(this.max)
+	}
+	return n
+}
+
+//leaves returns the elements and wildcards in the particle.
+func (this *xsdParticle) leaves() []*xsdParticle {
+	if this.kind == "element" || this.kind == "any" {
+		return []*xsdParticle{this}
+	}
+	var ls []*xsdParticle
+	for _, c := range this.children {
+		ls = append(ls, c.leaves()...)
+	}
+	return ls
+}
+
+//particle returns the particle for the content pattern, without attributes, or nil if it is empty.
+//top is true if the pattern is only nested in interleaves, where text is the same as mixed content.
+func (this *toXSD) particle(p *NameOrPattern, top bool, c *xsdContent) *xsdParticle {
+	switch {
+	case p.Empty != nil:
+		return nil
+	case p.NotAllowed != nil:
+		return &xsdParticle{kind: "choice", min: 1, max: 1}
+	case p.Text != nil:
+		c.mixed = true
+		if !top {
+			this.approximate(true, "text in a sequence or choice is exported as mixed content, where text is allowed between all elements")
+		}
+		return nil
+	case p.Ref != nil:
+		return this.element(p.Ref.Name)
+	case p.Group != nil:
+		return this.model("sequence", this.particles(p, c), 1)
+	case p.Choice != nil:
+		if opt, ok := optionalPattern(p); ok {
+			o := this.particle(opt, false, c)
+			if o != nil {
+				o.min = 0
+			}
+			return o
+		}
+		ps := this.particles(p, c)
+		min := 1
+		var nonEmpty []*xsdParticle
+		for _, child := range ps {
+			if child == nil {
+				min = 0
+			} else {
+				nonEmpty = append(nonEmpty, child)
+			}
+		}
+		return this.model("choice", nonEmpty, min)
+	case p.Interleave != nil:
+		var ps []*xsdParticle
+		for _, child := range flatten(p) {
+			if child.Text != nil {
+				c.mixed = true
+				continue
+			}
+			if q := this.particle(child, false, c); q != nil {
+				ps = append(ps, q)
+			}
+		}
+		if len(ps) == 1 {
+			return ps[0]
+		}
+		all := top
+		for _, q := range ps {
+			if q.kind != "element" || q.max != 1 {
+				all = false
+			}
+		}
+		if all {
+			return this.model("all", ps, 1)
+		}
+		this.approximate(true, "an interleave, that is not of single elements, is exported as a repeated choice of its elements")
+		return repeatedChoice(&xsdParticle{kind: "sequence", children: ps})
+	case p.OneOrMore != nil:
+		q := this.particle(p.OneOrMore.NameOrPattern, false, c)
+		if q != nil {
+			q.max = -1
+		}
+		return q
+	}
+	this.approximate(true, "data in element content is exported as mixed content")
+	c.mixed = true
+	return nil
+}
+
+//particles returns the particles for the operands of a group or choice, where nested operators of the same kind are flattened.
+func (this *toXSD) particles(p *NameOrPattern, c *xsdContent) []*xsdParticle {
+	var ps []*xsdParticle
+	for _, child := range flatten(p) {
+		q := this.particle(child, false, c)
+		if q == nil && p.Group != nil {
+			continue
+		}
+		ps = append(ps, q)
+	}
+	return ps
+}
+
+//flatten returns the operands of nested groups, choices or interleaves of the same kind.
+func flatten(p *NameOrPattern) []*NameOrPattern {
+	var pair *Pair
+	same := func(q *NameOrPattern) bool { return false }
+	switch {
+	case p.Group != nil:
+		pair = p.Group
+		same = func(q *NameOrPattern) bool { return q.Group != nil }
+	case p.Choice != nil:
+		pair = p.Choice
+		same = func(q *NameOrPattern) bool { _, opt := optionalPattern(q); return q.Choice != nil && !opt }
+	case p.Interleave != nil:
+		pair = p.Interleave
+		same = func(q *NameOrPattern) bool { return q.Interleave != nil }
+	default:
+		return []*NameOrPattern{p}
+	}
+	var ps []*NameOrPattern
+	for _, q := range []*NameOrPattern{pair.Left, pair.Right} {
+		if same(q) {
+			ps = append(ps, flatten(q)...)
+		} else {
+			ps = append(ps, q)
+		}
+	}
+	return ps
+}
+
+func (this *toXSD) model(kind string, ps []*xsdParticle, min int) *xsdParticle {
+	if kind != "choice" && len(ps) == 0 {
+		return nil
+	}
+	if len(ps) == 1 {
+		if min == 0 {
+			ps[0].min = 0
+		}
+		return ps[0]
+	}
+	return &xsdParticle{kind: kind, min: min, max: 1, children: ps}
+}
+
+//element returns the particle for a reference to the define of an element.
+func (this *toXSD) element(name string) *xsdParticle {
+	d := this.defines[name]
+	nameClass := d.Element.Left
+	if !isNameChoice(nameClass) {
+		this.approximate(true, "element %s, with a name class, is exported as a wildcard with lax processing", name)
+		return &xsdParticle{kind: "any", namespace: wildcardNamespace(wildcardNamespaces(nameClass)), min: 1, max: 1}
+	}
+	var ps []*xsdParticle
+	for _, n := range nameClassNames(nameClass) {
+		if len(n.Space) > 0 && n.Space != this.tns {
+			this.approximate(true, "element %s, in namespace %q, is exported as a wildcard", n.Local, n.Space)
+			ps = append(ps, &xsdParticle{kind: "any", namespace: n.Space, min: 1, max: 1})
+			continue
+		}
+		ps = append(ps, &xsdParticle{kind: "element", name: n, typeName: name, min: 1, max: 1})
+	}
+	return this.model("choice", ps, 1)
+}
+
+func nameClassNames(n *NameOrPattern) []xml.Name {
+	if n.Choice != nil {
+		return append(nameClassNames(n.Choice.Left), nameClassNames(n.Choice.Right)...)
+	}
+	return []xml.Name{{Space: n.Name.Ns, Local: n.Name.Text}}
+}
+
+func wildcardNamespaces(n *NameOrPattern) []string {
+	switch {
+	case n.Choice != nil:
+		return append(wildcardNamespaces(n.Choice.Left), wildcardNamespaces(n.Choice.Right)...)
+	case n.NsName != nil:
+		return []string{n.NsName.Ns}
+	case n.Name != nil:
+		return []string{n.Name.Ns}
+	}
+	return []string{"##any"}
+}
+
+//consistent gives elements, with the same name and different types, the type anyType,
+//since XSD requires elements with the same name in a content model to have the same type.
+//Types of defines with the same content are the same.
+func (this *toXSD) consistent(p *xsdParticle) {
+	types := make(map[xml.Name]string)
+	conflicts := make(map[xml.Name]bool)
+	leaves := p.leaves()
+	for _, l := range leaves {
+		if l.kind != "element" {
+			continue
+		}
+		t, ok := types[l.name]
+		if !ok {
+			types[l.name] = l.typeName
+			continue
+		}
+		if t == l.typeName {
+			continue
+		}
+		if this.defines[t].Element.Right.String() == this.defines[l.typeName].Element.Right.String() {
+			l.typeName = t
+			continue
+		}
+		conflicts[l.name] = true
+	}
+	for _, l := range leaves {
+		if conflicts[l.name] {
+			this.approximate(true, "elements named %s with different content are exported with any content", l.name.Local)
+			l.typeName = ""
+		}
+	}
+}
+
+//repeatedChoice returns a choice, of the distinct elements in the particle, that can repeat,
+//or a wildcard if the particle contains a wildcard.
+func repeatedChoice(p *xsdParticle) *xsdParticle {
+	seen := make(map[xml.Name]bool)
+	choice := &xsdParticle{kind: "choice", min: 0, max: -1}
+	for _, l := range p.leaves() {
+		if l.kind == "any" {
+			return &xsdParticle{kind: "any", namespace: "##any", min: 0, max: -1}
+		}
+		if seen[l.name] {
+			continue
+		}
+		seen[l.name] = true
+		c := *l
+		c.min, c.max = 1, 1
+		choice.children = append(choice.children, &c)
+	}
+	return choice
+}
+
+//deterministic returns whether the content model satisfies the unique particle attribution constraint of XSD,
+//by checking that the positions, that can follow each position in its Glushkov automaton, do not overlap.
+func deterministic(p *xsdParticle) bool {
+	g := &glushkov{follow: make(map[int][]int)}
+	first, _, _ := g.positions(p)
+	if overlaps(g.leaves, first) {
+		return false
+	}
+	for _, follow := range g.follow {
+		if overlaps(g.leaves, follow) {
+			return false
+		}
+	}
+	return true
+}
+
+type glushkov struct {
+	leaves []*xsdParticle
+	follow map[int][]int
+}
+
+//positions returns the first and last positions of the particle and whether it can be empty.
+func (this *glushkov) positions(p *xsdParticle) (first []int, last []int, nullable bool) {
+	switch p.kind {
+	case "element", "any":
+		i := len(this.leaves)
+		this.leaves = append(this.leaves, p)
+		first, last = []int{i}, []int{i}
+	case "sequence":
+		nullable = true
+		for _, c := range p.children {
+			f, l, n := this.positions(c)
+			for _, x := range last {
+				this.follow[x] = append(this.follow[x], f...)
+			}
+			if nullable {
+				first = append(first, f...)
+			}
+			if n {
+				last = append(last, l...)
+			} else {
+				last = l
+			}
+			nullable = nullable && n
+		}
+	case "choice":
+		for _, c := range p.children {
+			f, l, n := this.positions(c)
+			first, last = append(first, f...), append(last, l...)
+			nullable = nullable || n
+		}
+	case "all":
+		nullable = true
+		var lasts [][]int
+		var firsts [][]int
+		for _, c := range p.children {
+			f, l, n := this.positions(c)
+			firsts, lasts = append(firsts, f), append(lasts, l)
+			first, last = append(first, f...), append(last, l...)
+			nullable = nullable && n
+		}
+		for i := range lasts {
+			for j := range firsts {
+				if i == j {
+					continue
+				}
+				for _, x := range lasts[i] {
+					this.follow[x] = append(this.follow[x], firsts[j]...)
+				}
+			}
+		}
+	}
+	if p.max != 1 {
+		for _, x := range last {
+			this.follow[x] = append(this.follow[x], first...)
+		}
+	}
+	if p.min == 0 {
+		nullable = true
+	}
+	return first, last, nullable
+}
+
+//overlaps returns whether two different positions could match the same element.
+func overlaps(leaves []*xsdParticle, positions []int) bool {
+	for i, x := range positions {
+		for _, y := range positions[i+1:] {
+			if x == y {
+				continue
+			}
+			a, b := leaves[x], leaves[y]
+			if a.kind == "any" || b.kind == "any" || a.name == b.name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//isSimple returns whether the pattern only contains text, datatypes, values and lists.
+func isSimple(p *NameOrPattern) bool {
+	switch {
+	case p.Text != nil, p.Data != nil, p.Value != nil, p.List != nil:
+		return true
+	case p.Choice != nil:
+		return (isSimple(p.Choice.Left) || p.Choice.Left.Empty != nil) && (isSimple(p.Choice.Right) || p.Choice.Right.Empty != nil)
+	}
+	return false
+}
+
+//xsdSimpleType is a builtin or named type in base, or an anonymous simple type.
+type xsdSimpleType struct {
+	base string
+	//restriction, list or union of an anonymous type.
+	node *rngNode
+}
+
+//set sets the type of the element or attribute declaration.
+func (this *xsdSimpleType) set(decl *rngNode) {
+	if len(this.base) > 0 {
+		decl.attrs["type"] = this.base
+		return
+	}
+	decl.children = append(decl.children, newRngNode("xs:simpleType", this.node))
+}
+
+func namedSimpleType(name string, s *xsdSimpleType) *rngNode {
+	n := s.node
+	if n == nil {
+		n = newRngNodeAttrs("xs:restriction", map[string]string{"base": s.base})
+	}
+	return newRngNodeAttrs("xs:simpleType", map[string]string{"name": name}, n)
+}
+
+var xsdFacetParams = map[string]bool{
+	"length": true, "minLength": true, "maxLength": true, "pattern": true, "enumeration": true,
+	"minInclusive": true, "maxInclusive": true, "minExclusive": true, "maxExclusive": true,
+	"totalDigits": true, "fractionDigits": true, "whiteSpace": true,
+}
+
+//builtin returns the XSD type for a datatype.
+func (this *toXSD) builtin(library, typ string) string {
+	switch library {
+	case "":
+		if typ == "string" {
+			return "xs:string"
+		}
+		return "xs:token"
+	case xsdDatatypes:
+		return "xs:" + typ
+	case compatibilityDatatypes:
+		return "xs:" + typ
+	}
+	this.approximate(true, "datatype %s of library %s is exported as string", typ, library)
+	return "xs:string"
+}
+
+func facet(name, value string) *rngNode {
+	return newRngNodeAttrs("xs:"+name, map[string]string{"value": value})
+}
+
+//simpleType returns the simple type for a pattern that only contains text, datatypes, values and lists.
+func (this *toXSD) simpleType(p *NameOrPattern) *xsdSimpleType {
+	switch {
+	case p.Text != nil:
+		return &xsdSimpleType{base: "xs:string"}
+	case p.Empty != nil:
+		//Empty matches white space, which token collapses to the empty string.
+		return &xsdSimpleType{node: newRngNodeAttrs("xs:restriction", map[string]string{"base": "xs:token"}, facet("enumeration", ""))}
+	case p.Data != nil:
+		base := this.builtin(p.Data.DatatypeLibrary, p.Data.Type)
+		if p.Data.Except != nil {
+			this.approximate(true, "the except of data is ignored")
+		}
+		if len(p.Data.Param) == 0 {
+			return &xsdSimpleType{base: base}
+		}
+		r := newRngNodeAttrs("xs:restriction", map[string]string{"base": base})
+		for _, param := range p.Data.Param {
+			if !xsdFacetParams[param.Name] {
+				this.approximate(true, "parameter %s is ignored", param.Name)
+				continue
+			}
+			r.children = append(r.children, facet(param.Name, param.Text))
+		}
+		return &xsdSimpleType{node: r}
+	case p.Value != nil:
+		base := this.builtin(p.Value.DatatypeLibrary, p.Value.Type)
+		return &xsdSimpleType{node: newRngNodeAttrs("xs:restriction", map[string]string{"base": base}, facet("enumeration", p.Value.Text))}
+	case p.List != nil:
+		return this.list(p.List.NameOrPattern)
+	case p.Choice != nil:
+		return this.union(flatten(p))
+	}
+	this.approximate(true, "%s is exported as string", p)
+	return &xsdSimpleType{base: "xs:string"}
+}
+
+//union returns an enumeration, if all the alternatives are values of the same type, or otherwise a union.
+func (this *toXSD) union(alternatives []*NameOrPattern) *xsdSimpleType {
+	var values []*rngNode
+	base := ""
+	for _, a := range alternatives {
+		b := "xs:token"
+		if a.Value != nil {
+			b = this.builtin(a.Value.DatatypeLibrary, a.Value.Type)
+		} else if a.Empty == nil {
+			values = nil
+			break
+		}
+		if len(base) > 0 && b != base {
+			values = nil
+			break
+		}
+		base = b
+		text := ""
+		if a.Value != nil {
+			text = a.Value.Text
+		}
+		values = append(values, facet("enumeration", text))
+	}
+	if values != nil {
+		return &xsdSimpleType{node: newRngNodeAttrs("xs:restriction", map[string]string{"base": base}, values...)}
+	}
+	u := newRngNode("xs:union")
+	members := ""
+	for _, a := range alternatives {
+		s := this.simpleType(a)
+		if len(s.base) > 0 {
+			if len(members) > 0 {
+				members += " "
+			}
+			members += s.base
+			continue
+		}
+		u.children = append(u.children, newRngNode("xs:simpleType", s.node))
+	}
+	if len(members) > 0 {
+		u.attrs["memberTypes"] = members
+	}
+	return &xsdSimpleType{node: u}
+}
+
+//list returns a list type for a list of zero or more, or one or more, items.
+func (this *toXSD) list(p *NameOrPattern) *xsdSimpleType {
+	minLength := ""
+	item := p
+	if opt, ok := optionalPattern(p); ok && opt.OneOrMore != nil {
+		item = opt.OneOrMore.NameOrPattern
+	} else if p.OneOrMore != nil {
+		item = p.OneOrMore.NameOrPattern
+		minLength = "1"
+	} else {
+		this.approximate(true, "a list, that is not a repetition of an item, is exported as a list of any number of items")
+		item = leafUnion(p)
+	}
+	if item.List != nil {
+		this.approximate(true, "a list in a list is exported as string")
+		return &xsdSimpleType{base: "xs:string"}
+	}
+	l := newRngNode("xs:list")
+	this.simpleType(item).setItem(l)
+	if len(minLength) == 0 {
+		return &xsdSimpleType{node: l}
+	}
+	r := newRngNode("xs:restriction", newRngNode("xs:simpleType", l), facet("minLength", minLength))
+	return &xsdSimpleType{node: r}
+}
+
+func (this *xsdSimpleType) setItem(l *rngNode) {
+	if len(this.base) > 0 {
+		l.attrs["itemType"] = this.base
+		return
+	}
+	l.children = append(l.children, newRngNode("xs:simpleType", this.node))
+}
+
+//leafUnion returns the choice of the datatypes and values in a list pattern.
+func leafUnion(p *NameOrPattern) *NameOrPattern {
+	var leaves []*NameOrPattern
+	var collect func(p *NameOrPattern)
+	collect = func(p *NameOrPattern) {
+		switch {
+		case p.Data != nil, p.Value != nil, p.Text != nil:
+			leaves = append(leaves, p)
+		case p.OneOrMore != nil:
+			collect(p.OneOrMore.NameOrPattern)
+		case p.Choice != nil:
+			collect(p.Choice.Left)
+			collect(p.Choice.Right)
+		case p.Group != nil:
+			collect(p.Group.Left)
+			collect(p.Group.Right)
+		case p.Interleave != nil:
+			collect(p.Interleave.Left)
+			collect(p.Interleave.Right)
+		}
+	}
+	collect(p)
+	if len(leaves) == 0 {
+		return &NameOrPattern{Text: &Text{}}
+	}
+	u := leaves[0]
+	for _, l := range leaves[1:] {
+		u = &NameOrPattern{Choice: newPair(u, l)}
+	}
+	return u
+}
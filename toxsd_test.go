@@ -0,0 +1,193 @@
+package relaxng
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//TestToXSDSuite checks that the schema, exported from every correct grammar, can be imported again
+//and that, when it is exact, it agrees with the grammar about the validity of the test documents.
+func TestToXSDSuite(t *testing.T) {
+	exact := 0
+	for _, spec := range scanFiles() {
+		if len(spec.SimpleFilename) == 0 {
+			continue
+		}
+		num := testNumber(spec.Filename)
+		g, err := SimplifyFile(filepath.Join(filepath.Dir(spec.Filename), "c.rng"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refs(g.Start)) == 0 {
+			continue
+		}
+		xsd, approximations, err := ToXSD(g)
+		if err != nil && len(approximations) > 0 {
+			//None of the root elements could be exported.
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", num, err)
+		}
+		imported, err := FromXSD(xsd)
+		_, unsupported := err.(*UnsupportedError)
+		if err != nil && !unsupported {
+			t.Fatalf("%s: %v\n%s", num, err, xsd)
+		}
+		if len(approximations) > 0 || unsupported || anyNameAttributes[num] {
+			continue
+		}
+		exact++
+		for _, x := range spec.Xmls {
+			want, err := Diagnose(g, x.Content)
+			if err != nil {
+				continue
+			}
+			got, err := Diagnose(imported, x.Content)
+			if err != nil {
+				t.Fatalf("%s: %v", num, err)
+			}
+			if (len(want) == 0) != (len(got) == 0) {
+				t.Errorf("%s: %s: expected the exported schema to agree with the grammar %v, but got %v\n%s", num, x.Content, want, got, xsd)
+			}
+		}
+	}
+	t.Logf("exported %d grammars exactly", exact)
+}
+
+func TestToXSD(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" ns="urn:orders" xmlns="http://relaxng.org/ns/structure/1.0"
+		datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+		<attribute name="status">
+			<choice>
+				<value type="token" datatypeLibrary="">open</value>
+				<value type="token" datatypeLibrary="">closed</value>
+			</choice>
+		</attribute>
+		<optional>
+			<attribute name="priority"><data type="int"><param name="minInclusive">1</param></data></attribute>
+		</optional>
+		<optional>
+			<attribute name="xml:lang"/>
+		</optional>
+		<optional>
+			<element name="note"><mixed><zeroOrMore><element name="b"><text/></element></zeroOrMore></mixed></element>
+		</optional>
+		<oneOrMore>
+			<element name="item">
+				<attribute name="tags"><list><oneOrMore><data type="NMTOKEN"/></oneOrMore></list></attribute>
+				<data type="string"/>
+			</element>
+		</oneOrMore>
+		<choice>
+			<element name="card"><attribute name="number"/></element>
+			<element name="cash"><empty/></element>
+		</choice>
+		<element name="payment">
+			<interleave>
+				<element name="paid"><data type="boolean"/></element>
+				<optional><element name="shipped"><data type="date"/></element></optional>
+			</interleave>
+		</element>
+	</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xsd, approximations, err := ToXSD(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approximations) > 0 {
+		t.Fatalf("expected an exact export, but got %v", approximations)
+	}
+	for _, want := range []string{
+		`<xs:schema xmlns="urn:orders" xmlns:xs="http://www.w3.org/2001/XMLSchema" elementFormDefault="qualified" targetNamespace="urn:orders">`,
+		`<xs:import namespace="http://www.w3.org/XML/1998/namespace" schemaLocation="http://www.w3.org/2001/xml.xsd"/>`,
+		`<xs:element name="order" type="order"/>`,
+		`<xs:attribute ref="xml:lang"/>`,
+		`<xs:enumeration value="closed"/>`,
+		`<xs:minInclusive value="1"/>`,
+		`<xs:complexType mixed="true" name="note">`,
+		`<xs:element maxOccurs="unbounded" name="item" type="item"/>`,
+		`<xs:extension base="xs:string">`,
+		`<xs:list itemType="xs:NMTOKEN"/>`,
+		`<xs:all>`,
+		`<xs:element minOccurs="0" name="shipped" type="shipped"/>`,
+		`<xs:simpleType name="paid">`,
+	} {
+		if !strings.Contains(string(xsd), want) {
+			t.Fatalf("expected %s in\n%s", want, xsd)
+		}
+	}
+	imported, err := FromXSD(xsd)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, xsd)
+	}
+	for _, doc := range []string{
+		`<order xmlns="urn:orders" status="open"><item tags="a">x</item><cash/><payment><paid>true</paid></payment></order>`,
+		`<order xmlns="urn:orders" status="closed" priority="2" xml:lang="en"><note>a <b>b</b></note><item tags="a b">x</item><item tags="c">y</item><card number="1"/><payment><shipped>2018-01-01</shipped><paid>false</paid></payment></order>`,
+		`<order xmlns="urn:orders" status="pending"><item tags="a">x</item><cash/><payment><paid>true</paid></payment></order>`,
+		`<order xmlns="urn:orders" status="open"><cash/><payment><paid>true</paid></payment></order>`,
+		`<order xmlns="urn:orders" status="open"><item tags="a">x</item><cash/><card number="1"/><payment><paid>true</paid></payment></order>`,
+		`<order status="open"><item tags="a">x</item><cash/><payment><paid>true</paid></payment></order>`,
+	} {
+		want, _ := Diagnose(g, []byte(doc))
+		got, _ := Diagnose(imported, []byte(doc))
+		if (len(want) == 0) != (len(got) == 0) {
+			t.Errorf("%s: expected the exported schema to agree with the grammar %v, but got %v", doc, want, got)
+		}
+	}
+}
+
+func TestToXSDApproximations(t *testing.T) {
+	g, err := Simplify([]byte(`<grammar xmlns="http://relaxng.org/ns/structure/1.0">
+		<start>
+			<choice>
+				<ref name="a"/>
+				<element name="other" ns="urn:other"><empty/></element>
+			</choice>
+		</start>
+		<define name="a">
+			<element name="a">
+				<choice>
+					<attribute name="href"/>
+					<element name="link"><text/></element>
+				</choice>
+				<interleave>
+					<oneOrMore><element name="x"><empty/></element></oneOrMore>
+					<element name="y"><empty/></element>
+				</interleave>
+				<choice>
+					<group><element name="p"><empty/></element><element name="q"><empty/></element></group>
+					<group><element name="p"><empty/></element><element name="r"><empty/></element></group>
+				</choice>
+				<element name="foreign" ns="urn:other"><empty/></element>
+			</element>
+		</define>
+	</grammar>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xsd, approximations, err := ToXSD(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range approximations {
+		got = append(got, a.String())
+	}
+	want := []string{
+		`other: stricter: the root element other, in namespace "urn:other", is omitted`,
+		`a: looser: a choice between attributes, or between attributes and content, is exported as optional attributes`,
+		`a: looser: an interleave, that is not of single elements, is exported as a repeated choice of its elements`,
+		`a: looser: element foreign, in namespace "urn:other", is exported as a wildcard`,
+		`a: looser: the content model is not deterministic and is replaced with a repeated choice of its elements`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected\n%s\nbut got\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if _, err := FromXSD(xsd); err != nil {
+		t.Fatalf("%v\n%s", err, xsd)
+	}
+}
@@ -30,9 +30,11 @@ func nameConflict(ns []string, name string) bool {
 	return false
 }
 
+//names are the reserved names of the whitespace and text patterns, together with the options.
 type names struct {
 	ws  string
 	any string
+	TranslateOptions
 }
 
 func newReserved(names []string, name string) string {
@@ -42,42 +44,46 @@ func newReserved(names []string, name string) string {
 	return name
 }
 
-func translate(g *Grammar) (*ast.Grammar, error) {
+func translate(g *Grammar, opts TranslateOptions) (*ast.Grammar, error) {
+	opts = opts.withDefaults()
 	ds := make([]string, len(g.Define)+1)
 	ds[0] = "main"
 	for i, d := range g.Define {
 		ds[i+1] = d.Name
 	}
 	reserved := &names{
-		ws:  newReserved(ds, "ws"),
-		any: newReserved(ds, "text"),
+		ws:               newReserved(ds, opts.WhitespaceDefine),
+		any:              newReserved(ds, opts.TextDefine),
+		TranslateOptions: opts,
 	}
 
 	refs := make(ast.RefLookup)
 	refs["main"] = translatePattern(g.Start, false, reserved)
 	for _, d := range g.Define {
 		pattern := translatePattern(d.Element.Right, false, reserved)
-		if !hasNsName(d.Element.Left) {
-			pattern = addXmlns(pattern)
+		if !hasNsName(d.Element.Left) && !opts.NoXmlns {
+			pattern = addXmlns(pattern, reserved)
+		}
+		pattern = newTreeNode(d.Element.Left, pattern, reserved)
+		if !opts.NoWhitespace {
+			pattern = ast.NewInterleave(pattern,
+				ast.NewZeroOrMore(ast.NewReference(reserved.ws)),
+			)
 		}
-		pattern = newTreeNode(d.Element.Left, pattern)
-		pattern = ast.NewInterleave(pattern,
-			ast.NewZeroOrMore(ast.NewReference(reserved.ws)),
-		)
 		refs[d.Name] = pattern
 	}
 
-	refs[reserved.ws] = newWhitespace()
-	refs[reserved.any] = newAnyValue()
+	refs[reserved.ws] = newWhitespace(opts)
+	refs[reserved.any] = newAnyValue(opts)
 	gg := ast.NewGrammar(refs)
 	gg.Format()
 	return gg, nil
 }
 
-func addXmlns(p *ast.Pattern) *ast.Pattern {
+func addXmlns(p *ast.Pattern, reserved *names) *ast.Pattern {
 	return ast.NewConcat(
 		ast.NewOptional(
-			ast.NewTreeNode(ast.NewStringName("attr_xmlns"), ast.NewZAny()),
+			ast.NewTreeNode(ast.NewStringName(reserved.AttrPrefix+"xmlns"), ast.NewZAny()),
 		),
 		p,
 	)
@@ -134,6 +140,9 @@ func translatePattern(p *NameOrPattern, attr bool, reserved *names) *ast.Pattern
 		if attr {
 			return ast.NewReference(reserved.ws)
 		}
+		if reserved.NoWhitespace {
+			return ast.NewEmpty()
+		}
 		return ast.NewOr(
 			ast.NewEmpty(),
 			ast.NewReference(reserved.ws),
@@ -143,13 +152,17 @@ func translatePattern(p *NameOrPattern, attr bool, reserved *names) *ast.Pattern
 		return ast.NewZeroOrMore(ast.NewReference(reserved.any))
 	}
 	if p.Data != nil {
-		if len(p.Data.DatatypeLibrary) > 0 {
+		if p.Data.DatatypeLibrary == xsdDatatypes {
+			return translateXSDData(p.Data, reserved)
+		}
+		//The ID, IDREF and IDREFS types of the DTD compatibility datatypes are validated like token.
+		if len(p.Data.DatatypeLibrary) > 0 && p.Data.DatatypeLibrary != compatibilityDatatypes {
 			panic("data datatypeLibrary not supported")
 		}
 		if p.Data.Except == nil {
 			return ast.NewOr(ast.NewReference(reserved.any), ast.NewEmpty())
 		}
-		expr, nullable := translateLeaf(p.Data.Except)
+		expr, nullable := translateLeaf(p.Data.Except, reserved)
 		v := ast.NewAnd(
 			ast.NewReference(reserved.any),
 			ast.NewNot(expr),
@@ -160,17 +173,17 @@ func translatePattern(p *NameOrPattern, attr bool, reserved *names) *ast.Pattern
 		return ast.NewOr(v, ast.NewEmpty())
 	}
 	if p.Value != nil {
-		v, nullable := translateLeaf(p)
+		v, nullable := translateLeaf(p, reserved)
 		if !nullable {
 			return v
 		}
 		return ast.NewOr(v, ast.NewEmpty())
 	}
 	if p.List != nil {
-		return newList(p.List.NameOrPattern)
+		return newList(p.List.NameOrPattern, reserved.TranslateOptions)
 	}
 	if p.Attribute != nil {
-		nameExpr := translateNameClass(p.Attribute.Left, true)
+		nameExpr := translateNameClass(p.Attribute.Left, true, reserved)
 		pattern := translatePattern(p.Attribute.Right, true, reserved)
 		return ast.NewTreeNode(nameExpr, pattern)
 	}
@@ -208,11 +221,11 @@ func translatePattern(p *NameOrPattern, attr bool, reserved *names) *ast.Pattern
 	panic(fmt.Sprintf("unreachable pattern %v", p))
 }
 
-func newTreeNode(n *NameOrPattern, pattern *ast.Pattern) *ast.Pattern {
+func newTreeNode(n *NameOrPattern, pattern *ast.Pattern, reserved *names) *ast.Pattern {
 	if n.Choice != nil {
 		return ast.NewOr(
-			newTreeNode(n.Choice.Left, pattern),
-			newTreeNode(n.Choice.Right, pattern),
+			newTreeNode(n.Choice.Left, pattern, reserved),
+			newTreeNode(n.Choice.Right, pattern, reserved),
 		)
 	}
 	if n.AnyName != nil {
@@ -222,7 +235,7 @@ func newTreeNode(n *NameOrPattern, pattern *ast.Pattern) *ast.Pattern {
 				pattern,
 			)
 		}
-		except := translateNameClass(n.AnyName.Except, false)
+		except := translateNameClass(n.AnyName.Except, false, reserved)
 		return ast.NewTreeNode(ast.NewAnyNameExcept(except), pattern)
 	}
 	if n.NsName != nil {
@@ -233,30 +246,30 @@ func newTreeNode(n *NameOrPattern, pattern *ast.Pattern) *ast.Pattern {
 	}
 	if n.Name != nil {
 		if len(n.Name.Ns) > 0 {
-			return ast.NewTreeNode(ast.NewStringName("elem_"+n.Name.Text), ast.NewConcat(
-				ast.NewTreeNode(ast.NewStringName("attr_xmlns"),
-					newTextValue(n.Name.Ns),
+			return ast.NewTreeNode(ast.NewStringName(reserved.ElemPrefix+n.Name.Text), ast.NewConcat(
+				ast.NewTreeNode(ast.NewStringName(reserved.AttrPrefix+"xmlns"),
+					newTextValue(n.Name.Ns, reserved.TranslateOptions),
 				),
 				pattern,
 			))
 		}
-		return ast.NewTreeNode(ast.NewStringName("elem_"+n.Name.Text), pattern)
+		return ast.NewTreeNode(ast.NewStringName(reserved.ElemPrefix+n.Name.Text), pattern)
 	}
 	panic(fmt.Sprintf("unreachable nameclass %v", n))
 }
 
-func translateNameClass(n *NameOrPattern, attr bool) *ast.NameExpr {
+func translateNameClass(n *NameOrPattern, attr bool, reserved *names) *ast.NameExpr {
 	if n.Choice != nil {
 		return ast.NewNameChoice(
-			translateNameClass(n.Choice.Left, attr),
-			translateNameClass(n.Choice.Right, attr),
+			translateNameClass(n.Choice.Left, attr, reserved),
+			translateNameClass(n.Choice.Right, attr, reserved),
 		)
 	}
 	if n.AnyName != nil {
 		if n.AnyName.Except == nil {
 			return ast.NewAnyName()
 		}
-		except := translateNameClass(n.AnyName.Except, attr)
+		except := translateNameClass(n.AnyName.Except, attr, reserved)
 		return ast.NewAnyNameExcept(except)
 	}
 	if n.NsName != nil {
@@ -274,31 +287,47 @@ func translateNameClass(n *NameOrPattern, attr bool) *ast.NameExpr {
 			panic(fmt.Sprintf("name ns <%v> is not supported", n.Name.Ns))
 		}
 		if attr {
-			return ast.NewStringName("attr_" + n.Name.Text)
+			return ast.NewStringName(reserved.AttrPrefix + n.Name.Text)
 		}
-		return ast.NewStringName("elem_" + n.Name.Text)
+		return ast.NewStringName(reserved.ElemPrefix + n.Name.Text)
 	}
 	panic(fmt.Sprintf("unreachable nameclass %v", n))
 }
 
-func translateLeaf(p *NameOrPattern) (*ast.Pattern, bool) {
+//translateXSDData only checks the lexical space of the builtin W3C XML Schema datatype, facets given as params are ignored.
+//The empty text, which is also the content of an empty element, is only valid if the datatype accepts it.
+func translateXSDData(d *Data, reserved *names) *ast.Pattern {
+	v := newDatatypeValue(d.Type, reserved.TranslateOptions)
+	nullable := isXSDLexical(d.Type, "")
+	if d.Except != nil {
+		expr, exceptNullable := translateLeaf(d.Except, reserved)
+		v = ast.NewAnd(v, ast.NewNot(expr))
+		nullable = nullable && !exceptNullable
+	}
+	if !nullable {
+		return v
+	}
+	return ast.NewOr(v, ast.NewEmpty())
+}
+
+func translateLeaf(p *NameOrPattern, reserved *names) (*ast.Pattern, bool) {
 	if p.Value != nil {
 		if len(p.Value.Ns) > 0 {
 			panic("value ns not supported")
 		}
 		text := p.Value.Text
 		if p.Value.IsString() {
-			return newTextValue(text), len(text) == 0
+			return newTextValue(text, reserved.TranslateOptions), len(text) == 0
 		}
 		text = strings.Replace(text, "\n", "", -1)
 		text = strings.Replace(text, "\r", "", -1)
 		text = strings.Replace(text, "\t", "", -1)
 		text = strings.TrimSpace(text)
-		return newTokenValue(text), len(text) == 0
+		return newTokenValue(text, reserved.TranslateOptions), len(text) == 0
 	}
 	if p.Choice != nil {
-		l, nl := translateLeaf(p.Choice.Left)
-		r, nr := translateLeaf(p.Choice.Right)
+		l, nl := translateLeaf(p.Choice.Left, reserved)
+		r, nr := translateLeaf(p.Choice.Right, reserved)
 		return ast.NewOr(l, r), nl || nr
 	}
 	panic(fmt.Sprintf("unsupported leaf %v", p))
@@ -0,0 +1,196 @@
+package relaxng
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTranslateOptions(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="a" xmlns="http://relaxng.org/ns/structure/1.0">
+	<attribute name="b"><value>1</value></attribute>
+	<zeroOrMore><element name="c"><empty/></element></zeroOrMore>
+</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := TranslateOptions{
+		ElemPrefix:       "e:",
+		AttrPrefix:       "a:",
+		TextPrefix:       "t:",
+		NoWhitespace:     true,
+		NoXmlns:          true,
+		WhitespaceDefine: "space",
+	}
+	katydid, err := TranslateWithOptions(g, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"space", "text"} {
+		found := false
+		for _, p := range katydid.PatternDecls {
+			found = found || p.Name == name
+		}
+		if !found {
+			t.Fatalf("expected a %s define in\n%s", name, katydid)
+		}
+	}
+	tests := map[string]bool{
+		`<a b="1"><c/><c/></a>`: true,
+		`<a b="1"/>`:            true,
+		`<a b="2"/>`:            false,
+		`<a b="1">  <c/></a>`:   false,
+		`<a b="1"><c> </c></a>`: false,
+	}
+	for xml, want := range tests {
+		if got := ValidateWithOptions(katydid, []byte(xml), opts) == nil; got != want {
+			t.Fatalf("%s: expected valid %v with\n%s", xml, want, katydid)
+		}
+		if err := Validate(katydid, []byte(xml)); err == nil {
+			t.Fatalf("%s: expected the default parser to not match the prefixes of\n%s", xml, katydid)
+		}
+	}
+	defaults, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, xml := range []string{`<a b="1">  <c/></a>`, `<a b="1"><c> </c></a>`} {
+		if err := Validate(defaults, []byte(xml)); err != nil {
+			t.Fatalf("%s: expected valid by default, but got %v", xml, err)
+		}
+	}
+
+	anyName, err := Simplify([]byte(`<element xmlns="http://relaxng.org/ns/structure/1.0"><anyName/><empty/></element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noXmlns, err := TranslateWithOptions(anyName, TranslateOptions{NoXmlns: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(noXmlns, []byte(`<c xmlns=""/>`)); err == nil {
+		t.Fatalf("expected an xmlns attribute to be invalid with\n%s", noXmlns)
+	}
+	defaults, err = Translate(anyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(defaults, []byte(`<c xmlns=""/>`)); err != nil {
+		t.Fatalf("expected an xmlns attribute to be valid by default, but got %v", err)
+	}
+}
+
+func TestTypedTextSuite(t *testing.T) {
+	opts := TranslateOptions{TypedText: true}
+	for _, spec := range scanFiles() {
+		num := testNumber(spec.Filename)
+		if len(spec.SimpleFilename) == 0 || namespaces[num] || datatypeLibrary[num] {
+			continue
+		}
+		g, err := ParseGrammar(spec.SimpleContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		katydid, err := Translate(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typed, err := TranslateWithOptions(g, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, xml := range spec.Xmls {
+			want := Validate(katydid, xml.Content) == nil
+			if got := ValidateWithOptions(typed, xml.Content, opts) == nil; got != want {
+				t.Fatalf("%s: %s: expected valid %v with typed text\n%s", num, xml.Filename, want, typed)
+			}
+		}
+	}
+}
+
+func TestTypedTextMalformed(t *testing.T) {
+	opts := TranslateOptions{TypedText: true}
+	g, err := Simplify([]byte(`<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><zeroOrMore><element name="b"><empty/></element></zeroOrMore></element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := TranslateWithOptions(g, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{`<a><b></a></b>`, `<a></a></a>`, `<a><b>`} {
+		if err := ValidateWithOptions(katydid, []byte(doc), opts); err == nil {
+			t.Errorf("expected %s to be invalid", doc)
+		}
+	}
+}
+
+func TestTypedTextPrefixedAttributes(t *testing.T) {
+	opts := TranslateOptions{TypedText: true}
+	g, err := Simplify([]byte(`<element name="a" xmlns="http://relaxng.org/ns/structure/1.0"><attribute name="foo"><value>1</value></attribute></element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := TranslateWithOptions(g, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateWithOptions(katydid, []byte(`<a xmlns:x="urn:x" foo="1"/>`), opts); err != nil {
+		t.Fatalf("expected the namespace declaration to be left out: %v", err)
+	}
+	if err := ValidateWithOptions(katydid, []byte(`<a xmlns:x="urn:x" x:foo="1"/>`), opts); err == nil {
+		t.Fatal("expected x:foo to be another attribute than foo")
+	}
+	if err := ValidateWithOptions(katydid, []byte(`<a xmlns:x="urn:x" x:foo="2" foo="1"/>`), opts); err == nil {
+		t.Fatal("expected the extra attribute x:foo to be invalid")
+	}
+}
+
+var textHeavyGrammar = `<element name="book" xmlns="http://relaxng.org/ns/structure/1.0">
+	<attribute name="lang"><choice><value>en</value><value>fr</value></choice></attribute>
+	<oneOrMore>
+		<element name="para">
+			<attribute name="style"><value type="token">plain text</value></attribute>
+			<text/>
+		</element>
+	</oneOrMore>
+</element>`
+
+func textHeavyDocument() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<book lang="en">`)
+	for i := 0; i < 200; i++ {
+		buf.WriteString("\n\t<para style=\" plain text \">Lorem ipsum dolor sit amet, consectetur adipiscing elit.</para>")
+	}
+	buf.WriteString("\n</book>")
+	return buf.Bytes()
+}
+
+func benchmarkValidate(b *testing.B, opts TranslateOptions) {
+	g, err := Simplify([]byte(textHeavyGrammar))
+	if err != nil {
+		b.Fatal(err)
+	}
+	katydid, err := TranslateWithOptions(g, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	doc := textHeavyDocument()
+	if err := ValidateWithOptions(katydid, doc, opts); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ValidateWithOptions(katydid, doc, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidatePrefixedText(b *testing.B) {
+	benchmarkValidate(b, TranslateOptions{})
+}
+
+func BenchmarkValidateTypedText(b *testing.B) {
+	benchmarkValidate(b, TranslateOptions{TypedText: true})
+}
@@ -0,0 +1,241 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//treeParser is a parser over a tree of labels, that is built from xml tokens, json or nodes.
+//With typed text, text is a leaf that is only returned by Bytes, without a prefix, and not by String,
+//otherwise the label of a text leaf is its text with the text prefix, like the parser of NewXMLParser.
+type treeParser struct {
+	typed      bool
+	elemPrefix string
+	attrPrefix string
+	textPrefix string
+	stack      []labelFrame
+	cur        labelFrame
+}
+
+type labelNode struct {
+	label    string
+	text     []byte
+	leaf     bool
+	children []*labelNode
+}
+
+type labelFrame struct {
+	nodes []*labelNode
+	i     int
+}
+
+var (
+	errTypedText  = errors.New("text is not a string")
+	errTypedLabel = errors.New("the name of an element or attribute is not bytes")
+	errTypedType  = errors.New("xml only has strings and text")
+)
+
+func newTreeParser(opts TranslateOptions) treeParser {
+	return treeParser{
+		typed:      opts.TypedText,
+		elemPrefix: opts.ElemPrefix,
+		attrPrefix: opts.AttrPrefix,
+		textPrefix: opts.TextPrefix,
+	}
+}
+
+//newElement returns an element, with its attributes as its first children.
+//Prefixed namespace declarations are left out and attributes in a namespace keep their prefix or namespace in their label,
+//so that they do not collide with the attributes without a namespace.
+//The default namespace declaration stays an xmlns attribute, since translated grammars check the namespace of elements with it.
+func (this *treeParser) newElement(name string, attrs []xml.Attr) *labelNode {
+	elem := &labelNode{label: this.elemPrefix + name}
+	for _, a := range attrs {
+		if a.Name.Space == "xmlns" {
+			continue
+		}
+		elem.children = append(elem.children, this.newAttr(attrName(a.Name), a.Value))
+	}
+	return elem
+}
+
+func attrName(name xml.Name) string {
+	if len(name.Space) == 0 {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func (this *treeParser) newAttr(name string, value string) *labelNode {
+	return &labelNode{
+		label:    this.attrPrefix + name,
+		children: []*labelNode{this.newText([]byte(value))},
+	}
+}
+
+//newText returns a text leaf, with the text prefix, unless the text is typed.
+func (this *treeParser) newText(text []byte) *labelNode {
+	if this.typed {
+		return &labelNode{text: text, leaf: true}
+	}
+	return &labelNode{label: this.textPrefix + string(text), leaf: true}
+}
+
+//addText adds the text to the children, where adjacent text is one text leaf and empty text is left out.
+//The text is copied, if it is added to a previous text leaf, otherwise it has to be copied already.
+func (this *treeParser) addText(children []*labelNode, text []byte) []*labelNode {
+	if len(text) == 0 {
+		return children
+	}
+	l := len(children)
+	if l == 0 || !children[l-1].leaf {
+		return append(children, this.newText(text))
+	}
+	if this.typed {
+		children[l-1].text = append(children[l-1].text, text...)
+	} else {
+		children[l-1].label += string(text)
+	}
+	return children
+}
+
+//buildXML returns the root of a tree of the xml tokens, where text outside of the root element,
+//comments, processing instructions and directives are left out.
+//Every end element has to match the start element, since raw tokens are not checked by the decoder.
+func (this *treeParser) buildXML(next func() (xml.Token, error)) (*labelNode, error) {
+	root := &labelNode{}
+	stack := []*labelNode{root}
+	names := []xml.Name{}
+	for {
+		t, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := t.(type) {
+		case xml.StartElement:
+			elem := this.newElement(t.Name.Local, t.Attr)
+			top.children = append(top.children, elem)
+			stack = append(stack, elem)
+			names = append(names, t.Name)
+		case xml.EndElement:
+			if len(names) == 0 {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			if start := names[len(names)-1]; start != t.Name {
+				return nil, fmt.Errorf("element %s is closed by end element %s", start.Local, t.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+			names = names[:len(names)-1]
+		case xml.CharData:
+			if len(stack) > 1 {
+				top.children = this.addText(top.children, t.Copy())
+			}
+		}
+	}
+	if len(stack) != 1 {
+		return nil, errors.New("unexpected EOF")
+	}
+	return root, nil
+}
+
+func (this *treeParser) init(root *labelNode) {
+	this.stack = nil
+	this.cur = labelFrame{root.children, -1}
+}
+
+func (this *treeParser) Next() error {
+	if this.cur.i+1 >= len(this.cur.nodes) {
+		this.cur.i = len(this.cur.nodes)
+		return io.EOF
+	}
+	this.cur.i++
+	return nil
+}
+
+func (this *treeParser) IsLeaf() bool {
+	return this.cur.nodes[this.cur.i].leaf
+}
+
+func (this *treeParser) Down() {
+	n := this.cur.nodes[this.cur.i]
+	this.stack = append(this.stack, this.cur)
+	this.cur = labelFrame{n.children, -1}
+}
+
+func (this *treeParser) Up() {
+	this.cur = this.stack[len(this.stack)-1]
+	this.stack = this.stack[:len(this.stack)-1]
+}
+
+func (this *treeParser) String() (string, error) {
+	n := this.cur.nodes[this.cur.i]
+	if n.leaf && this.typed {
+		return "", errTypedText
+	}
+	return n.label, nil
+}
+
+func (this *treeParser) Bytes() ([]byte, error) {
+	n := this.cur.nodes[this.cur.i]
+	if !n.leaf || !this.typed {
+		return nil, errTypedLabel
+	}
+	return n.text, nil
+}
+
+func (this *treeParser) Double() (float64, error) {
+	return 0, errTypedType
+}
+
+func (this *treeParser) Int() (int64, error) {
+	return 0, errTypedType
+}
+
+func (this *treeParser) Uint() (uint64, error) {
+	return 0, errTypedType
+}
+
+func (this *treeParser) Bool() (bool, error) {
+	return false, errTypedType
+}
+
+//typedParser is the xml parser of TypedText.
+//Elements and attributes are labeled with their prefixed names, like the parser of NewXMLParser,
+//but text is typed.
+type typedParser struct {
+	treeParser
+}
+
+func newTypedParser(opts TranslateOptions) *typedParser {
+	return &typedParser{newTreeParser(opts)}
+}
+
+func (this *typedParser) Init(buf []byte) error {
+	root, err := this.buildXML(xml.NewDecoder(bytes.NewReader(buf)).RawToken)
+	if err != nil {
+		return err
+	}
+	this.init(root)
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+)
+
+//ValidateAndUnmarshal validates the xml against a simplified RelaxNG Grammar and decodes it into v, like xml.Unmarshal,
+//while the xml is only parsed once.
+//The tokens are validated by a Session, as they are read, and only decoded into v, after the whole document is valid,
+//so that v is left untouched if it is not.
+//The Session checks datatypes like Validate does, so both agree on which values are valid,
+//but unlike Validate, it expands the names of elements and attributes with their namespaces.
+//A violation is returned as a *ValidationError, with the path of the element, both as /order[1]/item[2]
+//and in the syntax of the struct tags of encoding/xml, as order>item.
+//Errors of the decoding itself, like a number that does not fit in its field, are returned like xml.Unmarshal does.
+func ValidateAndUnmarshal(g *Grammar, xmlContent []byte, v interface{}) error {
+	s := NewSession(g)
+	dec := xml.NewDecoder(bytes.NewReader(xmlContent))
+	var tokens []xml.Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.Token(tok); err != nil {
+			return err
+		}
+		tokens = append(tokens, xml.CopyToken(tok))
+	}
+	if err := s.Close(); err != nil {
+		return err
+	}
+	return xml.NewTokenDecoder(&tokenSlice{tokens: tokens}).Decode(v)
+}
+
+//tokenSlice is a xml.TokenReader over tokens that were already read.
+type tokenSlice struct {
+	tokens []xml.Token
+}
+
+func (this *tokenSlice) Token() (xml.Token, error) {
+	if len(this.tokens) == 0 {
+		return nil, io.EOF
+	}
+	tok := this.tokens[0]
+	this.tokens = this.tokens[1:]
+	return tok, nil
+}
@@ -0,0 +1,89 @@
+package relaxng
+
+import (
+	"reflect"
+	"testing"
+)
+
+type unmarshalOrder struct {
+	ID    string          `xml:"id,attr"`
+	Items []unmarshalItem `xml:"items>item"`
+}
+
+type unmarshalItem struct {
+	Sku      string `xml:"sku,attr"`
+	Quantity int    `xml:",chardata"`
+}
+
+func TestValidateAndUnmarshal(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="order" ns="urn:orders" xmlns="http://relaxng.org/ns/structure/1.0">
+	<attribute name="id"/>
+	<element name="items">
+		<oneOrMore>
+			<element name="item">
+				<attribute name="sku"/>
+				<text/>
+			</element>
+		</oneOrMore>
+	</element>
+</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order unmarshalOrder
+	if err := ValidateAndUnmarshal(g, []byte(`<order xmlns="urn:orders" id="1"><items><item sku="a">2</item><item sku="b">3</item></items></order>`), &order); err != nil {
+		t.Fatal(err)
+	}
+	want := unmarshalOrder{ID: "1", Items: []unmarshalItem{{Sku: "a", Quantity: 2}, {Sku: "b", Quantity: 3}}}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected %#v, but got %#v", want, order)
+	}
+
+	order = unmarshalOrder{ID: "untouched"}
+	err = ValidateAndUnmarshal(g, []byte(`<order xmlns="urn:orders" id="2"><items><item sku="a">2</item><item>3</item></items></order>`), &order)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, but got %v", err)
+	}
+	if verr.Path != "/order[1]/items[1]/item[2]" || verr.Tags != "order>items>item" || verr.Message != "element item is missing attribute sku" {
+		t.Fatalf("unexpected violation %#v", verr)
+	}
+	if !reflect.DeepEqual(order, unmarshalOrder{ID: "untouched"}) {
+		t.Fatalf("expected the value to be untouched, but got %#v", order)
+	}
+
+	if err := ValidateAndUnmarshal(g, []byte(`<order id="3"><items/></order>`), &order); err == nil {
+		t.Fatal("expected an order without a namespace to be invalid")
+	}
+	if err := ValidateAndUnmarshal(g, []byte(`<order xmlns="urn:orders" id="4"><items><item sku="a">two</item></items></order>`), &order); err == nil {
+		t.Fatal("expected an error for a quantity that is not a number")
+	} else if _, ok := err.(*ValidationError); ok {
+		t.Fatalf("expected a decoding error, but got %v", err)
+	}
+}
+
+func TestValidateAndUnmarshalAgreesWithValidate(t *testing.T) {
+	g, err := Simplify([]byte(`<element name="item" xmlns="http://relaxng.org/ns/structure/1.0"
+	datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+	<attribute name="sku"><data type="token"/></attribute>
+	<data type="int"/>
+</element>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{`<item sku="a">2</item>`, `<item sku="a">2.5</item>`, `<item sku="a">99999999999</item>`, `<item sku="a"/>`} {
+		want := Validate(katydid, []byte(doc))
+		item := unmarshalItem{Sku: "untouched"}
+		got := ValidateAndUnmarshal(g, []byte(doc), &item)
+		if (got == nil) != (want == nil) {
+			t.Fatalf("expected %s to be valid %v, like Validate, but got %v", doc, want == nil, got)
+		}
+		if _, ok := got.(*ValidationError); got != nil && !ok {
+			t.Fatalf("expected a validation error for %s, but got %v", doc, got)
+		}
+	}
+}
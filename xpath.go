@@ -0,0 +1,1235 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//This file implements the subset of XPath 1.0 that Schematron rules are written in.
+//All the axes, except the namespace axis, and all the core functions,
+//except id and lang, are supported over elements, attributes and text.
+//Comments and processing instructions are not part of the document tree.
+
+type xnodeKind int
+
+const (
+	xrootNode xnodeKind = iota
+	xelementNode
+	xattributeNode
+	xtextNode
+)
+
+//xnode is a node of the document tree, over which XPath expressions are evaluated.
+type xnode struct {
+	kind     xnodeKind
+	name     xml.Name
+	value    string
+	parent   *xnode
+	children []*xnode
+	attrs    []*xnode
+	//order is the position of the node in document order.
+	order int
+	//offset is the offset of the start tag of the element, that is the node or the parent of the node.
+	offset int64
+	//path is the path of the element, that is the node or the parent of the node, for example /order[1]/item[2].
+	path string
+	//all is set on the root and holds the elements and text nodes in document order.
+	all []*xnode
+}
+
+//parseXTree parses the xml into a document tree.
+func parseXTree(xmlContent []byte) (*xnode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(xmlContent))
+	root := &xnode{kind: xrootNode, path: "/"}
+	n := root
+	counts := []map[string]int{make(map[string]int)}
+	order := 0
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			siblings := counts[len(counts)-1]
+			siblings[tok.Name.Local]++
+			path := fmt.Sprintf("%s/%s[%d]", strings.TrimSuffix(n.path, "/"), tok.Name.Local, siblings[tok.Name.Local])
+			order++
+			e := &xnode{kind: xelementNode, name: tok.Name, parent: n, order: order, offset: offset, path: path}
+			for _, attr := range tok.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					continue
+				}
+				order++
+				e.attrs = append(e.attrs, &xnode{kind: xattributeNode, name: attr.Name, value: attr.Value, parent: e, order: order, offset: offset, path: path})
+			}
+			n.children = append(n.children, e)
+			root.all = append(root.all, e)
+			n = e
+			counts = append(counts, make(map[string]int))
+		case xml.EndElement:
+			n = n.parent
+			counts = counts[:len(counts)-1]
+		case xml.CharData:
+			if n == root {
+				continue
+			}
+			if last := len(n.children) - 1; last >= 0 && n.children[last].kind == xtextNode {
+				n.children[last].value += string(tok)
+				continue
+			}
+			order++
+			t := &xnode{kind: xtextNode, value: string(tok), parent: n, order: order, offset: n.offset, path: n.path}
+			n.children = append(n.children, t)
+			root.all = append(root.all, t)
+		}
+	}
+	return root, nil
+}
+
+func (this *xnode) root() *xnode {
+	n := this
+	for n.parent != nil {
+		n = n.parent
+	}
+	return n
+}
+
+//stringValue returns the value of an attribute or text node, or the text of all the descendants of an element or root.
+func (this *xnode) stringValue() string {
+	switch this.kind {
+	case xattributeNode, xtextNode:
+		return this.value
+	}
+	var ss []string
+	for _, c := range this.children {
+		ss = append(ss, c.stringValue())
+	}
+	return strings.Join(ss, "")
+}
+
+//isAncestorOf returns whether the node is a proper ancestor of n.
+func (this *xnode) isAncestorOf(n *xnode) bool {
+	for p := n.parent; p != nil; p = p.parent {
+		if p == this {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *xnode) descendants() []*xnode {
+	var ds []*xnode
+	for _, c := range this.children {
+		ds = append(ds, c)
+		ds = append(ds, c.descendants()...)
+	}
+	return ds
+}
+
+type byOrder []*xnode
+
+func (this byOrder) Len() int {
+	return len(this)
+}
+
+func (this byOrder) Less(i, j int) bool {
+	return this[i].order < this[j].order
+}
+
+func (this byOrder) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+//xpathError is panicked with while evaluating an expression and recovered by evalXPath.
+type xpathError struct {
+	msg string
+}
+
+func (this *xpathError) Error() string {
+	return this.msg
+}
+
+//xpathExpr is a compiled XPath expression.
+//Its value is a node-set ([]*xnode in document order), a string, a float64 or a bool.
+type xpathExpr interface {
+	eval(ctx *xpathContext) interface{}
+}
+
+type xpathContext struct {
+	node     *xnode
+	position int
+	size     int
+	vars     map[string]interface{}
+}
+
+func (this *xpathContext) with(n *xnode, position, size int) *xpathContext {
+	return &xpathContext{node: n, position: position, size: size, vars: this.vars}
+}
+
+//evalXPath evaluates the expression with the node as context node.
+func evalXPath(e xpathExpr, n *xnode, vars map[string]interface{}) (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			xerr, ok := r.(*xpathError)
+			if !ok {
+				panic(r)
+			}
+			v, err = nil, xerr
+		}
+	}()
+	return e.eval(&xpathContext{node: n, position: 1, size: 1, vars: vars}), nil
+}
+
+func xpathString(v interface{}) string {
+	switch v := v.(type) {
+	case []*xnode:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].stringValue()
+	case string:
+		return v
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		case v == 0:
+			return "0"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	}
+	panic(&xpathError{fmt.Sprintf("unexpected value %v", v)})
+}
+
+func xpathNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	s := strings.TrimSpace(xpathString(v))
+	digits := strings.TrimPrefix(s, "-")
+	if len(digits) == 0 || strings.Count(digits, ".") > 1 || digits == "." || strings.IndexFunc(digits, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	}) >= 0 {
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func xpathBoolean(v interface{}) bool {
+	switch v := v.(type) {
+	case []*xnode:
+		return len(v) > 0
+	case string:
+		return len(v) > 0
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case bool:
+		return v
+	}
+	panic(&xpathError{fmt.Sprintf("unexpected value %v", v)})
+}
+
+func xpathNodes(v interface{}) []*xnode {
+	ns, ok := v.([]*xnode)
+	if !ok {
+		panic(&xpathError{fmt.Sprintf("expected a node-set, but got %s", xpathString(v))})
+	}
+	return ns
+}
+
+//xpathCompare compares two values, where a node-set compares true if any of its nodes does.
+func xpathCompare(op string, l, r interface{}) bool {
+	ln, lok := l.([]*xnode)
+	rn, rok := r.([]*xnode)
+	switch {
+	case lok && rok:
+		for _, a := range ln {
+			for _, b := range rn {
+				if compareAtoms(op, a.stringValue(), b.stringValue()) {
+					return true
+				}
+			}
+		}
+		return false
+	case lok:
+		if b, ok := r.(bool); ok {
+			return compareAtoms(op, xpathBoolean(l), b)
+		}
+		for _, a := range ln {
+			if compareAtoms(op, a.stringValue(), r) {
+				return true
+			}
+		}
+		return false
+	case rok:
+		if a, ok := l.(bool); ok {
+			return compareAtoms(op, a, xpathBoolean(r))
+		}
+		for _, b := range rn {
+			if compareAtoms(op, l, b.stringValue()) {
+				return true
+			}
+		}
+		return false
+	}
+	return compareAtoms(op, l, r)
+}
+
+func compareAtoms(op string, l, r interface{}) bool {
+	if op == "=" || op == "!=" {
+		var equal bool
+		_, lbool := l.(bool)
+		_, rbool := r.(bool)
+		_, lnum := l.(float64)
+		_, rnum := r.(float64)
+		switch {
+		case lbool || rbool:
+			equal = xpathBoolean(l) == xpathBoolean(r)
+		case lnum || rnum:
+			equal = xpathNumber(l) == xpathNumber(r)
+		default:
+			equal = xpathString(l) == xpathString(r)
+		}
+		return equal == (op == "=")
+	}
+	a, b := xpathNumber(l), xpathNumber(r)
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+type xpathLiteral struct {
+	value string
+}
+
+func (this *xpathLiteral) eval(ctx *xpathContext) interface{} {
+	return this.value
+}
+
+type xpathNumberLiteral struct {
+	value float64
+}
+
+func (this *xpathNumberLiteral) eval(ctx *xpathContext) interface{} {
+	return this.value
+}
+
+type xpathVariable struct {
+	name string
+}
+
+func (this *xpathVariable) eval(ctx *xpathContext) interface{} {
+	v, ok := ctx.vars[this.name]
+	if !ok {
+		panic(&xpathError{"undefined variable $" + this.name})
+	}
+	return v
+}
+
+type xpathNegate struct {
+	expr xpathExpr
+}
+
+func (this *xpathNegate) eval(ctx *xpathContext) interface{} {
+	return -xpathNumber(this.expr.eval(ctx))
+}
+
+type xpathBinary struct {
+	op          string
+	left, right xpathExpr
+}
+
+func (this *xpathBinary) eval(ctx *xpathContext) interface{} {
+	switch this.op {
+	case "or":
+		return xpathBoolean(this.left.eval(ctx)) || xpathBoolean(this.right.eval(ctx))
+	case "and":
+		return xpathBoolean(this.left.eval(ctx)) && xpathBoolean(this.right.eval(ctx))
+	case "=", "!=", "<", "<=", ">", ">=":
+		return xpathCompare(this.op, this.left.eval(ctx), this.right.eval(ctx))
+	case "|":
+		l, r := xpathNodes(this.left.eval(ctx)), xpathNodes(this.right.eval(ctx))
+		return unionNodes(l, r)
+	}
+	l, r := xpathNumber(this.left.eval(ctx)), xpathNumber(this.right.eval(ctx))
+	switch this.op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "div":
+		return l / r
+	}
+	return math.Mod(l, r)
+}
+
+//unionNodes returns the nodes that are in either node-set in document order.
+func unionNodes(l, r []*xnode) []*xnode {
+	seen := make(map[*xnode]bool, len(l)+len(r))
+	var ns []*xnode
+	for _, n := range append(append([]*xnode(nil), l...), r...) {
+		if !seen[n] {
+			seen[n] = true
+			ns = append(ns, n)
+		}
+	}
+	sort.Sort(byOrder(ns))
+	return ns
+}
+
+//xpathFilter is a primary expression, like a variable or a function call, followed by predicates.
+type xpathFilter struct {
+	primary    xpathExpr
+	predicates []xpathExpr
+}
+
+func (this *xpathFilter) eval(ctx *xpathContext) interface{} {
+	v := this.primary.eval(ctx)
+	if len(this.predicates) == 0 {
+		return v
+	}
+	return filterNodes(ctx, xpathNodes(v), this.predicates)
+}
+
+//filterNodes keeps the nodes, which are in the order of their axis, for which all the predicates are true.
+func filterNodes(ctx *xpathContext, nodes []*xnode, predicates []xpathExpr) []*xnode {
+	for _, p := range predicates {
+		var kept []*xnode
+		for i, n := range nodes {
+			v := p.eval(ctx.with(n, i+1, len(nodes)))
+			if f, ok := v.(float64); ok {
+				if f == float64(i+1) {
+					kept = append(kept, n)
+				}
+			} else if xpathBoolean(v) {
+				kept = append(kept, n)
+			}
+		}
+		nodes = kept
+	}
+	return nodes
+}
+
+//xpathPath is a location path, which starts at the root, the context node or the value of a filter expression.
+type xpathPath struct {
+	absolute bool
+	filter   xpathExpr
+	steps    []*xpathStep
+}
+
+func (this *xpathPath) eval(ctx *xpathContext) interface{} {
+	var nodes []*xnode
+	switch {
+	case this.filter != nil:
+		nodes = xpathNodes(this.filter.eval(ctx))
+	case this.absolute:
+		nodes = []*xnode{ctx.node.root()}
+	default:
+		nodes = []*xnode{ctx.node}
+	}
+	for _, step := range this.steps {
+		seen := make(map[*xnode]bool)
+		var next []*xnode
+		for _, n := range nodes {
+			for _, m := range filterNodes(ctx, step.axisNodes(n), step.predicates) {
+				if !seen[m] {
+					seen[m] = true
+					next = append(next, m)
+				}
+			}
+		}
+		sort.Sort(byOrder(next))
+		nodes = next
+	}
+	return nodes
+}
+
+type xpathNodeTest struct {
+	//kind is name, for a name test, or one of node, text, comment and processing-instruction.
+	kind string
+	//name is the name of a name test, where a Local of * matches any name in the namespace
+	//and a Space of * matches any namespace.
+	name xml.Name
+}
+
+type xpathStep struct {
+	axis       string
+	test       xpathNodeTest
+	predicates []xpathExpr
+}
+
+var xpathAxes = map[string]bool{
+	"ancestor": true, "ancestor-or-self": true, "attribute": true, "child": true, "descendant": true,
+	"descendant-or-self": true, "following": true, "following-sibling": true, "parent": true,
+	"preceding": true, "preceding-sibling": true, "self": true,
+}
+
+//axisNodes returns the nodes on the axis of the step that match its node test, with the nearest nodes first.
+func (this *xpathStep) axisNodes(n *xnode) []*xnode {
+	var candidates []*xnode
+	switch this.axis {
+	case "child":
+		candidates = n.children
+	case "descendant":
+		candidates = n.descendants()
+	case "descendant-or-self":
+		candidates = append([]*xnode{n}, n.descendants()...)
+	case "parent":
+		if n.parent != nil {
+			candidates = []*xnode{n.parent}
+		}
+	case "ancestor", "ancestor-or-self":
+		if this.axis == "ancestor-or-self" {
+			candidates = append(candidates, n)
+		}
+		for p := n.parent; p != nil; p = p.parent {
+			candidates = append(candidates, p)
+		}
+	case "following-sibling", "preceding-sibling":
+		if n.kind == xattributeNode || n.parent == nil {
+			break
+		}
+		siblings := n.parent.children
+		for i, s := range siblings {
+			if s != n {
+				continue
+			}
+			if this.axis == "following-sibling" {
+				candidates = siblings[i+1:]
+			} else {
+				for j := i - 1; j >= 0; j-- {
+					candidates = append(candidates, siblings[j])
+				}
+			}
+		}
+	case "following":
+		after := n
+		if n.kind == xattributeNode {
+			after = n.parent
+		}
+		for _, m := range n.root().all {
+			if m.order > after.order && !after.isAncestorOf(m) {
+				candidates = append(candidates, m)
+			}
+		}
+	case "preceding":
+		all := n.root().all
+		for i := len(all) - 1; i >= 0; i-- {
+			if m := all[i]; m.order < n.order && !m.isAncestorOf(n) {
+				candidates = append(candidates, m)
+			}
+		}
+	case "attribute":
+		candidates = n.attrs
+	case "self":
+		candidates = []*xnode{n}
+	}
+	var nodes []*xnode
+	for _, c := range candidates {
+		if this.matches(c) {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+func (this *xpathStep) matches(n *xnode) bool {
+	switch this.test.kind {
+	case "node":
+		return true
+	case "text":
+		return n.kind == xtextNode
+	case "name":
+		principal := xelementNode
+		if this.axis == "attribute" {
+			principal = xattributeNode
+		}
+		if n.kind != principal {
+			return false
+		}
+		name := this.test.name
+		return (name.Space == "*" || name.Space == n.name.Space) && (name.Local == "*" || name.Local == n.name.Local)
+	}
+	return false
+}
+
+//xpathFunction is a core function with the minimum and maximum number of arguments, where a maximum of -1 is unbounded.
+type xpathFunction struct {
+	min, max int
+	call     func(ctx *xpathContext, args []interface{}) interface{}
+}
+
+//contextNodes returns the node-set argument of a function or the context node, if the argument is optional and omitted.
+func contextNodes(ctx *xpathContext, args []interface{}) []*xnode {
+	if len(args) == 0 {
+		return []*xnode{ctx.node}
+	}
+	return xpathNodes(args[0])
+}
+
+func contextString(ctx *xpathContext, args []interface{}) string {
+	if len(args) == 0 {
+		return ctx.node.stringValue()
+	}
+	return xpathString(args[0])
+}
+
+func firstName(ctx *xpathContext, args []interface{}) xml.Name {
+	ns := contextNodes(ctx, args)
+	if len(ns) == 0 {
+		return xml.Name{}
+	}
+	return ns[0].name
+}
+
+//xpathRound rounds to the nearest integer, where halves are rounded up, as specified by XPath.
+func xpathRound(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	return math.Floor(f + 0.5)
+}
+
+var xpathFunctions map[string]*xpathFunction
+
+func init() {
+	xpathFunctions = map[string]*xpathFunction{
+		"last": {0, 0, func(ctx *xpathContext, args []interface{}) interface{} {
+			return float64(ctx.size)
+		}},
+		"position": {0, 0, func(ctx *xpathContext, args []interface{}) interface{} {
+			return float64(ctx.position)
+		}},
+		"count": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return float64(len(xpathNodes(args[0])))
+		}},
+		//The prefixes of the document are not kept, so name returns the local name.
+		"name": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return firstName(ctx, args).Local
+		}},
+		"local-name": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return firstName(ctx, args).Local
+		}},
+		"namespace-uri": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return firstName(ctx, args).Space
+		}},
+		"string": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return contextString(ctx, args)
+		}},
+		"concat": {2, -1, func(ctx *xpathContext, args []interface{}) interface{} {
+			ss := make([]string, len(args))
+			for i, a := range args {
+				ss[i] = xpathString(a)
+			}
+			return strings.Join(ss, "")
+		}},
+		"starts-with": {2, 2, func(ctx *xpathContext, args []interface{}) interface{} {
+			return strings.HasPrefix(xpathString(args[0]), xpathString(args[1]))
+		}},
+		"contains": {2, 2, func(ctx *xpathContext, args []interface{}) interface{} {
+			return strings.Contains(xpathString(args[0]), xpathString(args[1]))
+		}},
+		"substring-before": {2, 2, func(ctx *xpathContext, args []interface{}) interface{} {
+			s, sep := xpathString(args[0]), xpathString(args[1])
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[:i]
+			}
+			return ""
+		}},
+		"substring-after": {2, 2, func(ctx *xpathContext, args []interface{}) interface{} {
+			s, sep := xpathString(args[0]), xpathString(args[1])
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[i+len(sep):]
+			}
+			return ""
+		}},
+		"substring": {2, 3, func(ctx *xpathContext, args []interface{}) interface{} {
+			start := xpathRound(xpathNumber(args[1]))
+			end := math.Inf(1)
+			if len(args) == 3 {
+				end = start + xpathRound(xpathNumber(args[2]))
+			}
+			var rs []rune
+			for i, r := range []rune(xpathString(args[0])) {
+				if p := float64(i + 1); p >= start && p < end {
+					rs = append(rs, r)
+				}
+			}
+			return string(rs)
+		}},
+		"string-length": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return float64(utf8.RuneCountInString(contextString(ctx, args)))
+		}},
+		"normalize-space": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return strings.Join(strings.Fields(contextString(ctx, args)), " ")
+		}},
+		"translate": {3, 3, func(ctx *xpathContext, args []interface{}) interface{} {
+			from, to := []rune(xpathString(args[1])), []rune(xpathString(args[2]))
+			return strings.Map(func(r rune) rune {
+				for i, f := range from {
+					if f == r {
+						if i < len(to) {
+							return to[i]
+						}
+						return -1
+					}
+				}
+				return r
+			}, xpathString(args[0]))
+		}},
+		"boolean": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return xpathBoolean(args[0])
+		}},
+		"not": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return !xpathBoolean(args[0])
+		}},
+		"true": {0, 0, func(ctx *xpathContext, args []interface{}) interface{} {
+			return true
+		}},
+		"false": {0, 0, func(ctx *xpathContext, args []interface{}) interface{} {
+			return false
+		}},
+		"number": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			if len(args) == 0 {
+				return xpathNumber(ctx.node.stringValue())
+			}
+			return xpathNumber(args[0])
+		}},
+		"sum": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			sum := 0.0
+			for _, n := range xpathNodes(args[0]) {
+				sum += xpathNumber(n.stringValue())
+			}
+			return sum
+		}},
+		"floor": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return math.Floor(xpathNumber(args[0]))
+		}},
+		"ceiling": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return math.Ceil(xpathNumber(args[0]))
+		}},
+		"round": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return xpathRound(xpathNumber(args[0]))
+		}},
+	}
+}
+
+type xpathCall struct {
+	name string
+	fn   *xpathFunction
+	args []xpathExpr
+}
+
+func (this *xpathCall) eval(ctx *xpathContext) interface{} {
+	args := make([]interface{}, len(this.args))
+	for i, a := range this.args {
+		args[i] = a.eval(ctx)
+	}
+	return this.fn.call(ctx, args)
+}
+
+type xpathToken struct {
+	//kind is n for a name test or function name, o for an operator or punctuation,
+	//s for a string literal, d for a number and v for a variable reference.
+	kind byte
+	text string
+}
+
+//xpathNoOperatorAfter are the tokens after which * and names are not operators.
+var xpathNoOperatorAfter = map[string]bool{
+	"@": true, "::": true, "(": true, "[": true, ",": true, "/": true, "//": true, "|": true,
+	"+": true, "-": true, "=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"and": true, "or": true, "div": true, "mod": true, "*": true,
+}
+
+func isNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isNameChar(r rune) bool {
+	return isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r)
+}
+
+//lexXPath splits an expression into tokens.
+func lexXPath(s string) ([]xpathToken, error) {
+	var tokens []xpathToken
+	rs := []rune(s)
+	operatorPosition := func() bool {
+		if len(tokens) == 0 {
+			return false
+		}
+		prev := tokens[len(tokens)-1]
+		return prev.kind != 'o' || !xpathNoOperatorAfter[prev.text]
+	}
+	name := func(i int) int {
+		for i < len(rs) && isNameChar(rs[i]) {
+			i++
+		}
+		return i
+	}
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(rs) && rs[end] != r {
+				end++
+			}
+			if end == len(rs) {
+				return nil, fmt.Errorf("unterminated string literal in %q", s)
+			}
+			tokens = append(tokens, xpathToken{'s', string(rs[i+1 : end])})
+			i = end + 1
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1]):
+			end := i
+			for end < len(rs) && (unicode.IsDigit(rs[end]) || rs[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, xpathToken{'d', string(rs[i:end])})
+			i = end
+		case r == '$':
+			end := name(i + 1)
+			if end < len(rs) && rs[end] == ':' && end+1 < len(rs) && isNameStart(rs[end+1]) {
+				end = name(end + 1)
+			}
+			if end == i+1 {
+				return nil, fmt.Errorf("expected a variable name in %q", s)
+			}
+			tokens = append(tokens, xpathToken{'v', string(rs[i+1 : end])})
+			i = end
+		case r == '*':
+			if operatorPosition() {
+				tokens = append(tokens, xpathToken{'o', "*"})
+			} else {
+				tokens = append(tokens, xpathToken{'n', "*"})
+			}
+			i++
+		case isNameStart(r):
+			end := name(i)
+			if end+1 < len(rs) && rs[end] == ':' && rs[end+1] == '*' {
+				end += 2
+			} else if end+1 < len(rs) && rs[end] == ':' && isNameStart(rs[end+1]) {
+				end = name(end + 1)
+			}
+			text := string(rs[i:end])
+			if operatorPosition() && (text == "and" || text == "or" || text == "div" || text == "mod") {
+				tokens = append(tokens, xpathToken{'o', text})
+			} else {
+				tokens = append(tokens, xpathToken{'n', text})
+			}
+			i = end
+		default:
+			op := ""
+			for _, o := range []string{"//", "::", "..", "!=", "<=", ">=", "/", "[", "]", "(", ")", "@", ",", "|", "=", "<", ">", "+", "-", "."} {
+				if strings.HasPrefix(string(rs[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if len(op) == 0 {
+				return nil, fmt.Errorf("unexpected %q in %q", r, s)
+			}
+			tokens = append(tokens, xpathToken{'o', op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+type xpathParser struct {
+	src    string
+	tokens []xpathToken
+	pos    int
+	//namespaces maps prefixes to namespaces.
+	namespaces map[string]string
+}
+
+//compileXPath compiles an expression, where the prefixes in name tests are resolved with the namespaces.
+func compileXPath(s string, namespaces map[string]string) (xpathExpr, error) {
+	tokens, err := lexXPath(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &xpathParser{src: s, tokens: tokens, namespaces: namespaces}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+	return e, nil
+}
+
+func (this *xpathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s in XPath %q", fmt.Sprintf(format, args...), this.src)
+}
+
+func (this *xpathParser) peek(offset int) xpathToken {
+	if this.pos+offset < len(this.tokens) {
+		return this.tokens[this.pos+offset]
+	}
+	return xpathToken{}
+}
+
+func (this *xpathParser) accept(kind byte, texts ...string) (string, bool) {
+	t := this.peek(0)
+	if t.kind != kind {
+		return "", false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			this.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (this *xpathParser) expect(text string) error {
+	if _, ok := this.accept('o', text); !ok {
+		if this.pos >= len(this.tokens) {
+			return this.errorf("expected %s at the end", text)
+		}
+		return this.errorf("expected %s, but got %s", text, this.peek(0).text)
+	}
+	return nil
+}
+
+//binary parses a left associative sequence of operands that are separated by the operators.
+func (this *xpathParser) binary(operand func() (xpathExpr, error), ops ...string) (xpathExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := this.accept('o', ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &xpathBinary{op: op, left: left, right: right}
+	}
+}
+
+func (this *xpathParser) expr() (xpathExpr, error) {
+	return this.binary(this.and, "or")
+}
+
+func (this *xpathParser) and() (xpathExpr, error) {
+	return this.binary(this.equality, "and")
+}
+
+func (this *xpathParser) equality() (xpathExpr, error) {
+	return this.binary(this.relational, "=", "!=")
+}
+
+func (this *xpathParser) relational() (xpathExpr, error) {
+	return this.binary(this.additive, "<", "<=", ">", ">=")
+}
+
+func (this *xpathParser) additive() (xpathExpr, error) {
+	return this.binary(this.multiplicative, "+", "-")
+}
+
+func (this *xpathParser) multiplicative() (xpathExpr, error) {
+	return this.binary(this.unary, "*", "div", "mod")
+}
+
+func (this *xpathParser) unary() (xpathExpr, error) {
+	if _, ok := this.accept('o', "-"); ok {
+		e, err := this.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &xpathNegate{e}, nil
+	}
+	return this.binary(this.path, "|")
+}
+
+var xpathNodeTypes = map[string]bool{"node": true, "text": true, "comment": true, "processing-instruction": true}
+
+//isStepStart returns whether the next token starts a step of a location path.
+func (this *xpathParser) isStepStart() bool {
+	t := this.peek(0)
+	switch t.kind {
+	case 'n':
+		return true
+	case 'o':
+		return t.text == "@" || t.text == "." || t.text == ".."
+	}
+	return false
+}
+
+func (this *xpathParser) path() (xpathExpr, error) {
+	t := this.peek(0)
+	if t.kind == 'o' && t.text == "/" {
+		this.pos++
+		path := &xpathPath{absolute: true}
+		if !this.isStepStart() {
+			return path, nil
+		}
+		return this.steps(path)
+	}
+	if t.kind == 'o' && t.text == "//" {
+		this.pos++
+		return this.steps(&xpathPath{absolute: true, steps: []*xpathStep{descendantOrSelf()}})
+	}
+	isCall := t.kind == 'n' && this.peek(1).kind == 'o' && this.peek(1).text == "(" && !xpathNodeTypes[t.text]
+	if t.kind == 's' || t.kind == 'd' || t.kind == 'v' || t.kind == 'o' && t.text == "(" || isCall {
+		primary, err := this.primary()
+		if err != nil {
+			return nil, err
+		}
+		predicates, err := this.predicates()
+		if err != nil {
+			return nil, err
+		}
+		filter := &xpathFilter{primary: primary, predicates: predicates}
+		if op, ok := this.accept('o', "/", "//"); ok {
+			path := &xpathPath{filter: filter}
+			if op == "//" {
+				path.steps = append(path.steps, descendantOrSelf())
+			}
+			return this.steps(path)
+		}
+		if len(predicates) == 0 {
+			return primary, nil
+		}
+		return filter, nil
+	}
+	if !this.isStepStart() {
+		if this.pos >= len(this.tokens) {
+			return nil, this.errorf("unexpected end")
+		}
+		return nil, this.errorf("unexpected %s", t.text)
+	}
+	return this.steps(&xpathPath{})
+}
+
+func descendantOrSelf() *xpathStep {
+	return &xpathStep{axis: "descendant-or-self", test: xpathNodeTest{kind: "node"}}
+}
+
+//steps parses the steps of a relative location path and appends them to the path.
+func (this *xpathParser) steps(path *xpathPath) (xpathExpr, error) {
+	for {
+		step, err := this.step()
+		if err != nil {
+			return nil, err
+		}
+		path.steps = append(path.steps, step)
+		op, ok := this.accept('o', "/", "//")
+		if !ok {
+			return path, nil
+		}
+		if op == "//" {
+			path.steps = append(path.steps, descendantOrSelf())
+		}
+	}
+}
+
+func (this *xpathParser) step() (*xpathStep, error) {
+	if _, ok := this.accept('o', "."); ok {
+		return &xpathStep{axis: "self", test: xpathNodeTest{kind: "node"}}, nil
+	}
+	if _, ok := this.accept('o', ".."); ok {
+		return &xpathStep{axis: "parent", test: xpathNodeTest{kind: "node"}}, nil
+	}
+	step := &xpathStep{axis: "child"}
+	if _, ok := this.accept('o', "@"); ok {
+		step.axis = "attribute"
+	} else if t := this.peek(0); t.kind == 'n' && this.peek(1).kind == 'o' && this.peek(1).text == "::" {
+		if !xpathAxes[t.text] {
+			return nil, this.errorf("unsupported axis %s", t.text)
+		}
+		step.axis = t.text
+		this.pos += 2
+	}
+	t := this.peek(0)
+	if t.kind != 'n' {
+		return nil, this.errorf("expected a node test")
+	}
+	this.pos++
+	if xpathNodeTypes[t.text] && this.peek(0).kind == 'o' && this.peek(0).text == "(" {
+		this.pos++
+		if t.text == "processing-instruction" {
+			this.accept('s')
+		}
+		if err := this.expect(")"); err != nil {
+			return nil, err
+		}
+		step.test = xpathNodeTest{kind: t.text}
+	} else {
+		name, err := this.name(t.text)
+		if err != nil {
+			return nil, err
+		}
+		step.test = xpathNodeTest{kind: "name", name: name}
+	}
+	predicates, err := this.predicates()
+	if err != nil {
+		return nil, err
+	}
+	step.predicates = predicates
+	return step, nil
+}
+
+//name resolves the prefix of a name test, where names without a prefix are not in a namespace.
+func (this *xpathParser) name(qname string) (xml.Name, error) {
+	if qname == "*" {
+		return xml.Name{Space: "*", Local: "*"}, nil
+	}
+	i := strings.Index(qname, ":")
+	if i < 0 {
+		return xml.Name{Local: qname}, nil
+	}
+	ns, ok := this.namespaces[qname[:i]]
+	if !ok {
+		return xml.Name{}, this.errorf("undeclared prefix %s", qname[:i])
+	}
+	return xml.Name{Space: ns, Local: qname[i+1:]}, nil
+}
+
+func (this *xpathParser) predicates() ([]xpathExpr, error) {
+	var predicates []xpathExpr
+	for {
+		if _, ok := this.accept('o', "["); !ok {
+			return predicates, nil
+		}
+		e, err := this.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := this.expect("]"); err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, e)
+	}
+}
+
+func (this *xpathParser) primary() (xpathExpr, error) {
+	t := this.peek(0)
+	this.pos++
+	switch t.kind {
+	case 's':
+		return &xpathLiteral{t.text}, nil
+	case 'd':
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, this.errorf("invalid number %s", t.text)
+		}
+		return &xpathNumberLiteral{f}, nil
+	case 'v':
+		return &xpathVariable{t.text}, nil
+	case 'o':
+		e, err := this.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := this.expect(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	fn, ok := xpathFunctions[t.text]
+	if !ok {
+		return nil, this.errorf("unsupported function %s", t.text)
+	}
+	this.pos++
+	call := &xpathCall{name: t.text, fn: fn}
+	if _, ok := this.accept('o', ")"); !ok {
+		for {
+			arg, err := this.expr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if _, ok := this.accept('o', ","); !ok {
+				break
+			}
+		}
+		if err := this.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(call.args) < fn.min || fn.max >= 0 && len(call.args) > fn.max {
+		return nil, this.errorf("wrong number of arguments for %s", t.text)
+	}
+	return call, nil
+}
+
+//rootedPattern turns a Schematron rule context into an expression that selects all the nodes that it matches.
+//Relative location paths are matched anywhere in the document.
+func rootedPattern(e xpathExpr) xpathExpr {
+	switch e := e.(type) {
+	case *xpathBinary:
+		if e.op == "|" {
+			return &xpathBinary{op: "|", left: rootedPattern(e.left), right: rootedPattern(e.right)}
+		}
+	case *xpathPath:
+		if !e.absolute && e.filter == nil {
+			return &xpathPath{absolute: true, steps: append([]*xpathStep{descendantOrSelf()}, e.steps...)}
+		}
+	}
+	return e
+}
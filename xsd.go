@@ -0,0 +1,1397 @@
+// Copyright 2018 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package relaxng
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	xsdNamespace   = "http://www.w3.org/2001/XMLSchema"
+	maxXSDOccurs   = 32
+	anyContentName = "anyContent"
+)
+
+//UnsupportedError lists the features of a schema that could not be imported exactly.
+//The Grammar that is returned together with an UnsupportedError approximates these features.
+type UnsupportedError struct {
+	Features []string
+}
+
+func (this *UnsupportedError) Error() string {
+	return "unsupported: " + strings.Join(this.Features, "; ")
+}
+
+//FromXSD imports a W3C XML Schema into a simplified Grammar.
+//Every global element can be the root of a document.
+//Complex types, model groups, attribute groups, simple type restrictions, lists and unions,
+//extension and restriction derivation and substitution groups are supported.
+//Wildcards accept any content and types derived by restriction from complex types use the content model of the restriction.
+//Schemas that are included or imported can only be resolved with FromXSDFile.
+//If the schema uses features that are not supported, like identity constraints or nillable elements,
+//the Grammar, without those features, is returned together with an *UnsupportedError.
+func FromXSD(buf []byte) (*Grammar, error) {
+	imp := newXSDImporter()
+	if err := imp.load(buf, "", nil); err != nil {
+		return nil, err
+	}
+	return imp.grammar()
+}
+
+//FromXSDFile imports a W3C XML Schema file, and the schemas it includes and imports, into a simplified Grammar.
+//See FromXSD.
+func FromXSDFile(filename string) (*Grammar, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	imp := newXSDImporter()
+	if err := imp.load(buf, filename, nil); err != nil {
+		return nil, err
+	}
+	return imp.grammar()
+}
+
+//xsdSchema holds the properties of a schema document.
+type xsdSchema struct {
+	targetNamespace    string
+	elementQualified   bool
+	attributeQualified bool
+	//chameleon is true for a schema without a target namespace, that was included into one with a target namespace.
+	chameleon bool
+}
+
+//xsdNode is an element, in the XML Schema namespace, of a schema document, without annotations.
+type xsdNode struct {
+	name     string
+	attrs    map[string]string
+	children []*xsdNode
+	text     string
+	prefixes map[string]string
+	schema   *xsdSchema
+}
+
+func (this *xsdNode) attr(name string) (string, bool) {
+	v, ok := this.attrs[name]
+	return v, ok
+}
+
+//child returns the first child with one of the names.
+func (this *xsdNode) child(names ...string) *xsdNode {
+	for _, c := range this.children {
+		for _, name := range names {
+			if c.name == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (this *xsdNode) String() string {
+	if name, ok := this.attrs["name"]; ok {
+		return this.name + " " + name
+	}
+	if ref, ok := this.attrs["ref"]; ok {
+		return this.name + " " + ref
+	}
+	return this.name
+}
+
+//qname resolves a qualified name in an attribute value.
+func (this *xsdNode) qname(value string) (xml.Name, error) {
+	value = strings.TrimSpace(value)
+	prefix, local := "", value
+	if i := strings.Index(value, ":"); i >= 0 {
+		prefix, local = value[:i], value[i+1:]
+	}
+	ns, ok := this.prefixes[prefix]
+	if !ok && len(prefix) > 0 {
+		return xml.Name{}, fmt.Errorf("%s: undeclared prefix %s in %s", this, prefix, value)
+	}
+	if len(ns) == 0 && this.schema.chameleon {
+		ns = this.schema.targetNamespace
+	}
+	return xml.Name{Space: ns, Local: local}, nil
+}
+
+func parseXSD(buf []byte) (*xsdNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(buf))
+	var root *xsdNode
+	var stack []*xsdNode
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			prefixes := map[string]string{"xml": "http://www.w3.org/XML/1998/namespace"}
+			if len(stack) > 0 {
+				prefixes = stack[len(stack)-1].prefixes
+			}
+			if tok.Name.Space != xsdNamespace || tok.Name.Local == "annotation" {
+				if len(stack) == 0 {
+					return nil, fmt.Errorf("expected a schema element, but got %s", tok.Name.Local)
+				}
+				if err := d.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			n := &xsdNode{name: tok.Name.Local, attrs: make(map[string]string), prefixes: prefixes}
+			copied := false
+			for _, a := range tok.Attr {
+				switch {
+				case a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns":
+					if !copied {
+						n.prefixes = make(map[string]string, len(prefixes)+1)
+						for k, v := range prefixes {
+							n.prefixes[k] = v
+						}
+						copied = true
+					}
+					if a.Name.Space == "xmlns" {
+						n.prefixes[a.Name.Local] = a.Value
+					} else {
+						n.prefixes[""] = a.Value
+					}
+				case a.Name.Space == "":
+					n.attrs[a.Name.Local] = a.Value
+				}
+			}
+			if len(stack) == 0 {
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(tok)
+			}
+		}
+	}
+	if root == nil || root.name != "schema" {
+		return nil, errors.New("expected a schema element")
+	}
+	return root, nil
+}
+
+type xsdImporter struct {
+	elements        map[xml.Name]*xsdNode
+	types           map[xml.Name]*xsdNode
+	groups          map[xml.Name]*xsdNode
+	attributeGroups map[xml.Name]*xsdNode
+	attributes      map[xml.Name]*xsdNode
+	//globals holds the names of the global elements in order.
+	globals []xml.Name
+	//substitutes holds the global elements that are in the substitution group of an element.
+	substitutes map[xml.Name][]xml.Name
+	loaded      map[string]bool
+
+	defines     map[string]*rngNode
+	defineOrder []string
+	defineNames map[string]string
+	simples     map[*xsdNode]*xsdSimple
+	busy        map[*xsdNode]bool
+	unsupported []string
+}
+
+func newXSDImporter() *xsdImporter {
+	return &xsdImporter{
+		elements:        make(map[xml.Name]*xsdNode),
+		types:           make(map[xml.Name]*xsdNode),
+		groups:          make(map[xml.Name]*xsdNode),
+		attributeGroups: make(map[xml.Name]*xsdNode),
+		attributes:      make(map[xml.Name]*xsdNode),
+		substitutes:     make(map[xml.Name][]xml.Name),
+		loaded:          make(map[string]bool),
+		defines:         make(map[string]*rngNode),
+		defineNames:     make(map[string]string),
+		simples:         make(map[*xsdNode]*xsdSimple),
+		busy:            make(map[*xsdNode]bool),
+	}
+}
+
+func (this *xsdImporter) unsupportedf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, u := range this.unsupported {
+		if u == msg {
+			return
+		}
+	}
+	this.unsupported = append(this.unsupported, msg)
+}
+
+//load registers the global components of a schema document and loads the schemas that it includes and imports.
+//If includedInto is not nil, the schema is included into a schema with that target namespace.
+func (this *xsdImporter) load(buf []byte, filename string, includedInto *string) error {
+	if len(filename) > 0 {
+		abs, err := filepath.Abs(filename)
+		if err == nil {
+			if this.loaded[abs] {
+				return nil
+			}
+			this.loaded[abs] = true
+		}
+	}
+	root, err := parseXSD(buf)
+	if err != nil {
+		if len(filename) > 0 {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		return err
+	}
+	schema := &xsdSchema{
+		targetNamespace:    root.attrs["targetNamespace"],
+		elementQualified:   root.attrs["elementFormDefault"] == "qualified",
+		attributeQualified: root.attrs["attributeFormDefault"] == "qualified",
+	}
+	if includedInto != nil && len(schema.targetNamespace) == 0 && len(*includedInto) > 0 {
+		schema.targetNamespace = *includedInto
+		schema.chameleon = true
+	}
+	setSchema(root, schema)
+	for _, c := range root.children {
+		name := xml.Name{Space: schema.targetNamespace, Local: c.attrs["name"]}
+		switch c.name {
+		case "element":
+			this.elements[name] = c
+			this.globals = append(this.globals, name)
+		case "complexType", "simpleType":
+			this.types[name] = c
+		case "group":
+			this.groups[name] = c
+		case "attributeGroup":
+			this.attributeGroups[name] = c
+		case "attribute":
+			this.attributes[name] = c
+		case "include", "import":
+			if c.name == "import" && c.attrs["namespace"] == xmlNs {
+				//Attributes in the xml namespace are known without reading xml.xsd.
+				continue
+			}
+			location, ok := c.attr("schemaLocation")
+			if !ok {
+				if c.name == "import" && isBuiltinNamespace(c.attrs["namespace"]) {
+					continue
+				}
+				this.unsupportedf("%s without a schemaLocation", c.name)
+				continue
+			}
+			if len(filename) == 0 {
+				this.unsupportedf("%s of %s, which can only be resolved with FromXSDFile", c.name, location)
+				continue
+			}
+			path := filepath.Join(filepath.Dir(filename), location)
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var into *string
+			if c.name == "include" {
+				into = &schema.targetNamespace
+			}
+			if err := this.load(data, path, into); err != nil {
+				return err
+			}
+		case "redefine", "override", "defaultOpenContent":
+			this.unsupportedf("%s", c.name)
+		case "notation":
+		}
+	}
+	return nil
+}
+
+func isBuiltinNamespace(ns string) bool {
+	return ns == "http://www.w3.org/XML/1998/namespace" || ns == xsdNamespace
+}
+
+func setSchema(n *xsdNode, schema *xsdSchema) {
+	n.schema = schema
+	for _, c := range n.children {
+		setSchema(c, schema)
+	}
+}
+
+//grammar returns the simplified grammar with all global elements as possible roots.
+func (this *xsdImporter) grammar() (*Grammar, error) {
+	for _, name := range this.globals {
+		e := this.elements[name]
+		group, ok := e.attr("substitutionGroup")
+		if !ok {
+			continue
+		}
+		for _, head := range strings.Fields(group) {
+			headName, err := e.qname(head)
+			if err != nil {
+				return nil, err
+			}
+			this.substitutes[headName] = append(this.substitutes[headName], name)
+		}
+	}
+	if len(this.globals) == 0 {
+		return nil, errors.New("schema has no global elements")
+	}
+	var roots []*rngNode
+	for _, name := range this.globals {
+		if this.elements[name].attrs["abstract"] == "true" {
+			continue
+		}
+		ref, err := this.globalElement(name)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, ref)
+	}
+	start := newRngNode("start", choiceOf(roots))
+	grammar := newRngNodeAttrs("grammar", map[string]string{"xmlns": relaxngNs}, start)
+	for _, name := range this.defineOrder {
+		grammar.children = append(grammar.children, this.defines[name])
+	}
+	buf := bytes.NewBuffer(nil)
+	writeNode(buf, grammar, 0)
+	g, err := Simplify(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("imported grammar is not valid: %v", err)
+	}
+	if len(this.unsupported) > 0 {
+		return g, &UnsupportedError{Features: this.unsupported}
+	}
+	return g, nil
+}
+
+//writeNode writes the node as indented xml, with the namespace declarations first and the other attributes sorted by name.
+func writeNode(buf *bytes.Buffer, n *rngNode, depth int) {
+	buf.WriteString(strings.Repeat("\t", depth) + "<" + n.name)
+	keys := make([]string, 0, len(n.attrs))
+	for k := range n.attrs {
+		keys = append(keys, k)
+	}
+	sort.Sort(attrKeys(keys))
+	for _, k := range keys {
+		buf.WriteString(" " + k + `="`)
+		xml.EscapeText(buf, []byte(n.attrs[k]))
+		buf.WriteString(`"`)
+	}
+	switch {
+	case len(n.children) == 0 && len(n.text) == 0:
+		buf.WriteString("/>\n")
+		return
+	case len(n.children) == 0:
+		buf.WriteString(">")
+		xml.EscapeText(buf, []byte(n.text))
+		buf.WriteString("</" + n.name + ">\n")
+		return
+	}
+	buf.WriteString(">\n")
+	for _, c := range n.children {
+		writeNode(buf, c, depth+1)
+	}
+	buf.WriteString(strings.Repeat("\t", depth) + "</" + n.name + ">\n")
+}
+
+//attrKeys sorts namespace declarations before other attributes.
+type attrKeys []string
+
+func (this attrKeys) Len() int      { return len(this) }
+func (this attrKeys) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this attrKeys) Less(i, j int) bool {
+	xi, xj := strings.HasPrefix(this[i], "xmlns"), strings.HasPrefix(this[j], "xmlns")
+	if xi != xj {
+		return xi
+	}
+	return this[i] < this[j]
+}
+
+func newRngNodeAttrs(name string, attrs map[string]string, children ...*rngNode) *rngNode {
+	n := newRngNode(name, children...)
+	for k, v := range attrs {
+		n.attrs[k] = v
+	}
+	return n
+}
+
+func refNode(name string) *rngNode {
+	return newRngNodeAttrs("ref", map[string]string{"name": name})
+}
+
+//choiceOf returns a choice of the nodes, the node itself if there is only one or notAllowed if there are none.
+func choiceOf(ns []*rngNode) *rngNode {
+	switch len(ns) {
+	case 0:
+		return newRngNode("notAllowed")
+	case 1:
+		return ns[0]
+	}
+	return newRngNode("choice", ns...)
+}
+
+//composeNodes returns a group or interleave of the nodes, the node itself if there is only one or empty if there are none.
+func composeNodes(name string, ns []*rngNode) *rngNode {
+	switch len(ns) {
+	case 0:
+		return newRngNode("empty")
+	case 1:
+		return ns[0]
+	}
+	return newRngNode(name, ns...)
+}
+
+//define returns the name of the define for the component, which is created by build, the first time it is requested.
+func (this *xsdImporter) define(kind string, name xml.Name, build func() (*rngNode, error)) (string, error) {
+	key := kind + " {" + name.Space + "}" + name.Local
+	if defineName, ok := this.defineNames[key]; ok {
+		return defineName, nil
+	}
+	defineName := name.Local
+	for i := 1; this.defines[defineName] != nil || defineName == anyContentName; i++ {
+		defineName = name.Local + "_" + strconv.Itoa(i)
+	}
+	this.defineNames[key] = defineName
+	d := newRngNodeAttrs("define", map[string]string{"name": defineName})
+	this.defines[defineName] = d
+	this.defineOrder = append(this.defineOrder, defineName)
+	content, err := build()
+	if err != nil {
+		return "", err
+	}
+	d.children = []*rngNode{content}
+	return defineName, nil
+}
+
+//anyContent returns a reference to the content that allows any attributes, text and elements.
+func (this *xsdImporter) anyContent() *rngNode {
+	if _, ok := this.defines[anyContentName]; !ok {
+		element := newRngNode("element", newRngNode("anyName"), refNode(anyContentName))
+		attribute := newRngNode("attribute", newRngNode("anyName"))
+		content := newRngNode("zeroOrMore", newRngNode("choice", attribute, newRngNode("text"), element))
+		this.defines[anyContentName] = newRngNodeAttrs("define", map[string]string{"name": anyContentName}, content)
+		this.defineOrder = append(this.defineOrder, anyContentName)
+	}
+	return refNode(anyContentName)
+}
+
+//globalElement returns a reference to the global element or the choice of its substitution group.
+func (this *xsdImporter) globalElement(name xml.Name) (*rngNode, error) {
+	e, ok := this.elements[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined element {%s}%s", name.Space, name.Local)
+	}
+	var choices []*rngNode
+	if e.attrs["abstract"] != "true" {
+		defineName, err := this.define("element", name, func() (*rngNode, error) {
+			return this.element(e, name)
+		})
+		if err != nil {
+			return nil, err
+		}
+		choices = append(choices, refNode(defineName))
+	}
+	if this.busy[e] {
+		return choiceOf(choices), nil
+	}
+	this.busy[e] = true
+	defer delete(this.busy, e)
+	for _, sub := range this.substitutes[name] {
+		ref, err := this.globalElement(sub)
+		if err != nil {
+			return nil, err
+		}
+		if ref.name != "notAllowed" {
+			choices = append(choices, ref)
+		}
+	}
+	return choiceOf(choices), nil
+}
+
+//element returns the RelaxNG element for an element declaration with the name.
+func (this *xsdImporter) element(e *xsdNode, name xml.Name) (*rngNode, error) {
+	content, err := this.elementContent(e)
+	if err != nil {
+		return nil, err
+	}
+	return newRngNodeAttrs("element", map[string]string{"name": name.Local, "ns": name.Space}, content), nil
+}
+
+func (this *xsdImporter) elementContent(e *xsdNode) (*rngNode, error) {
+	if e.attrs["nillable"] == "true" {
+		this.unsupportedf("%s: nillable", e)
+	}
+	for _, c := range e.children {
+		switch c.name {
+		case "key", "keyref", "unique":
+			this.unsupportedf("%s: identity constraint %s", e, c)
+		case "alternative":
+			this.unsupportedf("%s: type alternatives", e)
+		}
+	}
+	if fixed, ok := e.attr("fixed"); ok {
+		s, err := this.elementSimpleType(e)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			return s.value(fixed), nil
+		}
+	}
+	if typ, ok := e.attr("type"); ok {
+		name, err := e.qname(typ)
+		if err != nil {
+			return nil, err
+		}
+		return this.typeRef(name)
+	}
+	if c := e.child("complexType"); c != nil {
+		return this.complexType(c)
+	}
+	if c := e.child("simpleType"); c != nil {
+		s, err := this.simpleType(c)
+		if err != nil {
+			return nil, err
+		}
+		return s.pattern(), nil
+	}
+	if group, ok := e.attr("substitutionGroup"); ok {
+		head, err := e.qname(strings.Fields(group)[0])
+		if err != nil {
+			return nil, err
+		}
+		if h, ok := this.elements[head]; ok && !this.busy[h] {
+			this.busy[h] = true
+			defer delete(this.busy, h)
+			return this.elementContent(h)
+		}
+	}
+	return this.anyContent(), nil
+}
+
+//elementSimpleType returns the simple type of the element or nil if it has a complex type.
+func (this *xsdImporter) elementSimpleType(e *xsdNode) (*xsdSimple, error) {
+	if typ, ok := e.attr("type"); ok {
+		name, err := e.qname(typ)
+		if err != nil {
+			return nil, err
+		}
+		if name.Space == xsdNamespace && name.Local != "anyType" {
+			return &xsdSimple{builtin: name.Local}, nil
+		}
+		if t, ok := this.types[name]; ok && t.name == "simpleType" {
+			return this.simpleType(t)
+		}
+		return nil, nil
+	}
+	if c := e.child("simpleType"); c != nil {
+		return this.simpleType(c)
+	}
+	return nil, nil
+}
+
+//typeRef returns the pattern for the content of an element of the named type.
+func (this *xsdImporter) typeRef(name xml.Name) (*rngNode, error) {
+	if name.Space == xsdNamespace {
+		if name.Local == "anyType" {
+			return this.anyContent(), nil
+		}
+		return (&xsdSimple{builtin: name.Local}).pattern(), nil
+	}
+	t, ok := this.types[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined type {%s}%s", name.Space, name.Local)
+	}
+	//The suffix keeps the names of elements free for the simplifier.
+	typeName := xml.Name{Space: name.Space, Local: name.Local + "-type"}
+	defineName, err := this.define("type", typeName, func() (*rngNode, error) {
+		if t.name == "simpleType" {
+			s, err := this.simpleType(t)
+			if err != nil {
+				return nil, err
+			}
+			return s.pattern(), nil
+		}
+		return this.complexType(t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refNode(defineName), nil
+}
+
+//xsdComplex is the content of a complex type.
+type xsdComplex struct {
+	attrs    []*xsdAttribute
+	wildcard *rngNode
+	particle *rngNode
+	mixed    bool
+	//simple is the type of the text of a complex type with simple content.
+	simple *xsdSimple
+}
+
+//xsdAttribute is the use of an attribute in a complex type.
+type xsdAttribute struct {
+	name       xml.Name
+	pattern    *rngNode
+	required   bool
+	prohibited bool
+}
+
+func (this *xsdImporter) complexType(t *xsdNode) (*rngNode, error) {
+	c, err := this.complex(t)
+	if err != nil {
+		return nil, err
+	}
+	var ps []*rngNode
+	var names []*rngNode
+	for _, a := range c.attrs {
+		if a.prohibited {
+			continue
+		}
+		names = append(names, nameNode(a.name))
+		if a.required {
+			ps = append(ps, a.pattern)
+		} else {
+			ps = append(ps, newRngNode("optional", a.pattern))
+		}
+	}
+	if c.wildcard != nil {
+		nameClass := c.wildcard
+		if len(names) > 0 {
+			nameClass = exceptNameClass(nameClass, names)
+		}
+		ps = append(ps, newRngNode("zeroOrMore", newRngNode("attribute", nameClass)))
+	}
+	switch {
+	case c.simple != nil:
+		ps = append(ps, c.simple.pattern())
+	case c.particle != nil && c.mixed:
+		ps = append(ps, newRngNode("mixed", c.particle))
+	case c.particle != nil:
+		ps = append(ps, c.particle)
+	case c.mixed:
+		ps = append(ps, newRngNode("text"))
+	}
+	return composeNodes("group", ps), nil
+}
+
+func nameNode(name xml.Name) *rngNode {
+	n := newRngNodeAttrs("name", map[string]string{"ns": name.Space})
+	n.text = name.Local
+	return n
+}
+
+//exceptNameClass removes the names from an anyName or nsName name class.
+func exceptNameClass(nameClass *rngNode, names []*rngNode) *rngNode {
+	if nameClass.name == "choice" {
+		children := make([]*rngNode, len(nameClass.children))
+		for i, c := range nameClass.children {
+			children[i] = exceptNameClass(c, names)
+		}
+		return newRngNode("choice", children...)
+	}
+	c := nameClass.copy()
+	if len(c.children) > 0 {
+		except := c.children[0]
+		except.children = append(except.children, names...)
+		return c
+	}
+	c.children = []*rngNode{newRngNode("except", names...)}
+	return c
+}
+
+//complex returns the attributes and content of a complex type, including those of its base type.
+func (this *xsdImporter) complex(t *xsdNode) (*xsdComplex, error) {
+	if this.busy[t] {
+		return nil, fmt.Errorf("%s is derived from itself", t)
+	}
+	this.busy[t] = true
+	defer delete(this.busy, t)
+	mixed := t.attrs["mixed"] == "true"
+	if cc := t.child("complexContent"); cc != nil {
+		if m, ok := cc.attr("mixed"); ok {
+			mixed = m == "true"
+		}
+		derivation := cc.child("extension", "restriction")
+		if derivation == nil {
+			return nil, fmt.Errorf("%s: complexContent without extension or restriction", t)
+		}
+		base, err := this.baseComplex(derivation)
+		if err != nil {
+			return nil, err
+		}
+		own, err := this.complexParts(derivation)
+		if err != nil {
+			return nil, err
+		}
+		own.mixed = mixed
+		if derivation.name == "restriction" {
+			own.attrs = restrictAttributes(base.attrs, own.attrs)
+			return own, nil
+		}
+		own.attrs = append(append([]*xsdAttribute(nil), base.attrs...), own.attrs...)
+		if base.wildcard != nil {
+			own.wildcard = unionNameClass(base.wildcard, own.wildcard)
+		}
+		switch {
+		case base.particle == nil:
+		case own.particle == nil:
+			own.particle = base.particle
+		default:
+			own.particle = newRngNode("group", base.particle, own.particle)
+		}
+		return own, nil
+	}
+	if sc := t.child("simpleContent"); sc != nil {
+		derivation := sc.child("extension", "restriction")
+		if derivation == nil {
+			return nil, fmt.Errorf("%s: simpleContent without extension or restriction", t)
+		}
+		baseName, err := derivation.qname(derivation.attrs["base"])
+		if err != nil {
+			return nil, err
+		}
+		own, err := this.complexParts(derivation)
+		if err != nil {
+			return nil, err
+		}
+		own.particle = nil
+		var base *xsdComplex
+		if bt, ok := this.types[baseName]; ok && bt.name == "complexType" {
+			base, err = this.complex(bt)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			s, err := this.simpleTypeRef(baseName)
+			if err != nil {
+				return nil, err
+			}
+			base = &xsdComplex{simple: s}
+		}
+		if base.simple == nil {
+			base.simple = &xsdSimple{builtin: "anySimpleType"}
+		}
+		own.simple = base.simple
+		if derivation.name == "restriction" {
+			if inline := derivation.child("simpleType"); inline != nil {
+				s, err := this.simpleType(inline)
+				if err != nil {
+					return nil, err
+				}
+				own.simple = s
+			}
+			own.simple, err = this.restrict(own.simple, derivation)
+			if err != nil {
+				return nil, err
+			}
+			own.attrs = restrictAttributes(base.attrs, own.attrs)
+			return own, nil
+		}
+		own.attrs = append(append([]*xsdAttribute(nil), base.attrs...), own.attrs...)
+		if base.wildcard != nil {
+			own.wildcard = unionNameClass(base.wildcard, own.wildcard)
+		}
+		return own, nil
+	}
+	c, err := this.complexParts(t)
+	if err != nil {
+		return nil, err
+	}
+	c.mixed = mixed
+	return c, nil
+}
+
+//baseComplex returns the content of the base type of an extension or restriction.
+func (this *xsdImporter) baseComplex(derivation *xsdNode) (*xsdComplex, error) {
+	baseName, err := derivation.qname(derivation.attrs["base"])
+	if err != nil {
+		return nil, err
+	}
+	if baseName.Space == xsdNamespace && baseName.Local == "anyType" {
+		if derivation.name == "extension" {
+			this.unsupportedf("extension of anyType")
+		}
+		return &xsdComplex{}, nil
+	}
+	bt, ok := this.types[baseName]
+	if !ok || bt.name != "complexType" {
+		return nil, fmt.Errorf("undefined complex type {%s}%s", baseName.Space, baseName.Local)
+	}
+	return this.complex(bt)
+}
+
+//restrictAttributes returns the attributes of the base, replaced or prohibited by those of the restriction.
+func restrictAttributes(base, restriction []*xsdAttribute) []*xsdAttribute {
+	var attrs []*xsdAttribute
+	for _, b := range base {
+		replaced := false
+		for _, r := range restriction {
+			if r.name == b.name {
+				replaced = true
+			}
+		}
+		if !replaced {
+			attrs = append(attrs, b)
+		}
+	}
+	return append(attrs, restriction...)
+}
+
+func unionNameClass(a, b *rngNode) *rngNode {
+	if b == nil {
+		return a
+	}
+	return newRngNode("choice", a, b)
+}
+
+//complexParts returns the attributes and the particle of a complex type, extension or restriction.
+func (this *xsdImporter) complexParts(n *xsdNode) (*xsdComplex, error) {
+	c := &xsdComplex{}
+	for _, child := range n.children {
+		switch child.name {
+		case "sequence", "choice", "all", "group":
+			p, err := this.particle(child)
+			if err != nil {
+				return nil, err
+			}
+			c.particle = p
+		case "attribute", "attributeGroup", "anyAttribute":
+			if err := this.attributeUses(child, c); err != nil {
+				return nil, err
+			}
+		case "openContent", "assert":
+			this.unsupportedf("%s: %s", n, child.name)
+		}
+	}
+	return c, nil
+}
+
+//attributeUses adds the attributes, attribute groups and wildcards to the complex type.
+func (this *xsdImporter) attributeUses(n *xsdNode, c *xsdComplex) error {
+	switch n.name {
+	case "attribute":
+		a, err := this.attribute(n)
+		if err != nil {
+			return err
+		}
+		c.attrs = append(c.attrs, a)
+	case "attributeGroup":
+		name, err := n.qname(n.attrs["ref"])
+		if err != nil {
+			return err
+		}
+		g, ok := this.attributeGroups[name]
+		if !ok {
+			return fmt.Errorf("undefined attribute group {%s}%s", name.Space, name.Local)
+		}
+		if this.busy[g] {
+			return fmt.Errorf("%s references itself", g)
+		}
+		this.busy[g] = true
+		defer delete(this.busy, g)
+		for _, child := range g.children {
+			if err := this.attributeUses(child, c); err != nil {
+				return err
+			}
+		}
+	case "anyAttribute":
+		c.wildcard = unionNameClass(this.wildcard(n), c.wildcard)
+	}
+	return nil
+}
+
+//attribute returns the use of a local attribute or a reference to a global attribute.
+func (this *xsdImporter) attribute(n *xsdNode) (*xsdAttribute, error) {
+	decl := n
+	var name xml.Name
+	if ref, ok := n.attr("ref"); ok {
+		var err error
+		name, err = n.qname(ref)
+		if err != nil {
+			return nil, err
+		}
+		if name.Space == xmlNs {
+			decl = &xsdNode{name: "attribute", attrs: map[string]string{}, schema: n.schema}
+		} else if decl, ok = this.attributes[name]; !ok {
+			return nil, fmt.Errorf("undefined attribute {%s}%s", name.Space, name.Local)
+		}
+	} else {
+		name = xml.Name{Local: n.attrs["name"]}
+		form, ok := n.attr("form")
+		if ok && form == "qualified" || !ok && n.schema.attributeQualified {
+			name.Space = n.schema.targetNamespace
+		}
+	}
+	a := &xsdAttribute{name: name, required: n.attrs["use"] == "required", prohibited: n.attrs["use"] == "prohibited"}
+	s, err := this.attributeType(decl)
+	if err != nil {
+		return nil, err
+	}
+	value := s.pattern()
+	fixed, ok := n.attr("fixed")
+	if !ok {
+		fixed, ok = decl.attr("fixed")
+	}
+	if ok {
+		value = s.value(fixed)
+	}
+	a.pattern = newRngNodeAttrs("attribute", map[string]string{"name": name.Local, "ns": name.Space}, value)
+	return a, nil
+}
+
+func (this *xsdImporter) attributeType(decl *xsdNode) (*xsdSimple, error) {
+	if typ, ok := decl.attr("type"); ok {
+		name, err := decl.qname(typ)
+		if err != nil {
+			return nil, err
+		}
+		return this.simpleTypeRef(name)
+	}
+	if c := decl.child("simpleType"); c != nil {
+		return this.simpleType(c)
+	}
+	return &xsdSimple{builtin: "anySimpleType"}, nil
+}
+
+//wildcard returns the name class of an any or anyAttribute.
+func (this *xsdImporter) wildcard(n *xsdNode) *rngNode {
+	tns := n.schema.targetNamespace
+	nsName := func(ns string) *rngNode {
+		return newRngNodeAttrs("nsName", map[string]string{"ns": ns})
+	}
+	namespace, ok := n.attr("namespace")
+	if !ok {
+		namespace = "##any"
+	}
+	if _, ok := n.attr("notNamespace"); ok {
+		this.unsupportedf("%s: notNamespace", n)
+	}
+	switch strings.TrimSpace(namespace) {
+	case "##any":
+		return newRngNode("anyName")
+	case "##other":
+		if len(tns) == 0 {
+			return newRngNode("anyName", newRngNode("except", nsName("")))
+		}
+		return newRngNode("anyName", newRngNode("except", nsName(tns), nsName("")))
+	}
+	var choices []*rngNode
+	for _, ns := range strings.Fields(namespace) {
+		switch ns {
+		case "##targetNamespace":
+			ns = tns
+		case "##local":
+			ns = ""
+		}
+		choices = append(choices, nsName(ns))
+	}
+	return choiceOf(choices)
+}
+
+//particle returns the pattern for an element, any, sequence, choice, all or group reference with its occurrences.
+func (this *xsdImporter) particle(n *xsdNode) (*rngNode, error) {
+	var p *rngNode
+	switch n.name {
+	case "element":
+		if ref, ok := n.attr("ref"); ok {
+			name, err := n.qname(ref)
+			if err != nil {
+				return nil, err
+			}
+			p, err = this.globalElement(name)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		name := xml.Name{Local: n.attrs["name"]}
+		form, ok := n.attr("form")
+		if ok && form == "qualified" || !ok && n.schema.elementQualified {
+			name.Space = n.schema.targetNamespace
+		}
+		var err error
+		p, err = this.element(n, name)
+		if err != nil {
+			return nil, err
+		}
+	case "any":
+		p = newRngNode("element", this.wildcard(n), this.anyContent())
+	case "sequence", "choice", "all":
+		var ps []*rngNode
+		for _, c := range n.children {
+			cp, err := this.particle(c)
+			if err != nil {
+				return nil, err
+			}
+			if cp != nil {
+				ps = append(ps, cp)
+			}
+		}
+		switch n.name {
+		case "sequence":
+			p = composeNodes("group", ps)
+		case "choice":
+			p = choiceOf(ps)
+		case "all":
+			p = composeNodes("interleave", ps)
+		}
+	case "group":
+		name, err := n.qname(n.attrs["ref"])
+		if err != nil {
+			return nil, err
+		}
+		g, ok := this.groups[name]
+		if !ok {
+			return nil, fmt.Errorf("undefined group {%s}%s", name.Space, name.Local)
+		}
+		if this.busy[g] {
+			return nil, fmt.Errorf("%s references itself", g)
+		}
+		this.busy[g] = true
+		defer delete(this.busy, g)
+		model := g.child("sequence", "choice", "all")
+		if model == nil {
+			p = newRngNode("empty")
+			break
+		}
+		p, err = this.particle(model)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	return this.occurs(n, p)
+}
+
+//occurs repeats the pattern according to minOccurs and maxOccurs.
+func (this *xsdImporter) occurs(n *xsdNode, p *rngNode) (*rngNode, error) {
+	min, max := 1, 1
+	if v, ok := n.attr("minOccurs"); ok {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid minOccurs %q", n, v)
+		}
+		min = i
+	}
+	unbounded := false
+	if v, ok := n.attr("maxOccurs"); ok {
+		if strings.TrimSpace(v) == "unbounded" {
+			unbounded = true
+		} else {
+			i, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid maxOccurs %q", n, v)
+			}
+			max = i
+		}
+	}
+	if !unbounded && max == 0 {
+		return newRngNode("empty"), nil
+	}
+	if !unbounded && max > maxXSDOccurs {
+		this.unsupportedf("%s: maxOccurs %d is treated as unbounded", n, max)
+		unbounded = true
+	}
+	if min > maxXSDOccurs {
+		this.unsupportedf("%s: minOccurs %d is treated as %d", n, min, maxXSDOccurs)
+		min = maxXSDOccurs
+	}
+	if (unbounded && min > 1 || !unbounded && max > 1) && p.name == "element" {
+		//An element that is repeated is defined once, so that it is not simplified into a define per repetition.
+		element := p
+		name := xml.Name{Local: element.attrs["name"]}
+		if len(name.Local) == 0 {
+			name.Local = "element"
+		}
+		defineName, err := this.define(fmt.Sprintf("%p", n), name, func() (*rngNode, error) {
+			return element, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p = refNode(defineName)
+	}
+	return repeat(p, min, max, unbounded), nil
+}
+
+//repeat repeats the pattern at least min and at most max times.
+func repeat(p *rngNode, min, max int, unbounded bool) *rngNode {
+	var ps []*rngNode
+	for i := 0; i < min; i++ {
+		ps = append(ps, p)
+	}
+	if unbounded {
+		if min == 0 {
+			return newRngNode("zeroOrMore", p)
+		}
+		ps[len(ps)-1] = newRngNode("oneOrMore", p)
+		return composeNodes("group", ps)
+	}
+	var optional *rngNode
+	for i := min; i < max; i++ {
+		if optional == nil {
+			optional = newRngNode("optional", p)
+		} else {
+			optional = newRngNode("optional", p, optional)
+		}
+	}
+	if optional != nil {
+		ps = append(ps, optional)
+	}
+	return composeNodes("group", ps)
+}
+
+//xsdSimple is a simple type, which is a builtin type with facets, a list or a union.
+type xsdSimple struct {
+	builtin string
+	params  [][2]string
+	enum    []string
+	hasEnum bool
+	list    *xsdSimple
+	//minItems and maxItems are the length facets of a list, where a negative maxItems is unbounded.
+	minItems int
+	maxItems int
+	union    []*xsdSimple
+}
+
+func (this *xsdSimple) copy() *xsdSimple {
+	c := *this
+	c.params = append([][2]string(nil), this.params...)
+	return &c
+}
+
+//xsdStrings are the builtin types, whose values are compared without normalizing whitespace.
+var xsdStrings = map[string]bool{"string": true, "normalizedString": true, "anySimpleType": true}
+
+//valueType returns the type and datatype library of values of the simple type.
+func (this *xsdSimple) valueType() (string, string) {
+	switch {
+	case this.list != nil, len(this.union) > 0:
+		return "token", ""
+	case xsdStrings[this.builtin]:
+		return "string", ""
+	case this.builtin == "token" || len(this.builtin) == 0:
+		return "token", ""
+	}
+	return this.builtin, xsdDatatypes
+}
+
+func (this *xsdSimple) value(v string) *rngNode {
+	typ, lib := this.valueType()
+	n := newRngNodeAttrs("value", map[string]string{"type": typ, "datatypeLibrary": lib})
+	n.text = v
+	return n
+}
+
+func (this *xsdSimple) pattern() *rngNode {
+	switch {
+	case this.hasEnum:
+		values := make([]*rngNode, len(this.enum))
+		for i, v := range this.enum {
+			values[i] = this.value(v)
+		}
+		return choiceOf(values)
+	case this.list != nil:
+		return newRngNode("list", repeat(this.list.pattern(), this.minItems, this.maxItems, this.maxItems < 0))
+	case len(this.union) > 0:
+		choices := make([]*rngNode, len(this.union))
+		for i, u := range this.union {
+			choices[i] = u.pattern()
+		}
+		return choiceOf(choices)
+	case this.builtin == "anySimpleType":
+		return newRngNode("text")
+	case len(this.params) == 0 && (this.builtin == "string" || this.builtin == "token"):
+		return newRngNodeAttrs("data", map[string]string{"type": this.builtin, "datatypeLibrary": ""})
+	}
+	data := newRngNodeAttrs("data", map[string]string{"type": this.builtin, "datatypeLibrary": xsdDatatypes})
+	for _, p := range this.params {
+		param := newRngNodeAttrs("param", map[string]string{"name": p[0]})
+		param.text = p[1]
+		data.children = append(data.children, param)
+	}
+	return data
+}
+
+func (this *xsdImporter) simpleTypeRef(name xml.Name) (*xsdSimple, error) {
+	if name.Space == xsdNamespace {
+		if name.Local == "anyType" {
+			return &xsdSimple{builtin: "anySimpleType"}, nil
+		}
+		return &xsdSimple{builtin: name.Local}, nil
+	}
+	t, ok := this.types[name]
+	if !ok || t.name != "simpleType" {
+		return nil, fmt.Errorf("undefined simple type {%s}%s", name.Space, name.Local)
+	}
+	return this.simpleType(t)
+}
+
+func (this *xsdImporter) simpleType(t *xsdNode) (*xsdSimple, error) {
+	if s, ok := this.simples[t]; ok {
+		return s, nil
+	}
+	if this.busy[t] {
+		return nil, fmt.Errorf("%s is derived from itself", t)
+	}
+	this.busy[t] = true
+	defer delete(this.busy, t)
+	var s *xsdSimple
+	var err error
+	switch {
+	case t.child("restriction") != nil:
+		r := t.child("restriction")
+		if base, ok := r.attr("base"); ok {
+			name, err := r.qname(base)
+			if err != nil {
+				return nil, err
+			}
+			s, err = this.simpleTypeRef(name)
+			if err != nil {
+				return nil, err
+			}
+		} else if inline := r.child("simpleType"); inline != nil {
+			s, err = this.simpleType(inline)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("%s: restriction without a base", t)
+		}
+		s, err = this.restrict(s, r)
+		if err != nil {
+			return nil, err
+		}
+	case t.child("list") != nil:
+		l := t.child("list")
+		var item *xsdSimple
+		if itemType, ok := l.attr("itemType"); ok {
+			name, err := l.qname(itemType)
+			if err != nil {
+				return nil, err
+			}
+			item, err = this.simpleTypeRef(name)
+			if err != nil {
+				return nil, err
+			}
+		} else if inline := l.child("simpleType"); inline != nil {
+			item, err = this.simpleType(inline)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("%s: list without an item type", t)
+		}
+		s = &xsdSimple{list: item, maxItems: -1}
+	case t.child("union") != nil:
+		u := t.child("union")
+		s = &xsdSimple{}
+		for _, member := range strings.Fields(u.attrs["memberTypes"]) {
+			name, err := u.qname(member)
+			if err != nil {
+				return nil, err
+			}
+			m, err := this.simpleTypeRef(name)
+			if err != nil {
+				return nil, err
+			}
+			s.union = append(s.union, m)
+		}
+		for _, c := range u.children {
+			if c.name == "simpleType" {
+				m, err := this.simpleType(c)
+				if err != nil {
+					return nil, err
+				}
+				s.union = append(s.union, m)
+			}
+		}
+		if len(s.union) == 0 {
+			return nil, fmt.Errorf("%s: union without member types", t)
+		}
+	default:
+		return nil, fmt.Errorf("%s: expected a restriction, list or union", t)
+	}
+	this.simples[t] = s
+	return s, nil
+}
+
+//xsdFacets are the facets that become parameters of data.
+var xsdFacets = map[string]bool{
+	"length": true, "minLength": true, "maxLength": true, "pattern": true,
+	"minInclusive": true, "maxInclusive": true, "minExclusive": true, "maxExclusive": true,
+	"totalDigits": true, "fractionDigits": true,
+}
+
+//restrict applies the facets of the restriction to the simple type.
+func (this *xsdImporter) restrict(s *xsdSimple, r *xsdNode) (*xsdSimple, error) {
+	s = s.copy()
+	var enum []string
+	hasEnum := false
+	for _, f := range r.children {
+		value := f.attrs["value"]
+		switch {
+		case f.name == "enumeration":
+			hasEnum = true
+			enum = append(enum, value)
+		case f.name == "whiteSpace":
+		case s.list != nil && !s.hasEnum && (f.name == "length" || f.name == "minLength" || f.name == "maxLength"):
+			i, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("%s: invalid %s %q", r, f.name, value)
+			}
+			if i > maxXSDOccurs {
+				this.unsupportedf("%s: %s %d on a list", r, f.name, i)
+				continue
+			}
+			if f.name != "maxLength" {
+				s.minItems = i
+			}
+			if f.name != "minLength" {
+				s.maxItems = i
+			}
+		case xsdFacets[f.name]:
+			if s.list != nil || len(s.union) > 0 || s.hasEnum || s.builtin == "anySimpleType" {
+				this.unsupportedf("%s: facet %s on a list, union, enumeration or anySimpleType", r, f.name)
+				continue
+			}
+			s.params = append(s.params, [2]string{f.name, value})
+		case f.name == "simpleType", f.name == "attribute", f.name == "attributeGroup", f.name == "anyAttribute",
+			f.name == "sequence", f.name == "choice", f.name == "all", f.name == "group":
+		default:
+			this.unsupportedf("%s: facet %s", r, f.name)
+		}
+	}
+	if hasEnum {
+		s.enum, s.hasEnum = enum, true
+	}
+	return s, nil
+}
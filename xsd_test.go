@@ -0,0 +1,330 @@
+package relaxng
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testXSD = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+	<xs:annotation><xs:documentation>orders</xs:documentation></xs:annotation>
+	<xs:element name="order" type="orderType"/>
+	<xs:element name="item" type="itemType" abstract="true"/>
+	<xs:element name="book" type="itemType" substitutionGroup="item"/>
+	<xs:element name="dvd" substitutionGroup="item"/>
+	<xs:complexType name="orderType">
+		<xs:sequence>
+			<xs:element name="customer" type="xs:string"/>
+			<xs:element ref="item" maxOccurs="unbounded"/>
+			<xs:choice minOccurs="0">
+				<xs:element name="note" type="xs:string"/>
+				<xs:element name="gift" type="empty"/>
+			</xs:choice>
+			<xs:group ref="totals"/>
+		</xs:sequence>
+		<xs:attributeGroup ref="common"/>
+		<xs:attribute name="status" type="status" use="required"/>
+	</xs:complexType>
+	<xs:complexType name="baseItem">
+		<xs:all>
+			<xs:element name="title" type="xs:string"/>
+		</xs:all>
+		<xs:attribute name="sku" type="xs:token"/>
+	</xs:complexType>
+	<xs:complexType name="itemType">
+		<xs:complexContent>
+			<xs:extension base="baseItem">
+				<xs:sequence>
+					<xs:element name="price" type="price" minOccurs="0" maxOccurs="2"/>
+				</xs:sequence>
+			</xs:extension>
+		</xs:complexContent>
+	</xs:complexType>
+	<xs:complexType name="empty"/>
+	<xs:complexType name="price">
+		<xs:simpleContent>
+			<xs:extension base="xs:decimal">
+				<xs:attribute name="currency" fixed="EUR"/>
+			</xs:extension>
+		</xs:simpleContent>
+	</xs:complexType>
+	<xs:group name="totals">
+		<xs:sequence>
+			<xs:element name="paid" type="xs:boolean"/>
+		</xs:sequence>
+	</xs:group>
+	<xs:attributeGroup name="common">
+		<xs:attribute name="id" type="xs:integer"/>
+		<xs:anyAttribute namespace="##other"/>
+	</xs:attributeGroup>
+	<xs:simpleType name="status">
+		<xs:restriction base="xs:token">
+			<xs:enumeration value="open"/>
+			<xs:enumeration value="closed"/>
+		</xs:restriction>
+	</xs:simpleType>
+</xs:schema>`
+
+func TestFromXSD(t *testing.T) {
+	g, err := FromXSD([]byte(testXSD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `namespace ns1 = ""
+
+start = order | book | dvd
+order = element order { attribute id { xsd:integer }?, attribute status { "open" | "closed" }, attribute * - (ns1:* | id | status) { text }*, customer, (book | dvd)+, (note | gift)?, paid }
+book = element book { attribute sku { token }?, title, (price, price?)? }
+price = element price { attribute currency { string "EUR" }?, xsd:decimal }
+dvd = element dvd { (attribute * { text } | text | \element)* }
+customer = element customer { string }
+note = element note { string }
+gift = element gift { empty }
+paid = element paid { xsd:boolean }
+title = element title { string }
+\element = element * { (attribute * { text } | text | \element)* }
+`
+	if got := g.Compact(); got != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, got)
+	}
+	valid := []string{
+		`<order status="open"><customer>bob</customer><book><title>a</title></book><paid>true</paid></order>`,
+		`<order id="1" status="closed"><customer>bob</customer><book sku="x"><title>a</title><price currency="EUR">1.5</price></book><dvd>any <b>thing</b></dvd><note>n</note><paid>false</paid></order>`,
+		`<book><title>a</title><price>1</price><price>2</price></book>`,
+	}
+	for _, v := range valid {
+		if err := diagnoseDoc(g, v); err != nil {
+			t.Errorf("expected %s to be valid: %v", v, err)
+		}
+	}
+	invalid := []string{
+		`<order status="pending"><customer>bob</customer><book><title>a</title></book><paid>true</paid></order>`,
+		`<order status="open"><customer>bob</customer><item><title>a</title></item><paid>true</paid></order>`,
+		`<order status="open"><customer>bob</customer><book><title>a</title></book></order>`,
+		`<book><title>a</title><price>1</price><price>2</price><price>3</price></book>`,
+		`<book><title>a</title><price currency="USD">1</price></book>`,
+		`<item><title>a</title></item>`,
+	}
+	for _, v := range invalid {
+		if err := diagnoseDoc(g, v); err == nil {
+			t.Errorf("expected %s to be invalid", v)
+		}
+	}
+}
+
+func diagnoseDoc(g *Grammar, doc string) error {
+	findings, err := Diagnose(g, []byte(doc))
+	if err != nil {
+		return err
+	}
+	if len(findings) > 0 {
+		return findings[0]
+	}
+	return nil
+}
+
+func TestFromXSDSimpleTypes(t *testing.T) {
+	g, err := FromXSD([]byte(`<schema xmlns="http://www.w3.org/2001/XMLSchema" xmlns:t="urn:t" targetNamespace="urn:t" elementFormDefault="qualified">
+		<element name="values">
+			<complexType mixed="true">
+				<sequence>
+					<element name="sizes" type="t:sizes"/>
+					<element name="code" type="t:code"/>
+					<element name="quantity" type="t:quantity" minOccurs="2" maxOccurs="unbounded"/>
+					<any namespace="##other" processContents="lax" minOccurs="0"/>
+				</sequence>
+				<attribute name="local" type="string"/>
+			</complexType>
+		</element>
+		<simpleType name="sizes">
+			<restriction>
+				<simpleType><list itemType="t:size"/></simpleType>
+				<minLength value="1"/>
+				<maxLength value="3"/>
+			</restriction>
+		</simpleType>
+		<simpleType name="size">
+			<union memberTypes="positiveInteger">
+				<simpleType>
+					<restriction base="string"><enumeration value="small"/><enumeration value="large"/></restriction>
+				</simpleType>
+			</union>
+		</simpleType>
+		<simpleType name="code">
+			<restriction base="string"><pattern value="[A-Z]+"/><maxLength value="3"/></restriction>
+		</simpleType>
+		<simpleType name="quantity">
+			<restriction base="t:positive"><maxInclusive value="10"/></restriction>
+		</simpleType>
+		<simpleType name="positive">
+			<restriction base="integer"><minInclusive value="1"/></restriction>
+		</simpleType>
+	</schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `namespace ns1 = "urn:t"
+namespace ns2 = ""
+
+start = values
+values = element ns1:values { attribute local { string }?, ((sizes, code, quantity, quantity+, \element?) & text) }
+quantity = element ns1:quantity { xsd:integer { minInclusive = "1" maxInclusive = "10" } }
+sizes = element ns1:sizes { list { (xsd:positiveInteger | string "small" | string "large"), ((xsd:positiveInteger | string "small" | string "large"), (xsd:positiveInteger | string "small" | string "large")?)? } }
+code = element ns1:code { xsd:string { pattern = "[A-Z]+" maxLength = "3" } }
+\element = element * - (ns1:* | ns2:*) { (attribute * { text } | text | element_1)* }
+element_1 = element * { (attribute * { text } | text | element_1)* }
+`
+	if got := g.Compact(); got != want {
+		t.Fatalf("expected\n%s\nbut got\n%s", want, got)
+	}
+}
+
+func TestFromXSDFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relaxng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"main.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:a="urn:a" xmlns:b="urn:b" targetNamespace="urn:a">
+			<xs:include schemaLocation="types.xsd"/>
+			<xs:import namespace="urn:b" schemaLocation="b.xsd"/>
+			<xs:element name="root" type="a:rootType"/>
+		</xs:schema>`,
+		"types.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:b="urn:b">
+			<xs:complexType name="rootType">
+				<xs:sequence><xs:element ref="b:leaf"/></xs:sequence>
+				<xs:attribute ref="b:lang"/>
+			</xs:complexType>
+		</xs:schema>`,
+		"b.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:b">
+			<xs:element name="leaf" type="xs:token" fixed="x"/>
+			<xs:attribute name="lang" type="xs:language"/>
+		</xs:schema>`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, err := FromXSDFile(filepath.Join(dir, "main.xsd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := `<root xmlns="urn:a" xmlns:b="urn:b" b:lang="en"><b:leaf> x </b:leaf></root>`
+	if err := diagnoseDoc(g, valid); err != nil {
+		t.Fatalf("expected %s to be valid: %v", valid, err)
+	}
+	invalid := `<root xmlns="urn:a" xmlns:b="urn:b"><b:leaf>y</b:leaf></root>`
+	if err := diagnoseDoc(g, invalid); err == nil {
+		t.Fatalf("expected %s to be invalid", invalid)
+	}
+	if _, err := FromXSD([]byte(files["main.xsd"])); err == nil {
+		t.Fatal("expected an error for an include without a filename")
+	}
+}
+
+func TestFromXSDUnsupported(t *testing.T) {
+	g, err := FromXSD([]byte(`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+		<xs:element name="a" nillable="true">
+			<xs:complexType>
+				<xs:sequence><xs:element name="b" type="xs:string" maxOccurs="100"/></xs:sequence>
+			</xs:complexType>
+			<xs:key name="k"><xs:selector xpath="b"/><xs:field xpath="."/></xs:key>
+		</xs:element>
+	</xs:schema>`))
+	unsupported, ok := err.(*UnsupportedError)
+	if !ok {
+		t.Fatalf("expected an UnsupportedError, but got %v", err)
+	}
+	if len(unsupported.Features) != 3 || !strings.Contains(err.Error(), "nillable") || !strings.Contains(err.Error(), "key k") {
+		t.Fatalf("unexpected unsupported features %v", unsupported.Features)
+	}
+	if g == nil || diagnoseDoc(g, `<a><b/><b/></a>`) != nil {
+		t.Fatalf("expected an approximate grammar")
+	}
+	for _, bad := range []string{
+		`<schema/>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:element name="a" type="missing"/></xs:schema>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:element name="a" type="p:a"/></xs:schema>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"/>`,
+	} {
+		if _, err := FromXSD([]byte(bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestFromXSDTranslate(t *testing.T) {
+	g, err := FromXSD([]byte(`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+		<xs:element name="order">
+			<xs:complexType>
+				<xs:sequence>
+					<xs:element name="item" type="xs:string" maxOccurs="unbounded"/>
+				</xs:sequence>
+				<xs:attribute name="status" use="required">
+					<xs:simpleType>
+						<xs:restriction base="xs:token"><xs:enumeration value="open"/><xs:enumeration value="closed"/></xs:restriction>
+					</xs:simpleType>
+				</xs:attribute>
+			</xs:complexType>
+		</xs:element>
+	</xs:schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(katydid, []byte(`<order status="open"><item>a</item><item>b</item></order>`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(katydid, []byte(`<order status="pending"><item>a</item></order>`)); err == nil {
+		t.Fatal("expected an invalid status")
+	}
+}
+
+func TestFromXSDTranslateBuiltins(t *testing.T) {
+	g, err := FromXSD([]byte(`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+		<xs:element name="event">
+			<xs:complexType>
+				<xs:sequence>
+					<xs:element name="day" type="xs:date"/>
+				</xs:sequence>
+				<xs:attribute name="seats" type="xs:int" use="required"/>
+			</xs:complexType>
+		</xs:element>
+	</xs:schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	katydid, err := Translate(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := []string{
+		`<event seats="42"><day>2018-02-28</day></event>`,
+		`<event seats=" -7 "><day>2018-02-28Z</day></event>`,
+	}
+	for _, v := range valid {
+		if err := Validate(katydid, []byte(v)); err != nil {
+			t.Errorf("expected %s to be valid: %v", v, err)
+		}
+	}
+	invalid := []string{
+		`<event seats="many"><day>2018-02-28</day></event>`,
+		`<event seats="2147483648"><day>2018-02-28</day></event>`,
+		`<event seats=""><day>2018-02-28</day></event>`,
+		`<event seats="42"><day>28/02/2018</day></event>`,
+		`<event seats="42"><day>2018-13-01</day></event>`,
+		`<event seats="42"><day/></event>`,
+	}
+	for _, v := range invalid {
+		if err := Validate(katydid, []byte(v)); err == nil {
+			t.Errorf("expected %s to be invalid", v)
+		}
+	}
+}